@@ -230,7 +230,7 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cfg.authRequired = true
+	cfg.authRequired.Store(true)
 
 	addJWTCookie(w, token)
 	addRefreshCookie(w, refresh, refreshLifetime)
@@ -251,7 +251,7 @@ func (cfg *apiConfig) handlerDeleteUser(id uuid.UUID, w http.ResponseWriter, r *
 
 	if count == 0 {
 		log.Println("ALL USERS HAVE BEEN DELETED. THE APP WILL NO LONGER USE AUTHENTICATION")
-		cfg.authRequired = false
+		cfg.authRequired.Store(false)
 	}
 
 	respondWithJson(w, http.StatusOK, struct {
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/Ethanol2/book-organizer/internal/metadata"
+)
+
+func (cfg *apiConfig) authorPhotoPath(id int) string {
+	return path.Join(cfg.metadataPath, "authors", strconv.Itoa(id)+".jpg")
+}
+
+// Removes the photos of authors that were merged away or deleted
+func (cfg *apiConfig) deleteAuthorPhotos(ids []int) {
+	for _, id := range ids {
+		err := os.Remove(cfg.authorPhotoPath(id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println(err)
+		}
+	}
+}
+
+func (cfg *apiConfig) respondWithAuthor(w http.ResponseWriter, author database.AuthorProfile, errs []string) {
+
+	if _, err := os.Stat(cfg.authorPhotoPath(author.Id)); err == nil {
+		photo := fmt.Sprintf("/api/authors/%d/photo", author.Id)
+		author.Photo = &photo
+	}
+
+	for i := range author.Series {
+		overviews := make([]database.BookOverview, len(author.Series[i].Books))
+		for j := range author.Series[i].Books {
+			overviews[j] = author.Series[i].Books[j].BookOverview
+		}
+		cfg.addThumbnails(overviews)
+		for j := range author.Series[i].Books {
+			author.Series[i].Books[j].BookOverview = overviews[j]
+		}
+	}
+	cfg.addThumbnails(author.Standalone)
+
+	if errs == nil {
+		respondWithJson(w, http.StatusOK, author)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Author database.AuthorProfile `json:"author"`
+		Errors []string               `json:"errors"`
+	}{author, errs})
+}
+
+func respondWithAuthorError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, NotFoundError, err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+}
+
+func (cfg *apiConfig) handlerGetAuthor(w http.ResponseWriter, r *http.Request) {
+
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	author, err := cfg.db.GetAuthorProfile(id)
+	if err != nil {
+		respondWithAuthorError(w, err)
+		return
+	}
+
+	cfg.respondWithAuthor(w, author, nil)
+}
+
+func (cfg *apiConfig) handlerUpdateAuthor(w http.ResponseWriter, r *http.Request) {
+
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	var params database.AuthorParams
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if params.Asin != nil && *params.Asin != "" && !metadata.IsValidASIN(*params.Asin) {
+		respondWithError(w, http.StatusBadRequest, "Invalid asin", nil)
+		return
+	}
+
+	author, err := cfg.db.UpdateAuthor(id, params)
+	if err != nil {
+		respondWithAuthorError(w, err)
+		return
+	}
+
+	cfg.respondWithAuthor(w, author, nil)
+}
+
+func (cfg *apiConfig) handlerGetAuthorPhoto(w http.ResponseWriter, r *http.Request) {
+
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	serveImage(w, r, cfg.authorPhotoPath(id))
+}
+
+// Pulls the bio, photo and ids from Audible, when the author has an asin, and OpenLibrary. Audible's bio and photo are preferred.
+// Providers that fail are listed in errors without stopping the others
+func (cfg *apiConfig) handlerRefreshAuthor(w http.ResponseWriter, r *http.Request) {
+
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	source := r.URL.Query().Get("source")
+	if source != "" && source != "audible" && source != "open library" {
+		respondWithError(w, http.StatusBadRequest, "Authors can be refreshed from audible or open library", fmt.Errorf("unknown source => %s", source))
+		return
+	}
+	region := r.URL.Query().Get("region")
+	if region == "" {
+		region = "com"
+	}
+	if !metadata.IsValidAudibleRegion(region) {
+		respondWithError(w, http.StatusBadRequest, "Valid audible regions are: co.au, ca, de, es, fr, co.in, it, co.jp, com, co.uk", fmt.Errorf("invalid region => %s", region))
+		return
+	}
+
+	author, err := cfg.db.GetAuthorProfile(id)
+	if err != nil {
+		respondWithAuthorError(w, err)
+		return
+	}
+
+	errs := []string{}
+	found := []metadata.AuthorDetails{}
+
+	if (source == "" || source == "audible") && author.Asin != nil {
+		details, err := metadata.GetAudibleAuthor(*author.Asin, region, &cfg.mdCache)
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, "Audible: "+err.Error())
+		} else {
+			found = append(found, details)
+		}
+	} else if source == "audible" {
+		errs = append(errs, "Audible: the author doesn't have an asin")
+	}
+
+	if source == "" || source == "open library" {
+		key := ""
+		if author.OpenLibraryKey != nil {
+			key = *author.OpenLibraryKey
+		}
+
+		details, err := metadata.GetOpenLibraryAuthor(key, author.Name, &cfg.mdCache)
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, "OpenLibrary: "+err.Error())
+		} else {
+			found = append(found, details)
+		}
+	}
+
+	var params database.AuthorParams
+	alternateNames := []string{}
+	for _, details := range found {
+		if details.Bio != "" && params.Bio == nil {
+			params.Bio = &details.Bio
+		}
+		if details.Asin != "" {
+			params.Asin = &details.Asin
+		}
+		if details.OpenLibraryKey != "" {
+			params.OpenLibraryKey = &details.OpenLibraryKey
+		}
+		alternateNames = append(alternateNames, details.AlternateNames...)
+
+		if details.PhotoUrl == "" || params.PhotoUrl != nil {
+			continue
+		}
+		err = cfg.downloadAuthorPhoto(id, details.PhotoUrl)
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, "Failed to download the photo \""+details.PhotoUrl+"\": "+err.Error())
+			continue
+		}
+		params.PhotoUrl = &details.PhotoUrl
+	}
+
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		_, err := c.UpdateAuthor(id, params)
+		if err != nil {
+			return err
+		}
+		return c.AddAuthorAlternateNames(id, alternateNames)
+	})
+	if err != nil {
+		respondWithAuthorError(w, err)
+		return
+	}
+
+	author, err = cfg.db.GetAuthorProfile(id)
+	if err != nil {
+		respondWithAuthorError(w, err)
+		return
+	}
+
+	cfg.respondWithAuthor(w, author, errs)
+}
+
+func (cfg *apiConfig) downloadAuthorPhoto(id int, url string) error {
+
+	err := fileManagement.CreateDirectory(path.Dir(cfg.authorPhotoPath(id)))
+	if err != nil {
+		return err
+	}
+
+	// A photo that fails to download or decode leaves the current one alone
+	return fileManagement.DownloadImage(url, cfg.authorPhotoPath(id))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/Ethanol2/book-organizer/internal/backup"
+)
+
+func (cfg *apiConfig) handlerGetBackups(w http.ResponseWriter, r *http.Request) {
+
+	manifests, err := cfg.backups.List()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, GenericError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []backup.Manifest `json:"values"`
+	}{manifests})
+}
+
+// Backs up the database now. The body is optional, and the metadata folder is included when the backups are
+// configured to include it unless it says otherwise
+func (cfg *apiConfig) handlerPostBackup(w http.ResponseWriter, r *http.Request) {
+
+	params := struct {
+		Metadata *bool `json:"metadata"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	withMetadata := cfg.backupMetadata
+	if params.Metadata != nil {
+		withMetadata = *params.Metadata
+	}
+
+	manifest, err := cfg.backups.Create(backup.ReasonManual, withMetadata)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, GenericError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusCreated, manifest)
+}
+
+func (cfg *apiConfig) handlerDownloadBackup(w http.ResponseWriter, r *http.Request) {
+
+	name := r.PathValue("name")
+	backupPath, err := cfg.backups.Path(name)
+	if errors.Is(err, backup.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, NotFoundError, err)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, GenericError, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+".zip\"")
+	http.ServeFile(w, r, backupPath)
+}
+
+// Replaces the database, and the metadata folder if the backup has it, with the backup's. Runs once every other request
+// and library scan has finished, and holds new ones until it's done. Responds with the backup of the state before the
+// restore, which can be restored to undo it
+func (cfg *apiConfig) handlerRestoreBackup(w http.ResponseWriter, r *http.Request) {
+
+	safety, err := cfg.backups.Restore(r.PathValue("name"))
+	if errors.Is(err, backup.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, NotFoundError, err)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, GenericError, err)
+		return
+	}
+
+	// The restored database may have different users, and brings back sessions that have since ended
+	count, err := cfg.db.CountUsers()
+	if err != nil {
+		log.Println("Failed to count the users after the restore =>", err)
+	} else {
+		cfg.authRequired.Store(count > 0)
+	}
+	err = cfg.db.RemoveAllRefreshTokens()
+	if err != nil {
+		log.Println("Failed to end the sessions after the restore =>", err)
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Previous backup.Manifest `json:"previous"`
+	}{safety})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Ethanol2/book-organizer/internal/backup"
+	"github.com/Ethanol2/book-organizer/internal/database"
+)
+
+func TestRestoreBackup(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.backups = &backup.Manager{Dir: path.Join(t.TempDir(), "backups"), MetadataPath: cfg.metadataPath, DB: &cfg.db}
+
+	// A backup from when the library had a user with a session
+	user, err := cfg.db.AddUser(database.UserParams{Username: "admin", Password: "hash"})
+	if err != nil {
+		t.Fatalf("AddUser failed: %v", err)
+	}
+	_, err = cfg.db.AddRefreshToken(user.Id, "old session", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("AddRefreshToken failed: %v", err)
+	}
+	manifest, err := cfg.backups.Create(backup.ReasonManual, false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Since then, every user was deleted
+	_, err = cfg.db.DeleteUser(user.Id)
+	if err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.SetPathValue("name", manifest.Name)
+	w := httptest.NewRecorder()
+	cfg.exclusiveMiddleware(cfg.handlerRestoreBackup)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the restore to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if !cfg.authRequired.Load() {
+		t.Error("Expected authentication required for the restored user")
+	}
+	if _, err := cfg.db.GetRefreshToken("old session"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the restored session ended, got %v", err)
+	}
+}
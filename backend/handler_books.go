@@ -3,9 +3,11 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
@@ -34,7 +36,7 @@ func (cfg *apiConfig) handlerGetBook(id uuid.UUID, w http.ResponseWriter, r *htt
 		return
 	}
 
-	book.Files.Prepend(cfg.libraryName)
+	book.Prepend(cfg.libraryName)
 
 	log.Println("Fetching \"", book.Title, "\" book details")
 
@@ -43,18 +45,85 @@ func (cfg *apiConfig) handlerGetBook(id uuid.UUID, w http.ResponseWriter, r *htt
 
 func (cfg *apiConfig) handlerGetBooks(w http.ResponseWriter, r *http.Request) {
 
-	getFullResults := r.URL.Query().Get("view")
+	filters, ok := cfg.requestBookFilters(w, r)
+	if !ok {
+		return
+	}
+
+	cfg.respondWithBooks(w, r, filters)
+}
+
+// The GET /api/books filters of the request. A saved search can stand in for them. Responds with an error and
+// returns false if the saved search can't be used
+func (cfg *apiConfig) requestBookFilters(w http.ResponseWriter, r *http.Request) (url.Values, bool) {
+
+	filters := r.URL.Query()
+
+	if savedId := filters.Get("saved"); savedId != "" {
+		id, err := uuid.Parse(savedId)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid saved search id", err)
+			return nil, false
+		}
+
+		filters, err = cfg.savedSearchFilters(r, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, http.StatusNotFound, NotFoundError, err)
+				return nil, false
+			}
+			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+			return nil, false
+		}
+	}
+
+	return filters, true
+}
+
+// Responds with an error and returns false if the user can't list the books the filters match
+func (cfg *apiConfig) checkBookFilters(w http.ResponseWriter, r *http.Request, filters url.Values) bool {
+
+	// Private collections can't be listed by other users
+	if collectionId := filters.Get("collection"); collectionId != "" {
+		id, err := uuid.Parse(collectionId)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid collection id", err)
+			return false
+		}
+
+		_, err = cfg.db.GetCollection(cfg.getUserId(r), id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				respondWithError(w, http.StatusNotFound, NotFoundError, err)
+				return false
+			}
+			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+			return false
+		}
+	}
+
+	return true
+}
+
+// Responds with the books matching the GET /api/books filters
+func (cfg *apiConfig) respondWithBooks(w http.ResponseWriter, r *http.Request, filters url.Values) {
+
+	if !cfg.checkBookFilters(w, r, filters) {
+		return
+	}
+
+	getFullResults := filters.Get("view")
 
 	switch getFullResults {
 	case "full":
-		results, err := cfg.db.GetBooks(r.URL.Query())
+		results, err := cfg.db.GetBooks(filters)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+			respondWithSearchError(w, err)
 			return
 		}
 
 		for i := range results.Items {
-			results.Items[i].Files.Prepend(cfg.libraryName)
+			results.Items[i].Prepend(cfg.libraryName)
 		}
 
 		log.Println("Fetching book details")
@@ -63,12 +132,13 @@ func (cfg *apiConfig) handlerGetBooks(w http.ResponseWriter, r *http.Request) {
 
 	case "":
 	case "summary":
-		results, err := cfg.db.GetBooksSummary(r.URL.Query())
+		results, err := cfg.db.GetBooksSummary(filters)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+			respondWithSearchError(w, err)
 			return
 		}
 
+		cfg.addThumbnails(results.Items)
 		for i := range results.Items {
 			if results.Items[i].Cover != nil {
 				cover := *results.Items[i].Cover
@@ -90,6 +160,28 @@ func (cfg *apiConfig) handlerGetBooks(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Bad search queries and pages are the client's fault. The response points at the problem in the query
+func respondWithSearchError(w http.ResponseWriter, err error) {
+
+	var queryErr database.QueryError
+	if errors.As(err, &queryErr) {
+		log.Println(err)
+		respondWithJson(w, http.StatusBadRequest, struct {
+			Error    string `json:"error"`
+			Position int    `json:"position"`
+		}{"Invalid query: " + queryErr.Msg, queryErr.Pos})
+		return
+	}
+
+	var pageErr database.PageError
+	if errors.As(err, &pageErr) {
+		respondWithError(w, http.StatusBadRequest, pageErr.Msg, err)
+		return
+	}
+
+	respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+}
+
 func (cfg *apiConfig) handlerPostBook(w http.ResponseWriter, r *http.Request) {
 
 	var params database.BookParams
@@ -110,21 +202,8 @@ func (cfg *apiConfig) handlerPostBook(w http.ResponseWriter, r *http.Request) {
 		defer coverFile.Close()
 	}
 
-	if params.ISBN != nil {
-		if *params.ISBN == "" {
-			params.ISBN = nil
-		} else if !metadata.IsValidISBN13(*params.ISBN) {
-			respondWithError(w, http.StatusBadRequest, "Invalid ISBN", nil)
-			return
-		}
-	}
-	if params.ASIN != nil {
-		if *params.ASIN == "" {
-			params.ASIN = nil
-		} else if !metadata.IsValidASIN(*params.ASIN) {
-			respondWithError(w, http.StatusBadRequest, "Invalid ASIN", nil)
-			return
-		}
+	if !cfg.checkBookParams(w, &params) {
+		return
 	}
 
 	var book database.Book
@@ -144,7 +223,15 @@ func (cfg *apiConfig) handlerPostBook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if coverFile != nil {
-		err = fileManagement.MoveFilesWithPaths(coverFile.Name(), path.Join(cfg.metadataPath, book.Id.String()+path.Ext(coverFile.Name())))
+		defer os.Remove(coverFile.Name())
+
+		candidate, err := cfg.storeCoverCandidate(*book.Id, coverFile.Name(), database.CoverCandidateParams{Source: database.CoverSourceUrl, Url: params.Cover})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
+			return
+		}
+
+		err = cfg.applyCoverCandidate(*book.Id, candidate)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
 			return
@@ -154,21 +241,16 @@ func (cfg *apiConfig) handlerPostBook(w http.ResponseWriter, r *http.Request) {
 	respondWithJson(w, http.StatusOK, book)
 }
 
-func (cfg *apiConfig) handlerUpdateBook(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
-
-	var params database.BookParams
-	err := json.NewDecoder(r.Body).Decode(&params)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
-		return
-	}
+// Checks the identifiers, publish date and custom values, responding with an error and returning false if any
+// aren't valid. Empty identifiers are cleared
+func (cfg *apiConfig) checkBookParams(w http.ResponseWriter, params *database.BookParams) bool {
 
 	if params.ISBN != nil {
 		if *params.ISBN == "" {
 			params.ISBN = nil
 		} else if !metadata.IsValidISBN13(*params.ISBN) {
 			respondWithError(w, http.StatusBadRequest, "Invalid ISBN", nil)
-			return
+			return false
 		}
 	}
 	if params.ASIN != nil {
@@ -176,9 +258,38 @@ func (cfg *apiConfig) handlerUpdateBook(id uuid.UUID, w http.ResponseWriter, r *
 			params.ASIN = nil
 		} else if !metadata.IsValidASIN(*params.ASIN) {
 			respondWithError(w, http.StatusBadRequest, "Invalid ASIN", nil)
-			return
+			return false
+		}
+	}
+	if params.PublishDate != nil && *params.PublishDate != "" {
+		if _, _, ok := database.NormalizePublishDate(*params.PublishDate); !ok {
+			respondWithError(w, http.StatusBadRequest, "Invalid publish date", nil)
+			return false
 		}
 	}
+	if params.Custom != nil {
+		err := cfg.db.ValidateCustomValues(*params.Custom)
+		if err != nil {
+			respondWithCustomFieldError(w, err)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (cfg *apiConfig) handlerUpdateBook(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	var params database.BookParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if !cfg.checkBookParams(w, &params) {
+		return
+	}
 
 	var newCover *os.File
 	if params.Cover != nil {
@@ -191,6 +302,9 @@ func (cfg *apiConfig) handlerUpdateBook(id uuid.UUID, w http.ResponseWriter, r *
 		defer newCover.Close()
 	}
 
+	before := cfg.snapshotBooks(id)
+	defer cfg.recordBookChanges(r, "update", before)
+
 	oldPath, err := cfg.db.GetBookDirectory(id)
 
 	var book database.Book
@@ -206,70 +320,87 @@ func (cfg *apiConfig) handlerUpdateBook(id uuid.UUID, w http.ResponseWriter, r *
 	}
 
 	if needsFileUpdate {
-		authorDir, seriesDir, bookDir, err := cfg.db.GetPathComponents(id)
+		err = cfg.relocateBook(id, *oldPath)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
 			return
 		}
+	}
 
-		err = fileManagement.CreateDirectory(path.Join(cfg.libraryPath, authorDir))
+	if newCover != nil {
+		defer os.Remove(newCover.Name())
+
+		// The new cover joins the book's cover gallery and replaces the current cover
+		candidate, err := cfg.storeCoverCandidate(id, newCover.Name(), database.CoverCandidateParams{Source: database.CoverSourceUrl, Url: params.Cover})
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
 			return
 		}
 
-		err = fileManagement.CreateDirectory(path.Join(cfg.libraryPath, authorDir, seriesDir))
+		err = cfg.applyCoverCandidate(id, candidate)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
 			return
 		}
+	}
 
-		newPath := path.Join(authorDir, seriesDir, bookDir)
+	book, err = cfg.db.GetBook(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
 
-		if book.Files.Root != &newPath {
-			err = fileManagement.MoveFilesWithPaths(path.Join(cfg.libraryPath, *oldPath), path.Join(cfg.libraryPath, newPath))
-			if err != nil {
-				respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
-				return
-			}
-		}
+	if book.Files.Root != nil {
+		fileManagement.CreateMetadataFile(*metadata.BookToMetadata(book), path.Join(cfg.libraryPath, *book.Files.Root))
+	}
+
+	book.Prepend(cfg.libraryName)
+	respondWithJson(w, http.StatusOK, book)
+}
 
+// Moves the book's files from oldDir to the directory recorded in the database. If they can't be moved, the database
+// is pointed back at oldDir so it matches where the files still are
+func (cfg *apiConfig) relocateBook(id uuid.UUID, oldDir string) error {
+
+	newDir, err := cfg.db.GetBookDirectory(id)
+	if err != nil || newDir == nil {
+		return err
 	}
 
-	if newCover != nil {
+	err = cfg.moveLibraryFolder(oldDir, *newDir)
+	if err == nil {
+		return nil
+	}
 
-		coverPath := ""
+	revertErr := cfg.db.HandleTransaction(func(c *database.Client) error {
+		return c.SetBookDirectory(id, oldDir)
+	})
+	if revertErr != nil {
+		return fmt.Errorf("%w, and failed to point the book back at \"%s\": %w", err, oldDir, revertErr)
+	}
 
-		if book.Files.Root == nil {
-			coverPath = path.Join(cfg.metadataPath, book.Id.String()+path.Ext(newCover.Name()))
-		} else {
-			coverPath = path.Join(cfg.libraryPath, *book.Files.Root, "cover.jpg")
-		}
+	return err
+}
 
-		err = fileManagement.DeleteFiles(coverPath)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, FileDeleteError, err)
-			return
-		}
+// Moves a folder in the library, creating the author and series folders it goes in
+func (cfg *apiConfig) moveLibraryFolder(oldDir, newDir string) error {
 
-		err = fileManagement.MoveFilesWithPaths(newCover.Name(), coverPath)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
-		}
+	if newDir == oldDir {
+		return nil
 	}
 
-	book, err = cfg.db.GetBook(id)
+	authorDir := strings.Split(newDir, "/")[0]
+	err := fileManagement.CreateDirectory(path.Join(cfg.libraryPath, authorDir))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
-		return
+		return err
 	}
 
-	if book.Files.Root != nil {
-		fileManagement.CreateMetadataFile(*metadata.BookToMetadata(book), path.Join(cfg.libraryPath, *book.Files.Root))
+	err = fileManagement.CreateDirectory(path.Join(cfg.libraryPath, path.Dir(newDir)))
+	if err != nil {
+		return err
 	}
 
-	book.Files.Prepend(cfg.libraryName)
-	respondWithJson(w, http.StatusOK, book)
+	return fileManagement.MoveFilesWithPaths(path.Join(cfg.libraryPath, oldDir), path.Join(cfg.libraryPath, newDir))
 }
 
 func (cfg *apiConfig) handlerDeleteBook(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
@@ -284,6 +415,7 @@ func (cfg *apiConfig) handlerDeleteBook(id uuid.UUID, w http.ResponseWriter, r *
 
 	if deleteFiles := r.URL.Query().Get("files"); deleteFiles == "true" {
 		log.Println("Deleting files for \"", id, "\"")
+		before := cfg.snapshotBooks(id)
 		dir, err := cfg.db.GetBookDirectory(id)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, FileDeleteError, err)
@@ -297,11 +429,17 @@ func (cfg *apiConfig) handlerDeleteBook(id uuid.UUID, w http.ResponseWriter, r *
 			}
 		}
 		err = cfg.db.HandleTransaction(func(c *database.Client) error {
+			// Format folders are nested in the book folder, so they're gone too
+			err := c.DeleteBookFormats(id)
+			if err != nil {
+				return err
+			}
 			return c.UpdateBookFiles(id, fileManagement.Files{})
 		})
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
 		}
+		cfg.recordBookChanges(r, "delete files", before)
 	}
 
 	if deleteBook := r.URL.Query().Get("book"); deleteBook == "true" {
@@ -312,6 +450,16 @@ func (cfg *apiConfig) handlerDeleteBook(id uuid.UUID, w http.ResponseWriter, r *
 			log.Println(err)
 		}
 
+		err = fileManagement.DeleteThumbnails(cfg.thumbnailsPath(), id.String())
+		if err != nil {
+			log.Println(err)
+		}
+
+		err = fileManagement.DeleteFiles(cfg.coverCandidatesPath(id))
+		if err != nil {
+			log.Println(err)
+		}
+
 		err = cfg.db.HandleTransaction(func(c *database.Client) error {
 			return c.DeleteBook(id)
 		})
@@ -338,8 +486,17 @@ func (cfg *apiConfig) handlerGetScanLibrary(w http.ResponseWriter, r *http.Reque
 	knownDirs := map[string]foldersIds{}                            // Known directories in the library, mapped to their parent folders
 	scanErrors := []string{}                                        // Errors that occur during the scan that aren't cause for panic. The list is returned in the response
 
+	// The file changes the scan makes to books already in the library go in their history
+	trackedIds, _, err := cfg.db.GetAllBooksDirectories()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+	before := cfg.snapshotBooks(trackedIds...)
+	defer cfg.recordBookChanges(r, "scan", before)
+
 	// Start the transaction
-	err := cfg.db.HandleTransaction(func(c *database.Client) error {
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
 
 		// Functions ======================================================================
 
@@ -559,7 +716,7 @@ func (cfg *apiConfig) handlerGetScanLibrary(w http.ResponseWriter, r *http.Reque
 				if !metadata.IsValidASIN(*params.ASIN) {
 					scanErrors = append(scanErrors, "ASIN not valid => "+*files.Root)
 					continue
-				} else if ok, id, _ := c.CheckBookExistsASIN(*params.ISBN); ok {
+				} else if ok, id, _ := c.CheckBookExistsASIN(*params.ASIN); ok {
 
 					err := updateExistingBook(id, params, files)
 					if err != nil {
@@ -595,11 +752,12 @@ func (cfg *apiConfig) handlerGetScanLibrary(w http.ResponseWriter, r *http.Reque
 	// Return the book summaries using the search params
 	results, err := cfg.db.GetBooksSummary(r.URL.Query())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		respondWithSearchError(w, err)
 		return
 	}
 
 	// Prepend the library endpoint path to the cover paths
+	cfg.addThumbnails(results.Items)
 	for i := range results.Items {
 		if results.Items[i].Cover != nil {
 			cover := *results.Items[i].Cover
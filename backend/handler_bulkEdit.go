@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/Ethanol2/book-organizer/internal/metadata"
+	"github.com/google/uuid"
+)
+
+// Applies the same operations to many books in one transaction. A book that can't be edited is left as it was and
+// listed with its error. The folders of the edited books are moved once the edit is saved, a failure there is added
+// to the book's result without undoing the edit
+func (cfg *apiConfig) handlerBulkEditBooks(w http.ResponseWriter, r *http.Request) {
+
+	var params struct {
+		Ids        []uuid.UUID              `json:"ids"`
+		Operations []database.BulkOperation `json:"operations"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if len(params.Ids) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No books to edit", nil)
+		return
+	}
+	if len(params.Operations) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No operations to apply", nil)
+		return
+	}
+
+	// Books are numbered by where they are in the list, so each can only be in it once
+	seen := map[uuid.UUID]bool{}
+	for _, id := range params.Ids {
+		if seen[id] {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s is listed more than once", id), nil)
+			return
+		}
+		seen[id] = true
+	}
+
+	var bulkErr database.BulkEditError
+	for i := range params.Operations {
+		if params.Operations[i].Op != database.BulkSet {
+			continue
+		}
+
+		bookParams, err := params.Operations[i].SetParams()
+		if errors.As(err, &bulkErr) {
+			respondWithError(w, http.StatusBadRequest, bulkErr.Msg, err)
+			return
+		} else if err != nil {
+			respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+			return
+		}
+		if !cfg.checkBookParams(w, bookParams) {
+			return
+		}
+	}
+
+	before := cfg.snapshotBooks(params.Ids...)
+
+	oldDirs, err := cfg.db.GetBookDirectories(params.Ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	var results []database.BulkEditResult
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		results, err = c.BulkEditBooks(params.Ids, params.Operations)
+		return err
+	})
+	if errors.As(err, &bulkErr) {
+		respondWithError(w, http.StatusBadRequest, bulkErr.Msg, err)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+	defer cfg.recordBookChanges(r, "bulk edit", before)
+
+	fail := func(i int, msg string, err error) {
+		log.Println(msg, results[i].Id, "=>", err)
+		errMsg := fmt.Sprintf("%s: %s", msg, err)
+		results[i].Error = &errMsg
+	}
+
+	// A book that can't be moved keeps its old folder, and its metadata file is still written there
+	edited := []uuid.UUID{}
+	for i, result := range results {
+		if result.Book == nil {
+			continue
+		}
+		edited = append(edited, result.Id)
+
+		if oldDirs[result.Id] != nil {
+			err = cfg.relocateBook(result.Id, *oldDirs[result.Id])
+			if err != nil {
+				fail(i, "Saved, but failed to move the files", err)
+			}
+		}
+	}
+
+	loaded, err := cfg.db.GetBooksByIds(edited)
+	if err != nil {
+		for i, result := range results {
+			if result.Book != nil {
+				fail(i, "Saved, but failed to get the book", err)
+			}
+		}
+	}
+	books := map[uuid.UUID]database.Book{}
+	for _, book := range loaded {
+		books[*book.Id] = book
+	}
+
+	for i, result := range results {
+		book, ok := books[result.Id]
+		if result.Book == nil || !ok {
+			continue
+		}
+
+		if book.Files.Root != nil {
+			err := fileManagement.CreateMetadataFile(*metadata.BookToMetadata(book), path.Join(cfg.libraryPath, *book.Files.Root))
+			if err != nil {
+				fail(i, "Saved, but failed to update the metadata file", err)
+			}
+		}
+
+		book.Prepend(cfg.libraryName)
+		results[i].Book = &book
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []database.BulkEditResult `json:"values"`
+	}{results})
+}
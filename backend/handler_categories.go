@@ -1,12 +1,21 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/Ethanol2/book-organizer/internal/metadata"
+	"github.com/google/uuid"
 )
 
 func getCategoryType(r *http.Request) (database.CategoryType, error) {
@@ -23,6 +32,8 @@ func getCategoryType(r *http.Request) (database.CategoryType, error) {
 		catType = database.Narrators
 	case "authors":
 		catType = database.Authors
+	case "tags":
+		catType = database.Tags
 
 	default:
 		return database.NoType, fmt.Errorf("Unknown category type")
@@ -69,7 +80,8 @@ func (cfg *apiConfig) handlerGetAllOfCategory(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	category, err := cfg.db.GetAllOfCategory(catType)
+	withCounts := r.URL.Query().Get("counts") == "true"
+	category, err := cfg.db.GetAllOfCategory(catType, r.URL.Query().Get("sortBy"), withCounts)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
 		return
@@ -79,3 +91,322 @@ func (cfg *apiConfig) handlerGetAllOfCategory(w http.ResponseWriter, r *http.Req
 		Values []database.Category `json:"values"`
 	}{category})
 }
+
+func getCategoryId(r *http.Request) (int, error) {
+	return strconv.Atoi(r.PathValue("id"))
+}
+
+// Runs a change to categories of catType and brings the folders and metadata.json files of the books that had the categories up to date.
+// Returns the category from the change and the problems with individual books, which don't undo the change
+func (cfg *apiConfig) changeCategories(r *http.Request, catType database.CategoryType, ids []int, change func(c *database.Client) (database.Category, error)) (database.Category, []string, error) {
+
+	bookIds, err := cfg.db.GetBooksWithCategories(catType, ids)
+	if err != nil {
+		return database.Category{}, nil, err
+	}
+
+	before := cfg.snapshotBooks(bookIds...)
+
+	oldDirs, err := cfg.db.GetBookDirectories(bookIds)
+	if err != nil {
+		return database.Category{}, nil, err
+	}
+
+	var category database.Category
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		var err error
+		category, err = change(c)
+		if err != nil {
+			return err
+		}
+
+		// Folders are named after the first author and series
+		if catType != database.Authors && catType != database.Series {
+			return nil
+		}
+		for _, id := range bookIds {
+			_, _, err = c.RelocateBook(id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return database.Category{}, nil, err
+	}
+
+	defer cfg.recordBookChanges(r, "category", before)
+
+	withFiles := []uuid.UUID{}
+	for _, id := range bookIds {
+		if oldDirs[id] != nil {
+			withFiles = append(withFiles, id)
+		}
+	}
+
+	// A book that can't be moved keeps its old folder, and its metadata file is still written there
+	errs := []string{}
+	for _, id := range withFiles {
+		err = cfg.relocateBook(id, *oldDirs[id])
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, fmt.Sprintf("Failed to move the files of %s: %s", id, err))
+		}
+	}
+
+	books, err := cfg.db.GetBooksByIds(withFiles)
+	if err != nil {
+		log.Println(err)
+		return category, append(errs, fmt.Sprintf("Failed to get the books to update their metadata files: %s", err)), nil
+	}
+
+	for _, book := range books {
+		if book.Files.Root == nil {
+			continue
+		}
+
+		err = fileManagement.CreateMetadataFile(*metadata.BookToMetadata(book), path.Join(cfg.libraryPath, *book.Files.Root))
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, fmt.Sprintf("Failed to update the metadata file of \"%s\": %s", book.Title, err))
+		}
+	}
+
+	return category, errs, nil
+}
+
+// Responds with the category errors that are the client's fault, or a database error
+func respondWithCategoryError(w http.ResponseWriter, err error) {
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		respondWithError(w, http.StatusNotFound, NotFoundError, err)
+	case errors.Is(err, database.ErrCategoryExists):
+		respondWithError(w, http.StatusConflict, "A category with that name already exists. Merge the categories instead", err)
+	default:
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+	}
+}
+
+func (cfg *apiConfig) handlerGetCategory(w http.ResponseWriter, r *http.Request) {
+
+	catType, err := getCategoryType(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown category type", err)
+		return
+	}
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	category, err := cfg.db.GetCategoryWithAliases(catType, id)
+	if err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, category)
+}
+
+func (cfg *apiConfig) handlerUpdateCategory(w http.ResponseWriter, r *http.Request) {
+
+	var params struct {
+		Name     *string `json:"name"`
+		SortName *string `json:"sort_name"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	catType, err := getCategoryType(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown category type", err)
+		return
+	}
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	if params.Name != nil {
+		*params.Name = strings.TrimSpace(*params.Name)
+		if *params.Name == "" {
+			respondWithError(w, http.StatusBadRequest, "Categories need a name", nil)
+			return
+		}
+	}
+	if params.SortName != nil && catType != database.Authors && catType != database.Narrators {
+		respondWithError(w, http.StatusBadRequest, "Only authors and narrators have sort names", nil)
+		return
+	}
+
+	category, errs, err := cfg.changeCategories(r, catType, []int{id}, func(c *database.Client) (database.Category, error) {
+		if params.Name != nil {
+			_, err := c.RenameCategory(catType, id, *params.Name)
+			if err != nil {
+				return database.Category{}, err
+			}
+		}
+		if params.SortName != nil {
+			_, err := c.SetCategorySortName(catType, id, *params.SortName)
+			if err != nil {
+				return database.Category{}, err
+			}
+		}
+		return c.GetCategoryWithAliases(catType, id)
+	})
+	if err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Category database.Category `json:"category"`
+		Errors   []string          `json:"errors"`
+	}{category, errs})
+}
+
+func (cfg *apiConfig) handlerMergeCategories(w http.ResponseWriter, r *http.Request) {
+
+	var params struct {
+		Ids []int `json:"ids"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	catType, err := getCategoryType(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown category type", err)
+		return
+	}
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	if len(params.Ids) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No categories to merge", nil)
+		return
+	}
+
+	category, errs, err := cfg.changeCategories(r, catType, append(params.Ids, id), func(c *database.Client) (database.Category, error) {
+		return c.MergeCategories(catType, id, params.Ids)
+	})
+	if err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	if catType == database.Authors {
+		cfg.deleteAuthorPhotos(slices.DeleteFunc(slices.Clone(params.Ids), func(source int) bool { return source == id }))
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Category database.Category `json:"category"`
+		Errors   []string          `json:"errors"`
+	}{category, errs})
+}
+
+func (cfg *apiConfig) handlerDeleteCategory(w http.ResponseWriter, r *http.Request) {
+
+	catType, err := getCategoryType(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown category type", err)
+		return
+	}
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	_, errs, err := cfg.changeCategories(r, catType, []int{id}, func(c *database.Client) (database.Category, error) {
+		return database.Category{}, c.DeleteCategoryWithID(catType, id)
+	})
+	if err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	if catType == database.Authors {
+		cfg.deleteAuthorPhotos([]int{id})
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Errors []string `json:"errors"`
+	}{errs})
+}
+
+func (cfg *apiConfig) handlerAddCategoryAlias(w http.ResponseWriter, r *http.Request) {
+
+	var params struct {
+		Alias string `json:"alias"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	catType, err := getCategoryType(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown category type", err)
+		return
+	}
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	params.Alias = strings.TrimSpace(params.Alias)
+	if params.Alias == "" {
+		respondWithError(w, http.StatusBadRequest, "Aliases can't be empty", nil)
+		return
+	}
+
+	if _, err = cfg.db.GetCategoryWithAliases(catType, id); err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	category, err := cfg.db.AddCategoryAlias(catType, id, params.Alias)
+	if err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, category)
+}
+
+func (cfg *apiConfig) handlerDeleteCategoryAlias(w http.ResponseWriter, r *http.Request) {
+
+	catType, err := getCategoryType(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unknown category type", err)
+		return
+	}
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	category, err := cfg.db.RemoveCategoryAlias(catType, id, r.PathValue("alias"))
+	if err != nil {
+		respondWithCategoryError(w, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, category)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/google/uuid"
+)
+
+type collectionBooksParams struct {
+	BookIds []uuid.UUID `json:"book_ids"`
+
+	// Where to insert added books. Defaults to the end of the collection
+	Position *int `json:"position"`
+}
+
+// Points the collection at its cover member's cover image
+func (cfg *apiConfig) addCollectionCover(collection *database.Collection) {
+
+	if collection.CoverSource == nil {
+		return
+	}
+
+	book, err := cfg.db.GetBook(*collection.CoverSource)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if _, ok := cfg.getBookCoverPath(*collection.CoverSource, book.Files.Cover); !ok {
+		return
+	}
+
+	cover := fmt.Sprintf("/api/books/%s/cover", collection.CoverSource)
+	collection.Cover = &cover
+}
+
+// Looks up a collection the user can see. With owned set, responds with 403 unless the user owns it.
+// Responds with the error and returns false when the collection can't be used
+func (cfg *apiConfig) getCollection(w http.ResponseWriter, r *http.Request, id uuid.UUID, owned bool) (database.Collection, bool) {
+
+	userId := cfg.getUserId(r)
+
+	collection, err := cfg.db.GetCollection(userId, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, NotFoundError, err)
+			return database.Collection{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return database.Collection{}, false
+	}
+
+	if owned && !collection.OwnedBy(userId) {
+		respondWithError(w, http.StatusForbidden, "Only the owner can change a collection", nil)
+		return database.Collection{}, false
+	}
+
+	return collection, true
+}
+
+// Responds with the collection after a change
+func (cfg *apiConfig) respondWithCollection(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+
+	collection, err := cfg.db.GetCollection(cfg.getUserId(r), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	cfg.addCollectionCover(&collection)
+
+	respondWithJson(w, http.StatusOK, collection)
+}
+
+func (cfg *apiConfig) handlerGetCollections(w http.ResponseWriter, r *http.Request) {
+
+	collections, err := cfg.db.GetCollections(cfg.getUserId(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	for i := range collections {
+		cfg.addCollectionCover(&collections[i])
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []database.Collection `json:"values"`
+	}{collections})
+}
+
+func (cfg *apiConfig) handlerPostCollection(w http.ResponseWriter, r *http.Request) {
+
+	var params database.CollectionParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if params.Name == nil || strings.TrimSpace(*params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "Collections need a name", nil)
+		return
+	}
+	if params.CoverBookId != nil {
+		respondWithError(w, http.StatusBadRequest, "The cover has to be one of the collection's books. Add books before choosing the cover", nil)
+		return
+	}
+
+	collection, err := cfg.db.AddCollection(cfg.getUserId(r), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, collection)
+}
+
+func (cfg *apiConfig) handlerGetCollection(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	collection, ok := cfg.getCollection(w, r, id, false)
+	if !ok {
+		return
+	}
+
+	cfg.addCollectionCover(&collection)
+
+	respondWithJson(w, http.StatusOK, collection)
+}
+
+func (cfg *apiConfig) handlerUpdateCollection(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	var params database.CollectionParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if _, ok := cfg.getCollection(w, r, id, true); !ok {
+		return
+	}
+
+	if params.Name != nil && strings.TrimSpace(*params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "Collections need a name", nil)
+		return
+	}
+	if params.CoverBookId != nil && *params.CoverBookId != uuid.Nil {
+		member, err := cfg.db.CheckCollectionHasBook(id, *params.CoverBookId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+			return
+		}
+		if !member {
+			respondWithError(w, http.StatusBadRequest, "The cover has to be one of the collection's books", nil)
+			return
+		}
+	}
+
+	collection, err := cfg.db.UpdateCollection(cfg.getUserId(r), id, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	cfg.addCollectionCover(&collection)
+
+	respondWithJson(w, http.StatusOK, collection)
+}
+
+func (cfg *apiConfig) handlerDeleteCollection(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	if _, ok := cfg.getCollection(w, r, id, true); !ok {
+		return
+	}
+
+	err := cfg.db.DeleteCollection(cfg.getUserId(r), id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Lists the collection's books the same way GET /api/books does
+func (cfg *apiConfig) handlerGetCollectionBooks(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	filters := r.URL.Query()
+	filters.Set("collection", id.String())
+
+	cfg.respondWithBooks(w, r, filters)
+}
+
+func (cfg *apiConfig) handlerAddCollectionBooks(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	var params collectionBooksParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if _, ok := cfg.getCollection(w, r, id, true); !ok {
+		return
+	}
+
+	missing := []string{}
+	for _, bookId := range params.BookIds {
+		exists, err := cfg.db.CheckBookExistsID(bookId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+			return
+		}
+		if !exists {
+			missing = append(missing, bookId.String())
+		}
+	}
+	if len(missing) > 0 {
+		respondWithError(w, http.StatusBadRequest, "Books not found: "+strings.Join(missing, ", "), nil)
+		return
+	}
+
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		return c.AddBooksToCollection(id, params.BookIds, params.Position)
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	cfg.respondWithCollection(w, r, id)
+}
+
+func (cfg *apiConfig) handlerRemoveCollectionBooks(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	var params collectionBooksParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if _, ok := cfg.getCollection(w, r, id, true); !ok {
+		return
+	}
+
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		return c.RemoveBooksFromCollection(id, params.BookIds)
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	cfg.respondWithCollection(w, r, id)
+}
+
+func (cfg *apiConfig) handlerReorderCollectionBooks(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	var params collectionBooksParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if _, ok := cfg.getCollection(w, r, id, true); !ok {
+		return
+	}
+
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		return c.ReorderCollectionBooks(id, params.BookIds)
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	cfg.respondWithCollection(w, r, id)
+}
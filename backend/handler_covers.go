@@ -0,0 +1,598 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/Ethanol2/book-organizer/internal/metadata"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerGetBookCover(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	cover, err := cfg.db.GetBookCover(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Book "+NotFoundError, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	coverPath, ok := cfg.getBookCoverPath(id, cover)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Cover "+NotFoundError, nil)
+		return
+	}
+
+	size := r.URL.Query().Get("size")
+	if size != "" && size != "original" {
+		width, ok := fileManagement.CoverSizes[size]
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "Cover sizes are 'small', 'medium', 'large' or 'original'", fmt.Errorf("invalid cover size: %s", size))
+			return
+		}
+
+		coverPath, err = fileManagement.GetThumbnail(coverPath, cfg.thumbnailsPath(), id.String(), width)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to generate the cover thumbnail", err)
+			return
+		}
+	}
+
+	serveImage(w, r, coverPath)
+}
+
+// Returns the full path to the book's cover. Books without files fall back to the cover saved in the metadata folder
+func (cfg *apiConfig) getBookCoverPath(id uuid.UUID, cover *string) (string, bool) {
+
+	if cover != nil {
+		coverPath := path.Join(cfg.libraryPath, *cover)
+		if _, err := os.Stat(coverPath); err == nil {
+			return coverPath, true
+		}
+	}
+
+	coverPath := path.Join(cfg.metadataPath, id.String()+".jpg")
+	if _, err := os.Stat(coverPath); err == nil {
+		return coverPath, true
+	}
+
+	return "", false
+}
+
+func (cfg *apiConfig) thumbnailsPath() string {
+	return path.Join(cfg.metadataPath, "thumbnails")
+}
+
+// Points the overviews at the small thumbnail of their cover, so the library grid doesn't load full size images
+func (cfg *apiConfig) addThumbnails(items []database.BookOverview) {
+
+	for i := range items {
+		if _, ok := cfg.getBookCoverPath(items[i].Id, items[i].Cover); !ok {
+			continue
+		}
+
+		thumbnail := fmt.Sprintf("/api/books/%s/cover?size=small", items[i].Id)
+		items[i].Thumbnail = &thumbnail
+	}
+}
+
+// Serves an image with validators so browsers can revalidate instead of downloading the image again.
+// Covers can be replaced at the same url, so clients always revalidate.
+func serveImage(w http.ResponseWriter, r *http.Request, imgPath string) {
+
+	file, err := os.Open(imgPath)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, NotFoundError, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, GenericError, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, no-cache")
+	w.Header().Set("ETag", fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size()))
+
+	log.Println("Serving image from", imgPath)
+
+	http.ServeContent(w, r, path.Base(imgPath), info.ModTime(), file)
+}
+
+// #region Cover Candidates
+
+// The most covers kept from a single provider's search results
+const maxProviderCovers int = 3
+
+func (cfg *apiConfig) handlerGetCoverCandidates(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	if exists, err := cfg.db.CheckBookExistsID(id); err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	} else if !exists {
+		respondWithError(w, http.StatusNotFound, "Book "+NotFoundError, nil)
+		return
+	}
+
+	cfg.respondWithCoverCandidates(w, id, nil)
+}
+
+func (cfg *apiConfig) handlerPostCoverCandidate(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	var params struct {
+		Url    string `json:"url"`
+		Active bool   `json:"active"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+	if params.Url == "" {
+		respondWithError(w, http.StatusBadRequest, "Request missing url", nil)
+		return
+	}
+
+	if exists, err := cfg.db.CheckBookExistsID(id); err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	} else if !exists {
+		respondWithError(w, http.StatusNotFound, "Book "+NotFoundError, nil)
+		return
+	}
+
+	candidate, err := cfg.addCoverCandidateFromUrl(id, database.CoverSourceUrl, params.Url)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, CoverURLError, err)
+		return
+	}
+
+	if params.Active {
+		before := cfg.snapshotBooks(id)
+		err = cfg.applyCoverCandidate(id, candidate)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to set the book's cover", err)
+			return
+		}
+		cfg.recordBookChanges(r, "cover", before)
+		candidate.Active = true
+	}
+
+	candidate.Image = coverCandidateImage(candidate)
+
+	respondWithJson(w, http.StatusOK, candidate)
+}
+
+// Searches every metadata provider for the book and adds the covers found to its candidates
+func (cfg *apiConfig) handlerFetchCoverCandidates(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	book, err := cfg.db.GetBook(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Book "+NotFoundError, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	region := r.URL.Query().Get("region")
+	if region != "" && !metadata.IsValidAudibleRegion(region) {
+		respondWithError(w, http.StatusBadRequest, "Valid audible regions are: co.au, ca, de, es, fr, co.in, it, co.jp, com, co.uk", fmt.Errorf("invalid region => %s", region))
+		return
+	}
+
+	searchParams := metadata.SearchParams{
+		Title: &book.Title,
+		ISBN:  book.ISBN,
+	}
+	if len(book.Authors) > 0 {
+		searchParams.Author = &book.Authors[0].Name
+	}
+
+	providers := map[string]func() (metadata.SearchResults, error){
+		"open library": func() (metadata.SearchResults, error) {
+			return metadata.SearchOpenLibrary(searchParams, &cfg.mdCache)
+		},
+	}
+	if cfg.googleBooksApiKey != "" {
+		providers["google books"] = func() (metadata.SearchResults, error) {
+			return metadata.SearchGoogleBooks(searchParams, cfg.googleBooksApiKey, &cfg.mdCache)
+		}
+	}
+	if region != "" {
+		providers["audible"] = func() (metadata.SearchResults, error) {
+			params := searchParams
+			params.ASIN = book.ASIN
+			return metadata.SearchAudible(params, region, &cfg.mdCache)
+		}
+	}
+
+	fetchErrors := []string{}
+	for source, search := range providers {
+
+		log.Println("Fetching covers for \"", book.Title, "\" from", source)
+
+		results, err := search()
+		if err != nil {
+			fetchErrors = append(fetchErrors, fmt.Sprintf("%s => %s", source, err))
+			continue
+		}
+
+		added := 0
+		for _, item := range results.Items {
+			if added >= maxProviderCovers {
+				break
+			}
+			if item.Cover == nil || *item.Cover == "" {
+				continue
+			}
+
+			_, err = cfg.addCoverCandidateFromUrl(id, source, *item.Cover)
+			if err != nil {
+				fetchErrors = append(fetchErrors, fmt.Sprintf("%s => %s", *item.Cover, err))
+				continue
+			}
+			added++
+		}
+	}
+
+	cfg.respondWithCoverCandidates(w, id, fetchErrors)
+}
+
+func (cfg *apiConfig) handlerGetCoverCandidate(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	candidate, ok := cfg.getCoverCandidateFromPath(id, w, r)
+	if !ok {
+		return
+	}
+
+	serveImage(w, r, cfg.coverCandidatePath(candidate))
+}
+
+func (cfg *apiConfig) handlerActivateCoverCandidate(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	candidate, ok := cfg.getCoverCandidateFromPath(id, w, r)
+	if !ok {
+		return
+	}
+
+	before := cfg.snapshotBooks(id)
+	err := cfg.applyCoverCandidate(id, candidate)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to set the book's cover", err)
+		return
+	}
+	cfg.recordBookChanges(r, "cover", before)
+
+	cfg.respondWithCoverCandidates(w, id, nil)
+}
+
+func (cfg *apiConfig) handlerDeleteCoverCandidate(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	candidate, ok := cfg.getCoverCandidateFromPath(id, w, r)
+	if !ok {
+		return
+	}
+
+	if candidate.Active {
+		respondWithError(w, http.StatusConflict, "The active cover can't be deleted. Choose another cover first", nil)
+		return
+	}
+
+	err := cfg.db.HandleTransaction(func(c *database.Client) error {
+		return c.DeleteCoverCandidate(candidate.Id)
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	err = fileManagement.DeleteFiles(cfg.coverCandidatePath(candidate))
+	if err != nil {
+		log.Println("Failed to delete the cover candidate image =>", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Adds the images that came with a book's files to its candidates, along with the metadata cover if it isn't one already.
+// Returns all of the book's candidates
+func (cfg *apiConfig) collectCoverCandidates(bookId uuid.UUID, bookPath string, downloadedCover *string) ([]database.CoverCandidate, error) {
+
+	candidates, err := cfg.db.GetCoverCandidates(bookId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		metadataCoverPath := path.Join(cfg.metadataPath, bookId.String()+".jpg")
+		if _, err := os.Stat(metadataCoverPath); err == nil {
+			_, err = cfg.addCoverCandidateFromFile(bookId, metadataCoverPath, database.CoverCandidateParams{Source: database.CoverSourceMetadata})
+			if err != nil {
+				log.Println("Failed to add the metadata cover to the candidates =>", err)
+			}
+		}
+	}
+
+	images, err := fileManagement.ListImages(bookPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, img := range images {
+		source := database.CoverSourceFolder
+		if downloadedCover != nil && img == path.Base(*downloadedCover) {
+			source = database.CoverSourceDownload
+		}
+
+		_, err = cfg.addCoverCandidateFromFile(bookId, path.Join(bookPath, img), database.CoverCandidateParams{Source: source})
+		if err != nil {
+			log.Println("Skipping the image \"", img, "\" =>", err)
+		}
+	}
+
+	return cfg.db.GetCoverCandidates(bookId)
+}
+
+func (cfg *apiConfig) addCoverCandidateFromUrl(bookId uuid.UUID, source, url string) (database.CoverCandidate, error) {
+
+	existing, err := cfg.db.GetCoverCandidateByUrl(bookId, url)
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+	if existing != nil {
+		return *existing, nil
+	}
+
+	tmp, err := fileManagement.DownloadTempFile(url)
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	return cfg.storeCoverCandidate(bookId, tmp.Name(), database.CoverCandidateParams{Source: source, Url: &url})
+}
+
+func (cfg *apiConfig) addCoverCandidateFromFile(bookId uuid.UUID, srcPath string, params database.CoverCandidateParams) (database.CoverCandidate, error) {
+
+	tmp, err := os.CreateTemp("", "bookOrg-*.jpg")
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	_, _, err = fileManagement.NormalizeCover(srcPath, tmp.Name())
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+
+	return cfg.storeCoverCandidate(bookId, tmp.Name(), params)
+}
+
+// Copies an already normalized jpeg into the book's cover gallery and records it. The image is copied to a temp file in
+// the gallery first, and only given the candidate's name once it's recorded, so a failed transaction leaves nothing behind.
+// If the book already has a candidate with the same image, that candidate is returned instead
+func (cfg *apiConfig) storeCoverCandidate(bookId uuid.UUID, imgPath string, params database.CoverCandidateParams) (database.CoverCandidate, error) {
+
+	var err error
+	params.Width, params.Height, err = fileManagement.ImageDimensions(imgPath)
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+
+	params.Hash, err = fileManagement.HashFile(imgPath)
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+	existing, err := cfg.findCoverCandidate(bookId, params.Hash)
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+	if existing != nil {
+		return *existing, nil
+	}
+
+	err = os.MkdirAll(cfg.coverCandidatesPath(bookId), os.ModePerm)
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+
+	tmp, err := os.CreateTemp(cfg.coverCandidatesPath(bookId), ".bookOrg-*.jpg")
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	err = fileManagement.CopyFile(imgPath, tmp.Name())
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+
+	var candidate database.CoverCandidate
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		candidate, err = c.AddCoverCandidate(bookId, params)
+		return err
+	})
+	if err != nil {
+		return database.CoverCandidate{}, err
+	}
+
+	err = os.Rename(tmp.Name(), cfg.coverCandidatePath(candidate))
+	if err != nil {
+		deleteErr := cfg.db.HandleTransaction(func(c *database.Client) error {
+			return c.DeleteCoverCandidate(candidate.Id)
+		})
+		if deleteErr != nil {
+			log.Println("Failed to remove the cover candidate without an image =>", deleteErr)
+		}
+		return database.CoverCandidate{}, err
+	}
+
+	return candidate, nil
+}
+
+// Returns the book's candidate with the image hash, or nil. Candidates added before hashes were kept are hashed from
+// their image, and the hash saved, as they're compared
+func (cfg *apiConfig) findCoverCandidate(bookId uuid.UUID, hash string) (*database.CoverCandidate, error) {
+
+	existing, err := cfg.db.GetCoverCandidateByHash(bookId, hash)
+	if err != nil || existing != nil {
+		return existing, err
+	}
+
+	candidates, err := cfg.db.GetCoverCandidates(bookId)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		if candidate.Hash != nil {
+			continue
+		}
+
+		candidateHash, err := fileManagement.HashFile(cfg.coverCandidatePath(candidate))
+		if err != nil {
+			log.Println("Failed to hash the cover candidate", candidate.Id, "=>", err)
+			continue
+		}
+		err = cfg.db.HandleTransaction(func(c *database.Client) error {
+			return c.SetCoverCandidateHash(candidate.Id, candidateHash)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if candidateHash == hash {
+			return &candidate, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Writes the candidate to the book's folder as cover.jpg, or to the metadata folder if the book has no files, and marks it active
+func (cfg *apiConfig) applyCoverCandidate(bookId uuid.UUID, candidate database.CoverCandidate) error {
+
+	dir, err := cfg.db.GetBookDirectory(bookId)
+	if err != nil {
+		return err
+	}
+
+	if dir == nil {
+		err = fileManagement.CopyFile(cfg.coverCandidatePath(candidate), path.Join(cfg.metadataPath, bookId.String()+".jpg"))
+		if err != nil {
+			return err
+		}
+
+		return cfg.db.HandleTransaction(func(c *database.Client) error {
+			return c.SetActiveCoverCandidate(bookId, candidate.Id)
+		})
+	}
+
+	err = fileManagement.CopyFile(cfg.coverCandidatePath(candidate), path.Join(cfg.libraryPath, *dir, "cover.jpg"))
+	if err != nil {
+		return err
+	}
+
+	var oldCover string
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		oldCover, _, err = c.UpdateBookCover(bookId, "jpg")
+		if err != nil {
+			return err
+		}
+		return c.SetActiveCoverCandidate(bookId, candidate.Id)
+	})
+	if err != nil {
+		return err
+	}
+
+	// A cover.png or cover.webp would otherwise sit next to the new cover. It's kept in the gallery
+	if base := path.Base(oldCover); base != "cover.jpg" && strings.HasPrefix(base, "cover.") {
+		err = fileManagement.DeleteFiles(path.Join(cfg.libraryPath, oldCover))
+		if err != nil {
+			log.Println("Failed to remove the old cover =>", err)
+		}
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) respondWithCoverCandidates(w http.ResponseWriter, bookId uuid.UUID, errs []string) {
+
+	candidates, err := cfg.db.GetCoverCandidates(bookId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	for i := range candidates {
+		candidates[i].Image = coverCandidateImage(candidates[i])
+	}
+
+	if errs == nil {
+		respondWithJson(w, http.StatusOK, struct {
+			Values []database.CoverCandidate `json:"values"`
+		}{candidates})
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []database.CoverCandidate `json:"values"`
+		Errors []string                  `json:"errors"`
+	}{candidates, errs})
+}
+
+func (cfg *apiConfig) getCoverCandidateFromPath(bookId uuid.UUID, w http.ResponseWriter, r *http.Request) (database.CoverCandidate, bool) {
+
+	coverId, err := uuid.Parse(r.PathValue("coverId"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid cover id", err)
+		return database.CoverCandidate{}, false
+	}
+
+	candidate, err := cfg.db.GetCoverCandidate(coverId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Cover "+NotFoundError, err)
+			return database.CoverCandidate{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return database.CoverCandidate{}, false
+	}
+
+	if candidate.BookId != bookId {
+		respondWithError(w, http.StatusNotFound, "Cover "+NotFoundError, nil)
+		return database.CoverCandidate{}, false
+	}
+
+	return candidate, true
+}
+
+func (cfg *apiConfig) coverCandidatesPath(bookId uuid.UUID) string {
+	return path.Join(cfg.metadataPath, "covers", bookId.String())
+}
+
+func (cfg *apiConfig) coverCandidatePath(candidate database.CoverCandidate) string {
+	return path.Join(cfg.coverCandidatesPath(candidate.BookId), candidate.Id.String()+".jpg")
+}
+
+func coverCandidateImage(candidate database.CoverCandidate) string {
+	return fmt.Sprintf("/api/books/%s/covers/%s", candidate.BookId, candidate.Id)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/google/uuid"
+)
+
+// Returns a config with a fresh database and library, downloads and metadata folders in a temp folder
+func setupTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := database.NewClient(path.Join(dir, "library.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test DB: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	cfg := &apiConfig{
+		db:            db,
+		libraryPath:   path.Join(dir, "library"),
+		downloadsPath: path.Join(dir, "downloads"),
+		metadataPath:  path.Join(dir, "metadata"),
+		libraryName:   "library",
+		downloadsName: "downloads",
+	}
+	for _, p := range []string{cfg.libraryPath, cfg.downloadsPath, cfg.metadataPath} {
+		err = os.MkdirAll(p, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return cfg
+}
+
+// Adds a book with a folder in the library holding a cover of the given size
+func addTestBookWithCover(t *testing.T, cfg *apiConfig, title string, width, height int) uuid.UUID {
+	t.Helper()
+
+	book, err := cfg.db.AddBook(database.BookParams{Title: &title})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+
+	root := path.Join("Author", title)
+	cover := path.Join(root, "cover.jpg")
+	writeTestJpeg(t, path.Join(cfg.libraryPath, cover), width, height)
+
+	err = cfg.db.UpdateBookFiles(*book.Id, fileManagement.Files{Root: &root, AudioFiles: &[]string{}, TextFiles: &[]string{}, Cover: &cover})
+	if err != nil {
+		t.Fatalf("UpdateBookFiles failed: %v", err)
+	}
+
+	return *book.Id
+}
+
+func writeTestJpeg(t *testing.T, imgPath string, width, height int) {
+	t.Helper()
+
+	err := os.MkdirAll(path.Dir(imgPath), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(imgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	err = jpeg.Encode(file, image.NewRGBA(image.Rect(0, 0, width, height)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetBookCover(t *testing.T) {
+	cfg := setupTestConfig(t)
+	id := addTestBookWithCover(t, cfg, "Dune", 800, 1200)
+
+	getCover := func(id uuid.UUID, query string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/books/"+id.String()+"/cover"+query, nil)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		cfg.handlerGetBookCover(id, w, r)
+		return w
+	}
+
+	tests := []struct {
+		Name   string
+		Query  string
+		Status int
+		Width  int
+	}{
+		{"original by default", "", http.StatusOK, 800},
+		{"original", "?size=original", http.StatusOK, 800},
+		{"small", "?size=small", http.StatusOK, fileManagement.CoverSizes["small"]},
+		{"medium", "?size=medium", http.StatusOK, fileManagement.CoverSizes["medium"]},
+		{"large", "?size=large", http.StatusOK, fileManagement.CoverSizes["large"]},
+		{"invalid size", "?size=huge", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			w := getCover(id, test.Query, nil)
+			if w.Code != test.Status {
+				t.Fatalf("Expected %d, got %d: %s", test.Status, w.Code, w.Body.String())
+			}
+			if test.Status != http.StatusOK {
+				return
+			}
+
+			if w.Header().Get("Content-Type") != "image/jpeg" {
+				t.Errorf("Expected image/jpeg, got %s", w.Header().Get("Content-Type"))
+			}
+			if w.Header().Get("Cache-Control") != "public, no-cache" || w.Header().Get("ETag") == "" || w.Header().Get("Last-Modified") == "" {
+				t.Errorf("Expected caching headers, got %v", w.Header())
+			}
+
+			config, err := jpeg.DecodeConfig(w.Body)
+			if err != nil {
+				t.Fatalf("The cover doesn't decode: %v", err)
+			}
+			if config.Width != test.Width {
+				t.Errorf("Expected %dpx wide, got %dpx", test.Width, config.Width)
+			}
+
+			// Revalidating with the ETag doesn't download the image again
+			w = getCover(id, test.Query, http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+			if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+				t.Errorf("Expected 304 Not Modified, got %d with %d bytes", w.Code, w.Body.Len())
+			}
+		})
+	}
+
+	// Each size has its own ETag, so a cached thumbnail isn't served for another size
+	small := getCover(id, "?size=small", nil).Header().Get("ETag")
+	large := getCover(id, "?size=large", nil).Header().Get("ETag")
+	if small == large {
+		t.Error("Expected different ETags for different sizes")
+	}
+
+	// Books without covers, and unknown books
+	title := "No Cover"
+	book, err := cfg.db.AddBook(database.BookParams{Title: &title})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+	if w := getCover(*book.Id, "?size=small", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a book without a cover, got %d", w.Code)
+	}
+	if w := getCover(uuid.New(), "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown book, got %d", w.Code)
+	}
+}
+
+func TestChooseCoverCandidate(t *testing.T) {
+
+	downloaded := database.CoverCandidate{Id: uuid.New(), Source: database.CoverSourceDownload}
+	folder := database.CoverCandidate{Id: uuid.New(), Source: database.CoverSourceFolder}
+	metadataCover := database.CoverCandidate{Id: uuid.New(), Source: database.CoverSourceMetadata}
+	active := database.CoverCandidate{Id: uuid.New(), Source: database.CoverSourceUrl, Active: true}
+
+	tests := []struct {
+		Name          string
+		Candidates    []database.CoverCandidate
+		UseDownloaded bool
+		Expected      *uuid.UUID
+	}{
+		{"downloaded when asked", []database.CoverCandidate{active, downloaded, folder}, true, &downloaded.Id},
+		{"current cover by default", []database.CoverCandidate{downloaded, active, folder}, false, &active.Id},
+		{"active over the metadata cover", []database.CoverCandidate{metadataCover, active}, false, &active.Id},
+		{"metadata cover when none is active", []database.CoverCandidate{metadataCover, downloaded}, false, &metadataCover.Id},
+		{"downloaded without a current cover", []database.CoverCandidate{folder, downloaded}, false, &downloaded.Id},
+		{"current cover when nothing was downloaded", []database.CoverCandidate{folder, metadataCover}, true, &metadataCover.Id},
+		{"any other image", []database.CoverCandidate{folder}, false, &folder.Id},
+		{"nothing", []database.CoverCandidate{}, true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			chosen := chooseCoverCandidate(test.Candidates, test.UseDownloaded)
+			if test.Expected == nil {
+				if chosen != nil {
+					t.Errorf("Expected nothing chosen, got %+v", chosen)
+				}
+				return
+			}
+			if chosen == nil || chosen.Id != *test.Expected {
+				t.Errorf("Expected %v, got %+v", *test.Expected, chosen)
+			}
+		})
+	}
+}
+
+func TestCoverCandidates(t *testing.T) {
+	cfg := setupTestConfig(t)
+	id := addTestBookWithCover(t, cfg, "Dune", 400, 600)
+	bookPath := path.Join(cfg.libraryPath, "Author", "Dune")
+
+	// A second image in the folder, and a cover saved in the metadata folder before the book had files
+	writeTestJpeg(t, path.Join(bookPath, "back.jpg"), 300, 300)
+	writeTestJpeg(t, path.Join(cfg.metadataPath, id.String()+".jpg"), 200, 100)
+
+	downloadedCover := path.Join("Author", "Dune", "cover.jpg")
+	candidates, err := cfg.collectCoverCandidates(id, bookPath, &downloadedCover)
+	if err != nil {
+		t.Fatalf("collectCoverCandidates failed: %v", err)
+	}
+	if len(candidates) != 3 {
+		t.Fatalf("Expected 3 candidates, got %+v", candidates)
+	}
+
+	bySource := map[string]database.CoverCandidate{}
+	for _, candidate := range candidates {
+		bySource[candidate.Source] = candidate
+		if _, err := os.Stat(cfg.coverCandidatePath(candidate)); err != nil {
+			t.Errorf("Expected the %s candidate's image in the gallery: %v", candidate.Source, err)
+		}
+	}
+	if bySource[database.CoverSourceDownload].Width != 400 || bySource[database.CoverSourceFolder].Width != 300 || bySource[database.CoverSourceMetadata].Width != 200 {
+		t.Errorf("Expected a download, folder and metadata candidate with their sizes, got %+v", candidates)
+	}
+
+	// Collecting again, or adding the same image another way, doesn't add it to the gallery twice
+	candidates, err = cfg.collectCoverCandidates(id, bookPath, &downloadedCover)
+	if err != nil {
+		t.Fatalf("collectCoverCandidates failed: %v", err)
+	}
+	if len(candidates) != 3 {
+		t.Errorf("Expected the same 3 candidates after collecting again, got %d", len(candidates))
+	}
+	again, err := cfg.addCoverCandidateFromFile(id, path.Join(bookPath, "back.jpg"), database.CoverCandidateParams{Source: database.CoverSourceUrl})
+	if err != nil || again.Id != bySource[database.CoverSourceFolder].Id {
+		t.Errorf("Expected the existing folder candidate, got %+v (%v)", again, err)
+	}
+
+	// Only temp files left behind would be hidden in the gallery
+	entries, _ := os.ReadDir(cfg.coverCandidatesPath(id))
+	for _, entry := range entries {
+		if entry.Name()[0] == '.' {
+			t.Errorf("Expected no temp files left in the gallery, found %s", entry.Name())
+		}
+	}
+
+	coverRequest := func(method string, candidate database.CoverCandidate) *http.Request {
+		r := httptest.NewRequest(method, "/api/books/"+id.String()+"/covers/"+candidate.Id.String(), nil)
+		r.SetPathValue("coverId", candidate.Id.String())
+		return r
+	}
+
+	// Activating rewrites the library folder's cover
+	folder := bySource[database.CoverSourceFolder]
+	w := httptest.NewRecorder()
+	cfg.handlerActivateCoverCandidate(id, w, coverRequest("PUT", folder))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	file, err := os.Open(path.Join(bookPath, "cover.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := jpeg.DecodeConfig(file)
+	file.Close()
+	if err != nil || config.Width != 300 || config.Height != 300 {
+		t.Errorf("Expected cover.jpg rewritten with the 300x300 candidate, got %dx%d (%v)", config.Width, config.Height, err)
+	}
+	active, err := cfg.db.GetActiveCoverCandidate(id)
+	if err != nil || active == nil || active.Id != folder.Id {
+		t.Errorf("Expected the folder candidate active, got %+v (%v)", active, err)
+	}
+
+	// The active candidate can't be deleted, the others can
+	w = httptest.NewRecorder()
+	cfg.handlerDeleteCoverCandidate(id, w, coverRequest("DELETE", folder))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 deleting the active candidate, got %d", w.Code)
+	}
+
+	metadataCandidate := bySource[database.CoverSourceMetadata]
+	w = httptest.NewRecorder()
+	cfg.handlerDeleteCoverCandidate(id, w, coverRequest("DELETE", metadataCandidate))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(cfg.coverCandidatePath(metadataCandidate)); !os.IsNotExist(err) {
+		t.Error("Expected the deleted candidate's image removed")
+	}
+	if candidates, _ := cfg.db.GetCoverCandidates(id); len(candidates) != 2 {
+		t.Errorf("Expected 2 candidates left, got %d", len(candidates))
+	}
+
+	// Another book's candidate isn't found through this book
+	other := addTestBookWithCover(t, cfg, "Hyperion", 100, 100)
+	w = httptest.NewRecorder()
+	cfg.handlerDeleteCoverCandidate(other, w, coverRequest("DELETE", bySource[database.CoverSourceDownload]))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another book's candidate, got %d", w.Code)
+	}
+
+	// A candidate that can't be recorded leaves no image in the gallery
+	missing := uuid.New()
+	_, err = cfg.storeCoverCandidate(missing, path.Join(bookPath, "back.jpg"), database.CoverCandidateParams{Source: database.CoverSourceFolder})
+	if err == nil {
+		t.Fatal("Expected storing a candidate for a missing book to fail")
+	}
+	if entries, _ := os.ReadDir(cfg.coverCandidatesPath(missing)); len(entries) != 0 {
+		t.Errorf("Expected nothing left in the gallery, found %d files", len(entries))
+	}
+}
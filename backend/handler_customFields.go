@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+)
+
+// Responds with 400 for invalid definitions and values, 404 and 409 for missing and taken keys, or a database error
+func respondWithCustomFieldError(w http.ResponseWriter, err error) {
+
+	var fieldErr database.CustomFieldError
+	switch {
+	case errors.As(err, &fieldErr):
+		respondWithError(w, http.StatusBadRequest, fieldErr.Error(), err)
+	case errors.Is(err, sql.ErrNoRows):
+		respondWithError(w, http.StatusNotFound, "Custom field "+NotFoundError, err)
+	case errors.Is(err, database.ErrCustomFieldExists):
+		respondWithError(w, http.StatusConflict, "A custom field with that key already exists", err)
+	default:
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+	}
+}
+
+func (cfg *apiConfig) handlerGetCustomFields(w http.ResponseWriter, r *http.Request) {
+
+	fields, err := cfg.db.GetCustomFields()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []database.CustomField `json:"values"`
+	}{fields})
+}
+
+func (cfg *apiConfig) handlerPostCustomField(w http.ResponseWriter, r *http.Request) {
+
+	var params database.CustomFieldParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	var field database.CustomField
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		field, err = c.AddCustomField(params)
+		return err
+	})
+	if err != nil {
+		respondWithCustomFieldError(w, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, field)
+}
+
+func (cfg *apiConfig) handlerGetCustomField(w http.ResponseWriter, r *http.Request) {
+
+	field, err := cfg.db.GetCustomField(r.PathValue("key"))
+	if err != nil {
+		respondWithCustomFieldError(w, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, field)
+}
+
+func (cfg *apiConfig) handlerUpdateCustomField(w http.ResponseWriter, r *http.Request) {
+
+	var params database.CustomFieldParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	var field database.CustomField
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		field, err = c.UpdateCustomField(r.PathValue("key"), params)
+		return err
+	})
+	if err != nil {
+		respondWithCustomFieldError(w, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, field)
+}
+
+// Books lose their values for the field. Their metadata.json files keep them until the books are next written
+func (cfg *apiConfig) handlerDeleteCustomField(w http.ResponseWriter, r *http.Request) {
+
+	err := cfg.db.DeleteCustomField(r.PathValue("key"))
+	if err != nil {
+		respondWithCustomFieldError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -5,11 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path"
 
+	"github.com/Ethanol2/book-organizer/internal/database"
 	"github.com/Ethanol2/book-organizer/internal/fileManagement"
 	"github.com/Ethanol2/book-organizer/internal/metadata"
 	"github.com/google/uuid"
@@ -63,6 +65,10 @@ func (cfg *apiConfig) handlerAssociateDownloadToBook(downloadId uuid.UUID, w htt
 	var bookIdStruct struct {
 		BookId             uuid.UUID `json:"book_id"`
 		UseDownloadedCover bool      `json:"use_downloaded_cover"`
+
+		// Attaches the download as an additional format when the book already has files
+		AsFormat bool `json:"as_format"`
+		database.BookFormatParams
 	}
 	err = json.NewDecoder(r.Body).Decode(&bookIdStruct)
 	if err != nil {
@@ -80,12 +86,30 @@ func (cfg *apiConfig) handlerAssociateDownloadToBook(downloadId uuid.UUID, w htt
 		return
 	}
 
+	hasFiles, err := cfg.db.CheckBookHasFiles(bookIdStruct.BookId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+	if hasFiles {
+		if !bookIdStruct.AsFormat {
+			respondWithError(w, http.StatusConflict, "The book already has files. Set as_format to attach the download as an additional format", nil)
+			return
+		}
+
+		cfg.associateDownloadAsFormat(w, downloadId, downloadDir, bookIdStruct.BookId, bookIdStruct.BookFormatParams)
+		return
+	}
+
 	authorDir, seriesDir, bookDir, err := cfg.db.GetPathComponents(bookIdStruct.BookId)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
 		return
 	}
 
+	before := cfg.snapshotBooks(bookIdStruct.BookId)
+	defer cfg.recordBookChanges(r, "download", before)
+
 	oldPath, newPath, err := fileManagement.MoveFiles(downloadDir, cfg.downloadsPath, bookDir, cfg.libraryPath, authorDir, seriesDir)
 	if err != nil {
 		if os.IsExist(err) {
@@ -115,43 +139,24 @@ func (cfg *apiConfig) handlerAssociateDownloadToBook(downloadId uuid.UUID, w htt
 		return
 	}
 
-	if !bookIdStruct.UseDownloadedCover {
-
-		handleCoverReplacement := func() {
-			fmt.Println()
-			log.Println("Replacing the downloaded cover with the metadata cover")
-
-			newCoverPath := path.Join(newPath, "cover.jpg")
-			if _, err := os.Stat(newCoverPath); err == nil {
-				err = os.Rename(newCoverPath, path.Join(newPath, "old-cover.jpg"))
-				if err != nil {
-					log.Println("Failed to rename existing cover")
-					return
-				}
-			}
-
-			metadataCoverPath := path.Join(cfg.metadataPath, bookIdStruct.BookId.String()+".jpg")
-
-			if _, err := os.Stat(metadataCoverPath); err != nil {
-				log.Println("Couldn't use the metadata cover because it doesn't exist")
-				return
-			}
-
-			_, _, err = cfg.db.UpdateBookCover(bookIdStruct.BookId, "jpg")
-			if err != nil {
-				log.Println(err)
-				return
-			}
+	// Every cover that came with the download is kept in the book's cover gallery. The chosen one is written to the folder as cover.jpg
+	candidates, err := cfg.collectCoverCandidates(bookIdStruct.BookId, newPath, book.Files.Cover)
+	if err != nil {
+		log.Println("Failed to collect the book's cover candidates =>", err)
+	} else if chosen := chooseCoverCandidate(candidates, bookIdStruct.UseDownloadedCover); chosen != nil {
 
-			err = fileManagement.MoveFilesWithPaths(metadataCoverPath, newCoverPath)
-			if err != nil {
-				log.Println("Failed to move metadata cover to the library")
-			}
+		log.Println("Using the", chosen.Source, "cover")
 
+		err = cfg.applyCoverCandidate(bookIdStruct.BookId, *chosen)
+		if err != nil {
+			log.Println("Failed to apply the cover =>", err)
 		}
 
-		handleCoverReplacement()
-		fmt.Println()
+		book, err = cfg.db.GetBook(bookIdStruct.BookId)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+			return
+		}
 	}
 
 	err = fileManagement.CreateMetadataFile(*metadata.BookToMetadata(book), newPath)
@@ -159,11 +164,40 @@ func (cfg *apiConfig) handlerAssociateDownloadToBook(downloadId uuid.UUID, w htt
 		log.Println("failed to create metadata file:", err)
 	}
 
-	book.Files.Prepend(cfg.libraryName)
+	book.Prepend(cfg.libraryName)
 
 	respondWithJson(w, http.StatusOK, book)
 }
 
+// Picks the downloaded cover or the book's current cover, falling back to whichever exists
+func chooseCoverCandidate(candidates []database.CoverCandidate, useDownloaded bool) *database.CoverCandidate {
+
+	var downloaded, current, other *database.CoverCandidate
+	for i := range candidates {
+		switch {
+		case candidates[i].Source == database.CoverSourceDownload:
+			downloaded = &candidates[i]
+		case candidates[i].Active:
+			current = &candidates[i]
+		case candidates[i].Source == database.CoverSourceMetadata && current == nil:
+			current = &candidates[i]
+		case other == nil:
+			other = &candidates[i]
+		}
+	}
+
+	if useDownloaded && downloaded != nil {
+		return downloaded
+	}
+	if current != nil {
+		return current
+	}
+	if downloaded != nil {
+		return downloaded
+	}
+	return other
+}
+
 func (cfg *apiConfig) handlerGetDownloadCover(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
 	download, err := cfg.db.GetDownload(id)
 	if err != nil {
@@ -180,3 +214,185 @@ func (cfg *apiConfig) handlerGetDownloadCover(id uuid.UUID, w http.ResponseWrite
 
 	http.ServeFile(w, r, coverPath)
 }
+
+func (cfg *apiConfig) handlerUnassociateBook(bookId uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	// Without a body the files are returned to the downloads folder
+	var params struct {
+		KeepInLibrary bool `json:"keep_in_library"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	dir, err := cfg.db.GetBookDirectory(bookId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Book "+NotFoundError, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+	if dir == nil {
+		respondWithError(w, http.StatusBadRequest, "The book doesn't have any files to unassociate", nil)
+		return
+	}
+
+	if count, err := cfg.db.CountBookFormats(bookId); err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	} else if count > 0 {
+		respondWithError(w, http.StatusConflict, "The book has additional formats stored in its folder. Remove them before unassociating the book", nil)
+		return
+	}
+
+	bookPath := path.Join(cfg.libraryPath, *dir)
+	downloadDir := path.Base(*dir)
+	downloadPath := path.Join(cfg.downloadsPath, downloadDir)
+
+	before := cfg.snapshotBooks(bookId)
+	defer cfg.recordBookChanges(r, "unassociate", before)
+
+	if !params.KeepInLibrary {
+		if _, err := os.Stat(downloadPath); err == nil {
+			respondWithError(w, http.StatusConflict, "The downloads folder already has a folder named \""+downloadDir+"\"", nil)
+			return
+		}
+	}
+
+	folderPath := bookPath
+	if !params.KeepInLibrary {
+		err = fileManagement.MoveFilesWithPaths(bookPath, downloadPath)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
+			return
+		}
+		folderPath = downloadPath
+	}
+
+	moveBack := func(err error) {
+		if !params.KeepInLibrary {
+			moveErr := fileManagement.MoveFilesWithPaths(downloadPath, bookPath)
+			if moveErr != nil {
+				log.Println(moveErr)
+				respondWithError(w, http.StatusInternalServerError, "Failed to unassociate the book and files, and failed to move the files back into the library", err)
+				return
+			}
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+	}
+
+	var files *fileManagement.Files
+	if !params.KeepInLibrary {
+		contents, err := fileManagement.GetFolderContents(cfg.downloadsPath, downloadDir)
+		if err != nil || contents.Root == nil {
+			moveBack(fmt.Errorf("failed to read the moved folder %s: %w", downloadPath, err))
+			return
+		}
+		files = &contents
+	}
+
+	var download *database.Download
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		download, err = c.UnassociateBook(bookId, files)
+		return err
+	})
+	if err != nil {
+		moveBack(err)
+		return
+	}
+
+	if !params.KeepInLibrary {
+		err = fileManagement.RemoveEmptyParents(cfg.libraryPath, *dir)
+		if err != nil {
+			log.Println("Failed to remove empty library folders =>", err)
+		}
+	}
+
+	// Only once the book is unassociated are the covers swapped back, so a failure before leaves the folder as it was
+	err = cfg.restoreDownloadedCover(bookId, folderPath)
+	if err != nil {
+		log.Println("Unassociated, but failed to restore the downloaded cover =>", err)
+	} else if download != nil {
+		contents, err := fileManagement.GetFolderContents(cfg.downloadsPath, downloadDir)
+		if err == nil && contents.Root != nil {
+			err = cfg.db.UpdateDownloadFiles(download.Id, contents)
+		}
+		if err == nil {
+			download, err = cfg.db.GetDownload(download.Id)
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+			return
+		}
+	}
+
+	book, err := cfg.db.GetBook(bookId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	if download != nil {
+		download.Files.Prepend(cfg.downloadsName)
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Book     database.Book      `json:"book"`
+		Download *database.Download `json:"download"`
+	}{Book: book, Download: download})
+}
+
+// Puts the folder's downloaded cover back as cover.jpg, and keeps the cover the book had in the metadata folder
+func (cfg *apiConfig) restoreDownloadedCover(bookId uuid.UUID, folderPath string) error {
+
+	active, err := cfg.db.GetActiveCoverCandidate(bookId)
+	if err != nil {
+		return err
+	}
+	if active != nil {
+		err = fileManagement.CopyFile(cfg.coverCandidatePath(*active), path.Join(cfg.metadataPath, bookId.String()+".jpg"))
+		if err != nil {
+			return err
+		}
+
+		if active.Source != database.CoverSourceDownload {
+			candidates, err := cfg.db.GetCoverCandidates(bookId)
+			if err != nil {
+				return err
+			}
+
+			for _, candidate := range candidates {
+				if candidate.Source != database.CoverSourceDownload {
+					continue
+				}
+				err = fileManagement.CopyFile(cfg.coverCandidatePath(candidate), path.Join(folderPath, "cover.jpg"))
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	// Undo the cover swap done by associations made before cover candidates existed
+	oldCoverPath := path.Join(folderPath, "old-cover.jpg")
+	if _, err := os.Stat(oldCoverPath); err == nil {
+		log.Println("Restoring the downloaded cover")
+
+		coverPath := path.Join(folderPath, "cover.jpg")
+		if _, err := os.Stat(coverPath); err == nil {
+			err = fileManagement.MoveFilesWithPaths(coverPath, path.Join(cfg.metadataPath, bookId.String()+".jpg"))
+			if err != nil {
+				return err
+			}
+		}
+
+		return fileManagement.MoveFilesWithPaths(oldCoverPath, coverPath)
+	}
+
+	return nil
+}
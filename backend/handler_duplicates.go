@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/Ethanol2/book-organizer/internal/metadata"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerGetDuplicateBooks(w http.ResponseWriter, r *http.Request) {
+
+	groups, err := cfg.db.FindDuplicateBooks()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []database.DuplicateGroup `json:"values"`
+	}{groups})
+}
+
+// Merges the sources into the target. The kept files are moved to the merged book's folder, and the folders of the
+// other books with files are moved into it as additional formats. Problems after the merge is saved, like a folder
+// that couldn't be moved, are listed in errors without undoing the merge
+func (cfg *apiConfig) handlerMergeBooks(w http.ResponseWriter, r *http.Request) {
+
+	var params database.BookMergeParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	books := append([]uuid.UUID{params.Target}, params.Sources...)
+	dirs := map[uuid.UUID]*string{}
+	for _, id := range books {
+		if !cfg.checkBookExists(w, id) {
+			return
+		}
+		dirs[id], err = cfg.db.GetBookDirectory(id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+			return
+		}
+	}
+
+	// The files kept as the book's files. The others become formats nested in their folder
+	if params.Files == nil {
+		for _, id := range books {
+			if dirs[id] != nil {
+				params.Files = &id
+				break
+			}
+		}
+	} else if dir, ok := dirs[*params.Files]; !ok {
+		respondWithError(w, http.StatusBadRequest, "The book chosen for the files isn't one of the books being merged", nil)
+		return
+	} else if dir == nil {
+		respondWithError(w, http.StatusBadRequest, "The book chosen for the files doesn't have any", nil)
+		return
+	}
+
+	formatDirs := map[uuid.UUID]string{}
+	if params.Files != nil {
+		keptDir := *dirs[*params.Files]
+		used := []string{}
+		for _, id := range books {
+			if id == *params.Files || dirs[id] == nil {
+				continue
+			}
+
+			// Formats are nested in their book's folder, which would leave them a level too deep
+			if count, err := cfg.db.CountBookFormats(id); err != nil {
+				respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+				return
+			} else if count > 0 {
+				respondWithError(w, http.StatusConflict, fmt.Sprintf("%s has additional formats. Only the book whose files are kept can have formats", id), nil)
+				return
+			}
+
+			name := fileManagement.SafeFolderName(path.Base(*dirs[id]))
+			unique := fileManagement.UniqueFolderName(path.Join(cfg.libraryPath, keptDir), name)
+			for i := 2; slices.Contains(used, unique); i++ {
+				unique = fileManagement.UniqueFolderName(path.Join(cfg.libraryPath, keptDir), fmt.Sprintf("%s (%d)", name, i))
+			}
+			used = append(used, unique)
+			formatDirs[id] = path.Join(keptDir, unique)
+		}
+	}
+
+	before := cfg.snapshotBooks(params.Target)
+
+	var merged database.BookSnapshot
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		_, merged, err = c.MergeBooks(params, formatDirs)
+		return err
+	})
+	var mergeErr database.MergeError
+	if errors.As(err, &mergeErr) {
+		respondWithError(w, http.StatusBadRequest, mergeErr.Msg, err)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+	defer cfg.recordBookChanges(r, "merge", before)
+
+	errs := []string{}
+	fail := func(msg string, err error) {
+		log.Println(msg, "=>", err)
+		errs = append(errs, fmt.Sprintf("%s: %s", msg, err))
+	}
+
+	for id, dir := range formatDirs {
+		err = fileManagement.MoveFilesWithPaths(path.Join(cfg.libraryPath, *dirs[id]), path.Join(cfg.libraryPath, dir))
+		if err != nil {
+			fail(fmt.Sprintf("Failed to move the files of %s", id), err)
+		}
+	}
+	if params.Files != nil {
+		err = cfg.relocateBook(params.Target, *dirs[*params.Files])
+		if err != nil {
+			fail("Failed to move the book's files", err)
+		}
+	}
+
+	// The sources' covers are in the target's gallery now
+	err = fileManagement.CreateDirectory(cfg.coverCandidatesPath(params.Target))
+	if err != nil {
+		fail("Failed to create the cover gallery", err)
+	}
+	for _, id := range params.Sources {
+		entries, err := os.ReadDir(cfg.coverCandidatesPath(id))
+		if err != nil && !os.IsNotExist(err) {
+			fail(fmt.Sprintf("Failed to read the covers of %s", id), err)
+		}
+		for _, entry := range entries {
+			err = fileManagement.MoveFilesWithPaths(path.Join(cfg.coverCandidatesPath(id), entry.Name()), path.Join(cfg.coverCandidatesPath(params.Target), entry.Name()))
+			if err != nil {
+				fail(fmt.Sprintf("Failed to move the cover %s", entry.Name()), err)
+			}
+		}
+
+		for _, p := range []string{cfg.coverCandidatesPath(id), path.Join(cfg.metadataPath, id.String()+".jpg")} {
+			if err := fileManagement.DeleteFiles(p); err != nil {
+				log.Println(err)
+			}
+		}
+		if err := fileManagement.DeleteThumbnails(cfg.thumbnailsPath(), id.String()); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// The cover is written again when the files came from another book, so the folder has the merged book's cover
+	filesMoved := params.Files != nil && *params.Files != params.Target
+	if merged.Cover != nil && (filesMoved || before[params.Target].Cover == nil || *before[params.Target].Cover != *merged.Cover) {
+		candidate, err := cfg.db.GetCoverCandidate(*merged.Cover)
+		if err == nil {
+			err = cfg.applyCoverCandidate(params.Target, candidate)
+		}
+		if err != nil {
+			fail("Failed to set the cover", err)
+		}
+	}
+
+	book, err := cfg.db.GetBook(params.Target)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	if book.Files.Root != nil {
+		err = fileManagement.CreateMetadataFile(*metadata.BookToMetadata(book), path.Join(cfg.libraryPath, *book.Files.Root))
+		if err != nil {
+			fail("Failed to update the metadata file", err)
+		}
+	}
+
+	book.Prepend(cfg.libraryName)
+	respondWithJson(w, http.StatusOK, struct {
+		Book   database.Book `json:"book"`
+		Errors []string      `json:"errors"`
+	}{book, errs})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+)
+
+// The export formats, with their file extension and content type
+var exportFormats = map[string]struct {
+	extension   string
+	contentType string
+	export      func(c *database.Client, w io.Writer, filters map[string][]string) error
+}{
+	"json":      {"json", "application/json", (*database.Client).ExportJSON},
+	"csv":       {"csv", "text/csv; charset=utf-8", (*database.Client).ExportCSV},
+	"csv-files": {"csv", "text/csv; charset=utf-8", (*database.Client).ExportFilesCSV},
+}
+
+// Streams the books matching the GET /api/books filters as a download, in the format of the format query param
+func (cfg *apiConfig) handlerExportLibrary(w http.ResponseWriter, r *http.Request) {
+
+	filters, ok := cfg.requestBookFilters(w, r)
+	if !ok || !cfg.checkBookFilters(w, r, filters) {
+		return
+	}
+
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "json"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Export formats are 'json', 'csv' and 'csv-files'", fmt.Errorf("invalid export format: %s", name))
+		return
+	}
+
+	out := &attachmentWriter{
+		w:           w,
+		filename:    fmt.Sprintf("library-%s.%s", time.Now().Format("20060102"), format.extension),
+		contentType: format.contentType,
+	}
+	err := format.export(&cfg.db, out, filters)
+	if err != nil {
+		if !out.started {
+			respondWithSearchError(w, err)
+			return
+		}
+		// Too late to respond with an error. The download ends early
+		log.Println("Library export failed partway =>", err)
+	}
+}
+
+// Sends the download headers with the first write, so an error before anything is written can still be responded with
+type attachmentWriter struct {
+	w           http.ResponseWriter
+	filename    string
+	contentType string
+	started     bool
+}
+
+func (a *attachmentWriter) Write(p []byte) (int, error) {
+	if !a.started {
+		a.w.Header().Set("Content-Type", a.contentType)
+		a.w.Header().Set("Content-Disposition", "attachment; filename=\""+a.filename+"\"")
+		a.w.WriteHeader(http.StatusOK)
+		a.started = true
+	}
+	return a.w.Write(p)
+}
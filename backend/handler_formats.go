@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/google/uuid"
+)
+
+// Moves a download into a folder nested in the book's folder and records it as an additional format
+func (cfg *apiConfig) associateDownloadAsFormat(w http.ResponseWriter, downloadId uuid.UUID, downloadDir string, bookId uuid.UUID, params database.BookFormatParams) {
+
+	bookDir, err := cfg.db.GetBookDirectory(bookId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	download, err := cfg.db.GetDownload(downloadId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	if params.Label == nil || strings.TrimSpace(*params.Label) == "" {
+		kind := string(database.FormatKindOf(download.Files))
+		label := strings.ToUpper(kind[:1]) + kind[1:]
+		params.Label = &label
+	}
+
+	bookPath := path.Join(cfg.libraryPath, *bookDir)
+	formatDir := fileManagement.UniqueFolderName(bookPath, fileManagement.SafeFolderName(*params.Label))
+
+	oldPath := path.Join(cfg.downloadsPath, downloadDir)
+	newPath := path.Join(bookPath, formatDir)
+
+	err = fileManagement.MoveFilesWithPaths(oldPath, newPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
+		return
+	}
+
+	var book database.Book
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		_, err := c.AssociateFormatAndDownload(bookId, downloadId, path.Join(*bookDir, formatDir), params)
+		if err != nil {
+			return err
+		}
+
+		book, err = c.GetBook(bookId)
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+		err = fileManagement.MoveFilesWithPaths(newPath, oldPath)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to add the format to the book, and failed to move files back from the library to downloads", err)
+			return
+		}
+
+		respondWithError(w, http.StatusInternalServerError, "Failed to add the format to the book. Files have been returned to downloads", err)
+		return
+	}
+
+	book.Prepend(cfg.libraryName)
+
+	respondWithJson(w, http.StatusOK, book)
+}
+
+func (cfg *apiConfig) handlerGetBookFormats(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	if exists, err := cfg.db.CheckBookExistsID(id); err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	} else if !exists {
+		respondWithError(w, http.StatusNotFound, "Book "+NotFoundError, nil)
+		return
+	}
+
+	formats, err := cfg.db.GetBookFormats(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	for i := range formats {
+		formats[i].Files.Prepend(cfg.libraryName)
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []database.BookFormat `json:"values"`
+	}{formats})
+}
+
+func (cfg *apiConfig) handlerUpdateBookFormat(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	format, ok := cfg.getBookFormatFromPath(id, w, r)
+	if !ok {
+		return
+	}
+
+	var params database.BookFormatParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		format, err = c.UpdateBookFormat(format.Id, params)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	format.Files.Prepend(cfg.libraryName)
+
+	respondWithJson(w, http.StatusOK, format)
+}
+
+func (cfg *apiConfig) handlerDeleteBookFormat(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	format, ok := cfg.getBookFormatFromPath(id, w, r)
+	if !ok {
+		return
+	}
+
+	// Without files=true the folder goes back to the downloads, so it isn't left in the book's folder untracked
+	deleteFiles := r.URL.Query().Get("files") == "true"
+	toDownloads := r.URL.Query().Get("downloads") == "true"
+
+	if deleteFiles && toDownloads {
+		respondWithError(w, http.StatusBadRequest, "Format files can either be deleted or returned to downloads, not both", nil)
+		return
+	}
+
+	// The folder is moved out of the book's folder before the format is deleted, and moved back if that fails. Files
+	// to delete are only deleted once the format is
+	var formatPath, movedPath string
+	if format.Files.Root != nil {
+		formatPath = path.Join(cfg.libraryPath, *format.Files.Root)
+
+		if deleteFiles {
+			movedPath = path.Join(path.Dir(formatPath), fileManagement.UniqueFolderName(path.Dir(formatPath), ".deleting-"+path.Base(formatPath)))
+		} else {
+			movedPath = path.Join(cfg.downloadsPath, fileManagement.UniqueFolderName(cfg.downloadsPath, path.Base(formatPath)))
+		}
+
+		err := fileManagement.MoveFilesWithPaths(formatPath, movedPath)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
+			return
+		}
+	}
+
+	err := cfg.db.HandleTransaction(func(c *database.Client) error {
+		return c.DeleteBookFormat(format.Id)
+	})
+	if err != nil {
+		if movedPath != "" {
+			moveErr := fileManagement.MoveFilesWithPaths(movedPath, formatPath)
+			if moveErr != nil {
+				log.Println(moveErr)
+				respondWithError(w, http.StatusInternalServerError, "Failed to remove the format, and failed to move its files back", err)
+				return
+			}
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	if deleteFiles && movedPath != "" {
+		log.Println("Deleting files for the format \"", format.Label, "\"")
+		err = fileManagement.DeleteFiles(movedPath)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, FileDeleteError, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) getBookFormatFromPath(bookId uuid.UUID, w http.ResponseWriter, r *http.Request) (database.BookFormat, bool) {
+
+	formatId, err := uuid.Parse(r.PathValue("formatId"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid format id", err)
+		return database.BookFormat{}, false
+	}
+
+	format, err := cfg.db.GetBookFormat(formatId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Format "+NotFoundError, err)
+			return database.BookFormat{}, false
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return database.BookFormat{}, false
+	}
+
+	if format.BookId != bookId {
+		respondWithError(w, http.StatusNotFound, "Format "+NotFoundError, nil)
+		return database.BookFormat{}, false
+	}
+
+	return format, true
+}
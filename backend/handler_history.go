@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/Ethanol2/book-organizer/internal/metadata"
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+)
+
+// The books as they are before a change, to be passed to recordBookChanges once the change is made
+func (cfg *apiConfig) snapshotBooks(ids ...uuid.UUID) map[uuid.UUID]database.BookSnapshot {
+
+	snapshots, err := cfg.db.GetBookSnapshots(ids)
+	if err != nil {
+		log.Println("Failed to get the books before the change =>", err)
+		return map[uuid.UUID]database.BookSnapshot{}
+	}
+
+	return snapshots
+}
+
+// Adds a history entry to each book that changed since its snapshot. The change has already been made,
+// so a failure here is only logged
+func (cfg *apiConfig) recordBookChanges(r *http.Request, action string, before map[uuid.UUID]database.BookSnapshot) {
+
+	params := database.BookHistoryParams{UserId: cfg.getUserId(r), Action: action}
+	err := cfg.db.HandleTransaction(func(c *database.Client) error {
+		_, err := c.RecordBookChanges(before, params)
+		return err
+	})
+	if err != nil {
+		log.Println("Failed to record the book history =>", err)
+	}
+}
+
+func (cfg *apiConfig) handlerGetBookHistory(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	if !cfg.checkBookExists(w, id) {
+		return
+	}
+
+	history, err := cfg.db.GetBookHistory(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []database.BookHistoryEntry `json:"values"`
+	}{history})
+}
+
+// Puts the book back the way it was before the chosen change, moving its folder and cover back too.
+// The revert is itself added to the history, so it can be undone the same way
+func (cfg *apiConfig) handlerRevertBook(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	entryId, err := strconv.Atoi(r.PathValue("entryId"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid history entry id", err)
+		return
+	}
+
+	entry, snapshot, err := cfg.db.GetBookHistoryEntry(id, entryId)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "History entry "+NotFoundError, err)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	before := cfg.snapshotBooks(id)
+
+	oldPath, err := cfg.db.GetBookDirectory(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	// The folder is moved before the restore commits, so a folder that can't be moved leaves the book as it was
+	var movedTo *string
+	var moveErr error
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		book, err := c.RestoreBookSnapshot(id, snapshot)
+		if err != nil || oldPath == nil || book.Files.Root == nil || *book.Files.Root == *oldPath {
+			return err
+		}
+
+		moveErr = cfg.moveLibraryFolder(*oldPath, *book.Files.Root)
+		if moveErr != nil {
+			return moveErr
+		}
+		movedTo = book.Files.Root
+		return nil
+	})
+	if err != nil {
+		if movedTo != nil {
+			if err := cfg.moveLibraryFolder(*movedTo, *oldPath); err != nil {
+				log.Println("Failed to move the folder back after the revert failed =>", err)
+			}
+		}
+
+		var sqliteErr sqlite3.Error
+		switch {
+		case errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique:
+			respondWithError(w, http.StatusConflict, "Another book has the ISBN or ASIN the book had then", err)
+		case moveErr != nil:
+			respondWithError(w, http.StatusInternalServerError, FileMoveError, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		}
+		return
+	}
+
+	// The cover only comes back if it's still in the book's gallery
+	if snapshot.Cover != nil && (before[id].Cover == nil || *before[id].Cover != *snapshot.Cover) {
+		candidate, err := cfg.db.GetCoverCandidate(*snapshot.Cover)
+		if err == nil && candidate.BookId == id {
+			err = cfg.applyCoverCandidate(id, candidate)
+		}
+		if err != nil {
+			log.Println("Failed to restore the cover =>", err)
+		}
+	}
+
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		_, err := c.RecordBookChange(id, before[id], database.BookHistoryParams{
+			UserId:  cfg.getUserId(r),
+			Action:  "revert",
+			Reverts: &entry.Id,
+		})
+		return err
+	})
+	if err != nil {
+		log.Println("Failed to record the book history =>", err)
+	}
+
+	book, err := cfg.db.GetBook(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	if book.Files.Root != nil {
+		fileManagement.CreateMetadataFile(*metadata.BookToMetadata(book), path.Join(cfg.libraryPath, *book.Files.Root))
+	}
+
+	book.Prepend(cfg.libraryName)
+	respondWithJson(w, http.StatusOK, book)
+}
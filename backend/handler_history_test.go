@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+)
+
+func TestRevertBook(t *testing.T) {
+	cfg := setupTestConfig(t)
+
+	// A book in the folder its author and title call for
+	book, err := cfg.db.AddBook(database.BookParams{
+		Title:   ptr("Dune"),
+		Authors: &[]database.Category{{Name: "Frank Herbert"}},
+		ISBN:    ptr("9780441172719"),
+	})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+	id := *book.Id
+	err = cfg.db.UpdateBookFiles(id, fileManagement.Files{Root: ptr("tmp"), AudioFiles: &[]string{"tmp/01.mp3"}, TextFiles: &[]string{}})
+	if err != nil {
+		t.Fatalf("UpdateBookFiles failed: %v", err)
+	}
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		book, _, err = c.RelocateBook(id)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("RelocateBook failed: %v", err)
+	}
+	oldRoot := *book.Files.Root
+	writeTestFile(t, path.Join(cfg.libraryPath, oldRoot, "01.mp3"))
+
+	// A change that renames the book and moves its folder
+	r := httptest.NewRequest("POST", "/", nil)
+	before := cfg.snapshotBooks(id)
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		book, _, err = c.UpdateBook(id, database.BookParams{Title: ptr("Dune Messiah"), ISBN: ptr("9780593098233")})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("UpdateBook failed: %v", err)
+	}
+	newRoot := *book.Files.Root
+	err = cfg.relocateBook(id, oldRoot)
+	if err != nil {
+		t.Fatalf("relocateBook failed: %v", err)
+	}
+	cfg.recordBookChanges(r, "update", before)
+
+	history, err := cfg.db.GetBookHistory(id)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("Expected the update in the history, got %+v (%v)", history, err)
+	}
+
+	revert := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.SetPathValue("entryId", strconv.Itoa(history[0].Id))
+		w := httptest.NewRecorder()
+		cfg.handlerRevertBook(id, w, r)
+		return w
+	}
+	expectBook := func(title, root string) {
+		t.Helper()
+		got, err := cfg.db.GetBook(id)
+		if err != nil {
+			t.Fatalf("GetBook failed: %v", err)
+		}
+		if got.Title != title || *got.Files.Root != root {
+			t.Errorf("Expected %q in %s, got %q in %s", title, root, got.Title, *got.Files.Root)
+		}
+		if _, err := os.Stat(path.Join(cfg.libraryPath, root, "01.mp3")); err != nil {
+			t.Errorf("Expected the files in %s: %v", root, err)
+		}
+	}
+
+	// The old folder is taken, so the revert is undone
+	writeTestFile(t, path.Join(cfg.libraryPath, oldRoot, "other.mp3"))
+	if w := revert(); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when the folder can't be moved, got %d: %s", w.Code, w.Body.String())
+	}
+	expectBook("Dune Messiah", newRoot)
+	os.RemoveAll(path.Join(cfg.libraryPath, oldRoot))
+
+	// Another book has taken the old ISBN since
+	other, err := cfg.db.AddBook(database.BookParams{Title: ptr("Dune (copy)"), ISBN: ptr("9780441172719")})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+	if w := revert(); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for an ISBN another book has, got %d: %s", w.Code, w.Body.String())
+	}
+	expectBook("Dune Messiah", newRoot)
+
+	err = cfg.db.DeleteBook(*other.Id)
+	if err != nil {
+		t.Fatalf("DeleteBook failed: %v", err)
+	}
+	if w := revert(); w.Code != http.StatusOK {
+		t.Fatalf("Expected the revert to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	expectBook("Dune", oldRoot)
+	if _, err := os.Stat(path.Join(cfg.libraryPath, newRoot)); !os.IsNotExist(err) {
+		t.Error("Expected the folder moved back")
+	}
+
+	if history, _ = cfg.db.GetBookHistory(id); len(history) != 2 || history[0].Action != "revert" {
+		t.Errorf("Expected the revert in the history, got %+v", history)
+	}
+}
+
+func writeTestFile(t *testing.T, p string) {
+	t.Helper()
+
+	err := os.MkdirAll(path.Dir(p), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(p, []byte{}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/google/uuid"
+)
+
+// The identifier type holding each metadata source's record id
+var metadataSourceIdentifiers = map[string]string{
+	"open library": database.IdentifierOpenLibrary,
+	"google books": database.IdentifierGoogle,
+	"audible":      database.IdentifierASIN,
+}
+
+func (cfg *apiConfig) respondWithIdentifiers(w http.ResponseWriter, id uuid.UUID) {
+
+	identifiers, err := cfg.db.GetBookIdentifiers(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []database.BookIdentifier `json:"values"`
+	}{identifiers})
+}
+
+// Responds with 404 and returns false if the book doesn't exist
+func (cfg *apiConfig) checkBookExists(w http.ResponseWriter, id uuid.UUID) bool {
+
+	if exists, err := cfg.db.CheckBookExistsID(id); err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return false
+	} else if !exists {
+		respondWithError(w, http.StatusNotFound, "Book "+NotFoundError, nil)
+		return false
+	}
+
+	return true
+}
+
+// Finds a book by any of its identifiers. type is optional
+func (cfg *apiConfig) handlerLookupBook(w http.ResponseWriter, r *http.Request) {
+
+	identifierType := r.URL.Query().Get("type")
+	value := r.URL.Query().Get("value")
+	if value == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing the identifier value", nil)
+		return
+	}
+	if identifierType != "" {
+		if _, err := database.NormalizeIdentifier(identifierType, value); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	id, found, err := cfg.db.FindBookByIdentifier(identifierType, value)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Book "+NotFoundError, nil)
+		return
+	}
+
+	cfg.handlerGetBook(id, w, r)
+}
+
+func (cfg *apiConfig) handlerGetBookIdentifiers(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	if !cfg.checkBookExists(w, id) {
+		return
+	}
+
+	cfg.respondWithIdentifiers(w, id)
+}
+
+func (cfg *apiConfig) handlerAddBookIdentifier(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	var params database.BookIdentifier
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if !cfg.checkBookExists(w, id) {
+		return
+	}
+
+	if _, err := database.NormalizeIdentifier(params.Type, params.Value); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	err = cfg.db.AddBookIdentifier(id, params)
+	if err != nil {
+		if errors.Is(err, database.ErrIdentifierTaken) {
+			respondWithError(w, http.StatusConflict, "Another book has the identifier", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	cfg.respondWithIdentifiers(w, id)
+}
+
+func (cfg *apiConfig) handlerDeleteBookIdentifier(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	err := cfg.db.RemoveBookIdentifier(id, r.PathValue("type"), r.PathValue("value"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Fetches the book's record from a metadata source using the identifier the source gave it. Nothing is changed,
+// the result can be sent to PATCH /api/books/{id}
+func (cfg *apiConfig) handlerGetBookMetadata(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	source := r.URL.Query().Get("source")
+	identifierType, ok := metadataSourceIdentifiers[source]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, MetadataSourceError, fmt.Errorf("invalid source: %s", source))
+		return
+	}
+
+	if !cfg.checkBookExists(w, id) {
+		return
+	}
+
+	recordId, found, err := cfg.db.GetBookIdentifier(id, identifierType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("The book doesn't have a %s identifier", identifierType), sql.ErrNoRows)
+		return
+	}
+
+	region := r.URL.Query().Get("region")
+	if region == "" {
+		region = "com"
+	}
+
+	result, ok := cfg.getMetadataDetails(w, source, recordId, region)
+	if !ok {
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, result)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/Ethanol2/book-organizer/internal/metadata"
+)
+
+// Imports books from a library export or a CSV. Books that match one in the library by id or identifier update it, the
+// rest are added, and folders in the library are linked to the books by path. With dry_run the import runs and is
+// rolled back, so the results show what it would do. Conflicts are identifiers other books, or earlier rows, already
+// have, and are handled as on_conflict says
+func (cfg *apiConfig) handlerImportLibrary(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+	dryRun := query.Get("dry_run") == "true"
+	opts := database.ImportOptions{
+		OnMatch:    query.Get("on_match"),
+		OnConflict: query.Get("on_conflict"),
+		UserId:     cfg.getUserId(r),
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			format = "csv"
+		}
+	}
+
+	var fields []database.CustomField
+	var items []database.ImportItem
+	var err error
+	switch format {
+	case "json":
+		fields, items, err = database.ParseLibraryJSON(r.Body)
+
+	case "csv":
+		// map.<column>=<header> reads the column from a CSV column with another name
+		mapping := map[string]string{}
+		for key, values := range query {
+			if column, ok := strings.CutPrefix(key, "map."); ok {
+				mapping[column] = values[0]
+			}
+		}
+		items, err = cfg.db.ParseLibraryCSV(r.Body, mapping)
+
+	default:
+		respondWithError(w, http.StatusBadRequest, "Import formats are 'json' and 'csv'", fmt.Errorf("invalid import format: %s", format))
+		return
+	}
+	var importErr database.ImportError
+	if errors.As(err, &importErr) {
+		respondWithError(w, http.StatusBadRequest, importErr.Msg, err)
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	// Folders are only linked when they're in the library and hold a book
+	for i, item := range items {
+		if item.Root == nil {
+			continue
+		}
+		root := path.Clean(*item.Root)
+		if !filepath.IsLocal(root) {
+			continue
+		}
+		files, err := fileManagement.GetFolderContents(cfg.libraryPath, root)
+		if err != nil || files.Root == nil || files.HasNoFiles() {
+			continue
+		}
+		items[i].Files = &files
+	}
+
+	var result database.ImportResult
+	errDryRun := errors.New("dry run")
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		result, err = c.ImportBooks(fields, items, opts)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	result.DryRun = dryRun
+	if errors.Is(err, database.ErrImportConflicts) {
+		if dryRun {
+			respondWithJson(w, http.StatusOK, result)
+			return
+		}
+		respondWithJson(w, http.StatusConflict, result)
+		return
+	} else if errors.As(err, &importErr) {
+		respondWithError(w, http.StatusBadRequest, importErr.Msg, err)
+		return
+	} else if err != nil && !errors.Is(err, errDryRun) {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	if dryRun {
+		respondWithJson(w, http.StatusOK, result)
+		return
+	}
+
+	// The folders of updated books are moved if the update changed where they go, and the metadata files of books with
+	// folders are written. Failures are added to the book's warnings without undoing the import
+	for i, item := range result.Items {
+		if item.Action != database.ImportActionCreate && item.Action != database.ImportActionUpdate {
+			continue
+		}
+
+		warn := func(msg string, err error) {
+			log.Println(msg, item.BookId, "=>", err)
+			result.Items[i].Warnings = append(result.Items[i].Warnings, fmt.Sprintf("%s: %s", msg, err))
+		}
+
+		if previous := item.PreviousFolder(); previous != nil {
+			err = cfg.relocateBook(*item.BookId, *previous)
+			if err != nil {
+				warn("Imported, but failed to move the files", err)
+				continue
+			}
+		}
+
+		book, err := cfg.db.GetBook(*item.BookId)
+		if err != nil {
+			warn("Imported, but failed to get the book", err)
+			continue
+		}
+		if book.Files.Root != nil {
+			err = fileManagement.CreateMetadataFile(*metadata.BookToMetadata(book), path.Join(cfg.libraryPath, *book.Files.Root))
+			if err != nil {
+				warn("Imported, but failed to update the metadata file", err)
+			}
+		}
+	}
+
+	respondWithJson(w, http.StatusOK, result)
+}
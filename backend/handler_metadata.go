@@ -107,51 +107,60 @@ func (cfg *apiConfig) handlerGetMetadataBookDetails(w http.ResponseWriter, r *ht
 		return
 	}
 
+	result, ok := cfg.getMetadataDetails(w, r.URL.Query().Get("source"), id, r.URL.Query().Get("region"))
+	if !ok {
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, result)
+}
+
+// Gets a record from a metadata source. Responds with the error and returns false when the record can't be fetched
+func (cfg *apiConfig) getMetadataDetails(w http.ResponseWriter, source, id, region string) (database.BookParams, bool) {
+
 	var result database.BookParams
 	var err error
-	switch r.URL.Query().Get("source") {
+	switch source {
 
 	case "":
 		respondWithError(w, http.StatusBadRequest, MetadataSourceError, fmt.Errorf(MetadataSourceError))
-		return
+		return database.BookParams{}, false
 
 	case "open library":
 		result, err = metadata.GetFromOpenLibrary(id, &cfg.mdCache)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, MetadataFetchError, err)
-			return
+			return database.BookParams{}, false
 		}
 
 	case "google books":
 		if cfg.googleBooksApiKey == "" {
 			respondWithError(w, http.StatusInternalServerError, MetadataApiKeyMissing, fmt.Errorf(MetadataApiKeyMissing))
-			return
+			return database.BookParams{}, false
 		}
 
 		result, err = metadata.GetFromGoogleBooks(id, cfg.googleBooksApiKey, &cfg.mdCache)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, MetadataFetchError, err)
-			return
+			return database.BookParams{}, false
 		}
 
 	case "audible":
-		region := r.URL.Query().Get("region")
 		if region == "" || !metadata.IsValidAudibleRegion(region) {
 			respondWithError(w, http.StatusBadRequest, "Querying audible requires a valid region. Valid regions are: au, ca, de, es, fr, in, it, jp, us, uk", fmt.Errorf("no valid region provided"))
-			return
+			return database.BookParams{}, false
 		}
 
 		result, err = metadata.GetFromAudible(id, region, &cfg.mdCache)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, MetadataFetchError, err)
-			return
+			return database.BookParams{}, false
 		}
 
 	default:
-		src := r.URL.Query().Get("source")
-		respondWithError(w, http.StatusBadRequest, MetadataSourceError, fmt.Errorf("invalid source: %s", src))
-		return
+		respondWithError(w, http.StatusBadRequest, MetadataSourceError, fmt.Errorf("invalid source: %s", source))
+		return database.BookParams{}, false
 	}
 
-	respondWithJson(w, http.StatusOK, result)
+	return result, true
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/google/uuid"
+)
+
+// Returns the id of the logged in user, or nil when authentication is off
+func (cfg *apiConfig) getUserId(r *http.Request) *uuid.UUID {
+
+	userId, err := authenticate(cfg.authRequired.Load(), r, cfg.tokenSecret)
+	if err != nil || userId == uuid.Nil {
+		return nil
+	}
+
+	return &userId
+}
+
+// Returns the saved search's filters, with the paging and view options of the request that's evaluating it
+func (cfg *apiConfig) savedSearchFilters(r *http.Request, id uuid.UUID) (url.Values, error) {
+
+	search, err := cfg.db.GetSavedSearch(cfg.getUserId(r), id)
+	if err != nil {
+		return url.Values{}, err
+	}
+
+	filters := url.Values(search.Filters)
+	for _, key := range []string{"page", "count", "cursor", "view"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			filters.Set(key, value)
+		}
+	}
+
+	return filters, nil
+}
+
+// Checks that the saved search's query parses, so a broken search isn't found out the first time it's opened
+func validateSavedSearch(w http.ResponseWriter, params database.SavedSearchParams) bool {
+
+	query, ok, err := params.Encode()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid query string", err)
+		return false
+	}
+	if !ok {
+		return true
+	}
+
+	filters, _ := url.ParseQuery(query)
+	_, _, err = database.ParseQuery(filters.Get("q"), false)
+	if err != nil {
+		respondWithSearchError(w, err)
+		return false
+	}
+
+	return true
+}
+
+func (cfg *apiConfig) handlerGetSavedSearches(w http.ResponseWriter, r *http.Request) {
+
+	searches, err := cfg.db.GetSavedSearches(cfg.getUserId(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []database.SavedSearch `json:"values"`
+	}{searches})
+}
+
+func (cfg *apiConfig) handlerPostSavedSearch(w http.ResponseWriter, r *http.Request) {
+
+	var params database.SavedSearchParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if params.Name == nil || strings.TrimSpace(*params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "Saved searches need a name", nil)
+		return
+	}
+	if !validateSavedSearch(w, params) {
+		return
+	}
+
+	search, err := cfg.db.AddSavedSearch(cfg.getUserId(r), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, search)
+}
+
+func (cfg *apiConfig) handlerGetSavedSearch(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	search, err := cfg.db.GetSavedSearch(cfg.getUserId(r), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, NotFoundError, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, search)
+}
+
+func (cfg *apiConfig) handlerUpdateSavedSearch(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	var params database.SavedSearchParams
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if params.Name != nil && strings.TrimSpace(*params.Name) == "" {
+		respondWithError(w, http.StatusBadRequest, "Saved searches need a name", nil)
+		return
+	}
+	if !validateSavedSearch(w, params) {
+		return
+	}
+
+	search, err := cfg.db.UpdateSavedSearch(cfg.getUserId(r), id, params)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, NotFoundError, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, search)
+}
+
+func (cfg *apiConfig) handlerReorderSavedSearches(w http.ResponseWriter, r *http.Request) {
+
+	var params struct {
+		Ids []uuid.UUID `json:"ids"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	userId := cfg.getUserId(r)
+
+	var searches []database.SavedSearch
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		err := c.ReorderSavedSearches(userId, params.Ids)
+		if err != nil {
+			return err
+		}
+
+		searches, err = c.GetSavedSearches(userId)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Values []database.SavedSearch `json:"values"`
+	}{searches})
+}
+
+func (cfg *apiConfig) handlerDeleteSavedSearch(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	userId := cfg.getUserId(r)
+
+	_, err := cfg.db.GetSavedSearch(userId, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, NotFoundError, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	err = cfg.db.DeleteSavedSearch(userId, id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Evaluates the saved search, responding the same way GET /api/books does
+func (cfg *apiConfig) handlerGetSavedSearchBooks(id uuid.UUID, w http.ResponseWriter, r *http.Request) {
+
+	filters, err := cfg.savedSearchFilters(r, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, http.StatusNotFound, NotFoundError, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+
+	log.Println("Evaluating the saved search \"", id, "\"")
+
+	cfg.respondWithBooks(w, r, filters)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/metadata"
+)
+
+func (cfg *apiConfig) respondWithSeries(w http.ResponseWriter, series database.SeriesDetails) {
+
+	overviews := make([]database.BookOverview, len(series.Books))
+	for i := range series.Books {
+		overviews[i] = series.Books[i].BookOverview
+	}
+	cfg.addThumbnails(overviews)
+	for i := range series.Books {
+		series.Books[i].BookOverview = overviews[i]
+	}
+
+	respondWithJson(w, http.StatusOK, series)
+}
+
+func respondWithSeriesError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, NotFoundError, err)
+		return
+	}
+	var seriesErr database.SeriesError
+	if errors.As(err, &seriesErr) {
+		respondWithError(w, http.StatusBadRequest, seriesErr.Msg, err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+}
+
+func (cfg *apiConfig) handlerGetSeries(w http.ResponseWriter, r *http.Request) {
+
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	series, err := cfg.db.GetSeries(id)
+	if err != nil {
+		respondWithSeriesError(w, err)
+		return
+	}
+
+	cfg.respondWithSeries(w, series)
+}
+
+func (cfg *apiConfig) handlerUpdateSeries(w http.ResponseWriter, r *http.Request) {
+
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	var params database.SeriesParams
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if params.TotalBooks != nil && *params.TotalBooks < 0 {
+		respondWithError(w, http.StatusBadRequest, "The total can't be negative", nil)
+		return
+	}
+	if params.Asin != nil && *params.Asin != "" && !metadata.IsValidASIN(*params.Asin) {
+		respondWithError(w, http.StatusBadRequest, "Invalid asin", nil)
+		return
+	}
+
+	series, err := cfg.db.UpdateSeries(id, params)
+	if err != nil {
+		respondWithSeriesError(w, err)
+		return
+	}
+
+	cfg.respondWithSeries(w, series)
+}
+
+// Replaces the series' description and total with Audible's. Needs the series asin, which is saved when a book's metadata comes from Audible
+func (cfg *apiConfig) handlerRefreshSeries(w http.ResponseWriter, r *http.Request) {
+
+	id, err := getCategoryId(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid id", err)
+		return
+	}
+
+	region := r.URL.Query().Get("region")
+	if region == "" {
+		region = "com"
+	}
+	if !metadata.IsValidAudibleRegion(region) {
+		respondWithError(w, http.StatusBadRequest, "Valid audible regions are: co.au, ca, de, es, fr, co.in, it, co.jp, com, co.uk", fmt.Errorf("invalid region => %s", region))
+		return
+	}
+
+	series, err := cfg.db.GetSeries(id)
+	if err != nil {
+		respondWithSeriesError(w, err)
+		return
+	}
+	if series.Asin == nil {
+		respondWithError(w, http.StatusBadRequest, "The series doesn't have an asin. Set one or add a book with Audible metadata first", nil)
+		return
+	}
+
+	params, err := metadata.GetAudibleSeries(*series.Asin, region, &cfg.mdCache)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, MetadataFetchError, err)
+		return
+	}
+
+	series, err = cfg.db.UpdateSeries(id, params)
+	if err != nil {
+		respondWithSeriesError(w, err)
+		return
+	}
+
+	cfg.respondWithSeries(w, series)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/Ethanol2/book-organizer/internal/metadata"
+	"github.com/google/uuid"
+)
+
+// Adds and removes tags on many books at once. The metadata.json files of the changed books are rewritten,
+// failures there are listed in errors without undoing the change
+func (cfg *apiConfig) handlerTagBooks(w http.ResponseWriter, r *http.Request) {
+
+	var params struct {
+		BookIds []uuid.UUID `json:"book_ids"`
+		Add     []string    `json:"add"`
+		Remove  []string    `json:"remove"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, BodyDecodeError, err)
+		return
+	}
+
+	if len(params.BookIds) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No books to tag", nil)
+		return
+	}
+	if len(params.Add) == 0 && len(params.Remove) == 0 {
+		respondWithError(w, http.StatusBadRequest, "No tags to add or remove", nil)
+		return
+	}
+
+	for _, id := range params.BookIds {
+		if !cfg.checkBookExists(w, id) {
+			return
+		}
+	}
+
+	before := cfg.snapshotBooks(params.BookIds...)
+
+	var changed []uuid.UUID
+	err = cfg.db.HandleTransaction(func(c *database.Client) error {
+		changed, err = c.TagBooks(params.BookIds, params.Add, params.Remove)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, DatabaseError, err)
+		return
+	}
+	cfg.recordBookChanges(r, "tags", before)
+
+	errs := []string{}
+	for _, id := range changed {
+		book, err := cfg.db.GetBook(id)
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, fmt.Sprintf("Failed to get %s: %s", id, err))
+			continue
+		}
+		if book.Files.Root == nil {
+			continue
+		}
+
+		err = fileManagement.CreateMetadataFile(*metadata.BookToMetadata(book), path.Join(cfg.libraryPath, *book.Files.Root))
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, fmt.Sprintf("Failed to update the metadata file of \"%s\": %s", book.Title, err))
+		}
+	}
+
+	respondWithJson(w, http.StatusOK, struct {
+		Books  []uuid.UUID `json:"books"`
+		Errors []string    `json:"errors"`
+	}{changed, errs})
+}
@@ -0,0 +1,469 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+)
+
+// Why a backup was made
+const (
+	ReasonScheduled  = "scheduled"
+	ReasonManual     = "manual"
+	ReasonPreRestore = "pre-restore"
+)
+
+// Backups are zip files holding the database, the manifest and optionally the metadata folder
+const (
+	databaseFile = "library.db"
+	manifestFile = "backup.json"
+	metadataDir  = "metadata"
+)
+
+var namePattern = regexp.MustCompile(`^backup-\d{8}-\d{6}(-\d+)?$`)
+
+var ErrNotFound = errors.New("backup not found")
+
+type Manifest struct {
+	Name          string    `json:"name"`
+	CreatedAt     time.Time `json:"created_at"`
+	Reason        string    `json:"reason"`
+	SchemaVersion int       `json:"schema_version"`
+	Metadata      bool      `json:"metadata"`
+	Size          int64     `json:"size"`
+}
+
+type Manager struct {
+	Dir          string
+	MetadataPath string
+
+	// The number of scheduled backups kept. Older ones are deleted after each scheduled backup. 0 keeps them all
+	Keep int
+
+	DB *database.Client
+
+	// One backup or restore at a time
+	mu sync.Mutex
+}
+
+// Backs up the database, and the metadata folder when withMetadata is set
+func (m *Manager) Create(reason string, withMetadata bool) (Manifest, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	manifest, err := m.create(reason, withMetadata)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	if reason == ReasonScheduled {
+		err = m.prune()
+		if err != nil {
+			log.Println("Failed to delete old backups =>", err)
+		}
+	}
+
+	return manifest, nil
+}
+
+func (m *Manager) create(reason string, withMetadata bool) (Manifest, error) {
+
+	err := fileManagement.CreateDirectory(m.Dir)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	version, err := m.DB.GetSchemaVersion()
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	now := time.Now()
+	manifest := Manifest{
+		Name:          "backup-" + now.Format("20060102-150405"),
+		CreatedAt:     now.UTC(),
+		Reason:        reason,
+		SchemaVersion: version,
+		Metadata:      withMetadata,
+	}
+	for i := 2; fileExists(m.path(manifest.Name)); i++ {
+		manifest.Name = fmt.Sprintf("backup-%s-%d", now.Format("20060102-150405"), i)
+	}
+
+	dbCopy := filepath.Join(m.Dir, "."+manifest.Name+".db")
+	defer removeDatabase(dbCopy)
+	err = m.DB.BackupTo(dbCopy)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	// Written under a temporary name, so a backup that fails halfway isn't listed
+	partial := m.path(manifest.Name) + ".partial"
+	defer os.Remove(partial)
+	err = m.writeArchive(partial, dbCopy, manifest)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	err = os.Rename(partial, m.path(manifest.Name))
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	info, err := os.Stat(m.path(manifest.Name))
+	if err != nil {
+		return Manifest{}, err
+	}
+	manifest.Size = info.Size()
+
+	log.Println("Backed up the database to \"", m.path(manifest.Name), "\"")
+	return manifest, nil
+}
+
+func (m *Manager) writeArchive(archivePath, dbCopy string, manifest Manifest) error {
+
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	err = addFile(archive, databaseFile, dbCopy)
+	if err != nil {
+		return err
+	}
+
+	if manifest.Metadata {
+		err = filepath.WalkDir(m.MetadataPath, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(m.MetadataPath, p)
+			if err != nil {
+				return err
+			}
+			return addFile(archive, path.Join(metadataDir, filepath.ToSlash(rel)), p)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := archive.Create(manifestFile)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(w).Encode(manifest)
+	if err != nil {
+		return err
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Lists the backups, newest first
+func (m *Manager) List() ([]Manifest, error) {
+
+	entries, err := os.ReadDir(m.Dir)
+	if os.IsNotExist(err) {
+		return []Manifest{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	manifests := []Manifest{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".zip")
+		if !ok || !namePattern.MatchString(name) {
+			continue
+		}
+
+		manifest, err := m.Get(name)
+		if err != nil {
+			log.Println("Failed to read the backup \"", entry.Name(), "\" =>", err)
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	slices.SortFunc(manifests, func(a, b Manifest) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return manifests, nil
+}
+
+// Reads the backup's manifest. Returns ErrNotFound for names that aren't backups
+func (m *Manager) Get(name string) (Manifest, error) {
+
+	archivePath, err := m.Path(name)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer archive.Close()
+
+	r, err := archive.Open(manifestFile)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("the backup has no manifest: %w", err)
+	}
+	defer r.Close()
+
+	var manifest Manifest
+	err = json.NewDecoder(r).Decode(&manifest)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return Manifest{}, err
+	}
+	manifest.Name = name
+	manifest.Size = info.Size()
+
+	return manifest, nil
+}
+
+// The path of the backup's file. Returns ErrNotFound for names that aren't backups
+func (m *Manager) Path(name string) (string, error) {
+
+	if !namePattern.MatchString(name) || !fileExists(m.path(name)) {
+		return "", ErrNotFound
+	}
+	return m.path(name), nil
+}
+
+func (m *Manager) path(name string) string {
+	return filepath.Join(m.Dir, name+".zip")
+}
+
+// Replaces the database, and the metadata folder if the backup has it, with the backup's. The current state is backed
+// up first, and its manifest returned, so the restore can be undone. Nothing else should write to the database or
+// the metadata folder while this runs
+func (m *Manager) Restore(name string) (Manifest, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	archivePath, err := m.Path(name)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer archive.Close()
+
+	hasMetadata := slices.ContainsFunc(archive.File, func(f *zip.File) bool { return strings.HasPrefix(f.Name, metadataDir+"/") })
+
+	dbCopy := filepath.Join(m.Dir, "."+name+".restore.db")
+	defer removeDatabase(dbCopy)
+	err = extractFile(archive, databaseFile, dbCopy)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("couldn't read the database from the backup: %w", err)
+	}
+
+	// The metadata is unpacked next to the folder it replaces, so the swap is a rename
+	var metadataCopy string
+	if hasMetadata {
+		metadataCopy = filepath.Clean(m.MetadataPath) + ".restore"
+		err = os.RemoveAll(metadataCopy)
+		if err != nil {
+			return Manifest{}, err
+		}
+		defer os.RemoveAll(metadataCopy)
+
+		for _, f := range archive.File {
+			rel, ok := strings.CutPrefix(f.Name, metadataDir+"/")
+			if !ok || rel == "" || strings.HasSuffix(f.Name, "/") {
+				continue
+			}
+			if !filepath.IsLocal(rel) {
+				return Manifest{}, fmt.Errorf("the backup has a file outside the metadata folder: %s", f.Name)
+			}
+			err = extractFile(archive, f.Name, filepath.Join(metadataCopy, filepath.FromSlash(rel)))
+			if err != nil {
+				return Manifest{}, err
+			}
+		}
+	}
+
+	safety, err := m.create(ReasonPreRestore, hasMetadata)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("couldn't back up the current database before restoring: %w", err)
+	}
+
+	err = m.DB.RestoreFrom(dbCopy)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	if hasMetadata {
+		old := filepath.Clean(m.MetadataPath) + ".old"
+		err = os.RemoveAll(old)
+		if err != nil {
+			return Manifest{}, err
+		}
+		if fileExists(m.MetadataPath) {
+			err = os.Rename(m.MetadataPath, old)
+			if err != nil {
+				return Manifest{}, err
+			}
+		}
+		err = os.Rename(metadataCopy, m.MetadataPath)
+		if err != nil {
+			return Manifest{}, err
+		}
+		err = os.RemoveAll(old)
+		if err != nil {
+			log.Println("Failed to remove the replaced metadata folder =>", err)
+		}
+	}
+
+	log.Println("Restored the backup \"", name, "\"")
+	return safety, nil
+}
+
+// Backs up every interval until ctx is done. The first backup is made once an interval has passed since the
+// last scheduled backup, so restarting the app doesn't reset the schedule
+func (m *Manager) Schedule(ctx context.Context, interval time.Duration, withMetadata bool) {
+
+	next := time.Now()
+	if manifests, err := m.List(); err == nil {
+		i := slices.IndexFunc(manifests, func(manifest Manifest) bool { return manifest.Reason == ReasonScheduled })
+		if i >= 0 {
+			next = manifests[i].CreatedAt.Add(interval)
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+
+			_, err := m.Create(ReasonScheduled, withMetadata)
+			if err != nil {
+				log.Println("Scheduled backup failed =>", err)
+			}
+			next = time.Now().Add(interval)
+		}
+	}()
+}
+
+// Deletes the oldest scheduled backups past the number kept
+func (m *Manager) prune() error {
+
+	if m.Keep <= 0 {
+		return nil
+	}
+
+	manifests, err := m.List()
+	if err != nil {
+		return err
+	}
+
+	kept := 0
+	for _, manifest := range manifests {
+		if manifest.Reason != ReasonScheduled {
+			continue
+		}
+		kept++
+		if kept <= m.Keep {
+			continue
+		}
+
+		err = os.Remove(m.path(manifest.Name))
+		if err != nil {
+			return err
+		}
+		log.Println("Deleted the old backup \"", manifest.Name, "\"")
+	}
+
+	return nil
+}
+
+func addFile(archive *zip.Writer, name, src string) error {
+
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+func extractFile(archive *zip.ReadCloser, name, dest string) error {
+
+	r, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, r)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Removes a database file along with the -wal and -shm files SQLite leaves next to it. Databases copied from one in
+// WAL mode are in WAL mode too, and read-only connections can't remove those files when they close
+func removeDatabase(p string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(p + suffix)
+	}
+}
+
+func fileExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
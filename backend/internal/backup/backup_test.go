@@ -0,0 +1,336 @@
+package backup
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Ethanol2/book-organizer/internal/database"
+)
+
+func setupTestManager(t *testing.T) *Manager {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := database.NewClient(filepath.Join(dir, "library.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test DB: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	m := &Manager{
+		Dir:          filepath.Join(dir, "backups"),
+		MetadataPath: filepath.Join(dir, "metadata"),
+		DB:           &db,
+	}
+	writeTestFile(t, filepath.Join(m.MetadataPath, "covers", "cover.jpg"), "cover")
+
+	return m
+}
+
+func writeTestFile(t *testing.T, p, content string) {
+	t.Helper()
+
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(p, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func addTestBook(t *testing.T, m *Manager, title string) {
+	t.Helper()
+
+	_, err := m.DB.AddBook(database.BookParams{Title: &title})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+}
+
+func countTestBooks(t *testing.T, m *Manager) int {
+	t.Helper()
+
+	results, err := m.DB.GetBooksSummary(map[string][]string{})
+	if err != nil {
+		t.Fatalf("GetBooksSummary failed: %v", err)
+	}
+	return results.ResultsCount
+}
+
+func archiveEntries(t *testing.T, archivePath string) []string {
+	t.Helper()
+
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", archivePath, err)
+	}
+	defer archive.Close()
+
+	names := []string{}
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestCreateAndList(t *testing.T) {
+	m := setupTestManager(t)
+	addTestBook(t, m, "Dune")
+
+	// Listing before there are any backups
+	manifests, err := m.List()
+	if err != nil || len(manifests) != 0 {
+		t.Fatalf("Expected no backups, got %v (%v)", manifests, err)
+	}
+
+	plain, err := m.Create(ReasonManual, false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	withMetadata, err := m.Create(ReasonManual, true)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if !namePattern.MatchString(plain.Name) || !namePattern.MatchString(withMetadata.Name) || plain.Name == withMetadata.Name {
+		t.Errorf("Expected two unique backup names, got %s and %s", plain.Name, withMetadata.Name)
+	}
+	if plain.Size == 0 || plain.SchemaVersion == 0 {
+		t.Errorf("Expected the size and schema version, got %+v", plain)
+	}
+
+	// The zip layout
+	plainPath, _ := m.Path(plain.Name)
+	if entries := archiveEntries(t, plainPath); !slices.Equal(entries, []string{manifestFile, databaseFile}) {
+		t.Errorf("Expected only the database and manifest, got %v", entries)
+	}
+	metadataPath, _ := m.Path(withMetadata.Name)
+	if entries := archiveEntries(t, metadataPath); !slices.Equal(entries, []string{manifestFile, databaseFile, "metadata/covers/cover.jpg"}) {
+		t.Errorf("Expected the metadata folder too, got %v", entries)
+	}
+
+	// Temp files and files that aren't backups aren't listed
+	writeTestFile(t, filepath.Join(m.Dir, "notes.zip"), "")
+	writeTestFile(t, filepath.Join(m.Dir, "backup-20200101-000000.zip.partial"), "")
+
+	manifests, err = m.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(manifests) != 2 || manifests[0].Name != withMetadata.Name || manifests[1].Name != plain.Name {
+		t.Fatalf("Expected both backups, newest first, got %+v", manifests)
+	}
+	if !manifests[0].Metadata || manifests[0].Reason != ReasonManual || manifests[0].Size != withMetadata.Size {
+		t.Errorf("Expected the manifest read back from the backup, got %+v", manifests[0])
+	}
+
+	// Only names of existing backups have a path
+	for _, name := range []string{"../" + plain.Name, plain.Name + ".zip", "notes", "backup-20200101-000000", ""} {
+		if _, err := m.Path(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for %q, got %v", name, err)
+		}
+		if _, err := m.Get(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound getting %q, got %v", name, err)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	m := setupTestManager(t)
+	m.Keep = 2
+
+	manual, err := m.Create(ReasonManual, false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	scheduled := []string{}
+	for range 4 {
+		manifest, err := m.Create(ReasonScheduled, false)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		scheduled = append(scheduled, manifest.Name)
+	}
+
+	manifests, err := m.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	names := []string{}
+	for _, manifest := range manifests {
+		names = append(names, manifest.Name)
+	}
+
+	// Only scheduled backups count towards those kept, and the newest are kept
+	expected := []string{scheduled[3], scheduled[2], manual.Name}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Expected %v kept, got %v", expected, names)
+	}
+
+	// Manual backups don't prune
+	_, err = m.Create(ReasonManual, false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if manifests, _ = m.List(); len(manifests) != 4 {
+		t.Errorf("Expected 4 backups after a manual backup, got %d", len(manifests))
+	}
+
+	// 0 keeps every backup
+	m.Keep = 0
+	_, err = m.Create(ReasonScheduled, false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if manifests, _ = m.List(); len(manifests) != 5 {
+		t.Errorf("Expected 5 backups with Keep 0, got %d", len(manifests))
+	}
+}
+
+func TestRestore(t *testing.T) {
+	m := setupTestManager(t)
+	addTestBook(t, m, "Dune")
+
+	withMetadata, err := m.Create(ReasonManual, true)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	addTestBook(t, m, "Hyperion")
+	writeTestFile(t, filepath.Join(m.MetadataPath, "covers", "cover.jpg"), "changed")
+	writeTestFile(t, filepath.Join(m.MetadataPath, "added.jpg"), "added")
+
+	safety, err := m.Restore(withMetadata.Name)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	if n := countTestBooks(t, m); n != 1 {
+		t.Errorf("Expected the database restored to 1 book, got %d", n)
+	}
+	if content, _ := os.ReadFile(filepath.Join(m.MetadataPath, "covers", "cover.jpg")); string(content) != "cover" {
+		t.Errorf("Expected the metadata restored, got %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(m.MetadataPath, "added.jpg")); !os.IsNotExist(err) {
+		t.Error("Expected files added since the backup removed")
+	}
+	for _, suffix := range []string{".restore", ".old"} {
+		if _, err := os.Stat(m.MetadataPath + suffix); !os.IsNotExist(err) {
+			t.Errorf("Expected the %s folder cleaned up", suffix)
+		}
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(m.Dir, ".*")); len(leftovers) != 0 {
+		t.Errorf("Expected no temp files left in the backups folder, got %v", leftovers)
+	}
+
+	// The state before the restore was backed up, so the restore can be undone
+	if safety.Reason != ReasonPreRestore || !safety.Metadata {
+		t.Errorf("Expected a pre-restore backup with the metadata, got %+v", safety)
+	}
+	_, err = m.Restore(safety.Name)
+	if err != nil {
+		t.Fatalf("Restoring the pre-restore backup failed: %v", err)
+	}
+	if n := countTestBooks(t, m); n != 2 {
+		t.Errorf("Expected the restore undone, got %d books", n)
+	}
+	if content, _ := os.ReadFile(filepath.Join(m.MetadataPath, "added.jpg")); string(content) != "added" {
+		t.Errorf("Expected the metadata from before the restore, got %q", content)
+	}
+
+	// Without the metadata in the backup, the metadata folder is left alone
+	plain, err := m.Create(ReasonManual, false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	addTestBook(t, m, "Endymion")
+	writeTestFile(t, filepath.Join(m.MetadataPath, "later.jpg"), "later")
+
+	safety, err = m.Restore(plain.Name)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if n := countTestBooks(t, m); n != 2 {
+		t.Errorf("Expected the database restored to 2 books, got %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(m.MetadataPath, "later.jpg")); err != nil {
+		t.Error("Expected the metadata folder untouched by a backup without it")
+	}
+	if safety.Metadata {
+		t.Error("Expected the pre-restore backup to leave the metadata out too")
+	}
+
+	if _, err := m.Restore("backup-20200101-000000"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound restoring a missing backup, got %v", err)
+	}
+}
+
+func TestRestoreRejectsEntriesOutsideMetadata(t *testing.T) {
+	m := setupTestManager(t)
+	addTestBook(t, m, "Dune")
+
+	// A backup with a real database, but a metadata entry that climbs out of the folder
+	dbCopy := filepath.Join(t.TempDir(), "library.db")
+	err := m.DB.BackupTo(dbCopy)
+	if err != nil {
+		t.Fatalf("BackupTo failed: %v", err)
+	}
+
+	err = os.MkdirAll(m.Dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "backup-20200101-000000"
+	file, err := os.Create(filepath.Join(m.Dir, name+".zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(file)
+	if err = addFile(archive, databaseFile, dbCopy); err != nil {
+		t.Fatal(err)
+	}
+	for entry, content := range map[string]string{
+		manifestFile:           `{"name": "` + name + `", "reason": "manual", "metadata": true}`,
+		"metadata/ok.jpg":      "ok",
+		"metadata/../evil.txt": "evil",
+	} {
+		w, err := archive.Create(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err = archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	addTestBook(t, m, "Hyperion")
+
+	_, err = m.Restore(name)
+	if err == nil || !strings.Contains(err.Error(), "outside the metadata folder") {
+		t.Fatalf("Expected the restore refused, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(m.MetadataPath), "evil.txt")); !os.IsNotExist(err) {
+		t.Error("Expected nothing written outside the metadata folder")
+	}
+	if n := countTestBooks(t, m); n != 2 {
+		t.Errorf("Expected the database left as it was, got %d books", n)
+	}
+	if _, err := os.Stat(m.MetadataPath + ".restore"); !os.IsNotExist(err) {
+		t.Error("Expected the unpacked metadata cleaned up")
+	}
+	if content, _ := os.ReadFile(filepath.Join(m.MetadataPath, "covers", "cover.jpg")); string(content) != "cover" {
+		t.Errorf("Expected the metadata folder left as it was, got %q", content)
+	}
+}
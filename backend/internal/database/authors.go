@@ -0,0 +1,196 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// An author with their details and books. Alternate names are the author's aliases
+type AuthorProfile struct {
+	Id             int      `json:"id"`
+	Name           string   `json:"name"`
+	SortName       *string  `json:"sort_name"`
+	Bio            *string  `json:"bio"`
+	Asin           *string  `json:"asin"`
+	OpenLibraryKey *string  `json:"openlibrary_key"`
+	Aliases        []string `json:"aliases"`
+	BookCount      int      `json:"book_count"`
+
+	// Where the photo was downloaded from, and the url it's served at when there is one
+	PhotoUrl *string `json:"photo_url"`
+	Photo    *string `json:"photo"`
+
+	// The author's series with the author's books in reading order, then the books that aren't in a series
+	Series     []AuthorSeries `json:"series"`
+	Standalone []BookOverview `json:"standalone"`
+}
+
+type AuthorSeries struct {
+	Id    int          `json:"id"`
+	Name  string       `json:"name"`
+	Books []SeriesBook `json:"books"`
+}
+
+type AuthorParams struct {
+	Bio            *string `json:"bio"`
+	Asin           *string `json:"asin"`
+	OpenLibraryKey *string `json:"openlibrary_key"`
+
+	// Set by refreshes, users can't change it
+	PhotoUrl *string `json:"-"`
+}
+
+// Returns sql.ErrNoRows if the author doesn't exist
+func (c *Client) GetAuthorProfile(id int) (AuthorProfile, error) {
+
+	var author AuthorProfile
+	err := c.handler.QueryRow(`
+	SELECT id, name, sort_name, bio, asin, openlibrary_key, photo_url FROM authors WHERE id = ?
+	`, id).Scan(
+		&author.Id,
+		&author.Name,
+		&author.SortName,
+		&author.Bio,
+		&author.Asin,
+		&author.OpenLibraryKey,
+		&author.PhotoUrl,
+	)
+	if err != nil {
+		return AuthorProfile{}, err
+	}
+
+	cat, err := c.GetCategoryWithAliases(Authors, id)
+	if err != nil {
+		return AuthorProfile{}, err
+	}
+	author.Aliases = cat.Aliases
+
+	// Books in more than one series show up under each of them
+	rows, err := c.handler.Query(`
+	SELECT books.id, books.title, books.subtitle, books.cover, books.directory, series.id, series.name, books_series.series_index
+	FROM books_authors
+	JOIN books ON books.id = books_authors.book_id
+	LEFT JOIN books_series ON books_series.book_id = books.id
+	LEFT JOIN series ON series.id = books_series.series_id
+	WHERE books_authors.author_id = ?
+	ORDER BY series.name COLLATE NOCASE, books_series.sort_index IS NULL, books_series.sort_index, books.sort_title COLLATE NOCASE
+	`, id)
+	if err != nil {
+		return AuthorProfile{}, err
+	}
+	defer rows.Close()
+
+	author.Series = []AuthorSeries{}
+	author.Standalone = []BookOverview{}
+	books := map[string]bool{}
+	for rows.Next() {
+		var book SeriesBook
+		var dir *string
+		var seriesId *int
+		var seriesName *string
+		err = rows.Scan(&book.Id, &book.Title, &book.Subtitle, &book.Cover, &dir, &seriesId, &seriesName, &book.Index)
+		if err != nil {
+			return AuthorProfile{}, err
+		}
+
+		book.HasFiles = dir != nil
+		books[book.Id.String()] = true
+
+		if seriesId == nil {
+			author.Standalone = append(author.Standalone, book.BookOverview)
+			continue
+		}
+
+		if last := len(author.Series) - 1; last < 0 || author.Series[last].Id != *seriesId {
+			author.Series = append(author.Series, AuthorSeries{Id: *seriesId, Name: *seriesName, Books: []SeriesBook{}})
+		}
+		last := len(author.Series) - 1
+		author.Series[last].Books = append(author.Series[last].Books, book)
+	}
+	if err = rows.Err(); err != nil {
+		return AuthorProfile{}, err
+	}
+	rows.Close()
+
+	author.BookCount = len(books)
+
+	for i := range author.Series {
+		for j := range author.Series[i].Books {
+			book := &author.Series[i].Books[j]
+			book.Authors, err = c.GetCategoryTypesAssociatedWithBook(book.Id.String(), Authors)
+			if err != nil {
+				return AuthorProfile{}, err
+			}
+		}
+	}
+	for i := range author.Standalone {
+		author.Standalone[i].Authors, err = c.GetCategoryTypesAssociatedWithBook(author.Standalone[i].Id.String(), Authors)
+		if err != nil {
+			return AuthorProfile{}, err
+		}
+	}
+
+	return author, nil
+}
+
+// Empty values clear the detail
+func (c *Client) UpdateAuthor(id int, params AuthorParams) (AuthorProfile, error) {
+
+	setParts := []string{}
+	args := []any{}
+
+	if params.Bio != nil {
+		setParts = append(setParts, "bio = ?")
+		args = append(args, nullIfEmpty(*params.Bio))
+	}
+	if params.Asin != nil {
+		setParts = append(setParts, "asin = ?")
+		args = append(args, nullIfEmpty(strings.ToUpper(*params.Asin)))
+	}
+	if params.OpenLibraryKey != nil {
+		setParts = append(setParts, "openlibrary_key = ?")
+		args = append(args, nullIfEmpty(*params.OpenLibraryKey))
+	}
+	if params.PhotoUrl != nil {
+		setParts = append(setParts, "photo_url = ?")
+		args = append(args, nullIfEmpty(*params.PhotoUrl))
+	}
+
+	if len(setParts) > 0 {
+		args = append(args, id)
+		result, err := c.handler.Exec("UPDATE authors SET "+strings.Join(setParts, ", ")+" WHERE id = ?", args...)
+		if err != nil {
+			return AuthorProfile{}, err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return AuthorProfile{}, sql.ErrNoRows
+		}
+	}
+
+	return c.GetAuthorProfile(id)
+}
+
+// Adds the names as aliases of the author. Names that are already taken, by this author or another, are skipped
+func (c *Client) AddAuthorAlternateNames(id int, names []string) error {
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		existing := Category{Name: name, Type: Authors}
+		if ok, err := existing.GetID(c.handler); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+
+		err := c.addCategoryAlias(Authors, id, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
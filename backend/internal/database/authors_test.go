@@ -0,0 +1,86 @@
+package database
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAuthorProfile(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	asin := "B001IGFHW6"
+	books := []struct {
+		title  string
+		series string
+		index  string
+	}{
+		{"Words of Radiance", "The Stormlight Archive", "2"},
+		{"The Way of Kings", "The Stormlight Archive", "1"},
+		{"Mistborn", "Mistborn", "1"},
+		{"Warbreaker", "", ""},
+	}
+	for _, b := range books {
+		params := BookParams{Title: &b.title, Authors: &[]Category{{Name: "Brandon Sanderson", Asin: &asin}}}
+		if b.series != "" {
+			params.Series = &[]Category{{Name: b.series, Index: &b.index}}
+		}
+		_, err := client.AddBook(params)
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+	}
+
+	var id int
+	err := client.db.QueryRow("SELECT id FROM authors WHERE name = 'Brandon Sanderson'").Scan(&id)
+	if err != nil {
+		t.Fatalf("Failed to find the author: %v", err)
+	}
+
+	author, err := client.GetAuthorProfile(id)
+	if err != nil {
+		t.Fatalf("GetAuthorProfile failed: %v", err)
+	}
+
+	if author.Asin == nil || *author.Asin != asin {
+		t.Errorf("Expected the asin from the metadata, got %v", author.Asin)
+	}
+	if author.BookCount != 4 {
+		t.Errorf("Expected 4 books, got %d", author.BookCount)
+	}
+	if len(author.Series) != 2 || author.Series[0].Name != "Mistborn" || author.Series[1].Name != "The Stormlight Archive" {
+		t.Fatalf("Unexpected series %+v", author.Series)
+	}
+	if stormlight := author.Series[1].Books; len(stormlight) != 2 || stormlight[0].Title != "The Way of Kings" {
+		t.Errorf("Expected the series books in reading order, got %+v", stormlight)
+	}
+	if len(author.Standalone) != 1 || author.Standalone[0].Title != "Warbreaker" {
+		t.Errorf("Expected Warbreaker as the only standalone book, got %+v", author.Standalone)
+	}
+
+	bio := "Writes fantasy"
+	author, err = client.UpdateAuthor(id, AuthorParams{Bio: &bio})
+	if err != nil {
+		t.Fatalf("UpdateAuthor failed: %v", err)
+	}
+	if author.Bio == nil || *author.Bio != bio {
+		t.Errorf("Expected the bio to be set, got %v", author.Bio)
+	}
+
+	err = client.AddAuthorAlternateNames(id, []string{"Brandon Sanderson", "Brandon Winn Sanderson", " "})
+	if err != nil {
+		t.Fatalf("AddAuthorAlternateNames failed: %v", err)
+	}
+	author, err = client.GetAuthorProfile(id)
+	if err != nil {
+		t.Fatalf("GetAuthorProfile failed: %v", err)
+	}
+	if !slices.Equal(author.Aliases, []string{"Brandon Winn Sanderson"}) {
+		t.Errorf("Expected the alternate name as an alias, got %v", author.Aliases)
+	}
+
+	_, err = client.GetAuthorProfile(id + 100)
+	if err == nil {
+		t.Error("Expected an error for a missing author")
+	}
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Copies the whole database to a new file at path with SQLite's online backup API. The app keeps reading and writing
+// while the copy is made, and the copy is consistent as of the moment it finished
+func (c *Client) BackupTo(path string) error {
+
+	if c.db == nil {
+		return fmt.Errorf("can't back up the database during a transaction")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("\"%s\" already exists", path)
+	}
+
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	return copyDatabase(dest, c.db)
+}
+
+// Replaces the contents of the database with the backup at path, using SQLite's backup API so the app's open
+// connections see the restored data. Migrations the backup is missing are applied after. Backups from a newer
+// schema version than this build supports are refused
+func (c *Client) RestoreFrom(path string) error {
+
+	if c.db == nil {
+		return fmt.Errorf("can't restore the database during a transaction")
+	}
+
+	statuses, err := ReadMigrationStatus(path)
+	if err != nil {
+		return fmt.Errorf("couldn't read the backup: %w", err)
+	}
+	version := 0
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			version = max(version, status.Version)
+		}
+	}
+	if version > LatestSchemaVersion() {
+		return fmt.Errorf("the backup is at schema version %d but this build only supports up to version %d", version, LatestSchemaVersion())
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	err = copyDatabase(c.db, src)
+	if err != nil {
+		return err
+	}
+
+	err = c.migrate(c.path)
+	if err != nil {
+		return err
+	}
+
+	return c.ensureSearchIndex()
+}
+
+// How long a copy waits for a busy database before giving up
+const backupBusyTimeout = 30 * time.Second
+
+// Copies the main database of src over the main database of dest
+func copyDatabase(dest, src *sql.DB) error {
+
+	ctx := context.Background()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+
+			destSqlite, ok := destDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("the destination isn't a SQLite connection")
+			}
+			srcSqlite, ok := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("the source isn't a SQLite connection")
+			}
+
+			backup, err := destSqlite.Backup("main", srcSqlite, "main")
+			if err != nil {
+				return err
+			}
+
+			// Step returns without an error while a database is busy, so it's retried until done, or until it's been
+			// busy for too long
+			deadline := time.Now().Add(backupBusyTimeout)
+			for {
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Close()
+					return err
+				}
+				if done {
+					break
+				}
+				if time.Now().After(deadline) {
+					backup.Close()
+					return fmt.Errorf("the database was busy for over %s", backupBusyTimeout)
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+
+			return backup.Finish()
+		})
+	})
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	authors := []Category{{Name: "Frank Herbert"}}
+	dune, err := client.AddBook(BookParams{Title: ptr("Dune"), Authors: &authors, Tags: &[]string{"Sci-Fi"}})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	err = client.BackupTo(backupPath)
+	if err != nil {
+		t.Fatalf("BackupTo failed: %v", err)
+	}
+	if err := client.BackupTo(backupPath); err == nil {
+		t.Error("Expected an existing file not to be overwritten")
+	}
+
+	// Changes after the backup are undone by the restore
+	_, err = client.AddBook(BookParams{Title: ptr("Hyperion")})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+	err = client.DeleteBook(*dune.Id)
+	if err != nil {
+		t.Fatalf("DeleteBook failed: %v", err)
+	}
+
+	err = client.RestoreFrom(backupPath)
+	if err != nil {
+		t.Fatalf("RestoreFrom failed: %v", err)
+	}
+
+	results, err := client.GetBooks(map[string][]string{})
+	if err != nil {
+		t.Fatalf("GetBooks failed: %v", err)
+	}
+	if len(results.Items) != 1 || results.Items[0].Title != "Dune" {
+		t.Fatalf("Expected only the backed up book, got %+v", results.Items)
+	}
+	if names := CategoryToStrSlice(results.Items[0].Authors); len(names) != 1 || names[0] != "Frank Herbert" {
+		t.Errorf("Expected the book's author to be restored, got %v", names)
+	}
+
+	// The restored database can still be written to
+	_, err = client.AddBook(BookParams{Title: ptr("Children of Dune"), Authors: &authors})
+	if err != nil {
+		t.Fatalf("AddBook after the restore failed: %v", err)
+	}
+
+	// Backups from a newer build are refused
+	newer, err := sql.Open("sqlite3", backupPath)
+	if err != nil {
+		t.Fatalf("Failed to open the backup: %v", err)
+	}
+	_, err = newer.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, 'from the future')", LatestSchemaVersion()+1)
+	newer.Close()
+	if err != nil {
+		t.Fatalf("Failed to edit the backup: %v", err)
+	}
+	if err := client.RestoreFrom(backupPath); err == nil {
+		t.Error("Expected a backup from a newer schema version to be refused")
+	}
+}
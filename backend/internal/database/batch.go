@@ -0,0 +1,207 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// The ids as a JSON array, to match with IN (SELECT value FROM json_each(?)). One arg keeps clear of SQLite's limit on
+// the number of args however many books there are
+func idsParam(ids []uuid.UUID) (string, error) {
+	data, err := json.Marshal(ids)
+	return string(data), err
+}
+
+// Each book's categories of the type in rank order, loaded with one query. Books without any aren't in the map
+func (c *Client) getCategoriesOfBooks(ids []uuid.UUID, categoryType CategoryType) (map[uuid.UUID][]Category, error) {
+
+	param, err := idsParam(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := categoryColumns(categoryType)
+	if categoryType == Series {
+		columns += ", jn.series_index"
+	}
+
+	rows, err := c.handler.Query(fmt.Sprintf(`
+	SELECT %s, jn.book_id FROM %s AS cat
+	INNER JOIN books_%s AS jn
+		ON cat.id = jn.%s_id
+	WHERE jn.book_id IN (SELECT value FROM json_each(?))
+	ORDER BY jn.book_id, jn.rank ASC;
+	`, columns, categoryType, categoryType, categorySingular[categoryType]), param)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cats := map[uuid.UUID][]Category{}
+	for rows.Next() {
+		var bookId uuid.UUID
+		cat, err := scanCategory(rows, categoryType, categoryType == Series, &bookId)
+		if err != nil {
+			return nil, err
+		}
+		cats[bookId] = append(cats[bookId], cat)
+	}
+
+	return cats, rows.Err()
+}
+
+// Each book's formats, oldest first, loaded with one query
+func (c *Client) getFormatsOfBooks(ids []uuid.UUID) (map[uuid.UUID][]BookFormat, error) {
+
+	param, err := idsParam(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.handler.Query("SELECT "+formatColumns+" FROM book_formats WHERE book_id IN (SELECT value FROM json_each(?)) ORDER BY created_at ASC", param)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	formats := map[uuid.UUID][]BookFormat{}
+	for rows.Next() {
+		format, err := scanBookFormat(rows)
+		if err != nil {
+			return nil, err
+		}
+		formats[format.BookId] = append(formats[format.BookId], format)
+	}
+
+	return formats, rows.Err()
+}
+
+// Each book's identifiers, loaded with one query
+func (c *Client) getIdentifiersOfBooks(ids []uuid.UUID) (map[uuid.UUID][]BookIdentifier, error) {
+
+	param, err := idsParam(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.handler.Query("SELECT book_id, type, value FROM book_identifiers WHERE book_id IN (SELECT value FROM json_each(?)) ORDER BY type, created_at", param)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identifiers := map[uuid.UUID][]BookIdentifier{}
+	for rows.Next() {
+		var bookId uuid.UUID
+		var identifier BookIdentifier
+		err = rows.Scan(&bookId, &identifier.Type, &identifier.Value)
+		if err != nil {
+			return nil, err
+		}
+		identifiers[bookId] = append(identifiers[bookId], identifier)
+	}
+
+	return identifiers, rows.Err()
+}
+
+// Each book's custom values by key, loaded with one query
+func (c *Client) getCustomValuesOfBooks(ids []uuid.UUID) (map[uuid.UUID]map[string]any, error) {
+
+	param, err := idsParam(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.handler.Query(`
+	SELECT cv.book_id, cf.key, cf.type, cv.value FROM book_custom_values AS cv
+	JOIN custom_fields AS cf ON cf.key = cv.field_key
+	WHERE cv.book_id IN (SELECT value FROM json_each(?))
+	`, param)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[uuid.UUID]map[string]any{}
+	for rows.Next() {
+		var bookId uuid.UUID
+		var key string
+		var fieldType CustomFieldType
+		var value any
+		err = rows.Scan(&bookId, &key, &fieldType, &value)
+		if err != nil {
+			return nil, err
+		}
+		if values[bookId] == nil {
+			values[bookId] = map[string]any{}
+		}
+		values[bookId][key] = decodeCustomValue(fieldType, value)
+	}
+
+	return values, rows.Err()
+}
+
+// Fills in the categories, formats, identifiers and custom values of the books. The number of queries doesn't depend
+// on the number of books
+func (c *Client) loadBookDetails(books []Book) error {
+
+	ids := make([]uuid.UUID, len(books))
+	for i, book := range books {
+		ids[i] = *book.Id
+	}
+
+	categories := map[CategoryType]map[uuid.UUID][]Category{}
+	for _, categoryType := range []CategoryType{Authors, Genres, Series, Narrators, Tags} {
+		cats, err := c.getCategoriesOfBooks(ids, categoryType)
+		if err != nil {
+			return err
+		}
+		categories[categoryType] = cats
+	}
+
+	formats, err := c.getFormatsOfBooks(ids)
+	if err != nil {
+		return err
+	}
+	identifiers, err := c.getIdentifiersOfBooks(ids)
+	if err != nil {
+		return err
+	}
+	custom, err := c.getCustomValuesOfBooks(ids)
+	if err != nil {
+		return err
+	}
+
+	// Books without any keep empty lists, as GetBook returns them
+	orEmpty := func(cats []Category) []Category {
+		if cats == nil {
+			return []Category{}
+		}
+		return cats
+	}
+	for i := range books {
+		id := *books[i].Id
+		books[i].Authors = orEmpty(categories[Authors][id])
+		books[i].Genres = orEmpty(categories[Genres][id])
+		books[i].Series = orEmpty(categories[Series][id])
+		books[i].Narrators = orEmpty(categories[Narrators][id])
+		books[i].Tags = CategoryToStrSlice(categories[Tags][id])
+
+		books[i].Formats = formats[id]
+		if books[i].Formats == nil {
+			books[i].Formats = []BookFormat{}
+		}
+		books[i].Identifiers = identifiers[id]
+		if books[i].Identifiers == nil {
+			books[i].Identifiers = []BookIdentifier{}
+		}
+		books[i].Custom = custom[id]
+		if books[i].Custom == nil {
+			books[i].Custom = map[string]any{}
+		}
+	}
+
+	return nil
+}
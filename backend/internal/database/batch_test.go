@@ -0,0 +1,175 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/google/uuid"
+)
+
+// Counts the queries run through the client
+type countingHandler struct {
+	Handler
+	queries int
+}
+
+func (h *countingHandler) Query(query string, args ...any) (*sql.Rows, error) {
+	h.queries++
+	return h.Handler.Query(query, args...)
+}
+
+func (h *countingHandler) QueryRow(query string, args ...any) *sql.Row {
+	h.queries++
+	return h.Handler.QueryRow(query, args...)
+}
+
+// Adds a library of n books with authors, narrators, genres, series, tags, identifiers and custom values
+func addTestLibrary(t testing.TB, client *Client, n int) {
+	t.Helper()
+
+	_, err := client.AddCustomField(CustomFieldParams{Key: ptr("rating"), Type: ptr(CustomNumber)})
+	if err != nil {
+		t.Fatalf("AddCustomField failed: %v", err)
+	}
+
+	err = client.HandleTransaction(func(c *Client) error {
+		for i := range n {
+			index := fmt.Sprint(i%10 + 1)
+			_, err := c.AddBook(BookParams{
+				Title:       ptr(fmt.Sprintf("Book %d", i)),
+				Authors:     &[]Category{{Name: fmt.Sprintf("Author %d", i%50)}, {Name: fmt.Sprintf("Author %d", i%7+50)}},
+				Narrators:   &[]Category{{Name: fmt.Sprintf("Narrator %d", i%30)}},
+				Genres:      &[]Category{{Name: fmt.Sprintf("Genre %d", i%12)}},
+				Series:      &[]Category{{Name: fmt.Sprintf("Series %d", i/10), Index: &index}},
+				Tags:        &[]string{fmt.Sprintf("Tag %d", i%20), "Library"},
+				Identifiers: &[]BookIdentifier{{IdentifierGoogle, fmt.Sprintf("GOOGLE%06d", i)}},
+				Custom:      &map[string]any{"rating": float64(i % 5)},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to add the library: %v", err)
+	}
+}
+
+func TestListBooksQueryCount(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	addTestLibrary(t, &client, 150)
+
+	counter := &countingHandler{Handler: client.handler}
+	client.handler = counter
+
+	results, err := client.GetBooks(map[string][]string{"count": {"100"}, "sortBy": {"authors"}})
+	if err != nil {
+		t.Fatalf("GetBooks failed: %v", err)
+	}
+	if len(results.Items) != 100 {
+		t.Fatalf("Expected a page of 100 books, got %d", len(results.Items))
+	}
+	for _, book := range results.Items {
+		if len(book.Authors) != 2 || len(book.Narrators) != 1 || len(book.Genres) != 1 || len(book.Series) != 1 ||
+			len(book.Tags) != 2 || len(book.Identifiers) != 1 || book.Custom["rating"] == nil {
+			t.Fatalf("Expected every detail of %q to be loaded, got %+v", book.Title, book)
+		}
+		if book.Series[0].Index == nil {
+			t.Errorf("Expected the series index of %q", book.Title)
+		}
+	}
+	if counter.queries > 12 {
+		t.Errorf("Expected a constant number of queries for the page, got %d", counter.queries)
+	}
+
+	counter.queries = 0
+	summaries, err := client.GetBooksSummary(map[string][]string{"count": {"100"}})
+	if err != nil {
+		t.Fatalf("GetBooksSummary failed: %v", err)
+	}
+	for _, book := range summaries.Items {
+		if len(book.Authors) != 2 {
+			t.Fatalf("Expected the authors of %q, got %v", book.Title, book.Authors)
+		}
+	}
+	if counter.queries > 3 {
+		t.Errorf("Expected a constant number of queries for the summaries, got %d", counter.queries)
+	}
+}
+
+func TestGetBooksByIds(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	addTestLibrary(t, &client, 30)
+
+	all, err := client.GetBooks(map[string][]string{"count": {"30"}})
+	if err != nil {
+		t.Fatalf("GetBooks failed: %v", err)
+	}
+	ids := []uuid.UUID{}
+	for _, book := range all.Items {
+		ids = append(ids, *book.Id)
+	}
+	err = client.UpdateBookFiles(ids[0], fileManagement.Files{Root: ptr("Author 0/Book 0")})
+	if err != nil {
+		t.Fatalf("UpdateBookFiles failed: %v", err)
+	}
+
+	counter := &countingHandler{Handler: client.handler}
+	client.handler = counter
+
+	books, err := client.GetBooksByIds(append(ids, uuid.New()))
+	if err != nil {
+		t.Fatalf("GetBooksByIds failed: %v", err)
+	}
+	if len(books) != len(ids) {
+		t.Fatalf("Expected %d books, got %d", len(ids), len(books))
+	}
+	for _, book := range books {
+		if len(book.Authors) != 2 || len(book.Series) != 1 || len(book.Identifiers) != 1 {
+			t.Fatalf("Expected the details of %q to be loaded, got %+v", book.Title, book)
+		}
+	}
+
+	dirs, err := client.GetBookDirectories(append(ids, uuid.New()))
+	if err != nil {
+		t.Fatalf("GetBookDirectories failed: %v", err)
+	}
+	if len(dirs) != len(ids) || dirs[ids[0]] == nil || *dirs[ids[0]] != "Author 0/Book 0" || dirs[ids[1]] != nil {
+		t.Errorf("Expected every book's directory, got %v", dirs)
+	}
+
+	if counter.queries > 12 {
+		t.Errorf("Expected a constant number of queries, got %d", counter.queries)
+	}
+}
+
+func BenchmarkListBooks(b *testing.B) {
+	client := setupTestDB(b)
+	defer client.db.Close()
+
+	addTestLibrary(b, &client, 5000)
+
+	for _, view := range []string{"full", "summary"} {
+		b.Run(view, func(b *testing.B) {
+			filters := map[string][]string{"count": {"100"}, "page": {"10"}}
+			for b.Loop() {
+				var err error
+				if view == "full" {
+					_, err = client.GetBooks(filters)
+				} else {
+					_, err = client.GetBooksSummary(filters)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package database
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
 	"path"
@@ -25,7 +24,15 @@ type Book struct {
 	Tags        []string   `json:"tags"`
 	Publisher   *string    `json:"publisher"`
 	CreatedAt   *time.Time `json:"created_at,omitempty"`
-	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+
+	// YYYY-MM-DD, or YYYY-MM or YYYY when the full date isn't known
+	PublishDate *string `json:"publish_date"`
+	// ISO 639-1 code when known
+	Language       *string    `json:"language"`
+	Abridged       *bool      `json:"abridged"`
+	Explicit       *bool      `json:"explicit"`
+	RuntimeMinutes *int       `json:"runtime_minutes"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
 
 	// Categories
 	Series    []Category `json:"series"`
@@ -33,23 +40,34 @@ type Book struct {
 	Genres    []Category `json:"genres"`
 	Narrators []Category `json:"narrators"`
 
-	Files fileManagement.Files `json:"files,omitempty"`
+	Files       fileManagement.Files `json:"files,omitempty"`
+	Formats     []BookFormat         `json:"formats"`
+	Identifiers []BookIdentifier     `json:"identifiers"`
+
+	// Values of the custom fields, by field key
+	Custom map[string]any `json:"custom"`
+
+	// The matching text when the book was found with a search. Matches are wrapped in <mark> tags
+	Snippet *string `json:"snippet,omitempty"`
 }
 
 type BookOverview struct {
-	Id       uuid.UUID  `json:"id"`
-	Title    string     `json:"title"`
-	Subtitle *string    `json:"subtitle"`
-	Authors  []Category `json:"authors"`
-	Cover    *string    `json:"cover"`
-	HasFiles bool       `json:"has_files"`
+	Id        uuid.UUID  `json:"id"`
+	Title     string     `json:"title"`
+	Subtitle  *string    `json:"subtitle"`
+	Authors   []Category `json:"authors"`
+	Cover     *string    `json:"cover"`
+	Thumbnail *string    `json:"thumbnail"`
+	HasFiles  bool       `json:"has_files"`
+	Snippet   *string    `json:"snippet,omitempty"`
 }
 
 type BookSearchResults[T []BookOverview | []Book] struct {
-	ResultsCount int `json:"results_count"`
-	Count        int `json:"count"`
-	Page         int `json:"page"`
-	Items        T   `json:"items"`
+	ResultsCount int     `json:"results_count"`
+	Count        int     `json:"count"`
+	Page         int     `json:"page"`
+	NextCursor   *string `json:"next_cursor"`
+	Items        T       `json:"items"`
 }
 
 type BookParams struct {
@@ -62,17 +80,32 @@ type BookParams struct {
 	Tags        *[]string `json:"tags"`
 	Publisher   *string   `json:"publisher"`
 
+	// Also sets the year when the year isn't given
+	PublishDate    *string `json:"publish_date"`
+	Language       *string `json:"language"`
+	Abridged       *bool   `json:"abridged"`
+	Explicit       *bool   `json:"explicit"`
+	RuntimeMinutes *int    `json:"runtime_minutes"`
+
 	// Categories
 	Series    *[]Category `json:"series"`
 	Authors   *[]Category `json:"authors"`
 	Genres    *[]Category `json:"genres"`
 	Narrators *[]Category `json:"narrators"`
 
+	// Added to the book's identifiers. Existing identifiers are kept
+	Identifiers *[]BookIdentifier `json:"identifiers"`
+
+	// Values of custom fields by key. Only the fields included change, null removes the book's value
+	Custom *map[string]any `json:"custom"`
+
 	// URIs
 	Cover *string `json:"cover"`
 	Key   *string `json:"key"`
 }
 
+const bookColumns = "books.id, books.title, books.subtitle, books.publish_year, books.description, books.isbn, books.asin, books.publisher, books.publish_date, books.language, books.abridged, books.explicit, books.runtime_minutes, books.directory, books.audio_files, books.text_files, books.cover, books.created_at, books.updated_at"
+
 func (c *Client) CheckBookExistsID(id uuid.UUID) (bool, error) {
 	var exists bool
 	err := c.handler.QueryRow("SELECT EXISTS(SELECT 1 FROM books WHERE id = ?)", id).Scan(&exists)
@@ -82,8 +115,12 @@ func (c *Client) CheckBookExistsID(id uuid.UUID) (bool, error) {
 	return exists, nil
 }
 func (c *Client) CheckBookExistsISBN(isbn string) (bool, uuid.UUID, error) {
-	var id uuid.UUID
-	err := c.handler.QueryRow("SELECT id FROM books WHERE isbn = ? LIMIT 1", isbn).Scan(&id)
+	id, found, err := c.FindBookByIdentifier(IdentifierISBN, isbn)
+	if err != nil || found {
+		return found, id, err
+	}
+
+	err = c.handler.QueryRow("SELECT id FROM books WHERE isbn = ? LIMIT 1", isbn).Scan(&id)
 	if err == sql.ErrNoRows {
 		return false, uuid.Nil, nil
 	} else if err != nil {
@@ -93,8 +130,12 @@ func (c *Client) CheckBookExistsISBN(isbn string) (bool, uuid.UUID, error) {
 	return true, id, nil
 }
 func (c *Client) CheckBookExistsASIN(asin string) (bool, uuid.UUID, error) {
-	var id uuid.UUID
-	err := c.handler.QueryRow("SELECT id FROM books WHERE asin = ? LIMIT 1", asin).Scan(&id)
+	id, found, err := c.FindBookByIdentifier(IdentifierASIN, asin)
+	if err != nil || found {
+		return found, id, err
+	}
+
+	err = c.handler.QueryRow("SELECT id FROM books WHERE asin = ? LIMIT 1", asin).Scan(&id)
 	if err == sql.ErrNoRows {
 		return false, uuid.Nil, nil
 	} else if err != nil {
@@ -115,20 +156,31 @@ func (c *Client) CheckBookHasFiles(id uuid.UUID) (bool, error) {
 func (c *Client) AddBook(params BookParams) (Book, error) {
 
 	id := uuid.New()
-
-	tagsJson, err := json.Marshal(params.Tags)
-	if err != nil {
-		return Book{}, err
-	}
+	params.normalizePublication()
 
 	query := `
 	INSERT INTO books
-		(id, title, subtitle, publish_year, description, tags, isbn, asin, publisher, cover, directory, audio_files, text_files, created_at, updated_at)
+		(id, title, sort_title, subtitle, publish_year, description, isbn, asin, publisher, publish_date, language, abridged, explicit, runtime_minutes, cover, directory, audio_files, text_files, created_at, updated_at)
 	VALUES
-		(?, ?, ?, ?, ?, ?, ?, ?, ?, NULL, NULL, NULL, NULL, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)	
+		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULL, NULL, NULL, NULL, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)	
 	`
 
-	_, err = c.handler.Exec(query, id, params.Title, params.Subtitle, params.Year, params.Description, string(tagsJson), params.ISBN, params.ASIN, params.Publisher)
+	var publishDate, language *string
+	if params.PublishDate != nil {
+		publishDate = nullIfEmpty(*params.PublishDate)
+	}
+	if params.Language != nil {
+		language = nullIfEmpty(*params.Language)
+	}
+
+	articles := c.titleArticles("")
+	if language != nil {
+		articles = c.titleArticles(*language)
+	}
+
+	sortTitle := GenerateSortTitle(*params.Title, articles)
+	_, err := c.handler.Exec(query, id, params.Title, sortTitle, params.Subtitle, params.Year, params.Description, params.ISBN, params.ASIN, params.Publisher,
+		publishDate, language, params.Abridged, params.Explicit, params.RuntimeMinutes)
 	if err != nil {
 		return Book{}, err
 	}
@@ -172,6 +224,26 @@ func (c *Client) AddBook(params BookParams) (Book, error) {
 		return Book{}, err
 	}
 
+	if params.Tags != nil {
+		tags := tagCategories(*params.Tags)
+		err = sortCats(Tags, &tags)
+		if err != nil {
+			return Book{}, err
+		}
+	}
+
+	err = c.addBookIdentifiers(id, params)
+	if err != nil {
+		return Book{}, err
+	}
+
+	if params.Custom != nil {
+		err = c.setBookCustomValues(id, *params.Custom)
+		if err != nil {
+			return Book{}, err
+		}
+	}
+
 	log.Println("Added \"", *params.Title, "\" to books")
 
 	return c.GetBook(id)
@@ -179,176 +251,150 @@ func (c *Client) AddBook(params BookParams) (Book, error) {
 
 func (c *Client) GetBook(id uuid.UUID) (Book, error) {
 
-	var book Book
-	var tagsStr *string
-	var audioStr *string
-	var textStr *string
+	book, err := scanBook(c.handler.QueryRow("SELECT "+bookColumns+" FROM books WHERE id = ?", id))
+	if err != nil {
+		return Book{}, err
+	}
 
-	err := c.handler.QueryRow("SELECT * FROM books WHERE id = ?", id).Scan(
-		&book.Id,
-		&book.Title,
-		&book.Subtitle,
-		&book.Year,
-		&book.Description,
-		&tagsStr,
-		&book.ISBN,
-		&book.ASIN,
-		&book.Publisher,
-		&book.Files.Root,
-		&audioStr,
-		&textStr,
-		&book.Files.Cover,
-		&book.CreatedAt,
-		&book.UpdatedAt,
-	)
+	books := []Book{book}
+	err = c.loadBookDetails(books)
 	if err != nil {
 		return Book{}, err
 	}
+	book = books[0]
 
-	if tagsStr != nil {
-		err = json.Unmarshal([]byte(*tagsStr), &book.Tags)
-		if err != nil {
-			return Book{}, err
-		}
+	//defer log.Println("Retrieved \"", book.Title, "\" from books")
+
+	return book, nil
+}
+
+// The books with the ids, loaded with a fixed number of queries. Ids without a book are left out
+func (c *Client) GetBooksByIds(ids []uuid.UUID) ([]Book, error) {
+
+	param, err := idsParam(ids)
+	if err != nil {
+		return nil, err
 	}
 
-	if audioStr != nil {
-		err = book.Files.ParseAudioJson(*audioStr)
-		if err != nil {
-			return Book{}, err
-		}
+	rows, err := c.handler.Query("SELECT "+bookColumns+" FROM books WHERE id IN (SELECT value FROM json_each(?))", param)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	if textStr != nil {
-		err = book.Files.ParseTextJson(*textStr)
+	books := []Book{}
+	for rows.Next() {
+		book, err := scanBook(rows)
 		if err != nil {
-			return Book{}, err
+			return nil, err
 		}
+		books = append(books, book)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
+	rows.Close()
 
-	err = book.getBookCategories(c)
+	err = c.loadBookDetails(books)
 	if err != nil {
-		return Book{}, err
+		return nil, err
 	}
 
-	//defer log.Println("Retrieved \"", book.Title, "\" from books")
-
-	return book, nil
+	return books, nil
 }
 
 func (c *Client) GetBooks(filters map[string][]string) (BookSearchResults[[]Book], error) {
 
 	books := []Book{}
 
-	count, page, pageQuery := buildPageQuery(filters)
-	searchQuery, searchTerms := buildSearchQuery(filters)
-
-	query := "SELECT *, (SELECT COUNT(*) FROM books) AS total_count FROM books " + searchQuery + pageQuery
-	rows, err := c.handler.Query(query, searchTerms...)
+	rows, page, err := c.queryBookPage(filters, bookColumns)
 	if err != nil {
-		log.Println("Query:\n", query)
 		return BookSearchResults[[]Book]{}, err
 	}
 	defer rows.Close()
 
-	totalCount := 0
 	for rows.Next() {
-		var book Book
-		var tagsStr *string
-		var audioStr *string
-		var textStr *string
-
-		err := rows.Scan(
-			&book.Id,
-			&book.Title,
-			&book.Subtitle,
-			&book.Year,
-			&book.Description,
-			&tagsStr,
-			&book.ISBN,
-			&book.ASIN,
-			&book.Publisher,
-			&book.Files.Root,
-			&audioStr,
-			&textStr,
-			&book.Files.Cover,
-			&book.CreatedAt,
-			&book.UpdatedAt,
-			&totalCount,
-		)
+		var snippet *string
+		book, err := scanBook(rows, append([]any{&snippet}, page.dest()...)...)
 		if err != nil {
-			log.Println(err)
-			continue
+			return BookSearchResults[[]Book]{}, err
 		}
-
-		if audioStr != nil {
-			err = book.Files.ParseAudioJson(*audioStr)
-			if err != nil {
-				return BookSearchResults[[]Book]{}, err
-			}
-		}
-
-		if textStr != nil {
-			err = book.Files.ParseTextJson(*textStr)
-			if err != nil {
-				return BookSearchResults[[]Book]{}, err
-			}
-		}
-
-		err = book.getBookCategories(c)
-		if err != nil {
-			log.Println(err)
-			continue
+		if !page.scanned() {
+			break
 		}
 
+		book.Snippet = snippet
 		books = append(books, book)
 	}
+	if err = rows.Err(); err != nil {
+		return BookSearchResults[[]Book]{}, err
+	}
+	rows.Close()
+
+	// The details of the whole page are loaded together rather than book by book
+	err = c.loadBookDetails(books)
+	if err != nil {
+		return BookSearchResults[[]Book]{}, err
+	}
 
 	return BookSearchResults[[]Book]{
-		ResultsCount: totalCount,
-		Count:        count,
-		Page:         page,
+		ResultsCount: page.Total,
+		Count:        page.Count,
+		Page:         page.Page,
+		NextCursor:   page.Next,
 		Items:        books,
 	}, nil
 }
 
 func (c *Client) GetBooksSummary(filters map[string][]string) (BookSearchResults[[]BookOverview], error) {
 
-	countLimit, page, pageQuery := buildPageQuery(filters)
-	searchQuery, searchTerms := buildSearchQuery(filters)
-	query := "SELECT books.id, books.title, books.subtitle, books.cover, books.directory, (SELECT COUNT(*) FROM books) AS total_count  FROM books " + searchQuery + pageQuery
-
-	rows, err := c.handler.Query(query, searchTerms...)
+	rows, page, err := c.queryBookPage(filters, "books.id, books.title, books.subtitle, books.cover, books.directory")
 	if err != nil {
-		log.Println("Query:\n", query)
 		return BookSearchResults[[]BookOverview]{}, err
 	}
 	defer rows.Close()
 
-	totalCount := 0
 	var books []BookOverview
 	for rows.Next() {
 		var book BookOverview
 		var dir *string
-		err = rows.Scan(&book.Id, &book.Title, &book.Subtitle, &book.Cover, &dir, &totalCount)
+		err = rows.Scan(append([]any{&book.Id, &book.Title, &book.Subtitle, &book.Cover, &dir, &book.Snippet}, page.dest()...)...)
 		if err != nil {
 			return BookSearchResults[[]BookOverview]{}, err
 		}
-
-		book.Authors, err = c.GetCategoryTypesAssociatedWithBook(book.Id.String(), Authors)
-		if err != nil {
-			return BookSearchResults[[]BookOverview]{}, err
+		if !page.scanned() {
+			break
 		}
 
 		book.HasFiles = dir != nil
 
 		books = append(books, book)
 	}
+	if err = rows.Err(); err != nil {
+		return BookSearchResults[[]BookOverview]{}, err
+	}
+	rows.Close()
+
+	ids := make([]uuid.UUID, len(books))
+	for i, book := range books {
+		ids[i] = book.Id
+	}
+	authors, err := c.getCategoriesOfBooks(ids, Authors)
+	if err != nil {
+		return BookSearchResults[[]BookOverview]{}, err
+	}
+	for i := range books {
+		books[i].Authors = authors[books[i].Id]
+		if books[i].Authors == nil {
+			books[i].Authors = []Category{}
+		}
+	}
 
 	return BookSearchResults[[]BookOverview]{
-		ResultsCount: totalCount,
-		Count:        countLimit,
-		Page:         page,
+		ResultsCount: page.Total,
+		Count:        page.Count,
+		Page:         page.Page,
+		NextCursor:   page.Next,
 		Items:        books,
 	}, nil
 }
@@ -397,6 +443,27 @@ func (c *Client) UpdateBook(id uuid.UUID, update BookParams) (Book, bool, error)
 		args = append(args, arg)
 	}
 
+	update.normalizePublication()
+
+	// Titles sort without the articles of the book's language
+	if update.Title != nil || update.Language != nil {
+		var title, language *string
+		err := c.handler.QueryRow("SELECT title, language FROM books WHERE id = ?", id).Scan(&title, &language)
+		if err != nil {
+			return Book{}, false, err
+		}
+		if update.Title != nil {
+			title = update.Title
+		}
+		if update.Language != nil {
+			language = update.Language
+		}
+		if language == nil {
+			language = new(string)
+		}
+		add("sort_title", GenerateSortTitle(*title, c.titleArticles(*language)))
+	}
+
 	if update.Title != nil {
 		add("title", update.Title)
 		needsFileUpdate = true
@@ -407,13 +474,6 @@ func (c *Client) UpdateBook(id uuid.UUID, update BookParams) (Book, bool, error)
 	if update.Description != nil {
 		add("description", update.Description)
 	}
-	if update.Tags != nil {
-		tagsJson, err := json.Marshal(update.Tags)
-		if err != nil {
-			return Book{}, false, err
-		}
-		add("tags", string(tagsJson))
-	}
 	if update.ISBN != nil {
 		add("isbn", update.ISBN)
 	}
@@ -423,6 +483,21 @@ func (c *Client) UpdateBook(id uuid.UUID, update BookParams) (Book, bool, error)
 	if update.Publisher != nil {
 		add("publisher", update.Publisher)
 	}
+	if update.PublishDate != nil {
+		add("publish_date", nullIfEmpty(*update.PublishDate))
+	}
+	if update.Language != nil {
+		add("language", nullIfEmpty(*update.Language))
+	}
+	if update.Abridged != nil {
+		add("abridged", update.Abridged)
+	}
+	if update.Explicit != nil {
+		add("explicit", update.Explicit)
+	}
+	if update.RuntimeMinutes != nil {
+		add("runtime_minutes", update.RuntimeMinutes)
+	}
 
 	if len(setParts) > 0 {
 		query := "UPDATE books SET " + strings.Join(setParts, ", ") + " WHERE id = ?"
@@ -519,7 +594,30 @@ func (c *Client) UpdateBook(id uuid.UUID, update BookParams) (Book, bool, error)
 		}
 	}
 
-	err := c.CleanupCategories()
+	if update.Tags != nil {
+		old, err := c.GetCategoryTypesAssociatedWithBook(id.String(), Tags)
+		if err != nil {
+			return Book{}, false, err
+		}
+		err = handleCategories("DELETE FROM books_tags WHERE book_id = ? AND tag_id = ?", tagCategories(*update.Tags), old, Tags)
+		if err != nil {
+			return Book{}, false, err
+		}
+	}
+
+	err := c.addBookIdentifiers(id, update)
+	if err != nil {
+		return Book{}, false, err
+	}
+
+	if update.Custom != nil {
+		err = c.setBookCustomValues(id, *update.Custom)
+		if err != nil {
+			return Book{}, false, err
+		}
+	}
+
+	err = c.CleanupCategories()
 	if err != nil {
 		return Book{}, false, err
 	}
@@ -532,13 +630,7 @@ func (c *Client) UpdateBook(id uuid.UUID, update BookParams) (Book, bool, error)
 	needsFileUpdate = needsFileUpdate && book.Files.Root != nil
 
 	if needsFileUpdate {
-		authorDir, seriesDir, bookDir, err := c.GetPathComponents(id)
-		if err != nil {
-			return Book{}, false, err
-		}
-
-		book.Files.UpdateDirectory(path.Join(authorDir, seriesDir, bookDir))
-		err = book.ApplyBookFiles(c)
+		book, _, err = c.RelocateBook(id)
 		if err != nil {
 			return Book{}, false, err
 		}
@@ -549,6 +641,68 @@ func (c *Client) UpdateBook(id uuid.UUID, update BookParams) (Book, bool, error)
 	return book, needsFileUpdate, nil
 }
 
+// Points the book's files at the directory its authors, series and title call for. Only the database is updated,
+// the files have to be moved separately. Returns the book and whether its directory changed
+func (c *Client) RelocateBook(id uuid.UUID) (Book, bool, error) {
+
+	book, err := c.GetBook(id)
+	if err != nil {
+		return Book{}, false, err
+	}
+	if book.Files.Root == nil {
+		return book, false, nil
+	}
+
+	authorDir, seriesDir, bookDir, err := c.GetPathComponents(id)
+	if err != nil {
+		return Book{}, false, err
+	}
+
+	newDir := path.Join(authorDir, seriesDir, bookDir)
+	if newDir == *book.Files.Root {
+		return book, false, nil
+	}
+
+	err = c.setBookDirectory(&book, newDir)
+	if err != nil {
+		return Book{}, false, err
+	}
+
+	return book, true, nil
+}
+
+// Points the book's files back at dir, for when moving its folder to the directory RelocateBook chose failed.
+// Requires an active db transaction
+func (c *Client) SetBookDirectory(id uuid.UUID, dir string) error {
+
+	book, err := c.GetBook(id)
+	if err != nil {
+		return err
+	}
+	if book.Files.Root == nil || *book.Files.Root == dir {
+		return nil
+	}
+
+	return c.setBookDirectory(&book, dir)
+}
+
+func (c *Client) setBookDirectory(book *Book, dir string) error {
+
+	book.Files.UpdateDirectory(dir)
+	err := book.ApplyBookFiles(c)
+	if err != nil {
+		return err
+	}
+
+	err = c.relocateBookFormats(*book.Id, dir)
+	if err != nil {
+		return err
+	}
+
+	book.Formats, err = c.GetBookFormats(*book.Id)
+	return err
+}
+
 func (c *Client) UpdateBookCover(id uuid.UUID, ext string) (string, string, error) {
 
 	var dir *string
@@ -586,6 +740,9 @@ func (c *Client) GetPathComponents(id uuid.UUID) (string, string, string, error)
 	}
 	if len(authors) > 0 {
 		authorDir = authors[0].Name
+		if c.sorting.FolderSortNames && authors[0].SortName != nil {
+			authorDir = *authors[0].SortName
+		}
 	}
 
 	seriesDir := ""
@@ -639,6 +796,45 @@ func (c *Client) GetBookDirectory(id uuid.UUID) (*string, error) {
 	return dir, nil
 }
 
+// Each book's directory, loaded with one query. Books without files map to nil, and ids without a book are left out
+func (c *Client) GetBookDirectories(ids []uuid.UUID) (map[uuid.UUID]*string, error) {
+
+	param, err := idsParam(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.handler.Query("SELECT id, directory FROM books WHERE id IN (SELECT value FROM json_each(?))", param)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dirs := map[uuid.UUID]*string{}
+	for rows.Next() {
+		var id uuid.UUID
+		var dir *string
+		err = rows.Scan(&id, &dir)
+		if err != nil {
+			return nil, err
+		}
+		dirs[id] = dir
+	}
+
+	return dirs, rows.Err()
+}
+
+func (c *Client) GetBookCover(id uuid.UUID) (*string, error) {
+
+	var cover *string
+	err := c.handler.QueryRow("SELECT cover FROM books WHERE id = ?", id).Scan(&cover)
+	if err != nil {
+		return nil, err
+	}
+
+	return cover, nil
+}
+
 func (c *Client) GetAllBooksDirectories() ([]uuid.UUID, []string, error) {
 
 	rows, err := c.handler.Query("SELECT id, directory FROM books WHERE directory IS NOT NULL")
@@ -725,33 +921,86 @@ func (c *Client) RemoveAllBooks() error {
 	return nil
 }
 
-// #region Book Methods
-
-func (book *Book) getBookCategories(c *Client) error {
+// Scans a row of bookColumns, followed by any extra columns
+func scanBook(row rowScanner, extra ...any) (Book, error) {
 
-	var err error
+	var book Book
+	var audioStr *string
+	var textStr *string
 
-	book.Authors, err = c.GetCategoryTypesAssociatedWithBook(book.Id.String(), Authors)
+	err := row.Scan(append([]any{
+		&book.Id,
+		&book.Title,
+		&book.Subtitle,
+		&book.Year,
+		&book.Description,
+		&book.ISBN,
+		&book.ASIN,
+		&book.Publisher,
+		&book.PublishDate,
+		&book.Language,
+		&book.Abridged,
+		&book.Explicit,
+		&book.RuntimeMinutes,
+		&book.Files.Root,
+		&audioStr,
+		&textStr,
+		&book.Files.Cover,
+		&book.CreatedAt,
+		&book.UpdatedAt,
+	}, extra...)...)
 	if err != nil {
-		return err
+		return Book{}, err
 	}
 
-	book.Genres, err = c.GetCategoryTypesAssociatedWithBook(book.Id.String(), Genres)
-	if err != nil {
-		return err
+	if audioStr != nil {
+		err = book.Files.ParseAudioJson(*audioStr)
+		if err != nil {
+			return Book{}, err
+		}
 	}
 
-	book.Series, err = c.GetCategoryTypesAssociatedWithBook(book.Id.String(), Series)
-	if err != nil {
-		return err
+	if textStr != nil {
+		err = book.Files.ParseTextJson(*textStr)
+		if err != nil {
+			return Book{}, err
+		}
 	}
 
-	book.Narrators, err = c.GetCategoryTypesAssociatedWithBook(book.Id.String(), Narrators)
-	if err != nil {
-		return err
+	return book, nil
+}
+
+// #region Book Methods
+
+// Normalizes the publish date and language. Dates that can't be read are dropped, and set the year when it isn't given.
+// An empty date or language stays empty, so an update can clear it
+func (params *BookParams) normalizePublication() {
+
+	if params.PublishDate != nil && *params.PublishDate != "" {
+		date, year, ok := NormalizePublishDate(*params.PublishDate)
+		if ok {
+			params.PublishDate = &date
+			if params.Year == nil {
+				params.Year = &year
+			}
+		} else {
+			params.PublishDate = nil
+		}
 	}
 
-	return nil
+	if params.Language != nil {
+		language := NormalizeLanguage(*params.Language)
+		params.Language = &language
+	}
+}
+
+// Prepends p to the paths of the book's files and the files of its formats
+func (book *Book) Prepend(p string) {
+
+	book.Files.Prepend(p)
+	for i := range book.Formats {
+		book.Formats[i].Files.Prepend(p)
+	}
 }
 
 // Inserts files into a book directly, bypassing the update or association functions. Don't use this unless you have a good reason. Requires an active db transaction.
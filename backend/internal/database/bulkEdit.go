@@ -0,0 +1,246 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// A change made to every book of a bulk edit
+type BulkOperation struct {
+	// set replaces the field, add and remove change the items of a list field, and number numbers the books in a series
+	Op    string          `json:"op"`
+	Field string          `json:"field"`
+	Value json.RawMessage `json:"value"`
+
+	// number only. The books are numbered in the order they're listed, from start by step. Both default to 1
+	Series string   `json:"series"`
+	Start  *float64 `json:"start"`
+	Step   *float64 `json:"step"`
+
+	params *BookParams
+}
+
+type BulkEditResult struct {
+	Id    uuid.UUID `json:"id"`
+	Book  *Book     `json:"book,omitempty"`
+	Error *string   `json:"error"`
+}
+
+// Operations that can't be applied to any book
+type BulkEditError struct {
+	Msg string
+}
+
+func (e BulkEditError) Error() string {
+	return e.Msg
+}
+
+const (
+	BulkSet    = "set"
+	BulkAdd    = "add"
+	BulkRemove = "remove"
+	BulkNumber = "number"
+)
+
+// The fields add and remove work on. Their values are lists of names
+var bulkListFields = []string{"authors", "narrators", "genres", "series", "tags"}
+
+// The value of a set operation as the params of a book update, so it's validated the same way.
+// The params are kept, so changes made to them are what gets applied
+func (op *BulkOperation) SetParams() (*BookParams, error) {
+
+	if op.params != nil {
+		return op.params, nil
+	}
+	if op.Field == "" || op.Field == "cover" || op.Field == "key" {
+		return nil, BulkEditError{fmt.Sprintf("%q can't be set in a bulk edit", op.Field)}
+	}
+	if len(op.Value) == 0 {
+		return nil, BulkEditError{fmt.Sprintf("Missing the value to set %s to", op.Field)}
+	}
+
+	field, err := json.Marshal(op.Field)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(slices.Concat([]byte("{"), field, []byte(":"), op.Value, []byte("}"))))
+	decoder.DisallowUnknownFields()
+
+	var params BookParams
+	err = decoder.Decode(&params)
+	if err != nil {
+		return nil, BulkEditError{fmt.Sprintf("Can't set %s: %s", op.Field, err)}
+	}
+
+	op.params = &params
+	return op.params, nil
+}
+
+func (op *BulkOperation) validate() error {
+
+	switch op.Op {
+	case BulkSet:
+		_, err := op.SetParams()
+		return err
+
+	case BulkAdd, BulkRemove:
+		if !slices.Contains(bulkListFields, op.Field) {
+			return BulkEditError{fmt.Sprintf("Can't %s items of %q, expected one of %s", op.Op, op.Field, strings.Join(bulkListFields, ", "))}
+		}
+		var names []string
+		if err := json.Unmarshal(op.Value, &names); err != nil || len(names) == 0 {
+			return BulkEditError{fmt.Sprintf("The value to %s must be a list of names", op.Op)}
+		}
+
+	case BulkNumber:
+		if op.Field != "" && op.Field != "series" {
+			return BulkEditError{"Only series can be numbered"}
+		}
+		if strings.TrimSpace(op.Series) == "" {
+			return BulkEditError{"Missing the series to number the books in"}
+		}
+
+	default:
+		return BulkEditError{fmt.Sprintf("Unknown operation %q, expected set, add, remove or number", op.Op)}
+	}
+
+	return nil
+}
+
+// Applies the operations to each book in turn. Each book is edited in a savepoint, so a book that fails is left as it was
+// and listed with its error while the others are still edited. Only the database is updated, moving the files of books
+// whose folder changed is left to the caller. Requires an active db transaction
+func (c *Client) BulkEditBooks(ids []uuid.UUID, ops []BulkOperation) ([]BulkEditResult, error) {
+
+	if len(ops) == 0 {
+		return nil, BulkEditError{"No operations to apply"}
+	}
+	for i := range ops {
+		err := ops[i].validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := []BulkEditResult{}
+	for position, id := range ids {
+		result := BulkEditResult{Id: id}
+
+		_, err := c.handler.Exec("SAVEPOINT bulk_edit")
+		if err != nil {
+			return nil, err
+		}
+
+		book, err := c.bulkEditBook(id, position, ops)
+		if err != nil {
+			_, rollbackErr := c.handler.Exec("ROLLBACK TO bulk_edit")
+			if rollbackErr != nil {
+				return nil, rollbackErr
+			}
+
+			msg := err.Error()
+			if errors.Is(err, sql.ErrNoRows) {
+				msg = "Book not found"
+			}
+			result.Error = &msg
+		} else {
+			result.Book = &book
+		}
+
+		_, err = c.handler.Exec("RELEASE bulk_edit")
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// position is where the book is in the bulk edit, for numbering
+func (c *Client) bulkEditBook(id uuid.UUID, position int, ops []BulkOperation) (Book, error) {
+
+	book, err := c.GetBook(id)
+	if err != nil {
+		return Book{}, err
+	}
+
+	for _, op := range ops {
+		var params BookParams
+
+		switch op.Op {
+		case BulkSet:
+			params = *op.params
+
+		case BulkAdd, BulkRemove:
+			var names []string
+			err = json.Unmarshal(op.Value, &names)
+			if err != nil {
+				return Book{}, err
+			}
+
+			edit := func(cats []Category) *[]Category {
+				edited := slices.Clone(cats)
+				for _, name := range names {
+					name = strings.TrimSpace(name)
+					index := slices.IndexFunc(edited, func(cat Category) bool { return strings.EqualFold(cat.Name, name) })
+					switch {
+					case op.Op == BulkAdd && index < 0 && name != "":
+						edited = append(edited, Category{Name: name})
+					case op.Op == BulkRemove && index >= 0:
+						edited = slices.Delete(edited, index, index+1)
+					}
+				}
+				return &edited
+			}
+
+			switch op.Field {
+			case "authors":
+				params.Authors = edit(book.Authors)
+			case "narrators":
+				params.Narrators = edit(book.Narrators)
+			case "genres":
+				params.Genres = edit(book.Genres)
+			case "series":
+				params.Series = edit(book.Series)
+			case "tags":
+				tags := CategoryToStrSlice(*edit(StrToCategorySlice(book.Tags)))
+				params.Tags = &tags
+			}
+
+		case BulkNumber:
+			start, step := 1.0, 1.0
+			if op.Start != nil {
+				start = *op.Start
+			}
+			if op.Step != nil {
+				step = *op.Step
+			}
+			index := strconv.FormatFloat(start+float64(position)*step, 'f', -1, 64)
+
+			series := slices.Clone(book.Series)
+			if i := slices.IndexFunc(series, func(s Category) bool { return strings.EqualFold(s.Name, strings.TrimSpace(op.Series)) }); i >= 0 {
+				series[i].Index = &index
+			} else {
+				series = append(series, Category{Name: strings.TrimSpace(op.Series), Index: &index})
+			}
+			params.Series = &series
+		}
+
+		book, _, err = c.UpdateBook(id, params)
+		if err != nil {
+			return Book{}, err
+		}
+	}
+
+	return book, nil
+}
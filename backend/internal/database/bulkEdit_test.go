@@ -0,0 +1,127 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBulkEditBooks(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	ids := []uuid.UUID{}
+	for _, title := range []string{"Dune", "Dune Messiah", "Children of Dune"} {
+		authors := []Category{{Name: "Frank Herbert"}}
+		book, err := client.AddBook(BookParams{Title: ptr(title), Authors: &authors, Tags: &[]string{"Sci-Fi", "To Read"}})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+		ids = append(ids, *book.Id)
+	}
+
+	var bulkErr BulkEditError
+	for _, op := range []BulkOperation{
+		{Op: "rename"},
+		{Op: BulkSet, Field: "cover", Value: json.RawMessage(`"http://example.com/cover.jpg"`)},
+		{Op: BulkSet, Field: "colour", Value: json.RawMessage(`"red"`)},
+		{Op: BulkAdd, Field: "title", Value: json.RawMessage(`["Dune"]`)},
+		{Op: BulkRemove, Field: "tags", Value: json.RawMessage(`[]`)},
+		{Op: BulkNumber, Field: "series"},
+	} {
+		err := client.HandleTransaction(func(c *Client) error {
+			_, err := c.BulkEditBooks(ids, []BulkOperation{op})
+			return err
+		})
+		if !errors.As(err, &bulkErr) {
+			t.Errorf("Expected a BulkEditError for %+v, got %v", op, err)
+		}
+	}
+
+	missing := uuid.New()
+	var results []BulkEditResult
+	err := client.HandleTransaction(func(c *Client) error {
+		var err error
+		results, err = c.BulkEditBooks(append(ids, missing), []BulkOperation{
+			{Op: BulkSet, Field: "language", Value: json.RawMessage(`"en"`)},
+			{Op: BulkAdd, Field: "narrators", Value: json.RawMessage(`["Scott Brick"]`)},
+			{Op: BulkRemove, Field: "tags", Value: json.RawMessage(`["to read"]`)},
+			{Op: BulkNumber, Series: "Dune Chronicles", Start: ptr(1.0), Step: ptr(0.5)},
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("BulkEditBooks failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Expected a result for each book, got %d", len(results))
+	}
+	if results[3].Id != missing || results[3].Error == nil || results[3].Book != nil {
+		t.Errorf("Expected the missing book to fail, got %+v", results[3])
+	}
+
+	for i, id := range ids {
+		if results[i].Error != nil {
+			t.Fatalf("Unexpected error for %s: %s", id, *results[i].Error)
+		}
+
+		book, err := client.GetBook(id)
+		if err != nil {
+			t.Fatalf("GetBook failed: %v", err)
+		}
+		if book.Language == nil || *book.Language != "en" {
+			t.Errorf("Expected the language to be set, got %v", book.Language)
+		}
+		if names := CategoryToStrSlice(book.Narrators); !slices.Equal(names, []string{"Scott Brick"}) {
+			t.Errorf("Expected the narrator to be added, got %v", names)
+		}
+		if !slices.Equal(book.Tags, []string{"Sci-Fi"}) {
+			t.Errorf("Expected the tag to be removed, got %v", book.Tags)
+		}
+
+		index := []string{"1", "1.5", "2"}[i]
+		if len(book.Series) != 1 || book.Series[0].Name != "Dune Chronicles" || book.Series[0].Index == nil || *book.Series[0].Index != index {
+			t.Errorf("Expected %q to be number %s in the series, got %+v", book.Title, index, book.Series)
+		}
+	}
+
+	// A book that fails is left as it was, the others are still edited
+	_, err = client.db.Exec("UPDATE books SET isbn = '9780441013593' WHERE id = ?", ids[0])
+	if err != nil {
+		t.Fatalf("Failed to set the isbn: %v", err)
+	}
+	err = client.HandleTransaction(func(c *Client) error {
+		var err error
+		results, err = c.BulkEditBooks(ids, []BulkOperation{
+			{Op: BulkAdd, Field: "genres", Value: json.RawMessage(`["Classics"]`)},
+			{Op: BulkSet, Field: "isbn", Value: json.RawMessage(`"9780441013593"`)},
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("BulkEditBooks failed: %v", err)
+	}
+	for i, result := range results {
+		book, err := client.GetBook(result.Id)
+		if err != nil {
+			t.Fatalf("GetBook failed: %v", err)
+		}
+
+		// The first book already has the isbn
+		if i == 0 {
+			if result.Error != nil || len(book.Genres) != 1 {
+				t.Errorf("Expected the first book to be edited, got %v and %v", result.Error, book.Genres)
+			}
+			continue
+		}
+		if result.Error == nil {
+			t.Errorf("Expected the duplicate isbn to fail book %d", i)
+		}
+		if len(book.Genres) != 0 {
+			t.Errorf("Expected the failed book's changes to be rolled back, got %v", book.Genres)
+		}
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
+
+	"github.com/google/uuid"
 )
 
 type CategoryType string
@@ -15,47 +18,78 @@ const (
 	Authors   CategoryType = "authors"
 	Narrators CategoryType = "narrators"
 	Genres    CategoryType = "genres"
+	Tags      CategoryType = "tags"
 )
 
 type Category struct {
-	Id    *int         `json:"id,omitempty"`
-	Name  string       `json:"name"`
-	Index *string      `json:"index,omitempty"`
-	Type  CategoryType `json:"-"`
+	Id    *int    `json:"id,omitempty"`
+	Name  string  `json:"name"`
+	Index *string `json:"index,omitempty"`
+
+	// Authors and narrators only. Generated from the name unless a user set it
+	SortName *string `json:"sort_name,omitempty"`
+
+	// Series and authors. The Audible series or author the category was matched with
+	Asin *string `json:"asin,omitempty"`
+
+	Aliases []string `json:"aliases,omitempty"`
+
+	// Only when listing a category type with counts
+	BookCount *int `json:"book_count,omitempty"`
+
+	Type CategoryType `json:"-"`
 }
 
+// Returned when a category is renamed or aliased to the name of another category of the same type. Those have to be merged instead
+var ErrCategoryExists = errors.New("a category with that name already exists")
+
 var categorySingular = map[CategoryType]string{
 	Series:    "series",
 	Genres:    "genre",
 	Authors:   "author",
 	Narrators: "narrator",
+	Tags:      "tag",
 }
 
 func (c Client) AddCategory(categoryType CategoryType, name string) (Category, error) {
 
+	sortName := GenerateSortName(name)
+
+	columns, values, args := "id, name", "NULL, ?", []any{name}
+	if hasSortName(categoryType) {
+		columns += ", sort_name"
+		values += ", ?"
+		args = append(args, sortName)
+	}
+
 	query := fmt.Sprintf(`
 	INSERT INTO %s
-		(id, name)
+		(%s)
 	VALUES
-		(NULL, ?)
+		(%s)
 	ON CONFLICT(name)
 		DO NOTHING
 	RETURNING id
-	`, categoryType)
+	`, categoryType, columns, values)
 
 	var id int
-	err := c.handler.QueryRow(query, name).Scan(&id)
+	err := c.handler.QueryRow(query, args...).Scan(&id)
 	if err != nil {
 		return Category{}, err
 	}
 
 	log.Println("Added \"", name, "\" to", categoryType)
 
-	return Category{
+	cat := Category{
 		Id:   &id,
 		Name: name,
 		Type: categoryType,
-	}, nil
+	}
+	if hasSortName(categoryType) {
+		cat.SortName = &sortName
+	}
+
+	return cat, nil
 }
 
 func (c Client) DeleteCategory(category Category) error {
@@ -64,23 +98,24 @@ func (c Client) DeleteCategory(category Category) error {
 
 func (c Client) DeleteCategoryWithID(categoryType CategoryType, id int) error {
 
-	query := fmt.Sprintf(`
-	SELECT name FROM %s WHERE id = ?;
-	REMOVE FROM %s WHERE id = ?;
-	`, categoryType, categoryType)
+	var value string
+	err := c.handler.QueryRow(fmt.Sprintf("SELECT name FROM %s WHERE id = ?", categoryType), id).Scan(&value)
+	if err != nil {
+		return err
+	}
 
-	row, err := c.handler.Query(query, id)
+	// The books lose the category through the joining table's cascade
+	_, err = c.handler.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ?", categoryType), id)
 	if err != nil {
 		return err
 	}
 
-	var value string
-	err = row.Scan(&value)
+	_, err = c.handler.Exec("DELETE FROM category_aliases WHERE category_type = ? AND category_id = ?", categoryType, id)
 	if err != nil {
 		return err
 	}
 
-	defer log.Println("Removed \"", value, "\" from", categoryType)
+	log.Println("Removed \"", value, "\" from", categoryType)
 
 	return nil
 }
@@ -88,11 +123,10 @@ func (c Client) DeleteCategoryWithID(categoryType CategoryType, id int) error {
 func (c Client) GetCategory(categoryType CategoryType, id int) (Category, error) {
 
 	query := fmt.Sprintf(`
-	SELECT * FROM %s WHERE id = ?
-	`, categoryType)
+	SELECT %s FROM %s AS cat WHERE id = ?
+	`, categoryColumns(categoryType), categoryType)
 
-	cat := Category{Type: categoryType}
-	err := c.handler.QueryRow(query, id).Scan(&cat.Id, &cat.Name)
+	cat, err := scanCategory(c.handler.QueryRow(query, id), categoryType, false)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Category{}, nil
@@ -105,26 +139,302 @@ func (c Client) GetCategory(categoryType CategoryType, id int) (Category, error)
 	return cat, err
 }
 
-func (c Client) GetAllOfCategory(categoryType CategoryType) ([]Category, error) {
+// sortBy is "name", "sort_name" for authors and narrators, or "book_count" with counts. Otherwise categories are in the order they were added.
+// withCounts fills in the number of books with each category
+func (c Client) GetAllOfCategory(categoryType CategoryType, sortBy string, withCounts bool) ([]Category, error) {
+
+	columns := categoryColumns(categoryType)
+	if withCounts {
+		columns += fmt.Sprintf(", (SELECT COUNT(*) FROM books_%s AS jn WHERE jn.%s_id = cat.id) AS book_count", categoryType, categorySingular[categoryType])
+	}
+
+	order := ""
+	switch {
+	case sortBy == "name":
+		order = " ORDER BY cat.name COLLATE NOCASE"
+	case sortBy == "sort_name" && hasSortName(categoryType):
+		order = " ORDER BY cat.sort_name COLLATE NOCASE"
+	case sortBy == "book_count" && withCounts:
+		order = " ORDER BY book_count DESC, cat.name COLLATE NOCASE"
+	}
 
-	rows, err := c.handler.Query(fmt.Sprintf("SELECT * FROM %s", categoryType))
+	rows, err := c.handler.Query(fmt.Sprintf("SELECT %s FROM %s AS cat%s", columns, categoryType, order))
 	if err != nil {
 		return []Category{}, err
 	}
+	defer rows.Close()
 
 	categories := []Category{}
 	for rows.Next() {
-		cat := Category{Type: categoryType}
+		var count int
+		extra := []any{}
+		if withCounts {
+			extra = append(extra, &count)
+		}
 
-		err = rows.Scan(&cat.Id, &cat.Name)
+		cat, err := scanCategory(rows, categoryType, false, extra...)
 		if err != nil {
 			return []Category{}, err
 		}
+		if withCounts {
+			cat.BookCount = &count
+		}
 
 		categories = append(categories, cat)
 	}
 
-	return categories, nil
+	return categories, rows.Err()
+}
+
+// Renames the category and keeps the old name as an alias. Returns ErrCategoryExists if another category already has the name
+func (c Client) RenameCategory(categoryType CategoryType, id int, name string) (Category, error) {
+
+	cat, err := c.GetCategory(categoryType, id)
+	if err != nil {
+		return Category{}, err
+	}
+	if cat.Id == nil {
+		return Category{}, sql.ErrNoRows
+	}
+
+	existing := Category{Name: name, Type: categoryType}
+	if ok, err := existing.GetID(c.handler); err != nil {
+		return Category{}, err
+	} else if ok && *existing.Id != id {
+		return Category{}, ErrCategoryExists
+	}
+
+	_, err = c.handler.Exec(fmt.Sprintf("UPDATE %s SET name = ? WHERE id = ?", categoryType), name, id)
+	if err != nil {
+		return Category{}, err
+	}
+
+	if hasSortName(categoryType) {
+		_, err = c.handler.Exec(fmt.Sprintf("UPDATE %s SET sort_name = ? WHERE id = ? AND NOT sort_name_custom", categoryType), GenerateSortName(name), id)
+		if err != nil {
+			return Category{}, err
+		}
+	}
+
+	// The new name can't be an alias of itself
+	_, err = c.handler.Exec("DELETE FROM category_aliases WHERE category_type = ? AND alias = ?", categoryType, name)
+	if err != nil {
+		return Category{}, err
+	}
+
+	if !strings.EqualFold(cat.Name, name) {
+		err = c.addCategoryAlias(categoryType, id, cat.Name)
+		if err != nil {
+			return Category{}, err
+		}
+	}
+
+	log.Println("Renamed \"", cat.Name, "\" to \"", name, "\" in", categoryType)
+
+	return c.GetCategoryWithAliases(categoryType, id)
+}
+
+// Sets the sort name of an author or narrator. An empty sort name goes back to the one generated from the name
+func (c Client) SetCategorySortName(categoryType CategoryType, id int, sortName string) (Category, error) {
+
+	if !hasSortName(categoryType) {
+		return Category{}, fmt.Errorf("%s don't have sort names", categoryType)
+	}
+
+	cat, err := c.GetCategory(categoryType, id)
+	if err != nil {
+		return Category{}, err
+	}
+	if cat.Id == nil {
+		return Category{}, sql.ErrNoRows
+	}
+
+	custom := true
+	sortName = strings.TrimSpace(sortName)
+	if sortName == "" {
+		sortName = GenerateSortName(cat.Name)
+		custom = false
+	}
+
+	_, err = c.handler.Exec(fmt.Sprintf("UPDATE %s SET sort_name = ?, sort_name_custom = ? WHERE id = ?", categoryType), sortName, custom, id)
+	if err != nil {
+		return Category{}, err
+	}
+
+	return c.GetCategoryWithAliases(categoryType, id)
+}
+
+// Moves the books of the source categories onto the target and deletes the sources. A book keeps the better rank
+// of the two when it already has the target. The source names become aliases of the target
+func (c Client) MergeCategories(categoryType CategoryType, targetId int, sourceIds []int) (Category, error) {
+
+	if target, err := c.GetCategory(categoryType, targetId); err != nil {
+		return Category{}, err
+	} else if target.Id == nil {
+		return Category{}, sql.ErrNoRows
+	}
+
+	joinTable := "books_" + string(categoryType)
+	column := categorySingular[categoryType] + "_id"
+
+	for _, sourceId := range sourceIds {
+		if sourceId == targetId {
+			continue
+		}
+
+		source, err := c.GetCategory(categoryType, sourceId)
+		if err != nil {
+			return Category{}, err
+		}
+		if source.Id == nil {
+			continue
+		}
+
+		// Books with both keep a single row
+		shared := fmt.Sprintf("book_id IN (SELECT book_id FROM %s WHERE %s = ?)", joinTable, column)
+		sourceRow := fmt.Sprintf("(SELECT s.%%s FROM %s AS s WHERE s.book_id = %s.book_id AND s.%s = ?)", joinTable, joinTable, column)
+
+		setParts := "rank = MIN(rank, " + fmt.Sprintf(sourceRow, "rank") + ")"
+		args := []any{sourceId}
+		if categoryType == Series {
+			setParts += ", series_index = COALESCE(series_index, " + fmt.Sprintf(sourceRow, "series_index") + ")"
+			setParts += ", sort_index = COALESCE(sort_index, " + fmt.Sprintf(sourceRow, "sort_index") + ")"
+			args = append(args, sourceId, sourceId)
+		}
+		args = append(args, targetId, sourceId)
+
+		_, err = c.handler.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE %s = ? AND %s", joinTable, setParts, column, shared), args...)
+		if err != nil {
+			return Category{}, err
+		}
+
+		_, err = c.handler.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = ? AND %s", joinTable, column, shared), sourceId, targetId)
+		if err != nil {
+			return Category{}, err
+		}
+
+		_, err = c.handler.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", joinTable, column, column), targetId, sourceId)
+		if err != nil {
+			return Category{}, err
+		}
+
+		_, err = c.handler.Exec("UPDATE category_aliases SET category_id = ? WHERE category_type = ? AND category_id = ?", targetId, categoryType, sourceId)
+		if err != nil {
+			return Category{}, err
+		}
+
+		_, err = c.handler.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ?", categoryType), sourceId)
+		if err != nil {
+			return Category{}, err
+		}
+
+		err = c.addCategoryAlias(categoryType, targetId, source.Name)
+		if err != nil {
+			return Category{}, err
+		}
+
+		log.Println("Merged \"", source.Name, "\" into", categoryType, targetId)
+	}
+
+	return c.GetCategoryWithAliases(categoryType, targetId)
+}
+
+// Returns the category along with its aliases, or sql.ErrNoRows if it doesn't exist
+func (c Client) GetCategoryWithAliases(categoryType CategoryType, id int) (Category, error) {
+
+	cat, err := c.GetCategory(categoryType, id)
+	if err != nil {
+		return Category{}, err
+	}
+	if cat.Id == nil {
+		return Category{}, sql.ErrNoRows
+	}
+
+	rows, err := c.handler.Query("SELECT alias FROM category_aliases WHERE category_type = ? AND category_id = ? ORDER BY alias", categoryType, id)
+	if err != nil {
+		return Category{}, err
+	}
+	defer rows.Close()
+
+	cat.Aliases = []string{}
+	for rows.Next() {
+		var alias string
+		err = rows.Scan(&alias)
+		if err != nil {
+			return Category{}, err
+		}
+		cat.Aliases = append(cat.Aliases, alias)
+	}
+
+	return cat, rows.Err()
+}
+
+// Returns ErrCategoryExists if a category already has the alias as its name
+func (c Client) AddCategoryAlias(categoryType CategoryType, id int, alias string) (Category, error) {
+
+	existing := Category{Name: alias, Type: categoryType}
+	if ok, err := existing.GetID(c.handler); err != nil {
+		return Category{}, err
+	} else if ok && *existing.Id != id {
+		return Category{}, ErrCategoryExists
+	}
+
+	err := c.addCategoryAlias(categoryType, id, alias)
+	if err != nil {
+		return Category{}, err
+	}
+
+	return c.GetCategoryWithAliases(categoryType, id)
+}
+
+func (c Client) RemoveCategoryAlias(categoryType CategoryType, id int, alias string) (Category, error) {
+
+	result, err := c.handler.Exec("DELETE FROM category_aliases WHERE category_type = ? AND category_id = ? AND alias = ?", categoryType, id, alias)
+	if err != nil {
+		return Category{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return Category{}, err
+	} else if n == 0 {
+		return Category{}, sql.ErrNoRows
+	}
+
+	return c.GetCategoryWithAliases(categoryType, id)
+}
+
+// Returns the ids of the books with any of the categories
+func (c Client) GetBooksWithCategories(categoryType CategoryType, ids []int) ([]uuid.UUID, error) {
+
+	if len(ids) == 0 {
+		return []uuid.UUID{}, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := []any{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := c.handler.Query(fmt.Sprintf(
+		"SELECT DISTINCT book_id FROM books_%s WHERE %s_id IN (%s)",
+		categoryType, categorySingular[categoryType], placeholders,
+	), args...)
+	if err != nil {
+		return []uuid.UUID{}, err
+	}
+	defer rows.Close()
+
+	bookIds := []uuid.UUID{}
+	for rows.Next() {
+		var bookId uuid.UUID
+		err = rows.Scan(&bookId)
+		if err != nil {
+			return []uuid.UUID{}, err
+		}
+		bookIds = append(bookIds, bookId)
+	}
+
+	return bookIds, rows.Err()
 }
 
 // The Type field of the category must not be nil
@@ -139,6 +449,7 @@ func (c Client) associateBookAndCategoryType(bookId string, category Category, r
 				return err
 			}
 			cat.Index = category.Index
+			cat.Asin = category.Asin
 			category = cat
 		}
 	}
@@ -146,8 +457,8 @@ func (c Client) associateBookAndCategoryType(bookId string, category Category, r
 	insertLine := ""
 	var args []any
 	if category.Type == Series {
-		insertLine = fmt.Sprintf("INSERT INTO books_%s (book_id, %s_id, series_index, rank)\nSELECT b.id, c.id, ?, ?", category.Type, categorySingular[category.Type])
-		args = []any{category.Index, rank, bookId, category.Id}
+		insertLine = fmt.Sprintf("INSERT INTO books_%s (book_id, %s_id, series_index, sort_index, rank)\nSELECT b.id, c.id, ?, ?, ?", category.Type, categorySingular[category.Type])
+		args = []any{category.Index, seriesSortIndex(category.Index), rank, bookId, category.Id}
 	} else {
 		insertLine = fmt.Sprintf("INSERT INTO books_%s (book_id, %s_id, rank)\nSELECT b.id, c.id, ?", category.Type, categorySingular[category.Type])
 		args = []any{rank, bookId, category.Id}
@@ -167,7 +478,14 @@ func (c Client) associateBookAndCategoryType(bookId string, category Category, r
 	}
 
 	if category.Type == Series {
-		_, err := c.handler.Exec("UPDATE books_series SET series_index = ? WHERE book_id = ? AND series_id = ?", category.Index, bookId, category.Id)
+		_, err := c.handler.Exec("UPDATE books_series SET series_index = ?, sort_index = ? WHERE book_id = ? AND series_id = ?", category.Index, seriesSortIndex(category.Index), bookId, category.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if (category.Type == Series || category.Type == Authors) && category.Asin != nil && *category.Asin != "" {
+		_, err = c.handler.Exec(fmt.Sprintf("UPDATE %s SET asin = COALESCE(asin, ?) WHERE id = ?", category.Type), category.Asin, category.Id)
 		if err != nil {
 			return err
 		}
@@ -178,44 +496,65 @@ func (c Client) associateBookAndCategoryType(bookId string, category Category, r
 
 func (c Client) GetCategoryTypesAssociatedWithBook(bookId string, categoryType CategoryType) ([]Category, error) {
 
-	selectLine := ""
+	columns := categoryColumns(categoryType)
 	if categoryType == Series {
-		selectLine = fmt.Sprintf("SELECT cat.*, jn.series_index FROM %s AS cat", categoryType)
-	} else {
-		selectLine = fmt.Sprintf("SELECT cat.* FROM %s AS cat", categoryType)
+		columns += ", jn.series_index"
 	}
 
 	query := fmt.Sprintf(`
-	%s
+	SELECT %s FROM %s AS cat
 	INNER JOIN books_%s AS jn 
 		ON cat.id = jn.%s_id
 	WHERE jn.book_id = ?
 	ORDER BY jn.rank ASC;
-	`, selectLine, categoryType, categorySingular[categoryType])
+	`, columns, categoryType, categoryType, categorySingular[categoryType])
 
 	rows, err := c.handler.Query(query, bookId)
 	if err != nil {
 		//fmt.Println(query)
 		return []Category{}, err
 	}
+	defer rows.Close()
 
 	cats := []Category{}
 	for rows.Next() {
-		cat := Category{Type: categoryType}
-
-		if categoryType == Series {
-			err = rows.Scan(&cat.Id, &cat.Name, &cat.Index)
-		} else {
-			err = rows.Scan(&cat.Id, &cat.Name)
-		}
-
+		cat, err := scanCategory(rows, categoryType, categoryType == Series)
 		if err != nil {
 			return []Category{}, err
 		}
 		cats = append(cats, cat)
 	}
 
-	return cats, nil
+	return cats, rows.Err()
+}
+
+// The category columns of the table aliased as cat, in the order scanCategory reads them
+func categoryColumns(categoryType CategoryType) string {
+	if hasSortName(categoryType) {
+		return "cat.id, cat.name, cat.sort_name"
+	}
+	return "cat.id, cat.name"
+}
+
+// Columns selected after the category's are scanned into extra
+func scanCategory(row rowScanner, categoryType CategoryType, withIndex bool, extra ...any) (Category, error) {
+
+	cat := Category{Type: categoryType}
+	dest := []any{&cat.Id, &cat.Name}
+	if hasSortName(categoryType) {
+		dest = append(dest, &cat.SortName)
+	}
+	if withIndex {
+		dest = append(dest, &cat.Index)
+	}
+	dest = append(dest, extra...)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return Category{}, err
+	}
+
+	return cat, nil
 }
 
 func CategoryToStrSlice(items []Category) []string {
@@ -247,6 +586,8 @@ func stringToCategoryType(str string) CategoryType {
 		return Genres
 	case "series":
 		return Series
+	case "tags", "tag":
+		return Tags
 	default:
 		return NoType
 	}
@@ -276,7 +617,12 @@ func (c Client) CleanupCategories() error {
 		if count > 0 {
 			log.Println("Deleted", count, "rows from", catType)
 		}
-		return nil
+
+		_, err = c.handler.Exec(
+			fmt.Sprintf("DELETE FROM category_aliases WHERE category_type = ? AND category_id NOT IN (SELECT id FROM %s)", catType),
+			catType,
+		)
+		return err
 	}
 
 	err := cleanup(Authors)
@@ -295,10 +641,26 @@ func (c Client) CleanupCategories() error {
 	if err != nil {
 		return err
 	}
+	err = cleanup(Tags)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
 
+func (c Client) addCategoryAlias(categoryType CategoryType, id int, alias string) error {
+
+	_, err := c.handler.Exec(`
+	INSERT INTO category_aliases (category_type, alias, category_id)
+	VALUES (?, ?, ?)
+	ON CONFLICT (category_type, alias)
+		DO UPDATE SET category_id = excluded.category_id
+	`, categoryType, alias, id)
+	return err
+}
+
+// Finds the category's id by its name, or by one of its aliases. When found by an alias, the name is replaced with the category's name
 func (cat *Category) GetID(handler Handler) (bool, error) {
 
 	if cat.Type == NoType {
@@ -313,6 +675,15 @@ func (cat *Category) GetID(handler Handler) (bool, error) {
 	`, cat.Type)
 
 	err := handler.QueryRow(query, cat.Name).Scan(&cat.Id)
+	if err == sql.ErrNoRows {
+		aliasQuery := fmt.Sprintf(`
+		SELECT cat.id, cat.name FROM category_aliases
+		JOIN %s AS cat ON cat.id = category_aliases.category_id
+		WHERE category_aliases.category_type = ? AND category_aliases.alias = ?
+		`, cat.Type)
+
+		err = handler.QueryRow(aliasQuery, cat.Type, cat.Name).Scan(&cat.Id, &cat.Name)
+	}
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMergeAndAliasCategories(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	addBook := func(title string, authors ...string) Book {
+		t.Helper()
+
+		cats := StrToCategorySlice(authors)
+		book, err := client.AddBook(BookParams{Title: &title, Authors: &cats})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+		return book
+	}
+
+	hobbit := addBook("The Hobbit", "J.R.R. Tolkien")
+	children := addBook("The Children of Húrin", "Christopher Tolkien", "J. R. R. Tolkien")
+	addBook("Unfinished Tales", "J. R. R. Tolkien", "J.R.R. Tolkien")
+
+	target := Category{Name: "J.R.R. Tolkien", Type: Authors}
+	source := Category{Name: "J. R. R. Tolkien", Type: Authors}
+	for _, cat := range []*Category{&target, &source} {
+		if ok, err := cat.GetID(client.handler); err != nil || !ok {
+			t.Fatalf("Failed to find %s: %v", cat.Name, err)
+		}
+	}
+
+	merged, err := client.MergeCategories(Authors, *target.Id, []int{*source.Id})
+	if err != nil {
+		t.Fatalf("MergeCategories failed: %v", err)
+	}
+	if !slices.Equal(merged.Aliases, []string{"J. R. R. Tolkien"}) {
+		t.Errorf("Expected the merged name to become an alias, got %v", merged.Aliases)
+	}
+
+	// Books keep the rank they had the merged author at
+	authors, err := client.GetCategoryTypesAssociatedWithBook(children.Id.String(), Authors)
+	if err != nil {
+		t.Fatalf("GetCategoryTypesAssociatedWithBook failed: %v", err)
+	}
+	if len(authors) != 2 || authors[1].Name != "J.R.R. Tolkien" {
+		t.Errorf("Expected Tolkien to stay the second author, got %v", authors)
+	}
+
+	ids, err := client.GetBooksWithCategories(Authors, []int{*target.Id})
+	if err != nil {
+		t.Fatalf("GetBooksWithCategories failed: %v", err)
+	}
+	if len(ids) != 3 {
+		t.Errorf("Expected 3 books by the merged author, got %d", len(ids))
+	}
+
+	// New books using the alias map onto the merged author
+	silmarillion := addBook("The Silmarillion", "j. r. r. tolkien")
+	if len(silmarillion.Authors) != 1 || *silmarillion.Authors[0].Id != *target.Id {
+		t.Errorf("Expected the alias to resolve to the merged author, got %v", silmarillion.Authors)
+	}
+
+	// Renaming keeps the old name as an alias, and can't take the name of another author
+	renamed, err := client.RenameCategory(Authors, *target.Id, "John Ronald Reuel Tolkien")
+	if err != nil {
+		t.Fatalf("RenameCategory failed: %v", err)
+	}
+	if !slices.Contains(renamed.Aliases, "J.R.R. Tolkien") {
+		t.Errorf("Expected the old name to become an alias, got %v", renamed.Aliases)
+	}
+
+	_, err = client.RenameCategory(Authors, *target.Id, "Christopher Tolkien")
+	if !errors.Is(err, ErrCategoryExists) {
+		t.Errorf("Expected ErrCategoryExists, got %v", err)
+	}
+
+	// Removing an alias, and aliases or authors that don't exist
+	removed, err := client.RemoveCategoryAlias(Authors, *target.Id, "J.R.R. Tolkien")
+	if err != nil {
+		t.Fatalf("RemoveCategoryAlias failed: %v", err)
+	}
+	if slices.Contains(removed.Aliases, "J.R.R. Tolkien") {
+		t.Errorf("Expected the alias removed, got %v", removed.Aliases)
+	}
+	_, err = client.RemoveCategoryAlias(Authors, *target.Id, "J.R.R. Tolkien")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows removing a missing alias, got %v", err)
+	}
+	_, err = client.RemoveCategoryAlias(Authors, -1, "J. R. R. Tolkien")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a missing author, got %v", err)
+	}
+
+	err = client.DeleteCategoryWithID(Authors, *target.Id)
+	if err != nil {
+		t.Fatalf("DeleteCategoryWithID failed: %v", err)
+	}
+	book, err := client.GetBook(*hobbit.Id)
+	if err != nil {
+		t.Fatalf("GetBook failed: %v", err)
+	}
+	if len(book.Authors) != 0 {
+		t.Errorf("Expected the deleted author to be removed from the book, got %v", book.Authors)
+	}
+}
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A hand picked, ordered list of books. Private collections are only visible to the user that created them,
+// shared ones are visible to everyone but can still only be changed by their owner
+type Collection struct {
+	Id          uuid.UUID  `json:"id"`
+	UserId      *uuid.UUID `json:"user_id"`
+	Name        string     `json:"name"`
+	Description *string    `json:"description"`
+	Shared      bool       `json:"shared"`
+	BookCount   int        `json:"book_count"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// The member chosen as the cover. When none is chosen the first member with a cover is used
+	CoverBookId *uuid.UUID `json:"cover_book_id"`
+	Cover       *string    `json:"cover"`
+
+	// The member the cover is taken from
+	CoverSource *uuid.UUID `json:"-"`
+}
+
+type CollectionParams struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Shared      *bool   `json:"shared"`
+
+	// Must be a member of the collection. uuid.Nil clears the choice
+	CoverBookId *uuid.UUID `json:"cover_book_id"`
+}
+
+const collectionColumns = `
+	collections.id, collections.user_id, collections.name, collections.description, collections.shared,
+	(SELECT COUNT(*) FROM collections_books WHERE collection_id = collections.id),
+	collections.created_at, collections.updated_at, collections.cover_book_id,
+	COALESCE(
+		collections.cover_book_id,
+		(SELECT book_id FROM collections_books JOIN books ON books.id = collections_books.book_id
+			WHERE collection_id = collections.id AND books.cover IS NOT NULL ORDER BY position LIMIT 1),
+		(SELECT book_id FROM collections_books WHERE collection_id = collections.id ORDER BY position LIMIT 1)
+	)`
+
+// Collections the user can see
+const collectionVisible = "(collections.user_id IS ? OR collections.shared)"
+
+func (col Collection) OwnedBy(userId *uuid.UUID) bool {
+	if col.UserId == nil || userId == nil {
+		return col.UserId == userId
+	}
+	return *col.UserId == *userId
+}
+
+func (c *Client) AddCollection(userId *uuid.UUID, params CollectionParams) (Collection, error) {
+
+	id := uuid.New()
+	shared := params.Shared != nil && *params.Shared
+
+	_, err := c.handler.Exec(`
+	INSERT INTO collections
+		(id, user_id, name, description, shared, created_at, updated_at)
+	VALUES
+		(?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, id, userId, strings.TrimSpace(*params.Name), params.Description, shared)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	log.Println("Created the collection \"", *params.Name, "\"")
+
+	return c.GetCollection(userId, id)
+}
+
+func (c *Client) GetCollection(userId *uuid.UUID, id uuid.UUID) (Collection, error) {
+	return scanCollection(c.handler.QueryRow("SELECT "+collectionColumns+" FROM collections WHERE collections.id = ? AND "+collectionVisible, id, userId))
+}
+
+func (c *Client) GetCollections(userId *uuid.UUID) ([]Collection, error) {
+
+	rows, err := c.handler.Query("SELECT "+collectionColumns+" FROM collections WHERE "+collectionVisible+" ORDER BY collections.name COLLATE NOCASE", userId)
+	if err != nil {
+		return []Collection{}, err
+	}
+	defer rows.Close()
+
+	collections := []Collection{}
+	for rows.Next() {
+		collection, err := scanCollection(rows)
+		if err != nil {
+			return []Collection{}, err
+		}
+		collections = append(collections, collection)
+	}
+
+	return collections, rows.Err()
+}
+
+// Only updates the collection if the user owns it
+func (c *Client) UpdateCollection(userId *uuid.UUID, id uuid.UUID, params CollectionParams) (Collection, error) {
+
+	setParts := []string{"updated_at = CURRENT_TIMESTAMP"}
+	args := []any{}
+
+	if params.Name != nil {
+		setParts = append(setParts, "name = ?")
+		args = append(args, strings.TrimSpace(*params.Name))
+	}
+	if params.Description != nil {
+		setParts = append(setParts, "description = ?")
+		args = append(args, params.Description)
+	}
+	if params.Shared != nil {
+		setParts = append(setParts, "shared = ?")
+		args = append(args, *params.Shared)
+	}
+	if params.CoverBookId != nil {
+		setParts = append(setParts, "cover_book_id = ?")
+		if *params.CoverBookId == uuid.Nil {
+			args = append(args, nil)
+		} else {
+			args = append(args, params.CoverBookId)
+		}
+	}
+
+	args = append(args, id, userId)
+	_, err := c.handler.Exec("UPDATE collections SET "+strings.Join(setParts, ", ")+" WHERE id = ? AND user_id IS ?", args...)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	return c.GetCollection(userId, id)
+}
+
+// Only deletes the collection if the user owns it. The books aren't affected
+func (c *Client) DeleteCollection(userId *uuid.UUID, id uuid.UUID) error {
+
+	_, err := c.handler.Exec("DELETE FROM collections WHERE id = ? AND user_id IS ?", id, userId)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Removed the collection with the id \"", id, "\"")
+
+	return nil
+}
+
+// Returns the ids of the collection's books in order
+func (c *Client) GetCollectionBookIds(id uuid.UUID) ([]uuid.UUID, error) {
+
+	rows, err := c.handler.Query("SELECT book_id FROM collections_books WHERE collection_id = ? ORDER BY position ASC", id)
+	if err != nil {
+		return []uuid.UUID{}, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var bookId uuid.UUID
+		err = rows.Scan(&bookId)
+		if err != nil {
+			return []uuid.UUID{}, err
+		}
+		ids = append(ids, bookId)
+	}
+
+	return ids, rows.Err()
+}
+
+// Adds the books at position, or to the end when position is nil. Books that are already in the collection stay where they are
+func (c *Client) AddBooksToCollection(id uuid.UUID, bookIds []uuid.UUID, position *int) error {
+
+	current, err := c.GetCollectionBookIds(id)
+	if err != nil {
+		return err
+	}
+
+	added := []uuid.UUID{}
+	for _, bookId := range bookIds {
+		if slices.Contains(current, bookId) || slices.Contains(added, bookId) {
+			continue
+		}
+
+		_, err = c.handler.Exec("INSERT INTO collections_books (collection_id, book_id, position) VALUES (?, ?, ?)", id, bookId, len(current)+len(added))
+		if err != nil {
+			return err
+		}
+		added = append(added, bookId)
+	}
+
+	if position == nil || len(added) == 0 {
+		return c.touchCollection(id)
+	}
+
+	at := min(max(*position, 0), len(current))
+	return c.ReorderCollectionBooks(id, slices.Concat(current[:at], added, current[at:]))
+}
+
+func (c *Client) RemoveBooksFromCollection(id uuid.UUID, bookIds []uuid.UUID) error {
+
+	for _, bookId := range bookIds {
+		_, err := c.handler.Exec("DELETE FROM collections_books WHERE collection_id = ? AND book_id = ?", id, bookId)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A removed book can't be the cover anymore
+	_, err := c.handler.Exec(`
+	UPDATE collections SET cover_book_id = NULL
+	WHERE id = ? AND cover_book_id NOT IN (SELECT book_id FROM collections_books WHERE collection_id = ?)
+	`, id, id)
+	if err != nil {
+		return err
+	}
+
+	return c.ReorderCollectionBooks(id, nil)
+}
+
+// Sets the order of the collection's books. Books missing from bookIds keep their relative order after the listed ones
+func (c *Client) ReorderCollectionBooks(id uuid.UUID, bookIds []uuid.UUID) error {
+
+	current, err := c.GetCollectionBookIds(id)
+	if err != nil {
+		return err
+	}
+
+	order := []uuid.UUID{}
+	for _, bookId := range slices.Concat(bookIds, current) {
+		if slices.Contains(current, bookId) && !slices.Contains(order, bookId) {
+			order = append(order, bookId)
+		}
+	}
+
+	for position, bookId := range order {
+		_, err = c.handler.Exec("UPDATE collections_books SET position = ? WHERE collection_id = ? AND book_id = ?", position, id, bookId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.touchCollection(id)
+}
+
+func (c *Client) CheckCollectionHasBook(id uuid.UUID, bookId uuid.UUID) (bool, error) {
+
+	var exists bool
+	err := c.handler.QueryRow("SELECT EXISTS(SELECT 1 FROM collections_books WHERE collection_id = ? AND book_id = ?)", id, bookId).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// #region Helpers
+
+func (c *Client) touchCollection(id uuid.UUID) error {
+	_, err := c.handler.Exec("UPDATE collections SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+func scanCollection(row rowScanner) (Collection, error) {
+
+	var collection Collection
+	err := row.Scan(
+		&collection.Id,
+		&collection.UserId,
+		&collection.Name,
+		&collection.Description,
+		&collection.Shared,
+		&collection.BookCount,
+		&collection.CreatedAt,
+		&collection.UpdatedAt,
+		&collection.CoverBookId,
+		&collection.CoverSource,
+	)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	return collection, nil
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCollections(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	ids := []uuid.UUID{}
+	for _, title := range []string{"Mistborn", "Warbreaker", "Elantris", "Kingdom of Ash"} {
+		book, err := client.AddBook(BookParams{Title: &title})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+		ids = append(ids, *book.Id)
+	}
+
+	owner := uuid.New()
+	_, err := client.db.Exec("INSERT INTO users (id, username, password_hash) VALUES (?, 'owner', 'hash')", owner)
+	if err != nil {
+		t.Fatalf("Failed to add a user: %v", err)
+	}
+
+	name := "Cosmere"
+	collection, err := client.AddCollection(&owner, CollectionParams{Name: &name})
+	if err != nil {
+		t.Fatalf("AddCollection failed: %v", err)
+	}
+
+	err = client.AddBooksToCollection(collection.Id, []uuid.UUID{ids[0], ids[1]}, nil)
+	if err != nil {
+		t.Fatalf("AddBooksToCollection failed: %v", err)
+	}
+	position := 1
+	err = client.AddBooksToCollection(collection.Id, []uuid.UUID{ids[2], ids[0]}, &position)
+	if err != nil {
+		t.Fatalf("AddBooksToCollection failed: %v", err)
+	}
+
+	expectOrder := func(expected []uuid.UUID) {
+		t.Helper()
+
+		results, err := client.GetBooksSummary(map[string][]string{"collection": {collection.Id.String()}})
+		if err != nil {
+			t.Fatalf("GetBooksSummary failed: %v", err)
+		}
+		if len(results.Items) != len(expected) {
+			t.Fatalf("Expected %d books in the collection, got %d", len(expected), len(results.Items))
+		}
+		for i := range expected {
+			if results.Items[i].Id != expected[i] {
+				t.Errorf("Expected %s at position %d, got %s", expected[i], i, results.Items[i].Id)
+			}
+		}
+	}
+
+	// Books already in the collection stay where they are
+	expectOrder([]uuid.UUID{ids[0], ids[2], ids[1]})
+
+	err = client.ReorderCollectionBooks(collection.Id, []uuid.UUID{ids[1]})
+	if err != nil {
+		t.Fatalf("ReorderCollectionBooks failed: %v", err)
+	}
+	expectOrder([]uuid.UUID{ids[1], ids[0], ids[2]})
+
+	_, err = client.UpdateCollection(&owner, collection.Id, CollectionParams{CoverBookId: &ids[0]})
+	if err != nil {
+		t.Fatalf("UpdateCollection failed: %v", err)
+	}
+
+	// Removing the cover book falls back to the first member
+	err = client.RemoveBooksFromCollection(collection.Id, []uuid.UUID{ids[0]})
+	if err != nil {
+		t.Fatalf("RemoveBooksFromCollection failed: %v", err)
+	}
+	expectOrder([]uuid.UUID{ids[1], ids[2]})
+
+	collection, err = client.GetCollection(&owner, collection.Id)
+	if err != nil {
+		t.Fatalf("GetCollection failed: %v", err)
+	}
+	if collection.CoverBookId != nil || collection.CoverSource == nil || *collection.CoverSource != ids[1] {
+		t.Errorf("Expected the cover to fall back to %s, got %v", ids[1], collection.CoverSource)
+	}
+	if collection.BookCount != 2 {
+		t.Errorf("Expected 2 books, got %d", collection.BookCount)
+	}
+
+	// Private collections are hidden from other users until they're shared
+	other := uuid.New()
+	_, err = client.GetCollection(&other, collection.Id)
+	if err == nil {
+		t.Error("Private collections shouldn't be visible to other users")
+	}
+
+	shared := true
+	_, err = client.UpdateCollection(&other, collection.Id, CollectionParams{Shared: &shared})
+	if err == nil {
+		t.Error("Only the owner should be able to update a collection")
+	}
+	_, err = client.UpdateCollection(&owner, collection.Id, CollectionParams{Shared: &shared})
+	if err != nil {
+		t.Fatalf("UpdateCollection failed: %v", err)
+	}
+
+	collection, err = client.GetCollection(&other, collection.Id)
+	if err != nil {
+		t.Fatalf("Shared collections should be visible to everyone: %v", err)
+	}
+	if collection.OwnedBy(&other) {
+		t.Error("The collection shouldn't be owned by another user")
+	}
+}
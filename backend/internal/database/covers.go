@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A cover image that can be used for a book. Candidate images are stored in the metadata folder as covers/<book_id>/<id>.jpg
+type CoverCandidate struct {
+	Id        uuid.UUID `json:"id"`
+	BookId    uuid.UUID `json:"book_id"`
+	Source    string    `json:"source"`
+	Url       *string   `json:"url"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	Active    bool      `json:"active"`
+	Image     string    `json:"image"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// The sha256 of the image, nil for candidates added before hashes were kept
+	Hash *string `json:"-"`
+}
+
+type CoverCandidateParams struct {
+	Source string
+	Url    *string
+	Width  int
+	Height int
+	Hash   string
+}
+
+// Where a cover candidate came from
+const (
+	CoverSourceDownload = "download"
+	CoverSourceFolder   = "folder"
+	CoverSourceMetadata = "metadata"
+	CoverSourceUrl      = "url"
+)
+
+const coverColumns = "id, book_id, source, url, width, height, active, created_at, hash"
+
+func (c *Client) AddCoverCandidate(bookId uuid.UUID, params CoverCandidateParams) (CoverCandidate, error) {
+
+	id := uuid.New()
+
+	_, err := c.handler.Exec(`
+	INSERT INTO cover_candidates
+		(id, book_id, source, url, width, height, active, created_at, hash)
+	VALUES
+		(?, ?, ?, ?, ?, ?, FALSE, CURRENT_TIMESTAMP, ?)
+	`, id, bookId, params.Source, params.Url, params.Width, params.Height, nullIfEmpty(params.Hash))
+	if err != nil {
+		return CoverCandidate{}, err
+	}
+
+	log.Println("Added a", params.Source, "cover candidate to the book", bookId)
+
+	return c.GetCoverCandidate(id)
+}
+
+func (c *Client) GetCoverCandidate(id uuid.UUID) (CoverCandidate, error) {
+	return scanCoverCandidate(c.handler.QueryRow("SELECT "+coverColumns+" FROM cover_candidates WHERE id = ?", id))
+}
+
+func (c *Client) GetCoverCandidates(bookId uuid.UUID) ([]CoverCandidate, error) {
+
+	rows, err := c.handler.Query("SELECT "+coverColumns+" FROM cover_candidates WHERE book_id = ? ORDER BY created_at ASC", bookId)
+	if err != nil {
+		return []CoverCandidate{}, err
+	}
+	defer rows.Close()
+
+	candidates := []CoverCandidate{}
+	for rows.Next() {
+		candidate, err := scanCoverCandidate(rows)
+		if err != nil {
+			return []CoverCandidate{}, err
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
+}
+
+// Returns nil if the book has no active cover candidate
+func (c *Client) GetActiveCoverCandidate(bookId uuid.UUID) (*CoverCandidate, error) {
+
+	candidates, err := c.GetCoverCandidates(bookId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, candidate := range candidates {
+		if candidate.Active {
+			return &candidate, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Returns nil if the book has no candidate downloaded from the url
+func (c *Client) GetCoverCandidateByUrl(bookId uuid.UUID, url string) (*CoverCandidate, error) {
+
+	candidate, err := scanCoverCandidate(c.handler.QueryRow("SELECT "+coverColumns+" FROM cover_candidates WHERE book_id = ? AND url = ? LIMIT 1", bookId, url))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &candidate, nil
+}
+
+// Returns nil if the book has no candidate with the image hash
+func (c *Client) GetCoverCandidateByHash(bookId uuid.UUID, hash string) (*CoverCandidate, error) {
+
+	candidate, err := scanCoverCandidate(c.handler.QueryRow("SELECT "+coverColumns+" FROM cover_candidates WHERE book_id = ? AND hash = ? LIMIT 1", bookId, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &candidate, nil
+}
+
+// Saves the hash of a candidate added before hashes were kept
+func (c *Client) SetCoverCandidateHash(id uuid.UUID, hash string) error {
+	_, err := c.handler.Exec("UPDATE cover_candidates SET hash = ? WHERE id = ?", hash, id)
+	return err
+}
+
+// Marks the candidate as the book's cover. Only one candidate per book is active
+func (c *Client) SetActiveCoverCandidate(bookId, id uuid.UUID) error {
+	_, err := c.handler.Exec("UPDATE cover_candidates SET active = (id = ?) WHERE book_id = ?", id, bookId)
+	return err
+}
+
+func (c *Client) DeleteCoverCandidate(id uuid.UUID) error {
+
+	_, err := c.handler.Exec("DELETE FROM cover_candidates WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Removed the cover candidate with the id \"", id, "\" from the database")
+
+	return nil
+}
+
+// #region Helpers
+
+func scanCoverCandidate(row rowScanner) (CoverCandidate, error) {
+
+	var candidate CoverCandidate
+	err := row.Scan(
+		&candidate.Id,
+		&candidate.BookId,
+		&candidate.Source,
+		&candidate.Url,
+		&candidate.Width,
+		&candidate.Height,
+		&candidate.Active,
+		&candidate.CreatedAt,
+		&candidate.Hash,
+	)
+	if err != nil {
+		return CoverCandidate{}, err
+	}
+
+	return candidate, nil
+}
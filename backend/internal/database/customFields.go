@@ -0,0 +1,457 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CustomFieldType string
+
+const (
+	CustomText    CustomFieldType = "text"
+	CustomNumber  CustomFieldType = "number"
+	CustomDate    CustomFieldType = "date"
+	CustomBoolean CustomFieldType = "boolean"
+	CustomEnum    CustomFieldType = "enum"
+)
+
+var CustomFieldTypes = []CustomFieldType{CustomText, CustomNumber, CustomDate, CustomBoolean, CustomEnum}
+
+// A field the schema doesn't have, defined by the user. Books store a value for it under Key in their custom values
+type CustomField struct {
+	Key       string          `json:"key"`
+	Name      string          `json:"name"`
+	Type      CustomFieldType `json:"type"`
+	Options   []string        `json:"options,omitempty"`
+	CreatedAt *time.Time      `json:"created_at,omitempty"`
+}
+
+type CustomFieldParams struct {
+	Key     *string          `json:"key"`
+	Name    *string          `json:"name"`
+	Type    *CustomFieldType `json:"type"`
+	Options *[]string        `json:"options"`
+}
+
+// A custom field definition or value that isn't valid. Key is the field's key
+type CustomFieldError struct {
+	Key string
+	Msg string
+}
+
+func (e CustomFieldError) Error() string {
+	return fmt.Sprintf("custom field \"%s\": %s", e.Key, e.Msg)
+}
+
+var ErrCustomFieldExists = errors.New("a custom field with that key already exists")
+
+// Keys are used in filters like custom.<key>=value, so they're kept simple
+var customFieldKeyPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Dates are stored in this format so they sort and compare as text
+const customDateLayout = "2006-01-02"
+
+func (c *Client) GetCustomFields() ([]CustomField, error) {
+
+	rows, err := c.handler.Query("SELECT key, name, type, options, created_at FROM custom_fields ORDER BY created_at, rowid")
+	if err != nil {
+		return []CustomField{}, err
+	}
+	defer rows.Close()
+
+	fields := []CustomField{}
+	for rows.Next() {
+		field, err := scanCustomField(rows)
+		if err != nil {
+			return []CustomField{}, err
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, rows.Err()
+}
+
+// Returns sql.ErrNoRows if the field doesn't exist
+func (c *Client) GetCustomField(key string) (CustomField, error) {
+	return scanCustomField(c.handler.QueryRow("SELECT key, name, type, options, created_at FROM custom_fields WHERE key = ?", key))
+}
+
+// Returns a CustomFieldError if the definition isn't valid, or ErrCustomFieldExists if the key is taken
+func (c *Client) AddCustomField(params CustomFieldParams) (CustomField, error) {
+
+	if params.Key == nil || !customFieldKeyPattern.MatchString(*params.Key) {
+		key := ""
+		if params.Key != nil {
+			key = *params.Key
+		}
+		return CustomField{}, CustomFieldError{key, "keys can only have lowercase letters, numbers and underscores"}
+	}
+	if params.Type == nil || !slices.Contains(CustomFieldTypes, *params.Type) {
+		return CustomField{}, CustomFieldError{*params.Key, "the type must be text, number, date, boolean or enum"}
+	}
+
+	field := CustomField{Key: *params.Key, Name: *params.Key, Type: *params.Type}
+	if params.Name != nil && strings.TrimSpace(*params.Name) != "" {
+		field.Name = strings.TrimSpace(*params.Name)
+	}
+
+	options, err := customFieldOptions(field, params.Options)
+	if err != nil {
+		return CustomField{}, err
+	}
+
+	if _, err := c.GetCustomField(field.Key); err == nil {
+		return CustomField{}, ErrCustomFieldExists
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return CustomField{}, err
+	}
+
+	_, err = c.handler.Exec("INSERT INTO custom_fields (key, name, type, options, created_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)", field.Key, field.Name, field.Type, options)
+	if err != nil {
+		return CustomField{}, err
+	}
+
+	return c.GetCustomField(field.Key)
+}
+
+// Changes the name and the options of an enum. The key and type can't change. Options that books use can't be removed
+func (c *Client) UpdateCustomField(key string, params CustomFieldParams) (CustomField, error) {
+
+	field, err := c.GetCustomField(key)
+	if err != nil {
+		return CustomField{}, err
+	}
+
+	if params.Key != nil && *params.Key != key {
+		return CustomField{}, CustomFieldError{key, "the key can't be changed"}
+	}
+	if params.Type != nil && *params.Type != field.Type {
+		return CustomField{}, CustomFieldError{key, "the type can't be changed"}
+	}
+
+	if params.Name != nil && strings.TrimSpace(*params.Name) != "" {
+		_, err = c.handler.Exec("UPDATE custom_fields SET name = ? WHERE key = ?", strings.TrimSpace(*params.Name), key)
+		if err != nil {
+			return CustomField{}, err
+		}
+	}
+
+	if params.Options != nil {
+		options, err := customFieldOptions(field, params.Options)
+		if err != nil {
+			return CustomField{}, err
+		}
+
+		for _, option := range field.Options {
+			if slices.ContainsFunc(*params.Options, func(o string) bool { return strings.TrimSpace(o) == option }) {
+				continue
+			}
+			var count int
+			err = c.handler.QueryRow("SELECT COUNT(*) FROM book_custom_values WHERE field_key = ? AND value = ?", key, option).Scan(&count)
+			if err != nil {
+				return CustomField{}, err
+			}
+			if count > 0 {
+				return CustomField{}, CustomFieldError{key, fmt.Sprintf("\"%s\" is used by %d books", option, count)}
+			}
+		}
+
+		_, err = c.handler.Exec("UPDATE custom_fields SET options = ? WHERE key = ?", options, key)
+		if err != nil {
+			return CustomField{}, err
+		}
+	}
+
+	return c.GetCustomField(key)
+}
+
+// Removes the field and every book's value for it
+func (c *Client) DeleteCustomField(key string) error {
+
+	result, err := c.handler.Exec("DELETE FROM custom_fields WHERE key = ?", key)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Returns the book's values by field key
+func (c *Client) GetBookCustomValues(bookId uuid.UUID) (map[string]any, error) {
+
+	rows, err := c.handler.Query(`
+	SELECT cf.key, cf.type, cv.value FROM book_custom_values AS cv
+	JOIN custom_fields AS cf ON cf.key = cv.field_key
+	WHERE cv.book_id = ?
+	`, bookId)
+	if err != nil {
+		return map[string]any{}, err
+	}
+	defer rows.Close()
+
+	values := map[string]any{}
+	for rows.Next() {
+		var key string
+		var fieldType CustomFieldType
+		var value any
+		err = rows.Scan(&key, &fieldType, &value)
+		if err != nil {
+			return map[string]any{}, err
+		}
+		values[key] = decodeCustomValue(fieldType, value)
+	}
+
+	return values, rows.Err()
+}
+
+// Checks the values against their fields without storing them. Returns a CustomFieldError for the first invalid value
+func (c *Client) ValidateCustomValues(values map[string]any) error {
+
+	for key, value := range values {
+		field, err := c.GetCustomField(key)
+		if errors.Is(err, sql.ErrNoRows) {
+			return CustomFieldError{key, "there's no custom field with this key"}
+		} else if err != nil {
+			return err
+		}
+
+		if value == nil {
+			continue
+		}
+		if _, err := NormalizeCustomValue(field, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sets the book's values for the fields in values. Null or empty values remove the book's value. Other fields are left alone
+func (c *Client) setBookCustomValues(bookId uuid.UUID, values map[string]any) error {
+
+	err := c.ValidateCustomValues(values)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range values {
+		field, err := c.GetCustomField(key)
+		if err != nil {
+			return err
+		}
+
+		var normalized any
+		if value != nil {
+			normalized, err = NormalizeCustomValue(field, value)
+			if err != nil {
+				return err
+			}
+		}
+
+		if normalized == nil {
+			_, err = c.handler.Exec("DELETE FROM book_custom_values WHERE book_id = ? AND field_key = ?", bookId, key)
+		} else {
+			_, err = c.handler.Exec(`
+			INSERT INTO book_custom_values (book_id, field_key, value) VALUES (?, ?, ?)
+			ON CONFLICT (book_id, field_key) DO UPDATE SET value = excluded.value
+			`, bookId, key, normalized)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the value as it's stored: text, dates and enums as strings, numbers as floats and booleans as 0 or 1.
+// Empty text is nil, which removes the value
+func NormalizeCustomValue(field CustomField, value any) (any, error) {
+
+	invalid := func(msg string) (any, error) {
+		return nil, CustomFieldError{field.Key, msg}
+	}
+
+	str, isString := value.(string)
+	if isString {
+		str = strings.TrimSpace(str)
+		if str == "" {
+			return nil, nil
+		}
+	}
+
+	switch field.Type {
+	case CustomText:
+		if !isString {
+			return invalid("expected text")
+		}
+		return str, nil
+
+	case CustomNumber:
+		if number, ok := value.(float64); ok {
+			return number, nil
+		}
+		if number, err := strconv.ParseFloat(str, 64); isString && err == nil {
+			return number, nil
+		}
+		return invalid("expected a number")
+
+	case CustomDate:
+		if !isString {
+			return invalid("expected a date like 2024-01-31")
+		}
+		if date, err := time.Parse(customDateLayout, str); err == nil {
+			return date.Format(customDateLayout), nil
+		}
+		if date, err := time.Parse(time.RFC3339, str); err == nil {
+			return date.Format(customDateLayout), nil
+		}
+		return invalid("expected a date like 2024-01-31")
+
+	case CustomBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			parsed, err := strconv.ParseBool(str)
+			if !isString || err != nil {
+				return invalid("expected true or false")
+			}
+			b = parsed
+		}
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+
+	case CustomEnum:
+		if !isString {
+			return invalid("expected one of " + strings.Join(field.Options, ", "))
+		}
+		i := slices.IndexFunc(field.Options, func(option string) bool { return strings.EqualFold(option, str) })
+		if i < 0 {
+			return invalid("expected one of " + strings.Join(field.Options, ", "))
+		}
+		return field.Options[i], nil
+	}
+
+	return invalid("unknown type " + string(field.Type))
+}
+
+// The condition for a custom field filter, in the form of the query language's fields. Text matches as a substring,
+// other types match exactly or compare with op. The field's type is read in SQL, so the value is passed in every form
+func customFieldCondition(key string) func(string, string) (string, []any, string) {
+	return func(op, value string) (string, []any, string) {
+
+		var number, boolean any
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			number = n
+		}
+		if b, err := strconv.ParseBool(value); err == nil {
+			boolean = 0
+			if b {
+				boolean = 1
+			}
+		}
+
+		text := "cv.value " + op + " ? COLLATE NOCASE"
+		textArg := any(value)
+		if op == "=" {
+			text = "cv.value LIKE ?"
+			textArg = "%" + value + "%"
+		}
+
+		return fmt.Sprintf(`EXISTS (SELECT 1 FROM book_custom_values AS cv JOIN custom_fields AS cf ON cf.key = cv.field_key
+			WHERE cv.book_id = books.id AND cv.field_key = ? AND CASE cf.type
+				WHEN 'text' THEN %[2]s
+				WHEN 'number' THEN cv.value %[1]s ?
+				WHEN 'boolean' THEN cv.value %[1]s ?
+				ELSE cv.value %[1]s ? COLLATE NOCASE
+			END)`, op, text),
+			[]any{key, textArg, number, boolean, value}, ""
+	}
+}
+
+// #region Helpers
+
+func scanCustomField(row rowScanner) (CustomField, error) {
+
+	var field CustomField
+	var options *string
+	err := row.Scan(&field.Key, &field.Name, &field.Type, &options, &field.CreatedAt)
+	if err != nil {
+		return CustomField{}, err
+	}
+
+	if options != nil {
+		err = json.Unmarshal([]byte(*options), &field.Options)
+		if err != nil {
+			return CustomField{}, err
+		}
+	}
+
+	return field, nil
+}
+
+// Returns the options of an enum as JSON. Other types can't have options
+func customFieldOptions(field CustomField, options *[]string) (*string, error) {
+
+	if field.Type != CustomEnum {
+		if options != nil && len(*options) > 0 {
+			return nil, CustomFieldError{field.Key, "only enums have options"}
+		}
+		return nil, nil
+	}
+
+	cleaned := []string{}
+	if options != nil {
+		for _, option := range *options {
+			option = strings.TrimSpace(option)
+			if option != "" && !slices.ContainsFunc(cleaned, func(o string) bool { return strings.EqualFold(o, option) }) {
+				cleaned = append(cleaned, option)
+			}
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil, CustomFieldError{field.Key, "enums need at least one option"}
+	}
+
+	optionsJson, err := json.Marshal(cleaned)
+	if err != nil {
+		return nil, err
+	}
+	str := string(optionsJson)
+	return &str, nil
+}
+
+func decodeCustomValue(fieldType CustomFieldType, value any) any {
+
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+
+	switch fieldType {
+	case CustomNumber:
+		if n, ok := value.(int64); ok {
+			return float64(n)
+		}
+	case CustomBoolean:
+		if n, ok := value.(int64); ok {
+			return n != 0
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCustomFields(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	definitions := []CustomFieldParams{
+		{Key: ptr("purchased_from"), Name: ptr("Purchased From"), Type: ptr(CustomEnum), Options: &[]string{"Audible", "Kobo", " kobo "}},
+		{Key: ptr("price"), Type: ptr(CustomNumber)},
+		{Key: ptr("kids_approved"), Type: ptr(CustomBoolean)},
+		{Key: ptr("purchased"), Type: ptr(CustomDate)},
+		{Key: ptr("notes"), Type: ptr(CustomText)},
+	}
+	for _, params := range definitions {
+		_, err := client.AddCustomField(params)
+		if err != nil {
+			t.Fatalf("AddCustomField failed: %v", err)
+		}
+	}
+
+	field, err := client.GetCustomField("purchased_from")
+	if err != nil {
+		t.Fatalf("GetCustomField failed: %v", err)
+	}
+	if len(field.Options) != 2 {
+		t.Errorf("Expected repeated options to be dropped, got %v", field.Options)
+	}
+
+	var fieldErr CustomFieldError
+	for _, params := range []CustomFieldParams{
+		{Key: ptr("Bad Key"), Type: ptr(CustomText)},
+		{Key: ptr("mood"), Type: ptr(CustomEnum)},
+		{Key: ptr("mood"), Type: ptr(CustomFieldType("colour"))},
+	} {
+		if _, err := client.AddCustomField(params); !errors.As(err, &fieldErr) {
+			t.Errorf("Expected a CustomFieldError for %s, got %v", *params.Key, err)
+		}
+	}
+	if _, err := client.AddCustomField(definitions[1]); !errors.Is(err, ErrCustomFieldExists) {
+		t.Errorf("Expected ErrCustomFieldExists, got %v", err)
+	}
+
+	books := []struct {
+		title  string
+		custom map[string]any
+	}{
+		{"Mistborn", map[string]any{"purchased_from": "audible", "price": 25.5, "kids_approved": true, "purchased": "2024-03-01"}},
+		{"Elantris", map[string]any{"purchased_from": "Kobo", "price": "9", "kids_approved": "false", "notes": "Signed first edition"}},
+		{"Warbreaker", map[string]any{}},
+	}
+	for _, b := range books {
+		book, err := client.AddBook(BookParams{Title: &b.title, Custom: &b.custom})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+		if b.title == "Mistborn" && (book.Custom["purchased_from"] != "Audible" || book.Custom["price"] != 25.5 || book.Custom["kids_approved"] != true) {
+			t.Errorf("Expected the values to be normalized, got %v", book.Custom)
+		}
+	}
+
+	if err := client.ValidateCustomValues(map[string]any{"purchased_from": "Library"}); !errors.As(err, &fieldErr) {
+		t.Errorf("Expected a CustomFieldError for an unknown option, got %v", err)
+	}
+	if err := client.ValidateCustomValues(map[string]any{"missing": "x"}); !errors.As(err, &fieldErr) {
+		t.Errorf("Expected a CustomFieldError for an unknown field, got %v", err)
+	}
+
+	tests := []struct {
+		filters  map[string][]string
+		expected []string
+	}{
+		{map[string][]string{"custom.purchased_from": {"kobo"}}, []string{"Elantris"}},
+		{map[string][]string{"q": {"custom.price>10"}}, []string{"Mistborn"}},
+		{map[string][]string{"q": {"custom.kids_approved:true"}}, []string{"Mistborn"}},
+		{map[string][]string{"q": {"custom.purchased>=2024-01-01"}}, []string{"Mistborn"}},
+		{map[string][]string{"q": {"custom.notes:signed"}}, []string{"Elantris"}},
+		{map[string][]string{"sortBy": {"custom.price"}, "sortOrder": {"asc"}}, []string{"Elantris", "Mistborn", "Warbreaker"}},
+	}
+	for _, test := range tests {
+		results, err := client.GetBooksSummary(test.filters)
+		if err != nil {
+			t.Fatalf("GetBooksSummary failed for %v: %v", test.filters, err)
+		}
+		titles := []string{}
+		for _, item := range results.Items {
+			titles = append(titles, item.Title)
+		}
+		if len(titles) != len(test.expected) {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.filters, titles)
+			continue
+		}
+		for i := range titles {
+			if titles[i] != test.expected[i] {
+				t.Errorf("Expected %v for %v, got %v", test.expected, test.filters, titles)
+				break
+			}
+		}
+	}
+
+	_, err = client.UpdateCustomField("purchased_from", CustomFieldParams{Options: &[]string{"Audible"}})
+	if !errors.As(err, &fieldErr) {
+		t.Errorf("Expected an option in use to be kept, got %v", err)
+	}
+
+	err = client.DeleteCustomField("price")
+	if err != nil {
+		t.Fatalf("DeleteCustomField failed: %v", err)
+	}
+	results, err := client.GetBooks(map[string][]string{"q": {"Mistborn"}})
+	if err != nil {
+		t.Fatalf("GetBooks failed: %v", err)
+	}
+	if len(results.Items) != 1 {
+		t.Fatalf("Expected to find Mistborn, got %d books", len(results.Items))
+	}
+	if _, ok := results.Items[0].Custom["price"]; ok {
+		t.Error("Expected the deleted field's values to be removed")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log"
 	"path"
-	"strconv"
 	"strings"
 	"time"
 
@@ -22,6 +21,12 @@ type Handler interface {
 type Client struct {
 	db      *sql.DB
 	handler Handler
+	path    string
+
+	// Whether books_fts is available. See ensureSearchIndex
+	fullTextSearch bool
+
+	sorting SortOptions
 }
 
 func NewClient(dbPath string) (Client, error) {
@@ -37,15 +42,22 @@ func NewClient(dbPath string) (Client, error) {
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db: db, handler: db}
-	err = c.handleMigration()
+	c := Client{db: db, handler: db, path: dbPath}
+	err = c.migrate(dbPath)
+	if err != nil {
+		db.Close()
+		return Client{}, err
+	}
+	err = c.ensureSearchIndex()
 	if err != nil {
+		db.Close()
 		return Client{}, err
 	}
 	return c, nil
 }
 
-func (c *Client) handleMigration() error {
+// Migration 1. The schema as it was before versioned migrations. Existing databases already have these tables
+func migrateInitialSchema(c *Client) error {
 
 	// Authentication
 
@@ -58,7 +70,7 @@ func (c *Client) handleMigration() error {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	`
-	_, err := c.db.Exec(usersTable)
+	_, err := c.handler.Exec(usersTable)
 	if err != nil {
 		return err
 	}
@@ -74,7 +86,7 @@ func (c *Client) handleMigration() error {
 		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 	);
 	`
-	_, err = c.db.Exec(refreshTokensTable)
+	_, err = c.handler.Exec(refreshTokensTable)
 	if err != nil {
 		return err
 	}
@@ -92,7 +104,7 @@ func (c *Client) handleMigration() error {
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);	
 	`
-	_, err = c.db.Exec(downloadsTable)
+	_, err = c.handler.Exec(downloadsTable)
 	if err != nil {
 		return err
 	}
@@ -116,7 +128,7 @@ func (c *Client) handleMigration() error {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	`
-	_, err = c.db.Exec(booksTable)
+	_, err = c.handler.Exec(booksTable)
 	if err != nil {
 		return err
 	}
@@ -127,7 +139,7 @@ func (c *Client) handleMigration() error {
 		name TEXT UNIQUE NOT NULL	
 	);	
 	`
-	_, err = c.db.Exec(authorsTable)
+	_, err = c.handler.Exec(authorsTable)
 	if err != nil {
 		return err
 	}
@@ -138,7 +150,7 @@ func (c *Client) handleMigration() error {
 		name TEXT UNIQUE NOT NULL
 	);
 	`
-	_, err = c.db.Exec(narratorsTable)
+	_, err = c.handler.Exec(narratorsTable)
 	if err != nil {
 		return err
 	}
@@ -149,7 +161,7 @@ func (c *Client) handleMigration() error {
 		name TEXT UNIQUE NOT NULL
 	);
 	`
-	_, err = c.db.Exec(seriesTable)
+	_, err = c.handler.Exec(seriesTable)
 	if err != nil {
 		return err
 	}
@@ -160,7 +172,7 @@ func (c *Client) handleMigration() error {
 		name TEXT UNIQUE NOT NULL
 	);
 	`
-	_, err = c.db.Exec(genresTable)
+	_, err = c.handler.Exec(genresTable)
 	if err != nil {
 		return err
 	}
@@ -179,7 +191,46 @@ func (c *Client) handleMigration() error {
 			FOREIGN KEY (series_id) REFERENCES series(id) ON DELETE CASCADE
 		);
 		`
-	_, err = c.db.Exec(booksSeriestable)
+	_, err = c.handler.Exec(booksSeriestable)
+	if err != nil {
+		return err
+	}
+
+	bookFormatsTable := `
+	CREATE TABLE IF NOT EXISTS book_formats (
+		id TEXT PRIMARY KEY,
+		book_id TEXT NOT NULL,
+		label TEXT NOT NULL,
+		narrators TEXT,
+		source TEXT,
+		directory TEXT NOT NULL,
+		audio_files TEXT,
+		text_files TEXT,
+		cover TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.handler.Exec(bookFormatsTable)
+	if err != nil {
+		return err
+	}
+
+	coverCandidatesTable := `
+	CREATE TABLE IF NOT EXISTS cover_candidates (
+		id TEXT PRIMARY KEY,
+		book_id TEXT NOT NULL,
+		source TEXT NOT NULL,
+		url TEXT,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+	);
+	`
+	_, err = c.handler.Exec(coverCandidatesTable)
 	if err != nil {
 		return err
 	}
@@ -216,7 +267,7 @@ func (c *Client) generateJoiningTable(type1, type1Table, type2, type2Table strin
 		`,
 		type1Table, type2Table, type1, type2, type1, type2, type1, type1Table, type2, type2Table,
 	)
-	_, err := c.db.Exec(table)
+	_, err := c.handler.Exec(table)
 	return err
 }
 
@@ -278,9 +329,12 @@ func (c *Client) HandleTransaction(transaction func(c *Client) error) error {
 	}
 	defer tx.Rollback()
 
-	err = transaction(&Client{
-		handler: tx,
-	})
+	// The transaction client shares the settings of this one, but can't start another transaction
+	tc := *c
+	tc.db = nil
+	tc.handler = tx
+
+	err = transaction(&tc)
 	if err != nil {
 		return err
 	}
@@ -299,146 +353,191 @@ func (c *Client) Close() {
 	}
 }
 
-func buildSearchQuery(filters map[string][]string) (string, []any) {
-	var advSearchFields = [...]string{"authors", "narrators", "genres", "series", "publisher", "publish_year", "isbn", "asin", "tags"}
-	joinList := map[CategoryType]bool{
-		Authors:   false,
-		Genres:    false,
-		Narrators: false,
-		Series:    false,
-	}
+// The parts of the GET /api/books query built from its filters
+type bookQuery struct {
+	join    string
+	where   []string // AND-ed
+	args    []any    // for join and where, in order
+	sort    []sortKey
+	snippet string
+}
 
-	searchTerms := []any{}
+// Returns the query for the filters, with the column to select as the search snippet. Category filters and sorts use
+// subqueries rather than joins, so a book with many authors is still listed once.
+// Returns a QueryError if the q filter can't be parsed
+func buildSearchQuery(filters map[string][]string, fullTextSearch bool) (bookQuery, error) {
+	var advSearchFields = [...]string{"authors", "narrators", "genres", "series", "publisher", "publish_year", "isbn", "asin"}
 
-	hasFilter := false
-	filter := ""
+	query := bookQuery{snippet: "NULL"}
+	var rank []sortKey
 	if search, ok := filters["search"]; ok {
-		hasFilter = true
-		term := "%" + search[0] + "%"
-		filter += `
+		if match := buildMatchExpression(search[0]); fullTextSearch && match != "" {
+			query.join = "JOIN books_fts ON books_fts.book_id = books.id "
+			query.where = append(query.where, "books_fts MATCH ?")
+			query.args = append(query.args, match)
+			query.snippet = searchIndexSnippet
+			rank = []sortKey{{expr: searchIndexRank}}
+		} else if !fullTextSearch {
+			term := "%" + search[0] + "%"
+			query.where = append(query.where, `
 		(books.title LIKE ? OR 
 		books.subtitle LIKE ? OR 
-		books.description LIKE ?) `
-		searchTerms = append(searchTerms, term, term, term)
+		books.description LIKE ?)`)
+			query.args = append(query.args, term, term, term)
+		}
 	}
 
-	advFilter := []string{}
 	for _, field := range advSearchFields {
 		if terms, ok := filters[field]; ok {
-			hasFilter = true
-
 			if cat := stringToCategoryType(field); cat == NoType {
 				// Field is a part of the books table
 
 				split := strings.Split(terms[0], ",")
 				for _, term := range split {
-					advFilter = append(advFilter, `books.`+field+` LIKE ? `)
-					searchTerms = append(searchTerms, "%"+strings.TrimSpace(term)+"%")
+					query.where = append(query.where, `books.`+field+` LIKE ?`)
+					query.args = append(query.args, "%"+strings.TrimSpace(term)+"%")
 				}
 			} else {
 				// Field is attached via joining table
 
-				joinList[cat] = true
-				advFilter = append(advFilter, string(cat)+".name LIKE ?")
-				searchTerms = append(searchTerms, "%"+terms[0]+"%")
+				query.where = append(query.where, fmt.Sprintf(
+					"EXISTS (SELECT 1 FROM books_%[1]s JOIN %[1]s ON books_%[1]s.%[2]s_id = %[1]s.id WHERE books_%[1]s.book_id = books.id AND %[1]s.name LIKE ?)",
+					cat, categorySingular[cat],
+				))
+				query.args = append(query.args, "%"+terms[0]+"%")
 			}
 		}
 	}
 
-	if files, ok := filters["files"]; ok {
-		switch files[0] {
-		case "with_files":
-			hasFilter = true
-			advFilter = append(advFilter, "directory IS NOT NULL")
-		case "without_files":
-			hasFilter = true
-			advFilter = append(advFilter, "directory IS NULL")
+	// Books must have every tag in the list
+	if tags, ok := filters["tags"]; ok {
+		for _, tag := range tagCategories(strings.Split(tags[0], ",")) {
+			condition, args, _ := tagCondition("", tag.Name)
+			query.where = append(query.where, condition)
+			query.args = append(query.args, args...)
 		}
 	}
 
-	if hasFilter {
-		if len(advFilter) > 0 && filter != "" {
-			filter += " AND "
+	// Any of the comma separated languages
+	if languages, ok := filters["language"]; ok {
+		conditions := []string{}
+		for _, language := range strings.Split(languages[0], ",") {
+			if language = strings.TrimSpace(language); language != "" {
+				conditions = append(conditions, "books.language = ?")
+				query.args = append(query.args, NormalizeLanguage(language))
+			}
+		}
+		if len(conditions) > 0 {
+			query.where = append(query.where, "("+strings.Join(conditions, " OR ")+")")
 		}
-		filter = "WHERE " + filter
 	}
 
-	sort := ""
-	if sortType, ok := filters["sortBy"]; ok {
-		order := ""
-		if o, ok := filters["sortOrder"]; ok {
-			switch o[0] {
-			case "asc":
-				order = "ASC"
-			case "desc":
-				order = "DESC"
+	// explicit=false leaves out explicit books. Books that don't say count as false
+	for _, flag := range []string{"explicit", "abridged"} {
+		if value, ok := filters[flag]; ok {
+			if condition, args, msg := flagCondition(flag)("=", value[0]); msg == "" {
+				query.where = append(query.where, condition)
+				query.args = append(query.args, args...)
 			}
 		}
+	}
 
-		switch sortType[0] {
-		case "title", "publisher", "created_at", "publish_year":
-			sort = " ORDER BY " + sortType[0] + " " + order
-
-		default:
-			cat := stringToCategoryType(sortType[0])
-			joinList[cat] = true
-			sort = " ORDER BY " + string(cat) + ".name " + order
+	// custom.<key>=value, matched like the custom fields of q
+	for name, values := range filters {
+		key, ok := strings.CutPrefix(name, "custom.")
+		if !ok || !customFieldKeyPattern.MatchString(key) {
+			continue
+		}
+		condition, args, _ := customFieldCondition(key)("=", values[0])
+		query.where = append(query.where, condition)
+		query.args = append(query.args, args...)
+	}
 
-			if cat == Series {
-				sort += ", books_series.series_index " + order
-			}
+	if q, ok := filters["q"]; ok {
+		condition, args, err := ParseQuery(q[0], fullTextSearch)
+		if err != nil {
+			return bookQuery{}, err
+		}
+		if condition != "" {
+			query.where = append(query.where, condition)
+			query.args = append(query.args, args...)
 		}
 	}
 
-	join := ""
-	for cat, ok := range joinList {
-		if ok {
-			join += fmt.Sprintf(
-				`JOIN books_%s ON books.id = books_%s.book_id JOIN %s ON books_%s.%s_id = %s.id `,
+	if files, ok := filters["files"]; ok {
+		switch files[0] {
+		case "with_files":
+			query.where = append(query.where, "books.directory IS NOT NULL")
+		case "without_files":
+			query.where = append(query.where, "books.directory IS NULL")
+		}
+	}
 
-				string(cat),
-				string(cat),
-				string(cat),
-				string(cat),
-				categorySingular[cat],
-				string(cat),
-			)
+	// Collections keep their own order, which is used unless a sort is requested
+	if collection, ok := filters["collection"]; ok {
+		query.join += "JOIN collections_books ON collections_books.book_id = books.id "
+		query.where = append(query.where, "collections_books.collection_id = ?")
+		query.args = append(query.args, collection[0])
+		if rank == nil {
+			rank = []sortKey{{expr: "collections_books.position"}}
 		}
 	}
 
-	//fmt.Println(join + filter + strings.Join(advFilter, " AND ") + sort)
+	// Search results are ranked by relevance unless a sort is requested
+	query.sort = rank
+	if sortType, ok := filters["sortBy"]; ok {
+		desc := false
+		if o, ok := filters["sortOrder"]; ok {
+			desc = o[0] == "desc"
+		}
 
-	return join + filter + strings.Join(advFilter, " AND ") + sort, searchTerms
-}
-func buildPageQuery(filters map[string][]string) (int, int, string) {
+		switch sortType[0] {
+		case "title":
+			query.sort = []sortKey{{expr: "books.sort_title COLLATE NOCASE", desc: desc}}
 
-	countStr, hasCount := filters["count"]
-	pageStr, hasPage := filters["page"]
+		case "publisher", "created_at", "publish_year", "publish_date", "runtime_minutes", "language":
+			query.sort = []sortKey{{expr: "books." + sortType[0], desc: desc}}
 
-	if !hasCount && !hasPage {
-		return 20, 1, ""
-	}
+		default:
+			// Books without a value for the custom field go last
+			if key, ok := strings.CutPrefix(sortType[0], "custom."); ok {
+				value := "(SELECT value FROM book_custom_values WHERE book_id = books.id AND field_key = ?)"
+				query.sort = []sortKey{
+					{expr: value + " IS NULL", args: []any{key}},
+					{expr: value, args: []any{key}, desc: desc},
+				}
+				break
+			}
 
-	var err error
+			cat := stringToCategoryType(sortType[0])
+			if cat == NoType {
+				break
+			}
 
-	page := 1
-	if hasPage {
-		page, err = strconv.Atoi(pageStr[0])
-		if err != nil {
-			log.Println("Failed to convert page number to int =>", err)
-			page = 1
-		}
-	}
+			// Books sort by their first category. Authors and narrators sort by last name
+			column := "name"
+			collate := ""
+			if hasSortName(cat) {
+				column = "sort_name"
+				collate = " COLLATE NOCASE"
+			}
+			first := func(column string) string {
+				return fmt.Sprintf(
+					"(SELECT %s FROM books_%[2]s JOIN %[2]s ON books_%[2]s.%[3]s_id = %[2]s.id WHERE books_%[2]s.book_id = books.id ORDER BY books_%[2]s.rank LIMIT 1)",
+					column, cat, categorySingular[cat],
+				)
+			}
+			query.sort = []sortKey{{expr: first(string(cat)+"."+column) + collate, desc: desc}}
 
-	count := 20
-	if hasCount {
-		count, err = strconv.Atoi(countStr[0])
-		if err != nil {
-			log.Println("Failed to convert page count to int =>", err)
-			count = 20
+			if cat == Series {
+				query.sort = append(query.sort, sortKey{expr: first("books_series.sort_index"), desc: desc})
+			}
 		}
 	}
 
-	return count, page, fmt.Sprintf(" LIMIT %d OFFSET %d", count, (page-1)*count)
+	// Books added first come first among equals. The id settles the rest, and unlike the rowid it never changes, so a
+	// cursor made from it stays valid
+	query.sort = append(query.sort, sortKey{expr: "books.created_at"}, sortKey{expr: "books.id"})
 
+	return query, nil
 }
@@ -4,10 +4,11 @@ import (
 	"os"
 	"testing"
 
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func setupTestDB(t *testing.T) Client {
+func setupTestDB(t testing.TB) Client {
 	dbPath := "/tmp/test_book_organizer.db"
 	os.Remove(dbPath) // Clean up any previous test db
 	client, err := NewClient(dbPath)
@@ -66,6 +67,15 @@ func TestGetBook(t *testing.T) {
 	if *retrievedBook.Id != *addedBook.Id {
 		t.Error("Retrieved book ID does not match added book ID")
 	}
+
+	// A row that can't be read fails the whole page instead of going missing from it
+	_, err = client.db.Exec("INSERT INTO books (id, title, sort_title) VALUES ('not an id', 'Broken', 'Broken')")
+	if err != nil {
+		t.Fatalf("Failed to insert the broken book: %v", err)
+	}
+	if _, err := client.GetBooks(map[string][]string{}); err == nil {
+		t.Error("Expected GetBooks to return the scan error")
+	}
 }
 
 func TestUpdateBook(t *testing.T) {
@@ -127,3 +137,179 @@ func TestDeleteBook(t *testing.T) {
 		t.Error("GetBook should fail after deletion")
 	}
 }
+
+func TestBookFormatsFollowBookFolder(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	title := "Test Book"
+	addedBook, err := client.AddBook(BookParams{Title: &title})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+
+	root := "Unknown/Test Book"
+	audio := []string{"Unknown/Test Book/Test Book.m4b"}
+	err = client.UpdateBookFiles(*addedBook.Id, fileManagement.Files{Root: &root, AudioFiles: &audio})
+	if err != nil {
+		t.Fatalf("UpdateBookFiles failed: %v", err)
+	}
+
+	formatRoot := "Unknown/Test Book/Ebook"
+	text := []string{"Unknown/Test Book/Ebook/Test Book.epub"}
+	format, err := client.AddBookFormat(*addedBook.Id, BookFormatParams{}, fileManagement.Files{Root: &formatRoot, TextFiles: &text})
+	if err != nil {
+		t.Fatalf("AddBookFormat failed: %v", err)
+	}
+
+	if format.Kind != Ebook {
+		t.Errorf("Expected format kind %s, got %s", Ebook, format.Kind)
+	}
+
+	newTitle := "Renamed Book"
+	updatedBook, _, err := client.UpdateBook(*addedBook.Id, BookParams{Title: &newTitle})
+	if err != nil {
+		t.Fatalf("UpdateBook failed: %v", err)
+	}
+
+	if len(updatedBook.Formats) != 1 {
+		t.Fatalf("Expected 1 format, got %d", len(updatedBook.Formats))
+	}
+
+	expected := "Unknown/Renamed Book/Ebook/Test Book.epub"
+	if got := (*updatedBook.Formats[0].Files.TextFiles)[0]; got != expected {
+		t.Errorf("Expected format file %s, got %s", expected, got)
+	}
+
+	// Pointing the book back at its old folder, as when the folder couldn't be moved
+	err = client.SetBookDirectory(*addedBook.Id, root)
+	if err != nil {
+		t.Fatalf("SetBookDirectory failed: %v", err)
+	}
+	reverted, err := client.GetBook(*addedBook.Id)
+	if err != nil {
+		t.Fatalf("GetBook failed: %v", err)
+	}
+	if *reverted.Files.Root != root || (*reverted.Files.AudioFiles)[0] != audio[0] {
+		t.Errorf("Expected the book's files back in %s, got %+v", root, reverted.Files)
+	}
+	if got := (*reverted.Formats[0].Files.TextFiles)[0]; got != text[0] {
+		t.Errorf("Expected format file %s, got %s", text[0], got)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	client := setupTestDB(t)
+
+	version, err := client.GetSchemaVersion()
+	if err != nil {
+		t.Fatalf("GetSchemaVersion failed: %v", err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", LatestSchemaVersion(), version)
+	}
+
+	// Reopening an up to date database doesn't apply anything again
+	client.Close()
+	client, err = NewClient("/tmp/test_book_organizer.db")
+	if err != nil {
+		t.Fatalf("Failed to reopen the test DB: %v", err)
+	}
+
+	statuses, err := client.GetMigrationStatus()
+	if err != nil {
+		t.Fatalf("GetMigrationStatus failed: %v", err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("Expected %d migrations, got %d", len(migrations), len(statuses))
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("Migration %d wasn't applied", status.Version)
+		}
+	}
+
+	// A database from a newer build is refused
+	_, err = client.db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, 'from the future')", LatestSchemaVersion()+1)
+	if err != nil {
+		t.Fatalf("Failed to record a newer migration: %v", err)
+	}
+	client.Close()
+
+	_, err = NewClient("/tmp/test_book_organizer.db")
+	if err == nil {
+		t.Error("NewClient should refuse a database with a newer schema")
+	}
+}
+
+func TestBuildMatchExpression(t *testing.T) {
+
+	tests := []struct {
+		Search   string
+		Expected string
+	}{
+		{"mistborn", `"mistborn"*`},
+		{"way of kings", `"way"* "of"* "kings"*`},
+		{`"way of kings" sanderson`, `"way of kings" "sanderson"*`},
+		{`the "final empire`, `"the"* "final empire"`},
+		{"- ?", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Search, func(t *testing.T) {
+			if got := buildMatchExpression(test.Search); got != test.Expected {
+				t.Errorf("Expected %s, got %s", test.Expected, got)
+			}
+		})
+	}
+}
+
+func TestSearchBooks(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	if !client.fullTextSearch {
+		t.Skip("SQLite was built without FTS5. Run the tests with -tags sqlite_fts5")
+	}
+
+	titles := []string{"The Way of Kings", "Mistborn", "Kingdom of Ash"}
+	for _, title := range titles {
+		authors := []Category{{Name: "Brandon Sanderson"}}
+		if title == "Kingdom of Ash" {
+			authors = []Category{{Name: "Sarah J. Maas"}}
+		}
+
+		_, err := client.AddBook(BookParams{Title: &title, Authors: &authors})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		Search   string
+		Expected int
+	}{
+		{"sanders", 2},
+		{"king", 2},
+		{"kings sanderson", 1},
+		{`"of kings"`, 1},
+		{"nothing", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Search, func(t *testing.T) {
+			results, err := client.GetBooksSummary(map[string][]string{"search": {test.Search}})
+			if err != nil {
+				t.Fatalf("GetBooksSummary failed: %v", err)
+			}
+			if len(results.Items) != test.Expected {
+				t.Errorf("Expected %d results, got %d", test.Expected, len(results.Items))
+			}
+			for _, item := range results.Items {
+				if item.Snippet == nil {
+					t.Errorf("Expected a snippet for %s", item.Title)
+				}
+			}
+		})
+	}
+}
@@ -19,14 +19,14 @@ type Download struct {
 
 //#region Setters
 
-func (c *Client) AddDownload(files fileManagement.Files) error {
+func (c *Client) AddDownload(files fileManagement.Files) (uuid.UUID, error) {
 	var err error
 
 	id := uuid.New()
 
 	audio, text, err := files.FileListsToJson()
 	if err != nil {
-		return err
+		return uuid.Nil, err
 	}
 
 	query := `
@@ -37,12 +37,12 @@ func (c *Client) AddDownload(files fileManagement.Files) error {
 	`
 	_, err = c.handler.Exec(query, id, files.Root, audio, text, files.Cover, files.HasMetadata)
 	if err != nil {
-		return err
+		return uuid.Nil, err
 	}
 
 	log.Println("Added \"", *files.Root, "\" to downloads")
 
-	return nil
+	return id, nil
 }
 
 // Handles the transaction internallly
@@ -50,7 +50,7 @@ func (c *Client) AddDownloads(downloads []fileManagement.Files) error {
 
 	return c.HandleTransaction(func(c *Client) error {
 		for name := range downloads {
-			err := c.AddDownload(downloads[name])
+			_, err := c.AddDownload(downloads[name])
 			if err != nil {
 				return err
 			}
@@ -86,6 +86,28 @@ func (c *Client) UpdateDownloadFiles(id uuid.UUID, files fileManagement.Files) e
 
 }
 
+// Clears the book's files and, with files, adds the folder they were moved to back to the downloads. If the scanner
+// already added the folder, that download is returned instead
+func (c *Client) UnassociateBook(id uuid.UUID, files *fileManagement.Files) (*Download, error) {
+
+	err := c.DeleteBookFilesFromDatabase(id)
+	if err != nil || files == nil {
+		return nil, err
+	}
+
+	download, err := c.GetDownloadByDirectory(*files.Root)
+	if err != nil || download != nil {
+		return download, err
+	}
+
+	downloadId, err := c.AddDownload(*files)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetDownload(downloadId)
+}
+
 // Handles the transaction internally
 func (c *Client) DeleteDownload(id uuid.UUID) error {
 
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+)
+
+func TestUnassociateBook(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	book, err := client.AddBook(BookParams{Title: ptr("Dune")})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+	err = client.UpdateBookFiles(*book.Id, fileManagement.Files{
+		Root:       ptr("Frank Herbert/Dune"),
+		AudioFiles: &[]string{"Frank Herbert/Dune/01.mp3"},
+		TextFiles:  &[]string{},
+		Cover:      ptr("Frank Herbert/Dune/cover.jpg"),
+	})
+	if err != nil {
+		t.Fatalf("UpdateBookFiles failed: %v", err)
+	}
+
+	files := fileManagement.Files{
+		Root:       ptr("Dune"),
+		AudioFiles: &[]string{"Dune/01.mp3"},
+		TextFiles:  &[]string{},
+		Cover:      ptr("Dune/cover.jpg"),
+	}
+	var download *Download
+	err = client.HandleTransaction(func(c *Client) error {
+		download, err = c.UnassociateBook(*book.Id, &files)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("UnassociateBook failed: %v", err)
+	}
+
+	got, err := client.GetBook(*book.Id)
+	if err != nil {
+		t.Fatalf("GetBook failed: %v", err)
+	}
+	if got.Files.Root != nil || got.Files.Cover != nil || (got.Files.AudioFiles != nil && len(*got.Files.AudioFiles) > 0) {
+		t.Errorf("Expected the book's files to be cleared, got %+v", got.Files)
+	}
+
+	if download == nil || *download.Files.Root != "Dune" {
+		t.Fatalf("Expected the folder to be a download again, got %+v", download)
+	}
+	byDir, err := client.GetDownloadByDirectory("Dune")
+	if err != nil || byDir == nil || byDir.Id != download.Id {
+		t.Errorf("Expected the download to be found by its folder, got %+v (%v)", byDir, err)
+	}
+
+	// A download the scanner already added for the folder is kept, not added again
+	err = client.HandleTransaction(func(c *Client) error {
+		again, err := c.UnassociateBook(*book.Id, &files)
+		if err == nil && (again == nil || again.Id != download.Id) {
+			t.Errorf("Expected the existing download, got %+v", again)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("UnassociateBook failed: %v", err)
+	}
+	downloads, _ := client.GetDownloads()
+	if len(downloads) != 1 {
+		t.Errorf("Expected one download, got %d", len(downloads))
+	}
+
+	// Kept in the library, only the files are cleared
+	err = client.HandleTransaction(func(c *Client) error {
+		kept, err := c.UnassociateBook(*book.Id, nil)
+		if kept != nil {
+			t.Errorf("Expected no download when the files stay in the library, got %+v", kept)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("UnassociateBook failed: %v", err)
+	}
+}
@@ -0,0 +1,443 @@
+package database
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/google/uuid"
+)
+
+// Books that look like the same book, and why: identifier when they share an identifier, title when their titles
+// match once normalized, and authors when they also share an author
+type DuplicateGroup struct {
+	Books   []BookOverview `json:"books"`
+	Reasons []string       `json:"reasons"`
+}
+
+type BookMergeParams struct {
+	// The book that's kept. The sources are merged into it and deleted
+	Target  uuid.UUID   `json:"target"`
+	Sources []uuid.UUID `json:"sources"`
+
+	// The book to take each field from, by field name, e.g. {"description": "<source id>"}. Fields that aren't listed keep
+	// the target's value, or take the first source's value when the target doesn't have one
+	Fields map[string]uuid.UUID `json:"fields"`
+
+	// The book whose files become the merged book's files. Defaults to the target, or the first source with files.
+	// The files of the other books become additional formats of the merged book
+	Files *uuid.UUID `json:"files"`
+}
+
+// A merge the client asked for that can't be done
+type MergeError struct {
+	Msg string
+}
+
+func (e MergeError) Error() string {
+	return e.Msg
+}
+
+// The fields a merge can take from any of the books. Categories, tags, identifiers and custom values are combined instead
+var bookMergeFields = []string{"title", "subtitle", "description", "year", "isbn", "asin", "publisher", "publish_date", "language", "abridged", "explicit", "runtime_minutes", "cover"}
+
+// Editions and narrations, e.g. "(Unabridged)" or "[Dramatized Adaptation]"
+var titleEditionPattern = regexp.MustCompile(`\([^)]*\)|\[[^\]]*\]`)
+
+func (c *Client) FindDuplicateBooks() ([]DuplicateGroup, error) {
+
+	rows, err := c.handler.Query("SELECT id, title, subtitle, cover, directory, isbn, asin, language FROM books ORDER BY sort_title COLLATE NOCASE, created_at")
+	if err != nil {
+		return []DuplicateGroup{}, err
+	}
+	defer rows.Close()
+
+	books := []BookOverview{}
+	titles := map[string][]int{}
+	identifiers := map[string][]int{}
+	for rows.Next() {
+		var book BookOverview
+		var dir, isbn, asin, language *string
+		err = rows.Scan(&book.Id, &book.Title, &book.Subtitle, &book.Cover, &dir, &isbn, &asin, &language)
+		if err != nil {
+			return []DuplicateGroup{}, err
+		}
+		book.HasFiles = dir != nil
+
+		if language == nil {
+			language = new(string)
+		}
+		if title := normalizeDuplicateTitle(GenerateSortTitle(book.Title, c.titleArticles(*language))); title != "" {
+			titles[title] = append(titles[title], len(books))
+		}
+		for identifierType, value := range map[string]*string{IdentifierISBN: isbn, IdentifierASIN: asin} {
+			if value == nil {
+				continue
+			}
+			if normalized, err := NormalizeIdentifier(identifierType, *value); err == nil {
+				key := identifierType + ":" + normalized
+				identifiers[key] = append(identifiers[key], len(books))
+			}
+		}
+
+		books = append(books, book)
+	}
+	if err = rows.Err(); err != nil {
+		return []DuplicateGroup{}, err
+	}
+
+	index := map[uuid.UUID]int{}
+	for i := range books {
+		index[books[i].Id] = i
+	}
+
+	identifierRows, err := c.handler.Query("SELECT book_id, type, value FROM book_identifiers")
+	if err != nil {
+		return []DuplicateGroup{}, err
+	}
+	defer identifierRows.Close()
+	for identifierRows.Next() {
+		var bookId uuid.UUID
+		var identifierType, value string
+		err = identifierRows.Scan(&bookId, &identifierType, &value)
+		if err != nil {
+			return []DuplicateGroup{}, err
+		}
+		key := identifierType + ":" + value
+		if !slices.Contains(identifiers[key], index[bookId]) {
+			identifiers[key] = append(identifiers[key], index[bookId])
+		}
+	}
+	if err = identifierRows.Err(); err != nil {
+		return []DuplicateGroup{}, err
+	}
+
+	// Books are joined into groups as matches are found
+	parent := make([]int, len(books))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	reasons := map[int][]string{}
+	join := func(a, b int, why ...string) {
+		rootA, rootB := find(a), find(b)
+		if rootA != rootB {
+			parent[rootB] = rootA
+			reasons[rootA] = append(reasons[rootA], reasons[rootB]...)
+			delete(reasons, rootB)
+		}
+		for _, reason := range why {
+			if !slices.Contains(reasons[rootA], reason) {
+				reasons[rootA] = append(reasons[rootA], reason)
+			}
+		}
+	}
+
+	for _, matches := range identifiers {
+		for _, i := range matches[1:] {
+			join(matches[0], i, "identifier")
+		}
+	}
+
+	authors := map[int][]string{}
+	for _, matches := range titles {
+		if len(matches) < 2 {
+			continue
+		}
+		for _, i := range matches {
+			if _, ok := authors[i]; ok {
+				continue
+			}
+			cats, err := c.GetCategoryTypesAssociatedWithBook(books[i].Id.String(), Authors)
+			if err != nil {
+				return []DuplicateGroup{}, err
+			}
+			books[i].Authors = cats
+			for _, author := range cats {
+				authors[i] = append(authors[i], normalizeDuplicateAuthor(author.Name))
+			}
+		}
+
+		for x, a := range matches {
+			for _, b := range matches[x+1:] {
+				switch {
+				case len(authors[a]) == 0 || len(authors[b]) == 0:
+					// Without authors the title is all there is to go on
+					join(a, b, "title")
+				case slices.ContainsFunc(authors[a], func(name string) bool { return slices.Contains(authors[b], name) }):
+					join(a, b, "title", "authors")
+				}
+			}
+		}
+	}
+
+	groups := []DuplicateGroup{}
+	members := map[int][]int{}
+	order := []int{}
+	for i := range books {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			order = append(order, root)
+		}
+		members[root] = append(members[root], i)
+	}
+	for _, root := range order {
+		if len(members[root]) < 2 {
+			continue
+		}
+
+		group := DuplicateGroup{Books: []BookOverview{}, Reasons: reasons[find(root)]}
+		for _, i := range members[root] {
+			if books[i].Authors == nil {
+				books[i].Authors, err = c.GetCategoryTypesAssociatedWithBook(books[i].Id.String(), Authors)
+				if err != nil {
+					return []DuplicateGroup{}, err
+				}
+			}
+			group.Books = append(group.Books, books[i])
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// Merges the sources into the target and deletes them. formatDirs holds the folder, relative to the library, that the
+// files of each book other than params.Files are moved to. They're added to the target as formats.
+// Only the database is updated, the files and covers have to be moved separately.
+// Returns the merged book and the snapshot it was merged into, whose cover still has to be applied. Requires an active db transaction
+func (c *Client) MergeBooks(params BookMergeParams, formatDirs map[uuid.UUID]string) (Book, BookSnapshot, error) {
+
+	err := params.validate()
+	if err != nil {
+		return Book{}, BookSnapshot{}, err
+	}
+
+	snapshots := map[uuid.UUID]BookSnapshot{}
+	for _, id := range append([]uuid.UUID{params.Target}, params.Sources...) {
+		snapshots[id], err = c.GetBookSnapshot(id)
+		if err != nil {
+			return Book{}, BookSnapshot{}, err
+		}
+	}
+	merged := mergeBookSnapshots(params, snapshots)
+
+	// Files. The files that aren't kept as the book's files become formats
+	var files *fileManagement.Files
+	if params.Files != nil && *params.Files != params.Target {
+		book, err := c.GetBook(*params.Files)
+		if err != nil {
+			return Book{}, BookSnapshot{}, err
+		}
+		files = &book.Files
+
+		_, err = c.handler.Exec("UPDATE book_formats SET book_id = ? WHERE book_id = ?", params.Target, *params.Files)
+		if err != nil {
+			return Book{}, BookSnapshot{}, err
+		}
+	}
+	for id, dir := range formatDirs {
+		book, err := c.GetBook(id)
+		if err != nil {
+			return Book{}, BookSnapshot{}, err
+		}
+		if book.Files.Root == nil {
+			return Book{}, BookSnapshot{}, fmt.Errorf("%s has no files to add as a format", id)
+		}
+
+		kind := string(FormatKindOf(book.Files))
+		label := strings.ToUpper(kind[:1]) + kind[1:]
+		source := *book.Files.Root
+
+		book.Files.UpdateDirectory(dir)
+		_, err = c.AddBookFormat(params.Target, BookFormatParams{Label: &label, Source: &source}, book.Files)
+		if err != nil {
+			return Book{}, BookSnapshot{}, err
+		}
+	}
+	if files != nil {
+		err = c.UpdateBookFiles(params.Target, *files)
+		if err != nil {
+			return Book{}, BookSnapshot{}, err
+		}
+	}
+
+	for _, id := range params.Sources {
+		for _, query := range []string{
+			"UPDATE book_identifiers SET book_id = ? WHERE book_id = ?",
+			"UPDATE cover_candidates SET book_id = ?, active = FALSE WHERE book_id = ?",
+			"UPDATE OR IGNORE collections_books SET book_id = ? WHERE book_id = ?",
+			"UPDATE collections SET cover_book_id = ? WHERE cover_book_id = ?",
+		} {
+			_, err = c.handler.Exec(query, params.Target, id)
+			if err != nil {
+				return Book{}, BookSnapshot{}, err
+			}
+		}
+
+		// isbn and asin are unique, so the sources go before the target can take theirs
+		err = c.DeleteBook(id)
+		if err != nil {
+			return Book{}, BookSnapshot{}, err
+		}
+	}
+
+	book, err := c.RestoreBookSnapshot(params.Target, merged)
+	if err != nil {
+		return Book{}, BookSnapshot{}, err
+	}
+
+	return book, merged, nil
+}
+
+func (params BookMergeParams) validate() error {
+
+	if len(params.Sources) == 0 {
+		return MergeError{"No books to merge into the target"}
+	}
+
+	books := append([]uuid.UUID{params.Target}, params.Sources...)
+	for i, id := range books {
+		if slices.Contains(books[i+1:], id) {
+			return MergeError{fmt.Sprintf("%s is listed more than once", id)}
+		}
+	}
+
+	for field, id := range params.Fields {
+		if !slices.Contains(bookMergeFields, field) {
+			return MergeError{fmt.Sprintf("%s can't be chosen, expected one of %s", field, strings.Join(bookMergeFields, ", "))}
+		}
+		if !slices.Contains(books, id) {
+			return MergeError{fmt.Sprintf("%s is taken from %s, which isn't one of the books being merged", field, id)}
+		}
+	}
+
+	if params.Files != nil && !slices.Contains(books, *params.Files) {
+		return MergeError{fmt.Sprintf("The files are taken from %s, which isn't one of the books being merged", *params.Files)}
+	}
+
+	return nil
+}
+
+// The target with the chosen fields, and the categories, tags and custom values of every book. The directory is left out
+// so restoring the snapshot doesn't move the files
+func mergeBookSnapshots(params BookMergeParams, snapshots map[uuid.UUID]BookSnapshot) BookSnapshot {
+
+	merged := snapshots[params.Target]
+	merged.Directory = nil
+	merged.Custom = maps.Clone(merged.Custom)
+	if merged.Custom == nil {
+		merged.Custom = map[string]any{}
+	}
+
+	for _, name := range bookMergeFields {
+		field := snapshotField(&merged, name)
+
+		if from, ok := params.Fields[name]; ok {
+			source := snapshots[from]
+			field.Set(snapshotField(&source, name))
+			continue
+		}
+
+		for _, id := range params.Sources {
+			if !emptySnapshotField(field) {
+				break
+			}
+			source := snapshots[id]
+			field.Set(snapshotField(&source, name))
+		}
+	}
+
+	union := func(names, more []string) []string {
+		for _, name := range more {
+			if !slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, name) }) {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	for _, id := range params.Sources {
+		source := snapshots[id]
+
+		merged.Authors = union(slices.Clone(merged.Authors), source.Authors)
+		merged.Narrators = union(slices.Clone(merged.Narrators), source.Narrators)
+		merged.Genres = union(slices.Clone(merged.Genres), source.Genres)
+		merged.Tags = union(slices.Clone(merged.Tags), source.Tags)
+
+		for _, series := range source.Series {
+			if !slices.ContainsFunc(merged.Series, func(s Category) bool { return strings.EqualFold(s.Name, series.Name) }) {
+				merged.Series = append(slices.Clone(merged.Series), series)
+			}
+		}
+
+		for key, value := range source.Custom {
+			if _, ok := merged.Custom[key]; !ok {
+				merged.Custom[key] = value
+			}
+		}
+	}
+
+	return merged
+}
+
+// The snapshot's field with the json name
+func snapshotField(snapshot *BookSnapshot, name string) reflect.Value {
+
+	value := reflect.ValueOf(snapshot).Elem()
+	for i := range value.NumField() {
+		if tag, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("json"), ","); tag == name {
+			return value.Field(i)
+		}
+	}
+
+	return reflect.Value{}
+}
+
+func emptySnapshotField(field reflect.Value) bool {
+
+	if field.IsZero() {
+		return true
+	}
+	if field.Kind() == reflect.Pointer && field.Elem().Kind() == reflect.String {
+		return strings.TrimSpace(field.Elem().String()) == ""
+	}
+
+	return false
+}
+
+// Lowercase words without punctuation, subtitle or edition, so "Dune: Deluxe Edition (Unabridged)" matches "dune"
+func normalizeDuplicateTitle(title string) string {
+
+	title = titleEditionPattern.ReplaceAllString(strings.ToLower(title), " ")
+	title, _, _ = strings.Cut(title, ":")
+
+	words := strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	return strings.Join(words, " ")
+}
+
+// "Tolkien, J.R.R." and "JRR Tolkien" are both "jrrtolkien"
+func normalizeDuplicateAuthor(name string) string {
+
+	if last, first, ok := strings.Cut(name, ","); ok {
+		name = first + " " + last
+	}
+
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), "")
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/google/uuid"
+)
+
+func TestFindDuplicateBooks(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	books := []struct {
+		title   string
+		authors []string
+	}{
+		{"Dune", []string{"Frank Herbert"}},
+		{"Dune: Deluxe Edition (Unabridged)", []string{"Herbert, Frank"}},
+		{"The Dune", nil},
+		{"Dune", []string{"Someone Else"}},
+		{"Hyperion", []string{"Dan Simmons"}},
+		{"Hyperion Cantos", []string{"Dan Simmons"}},
+	}
+	ids := []uuid.UUID{}
+	for _, b := range books {
+		authors := StrToCategorySlice(b.authors)
+		book, err := client.AddBook(BookParams{Title: &b.title, Authors: &authors})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+		ids = append(ids, *book.Id)
+	}
+
+	// An identifier on the books table that's also in another book's identifiers
+	err := client.AddBookIdentifier(ids[4], BookIdentifier{IdentifierISBN, "9780553283686"})
+	if err != nil {
+		t.Fatalf("AddBookIdentifier failed: %v", err)
+	}
+	_, err = client.db.Exec("UPDATE books SET isbn = '9780553283686' WHERE id = ?", ids[5])
+	if err != nil {
+		t.Fatalf("Failed to set the isbn: %v", err)
+	}
+
+	groups, err := client.FindDuplicateBooks()
+	if err != nil {
+		t.Fatalf("FindDuplicateBooks failed: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %+v", groups)
+	}
+
+	for _, group := range groups {
+		found := []uuid.UUID{}
+		for _, book := range group.Books {
+			found = append(found, book.Id)
+		}
+		slices.SortFunc(found, func(a, b uuid.UUID) int { return slices.Index(ids, a) - slices.Index(ids, b) })
+
+		switch {
+		case slices.Contains(found, ids[0]):
+			// The other author's Dune is only tied in through the book without authors
+			if !slices.Equal(found, []uuid.UUID{ids[0], ids[1], ids[2], ids[3]}) {
+				t.Errorf("Unexpected Dune group %v", found)
+			}
+			if !slices.Contains(group.Reasons, "authors") {
+				t.Errorf("Expected the shared author to be a reason, got %v", group.Reasons)
+			}
+		case slices.Contains(found, ids[4]):
+			if !slices.Equal(found, []uuid.UUID{ids[4], ids[5]}) || !slices.Equal(group.Reasons, []string{"identifier"}) {
+				t.Errorf("Expected the Hyperion books to match by identifier, got %v %v", found, group.Reasons)
+			}
+		default:
+			t.Errorf("Unexpected group %v", found)
+		}
+	}
+}
+
+func TestMergeBooks(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	_, err := client.AddCustomField(CustomFieldParams{Key: ptr("rating"), Type: ptr(CustomNumber)})
+	if err != nil {
+		t.Fatalf("AddCustomField failed: %v", err)
+	}
+
+	authors := []Category{{Name: "Frank Herbert"}}
+	narrators := []Category{{Name: "Scott Brick"}}
+	target, err := client.AddBook(BookParams{Title: ptr("Dune"), Authors: &authors, Tags: &[]string{"Sci-Fi"}})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+	source, err := client.AddBook(BookParams{
+		Title:       ptr("Dune (Unabridged)"),
+		Description: ptr("Set on the desert planet Arrakis"),
+		Authors:     &authors,
+		Narrators:   &narrators,
+		Tags:        &[]string{"sci-fi", "Classics"},
+		Custom:      &map[string]any{"rating": 5.0},
+		Identifiers: &[]BookIdentifier{{IdentifierGoogle, "B1hSG45JCX4C"}},
+	})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+
+	// Both books have files
+	for _, b := range []struct {
+		id  uuid.UUID
+		dir string
+	}{{*target.Id, "Frank Herbert/Dune"}, {*source.Id, "Frank Herbert/Dune (Unabridged)"}} {
+		err = client.UpdateBookFiles(b.id, fileManagement.Files{Root: &b.dir, AudioFiles: &[]string{b.dir + "/dune.m4b"}})
+		if err != nil {
+			t.Fatalf("UpdateBookFiles failed: %v", err)
+		}
+	}
+
+	var mergeErr MergeError
+	for _, params := range []BookMergeParams{
+		{Target: *target.Id},
+		{Target: *target.Id, Sources: []uuid.UUID{*target.Id}},
+		{Target: *target.Id, Sources: []uuid.UUID{*source.Id}, Fields: map[string]uuid.UUID{"authors": *source.Id}},
+	} {
+		if _, _, err := client.MergeBooks(params, nil); !errors.As(err, &mergeErr) {
+			t.Errorf("Expected a MergeError for %+v, got %v", params, err)
+		}
+	}
+
+	book, _, err := client.MergeBooks(BookMergeParams{
+		Target:  *target.Id,
+		Sources: []uuid.UUID{*source.Id},
+		Fields:  map[string]uuid.UUID{"title": *target.Id},
+		Files:   target.Id,
+	}, map[uuid.UUID]string{*source.Id: "Frank Herbert/Dune/Dune (Unabridged)"})
+	if err != nil {
+		t.Fatalf("MergeBooks failed: %v", err)
+	}
+
+	if book.Title != "Dune" || book.Description == nil || *book.Description != "Set on the desert planet Arrakis" {
+		t.Errorf("Expected the target's title and the source's description, got %q and %v", book.Title, book.Description)
+	}
+	if names := CategoryToStrSlice(book.Narrators); !slices.Equal(names, []string{"Scott Brick"}) {
+		t.Errorf("Expected the source's narrator, got %v", names)
+	}
+	if !slices.Equal(book.Tags, []string{"Sci-Fi", "Classics"}) {
+		t.Errorf("Expected the tags to be combined, got %v", book.Tags)
+	}
+	if book.Custom["rating"] != 5.0 {
+		t.Errorf("Expected the source's custom value, got %v", book.Custom)
+	}
+	if !slices.Contains(book.Identifiers, BookIdentifier{IdentifierGoogle, "B1hSG45JCX4C"}) {
+		t.Errorf("Expected the source's identifier, got %v", book.Identifiers)
+	}
+	if book.Files.Root == nil || *book.Files.Root != "Frank Herbert/Dune" {
+		t.Errorf("Expected the target's files to be kept, got %v", book.Files.Root)
+	}
+	if len(book.Formats) != 1 || book.Formats[0].Files.Root == nil || *book.Formats[0].Files.Root != "Frank Herbert/Dune/Dune (Unabridged)" {
+		t.Errorf("Expected the source's files to become a format, got %+v", book.Formats)
+	}
+
+	if exists, _ := client.CheckBookExistsID(*source.Id); exists {
+		t.Error("Expected the source to be deleted")
+	}
+}
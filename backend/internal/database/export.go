@@ -0,0 +1,321 @@
+package database
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The version of the export format, for imports to check
+const ExportVersion = 1
+
+// Separates the values of list columns in CSV exports
+const ExportListSeparator = "; "
+
+// The columns of the books CSV export. Custom fields follow as custom.<key>
+var ExportColumns = []string{
+	"id", "title", "subtitle", "authors", "series", "genres", "narrators", "tags", "publisher", "publish_date", "year",
+	"language", "abridged", "explicit", "runtime_minutes", "isbn", "asin", "identifiers", "description", "root",
+	"audio_files", "text_files", "cover", "formats", "created_at", "updated_at",
+}
+
+// The columns of the files CSV export. The format is empty for the book's own files
+var ExportFileColumns = []string{"book_id", "title", "format", "kind", "path"}
+
+// The start of a JSON export. The books follow as a "books" array
+type LibraryExport struct {
+	Version      int           `json:"version"`
+	ExportedAt   time.Time     `json:"exported_at"`
+	CustomFields []CustomField `json:"custom_fields"`
+	Books        []Book        `json:"books,omitempty"`
+}
+
+// Calls each for every book matching the GET /api/books filters, a page at a time so the library is never all in
+// memory. Pagination filters are ignored. start is called once the filters are known to be valid, before the first book
+func (c *Client) exportBooks(filters map[string][]string, start func() error, each func(Book) error) error {
+
+	filters = maps.Clone(filters)
+	delete(filters, "page")
+	filters["count"] = []string{strconv.Itoa(MaxPageSize)}
+	delete(filters, "cursor")
+
+	started := false
+	for {
+		results, err := c.GetBooks(filters)
+		if err != nil {
+			return err
+		}
+
+		if !started {
+			err = start()
+			if err != nil {
+				return err
+			}
+			started = true
+		}
+
+		for _, book := range results.Items {
+			book.Snippet = nil
+			err = each(book)
+			if err != nil {
+				return err
+			}
+		}
+
+		if results.NextCursor == nil {
+			return nil
+		}
+		filters["cursor"] = []string{*results.NextCursor}
+	}
+}
+
+// Writes the books matching the filters, with the custom field definitions, as a LibraryExport. File paths are
+// relative to the library. Nothing is written if the filters are invalid
+func (c *Client) ExportJSON(w io.Writer, filters map[string][]string) error {
+
+	fields, err := c.GetCustomFields()
+	if err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(w)
+	first := true
+
+	err = c.exportBooks(filters, func() error {
+
+		// The header is written by hand so the books can follow one at a time
+		header, err := json.Marshal(LibraryExport{Version: ExportVersion, ExportedAt: time.Now().UTC(), CustomFields: fields})
+		if err != nil {
+			return err
+		}
+		_, err = buf.Write(header[:len(header)-1])
+		if err != nil {
+			return err
+		}
+		_, err = buf.WriteString(`,"books":[`)
+		return err
+
+	}, func(book Book) error {
+
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+
+		data, err := json.Marshal(book)
+		if err != nil {
+			return err
+		}
+		_, err = buf.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = buf.WriteString("]}\n")
+	if err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// Writes the books matching the filters as CSV, one row per book with the ExportColumns and a custom.<key> column per
+// custom field. Lists are joined with ExportListSeparator. Nothing is written if the filters are invalid
+func (c *Client) ExportCSV(w io.Writer, filters map[string][]string) error {
+
+	fields, err := c.GetCustomFields()
+	if err != nil {
+		return err
+	}
+
+	out := csv.NewWriter(w)
+
+	err = c.exportBooks(filters, func() error {
+
+		header := append([]string{}, ExportColumns...)
+		for _, field := range fields {
+			header = append(header, "custom."+field.Key)
+		}
+		return out.Write(header)
+
+	}, func(book Book) error {
+
+		row := exportRow(book)
+		for _, field := range fields {
+			row = append(row, formatCustomValue(book.Custom[field.Key]))
+		}
+		return out.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// Writes a row per file of the books matching the filters, including the files of their formats. Nothing is written if
+// the filters are invalid
+func (c *Client) ExportFilesCSV(w io.Writer, filters map[string][]string) error {
+
+	out := csv.NewWriter(w)
+
+	err := c.exportBooks(filters, func() error {
+		return out.Write(ExportFileColumns)
+
+	}, func(book Book) error {
+
+		write := func(format string, kind string, files *[]string) error {
+			if files == nil {
+				return nil
+			}
+			for _, file := range *files {
+				err := out.Write([]string{book.Id.String(), book.Title, format, kind, file})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		err := write("", "audio", book.Files.AudioFiles)
+		if err != nil {
+			return err
+		}
+		err = write("", "text", book.Files.TextFiles)
+		if err != nil {
+			return err
+		}
+		if book.Files.Cover != nil {
+			err = write("", "cover", &[]string{*book.Files.Cover})
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, format := range book.Formats {
+			err = write(format.Label, "audio", format.Files.AudioFiles)
+			if err != nil {
+				return err
+			}
+			err = write(format.Label, "text", format.Files.TextFiles)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+func exportRow(book Book) []string {
+
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	num := func(n *int) string {
+		if n == nil {
+			return ""
+		}
+		return strconv.Itoa(*n)
+	}
+	boolean := func(b *bool) string {
+		if b == nil {
+			return ""
+		}
+		return strconv.FormatBool(*b)
+	}
+	list := func(items []string) string {
+		return strings.Join(items, ExportListSeparator)
+	}
+	files := func(items *[]string) string {
+		if items == nil {
+			return ""
+		}
+		return list(*items)
+	}
+	timestamp := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	// Series keep their index as "Name #index"
+	series := []string{}
+	for _, s := range book.Series {
+		if s.Index != nil && *s.Index != "" {
+			series = append(series, s.Name+" #"+*s.Index)
+		} else {
+			series = append(series, s.Name)
+		}
+	}
+
+	identifiers := []string{}
+	for _, identifier := range book.Identifiers {
+		identifiers = append(identifiers, fmt.Sprintf("%s:%s", identifier.Type, identifier.Value))
+	}
+
+	formats := []string{}
+	for _, format := range book.Formats {
+		formats = append(formats, format.Label)
+	}
+
+	return []string{
+		book.Id.String(),
+		book.Title,
+		str(book.Subtitle),
+		list(CategoryToStrSlice(book.Authors)),
+		list(series),
+		list(CategoryToStrSlice(book.Genres)),
+		list(CategoryToStrSlice(book.Narrators)),
+		list(book.Tags),
+		str(book.Publisher),
+		str(book.PublishDate),
+		num(book.Year),
+		str(book.Language),
+		boolean(book.Abridged),
+		boolean(book.Explicit),
+		num(book.RuntimeMinutes),
+		str(book.ISBN),
+		str(book.ASIN),
+		list(identifiers),
+		str(book.Description),
+		str(book.Files.Root),
+		files(book.Files.AudioFiles),
+		files(book.Files.TextFiles),
+		str(book.Files.Cover),
+		list(formats),
+		timestamp(book.CreatedAt),
+		timestamp(book.UpdatedAt),
+	}
+}
+
+func formatCustomValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+)
+
+func TestExportLibrary(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	// More than a page, to check the pages are joined
+	addTestLibrary(t, &client, MaxPageSize+30)
+
+	dune, err := client.AddBook(BookParams{
+		Title:   ptr("Dune"),
+		Authors: &[]Category{{Name: "Frank Herbert"}},
+		Series:  &[]Category{{Name: "Dune", Index: ptr("1")}},
+		ISBN:    ptr("9780441172719"),
+		Tags:    &[]string{"Sci-Fi", "Classic"},
+		Custom:  &map[string]any{"rating": float64(4.5)},
+	})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+	err = client.UpdateBookFiles(*dune.Id, fileManagement.Files{
+		Root:       ptr("Frank Herbert/Dune/1 - Dune"),
+		AudioFiles: &[]string{"Frank Herbert/Dune/1 - Dune/01.mp3", "Frank Herbert/Dune/1 - Dune/02.mp3"},
+		TextFiles:  &[]string{"Frank Herbert/Dune/1 - Dune/dune.epub"},
+		Cover:      ptr("Frank Herbert/Dune/1 - Dune/cover.jpg"),
+	})
+	if err != nil {
+		t.Fatalf("UpdateBookFiles failed: %v", err)
+	}
+
+	// JSON
+	var out bytes.Buffer
+	err = client.ExportJSON(&out, map[string][]string{"page": {"3"}, "count": {"5"}})
+	if err != nil {
+		t.Fatalf("ExportJSON failed: %v", err)
+	}
+
+	var export LibraryExport
+	err = json.Unmarshal(out.Bytes(), &export)
+	if err != nil {
+		t.Fatalf("The JSON export doesn't decode: %v", err)
+	}
+	if export.Version != ExportVersion {
+		t.Errorf("Expected version %d, got %d", ExportVersion, export.Version)
+	}
+	if len(export.CustomFields) != 1 || export.CustomFields[0].Key != "rating" {
+		t.Errorf("Expected the custom field definitions, got %+v", export.CustomFields)
+	}
+	if len(export.Books) != MaxPageSize+31 {
+		t.Fatalf("Expected every book whatever the pagination filters, got %d", len(export.Books))
+	}
+
+	i := slices.IndexFunc(export.Books, func(book Book) bool { return book.Title == "Dune" })
+	if i < 0 {
+		t.Fatal("Expected Dune in the export")
+	}
+	book := export.Books[i]
+	if book.Files.Root == nil || *book.Files.Root != "Frank Herbert/Dune/1 - Dune" || book.Files.AudioFiles == nil || len(*book.Files.AudioFiles) != 2 {
+		t.Errorf("Expected the book's files relative to the library, got %+v", book.Files)
+	}
+	if len(book.Identifiers) != 1 || book.Custom["rating"] != 4.5 || len(book.Series) != 1 {
+		t.Errorf("Expected the book's details, got %+v", book)
+	}
+
+	// Books CSV
+	out.Reset()
+	err = client.ExportCSV(&out, map[string][]string{"authors": {"Frank Herbert"}})
+	if err != nil {
+		t.Fatalf("ExportCSV failed: %v", err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("The CSV export doesn't parse: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected a header and one book, got %d rows", len(records))
+	}
+
+	row := map[string]string{}
+	for j, column := range records[0] {
+		row[column] = records[1][j]
+	}
+	for column, expected := range map[string]string{
+		"title":           "Dune",
+		"authors":         "Frank Herbert",
+		"series":          "Dune #1",
+		"tags":            "Sci-Fi; Classic",
+		"isbn":            "9780441172719",
+		"identifiers":     "isbn:9780441172719",
+		"root":            "Frank Herbert/Dune/1 - Dune",
+		"audio_files":     "Frank Herbert/Dune/1 - Dune/01.mp3; Frank Herbert/Dune/1 - Dune/02.mp3",
+		"custom.rating":   "4.5",
+		"runtime_minutes": "",
+	} {
+		if row[column] != expected {
+			t.Errorf("Expected %s to be %q, got %q", column, expected, row[column])
+		}
+	}
+
+	// Files CSV
+	out.Reset()
+	err = client.ExportFilesCSV(&out, map[string][]string{"authors": {"Frank Herbert"}})
+	if err != nil {
+		t.Fatalf("ExportFilesCSV failed: %v", err)
+	}
+	records, err = csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("The files CSV export doesn't parse: %v", err)
+	}
+	if len(records) != 5 || !slices.Equal(records[0], ExportFileColumns) {
+		t.Fatalf("Expected a header and a row per file, got %v", records)
+	}
+	if records[4][3] != "cover" || records[4][4] != "Frank Herbert/Dune/1 - Dune/cover.jpg" {
+		t.Errorf("Expected the cover's row last, got %v", records[4])
+	}
+
+	// Nothing is written for invalid filters
+	out.Reset()
+	var queryErr QueryError
+	err = client.ExportJSON(&out, map[string][]string{"q": {"title:("}})
+	if !errors.As(err, &queryErr) {
+		t.Errorf("Expected a QueryError, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing written for invalid filters, got %q", out.String())
+	}
+}
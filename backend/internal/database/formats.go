@@ -0,0 +1,323 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/google/uuid"
+)
+
+// An additional format or edition of a book (an ebook next to the audiobook, a second narration, etc.).
+// The book's primary files stay on the books table. Additional formats live in folders nested inside the book's folder.
+type BookFormat struct {
+	Id        uuid.UUID            `json:"id"`
+	BookId    uuid.UUID            `json:"book_id"`
+	Label     string               `json:"label"`
+	Kind      FormatKind           `json:"kind"`
+	Narrators []string             `json:"narrators"`
+	Source    *string              `json:"source"`
+	Files     fileManagement.Files `json:"files"`
+	CreatedAt time.Time            `json:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+}
+
+type BookFormatParams struct {
+	Label     *string   `json:"label"`
+	Narrators *[]string `json:"narrators"`
+	Source    *string   `json:"source"`
+}
+
+type FormatKind string
+
+const (
+	Audiobook FormatKind = "audiobook"
+	Ebook     FormatKind = "ebook"
+	Mixed     FormatKind = "mixed"
+	NoFiles   FormatKind = "none"
+)
+
+func FormatKindOf(files fileManagement.Files) FormatKind {
+
+	hasAudio := files.AudioFiles != nil && len(*files.AudioFiles) > 0
+	hasText := files.TextFiles != nil && len(*files.TextFiles) > 0
+
+	switch {
+	case hasAudio && hasText:
+		return Mixed
+	case hasAudio:
+		return Audiobook
+	case hasText:
+		return Ebook
+	default:
+		return NoFiles
+	}
+}
+
+const formatColumns = "id, book_id, label, narrators, source, directory, audio_files, text_files, cover, created_at, updated_at"
+
+func (c *Client) AddBookFormat(bookId uuid.UUID, params BookFormatParams, files fileManagement.Files) (BookFormat, error) {
+
+	id := uuid.New()
+
+	label := string(FormatKindOf(files))
+	if params.Label != nil && strings.TrimSpace(*params.Label) != "" {
+		label = strings.TrimSpace(*params.Label)
+	}
+
+	narrators := []string{}
+	if params.Narrators != nil {
+		narrators = *params.Narrators
+	}
+	narratorsJson, err := json.Marshal(narrators)
+	if err != nil {
+		return BookFormat{}, err
+	}
+
+	audio, text, err := files.FileListsToJson()
+	if err != nil {
+		return BookFormat{}, err
+	}
+
+	_, err = c.handler.Exec(`
+	INSERT INTO book_formats
+		(id, book_id, label, narrators, source, directory, audio_files, text_files, cover, created_at, updated_at)
+	VALUES
+		(?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, id, bookId, label, string(narratorsJson), params.Source, files.Root, audio, text, files.Cover)
+	if err != nil {
+		return BookFormat{}, err
+	}
+
+	log.Println("Added the format \"", label, "\" to the book", bookId)
+
+	return c.GetBookFormat(id)
+}
+
+// Moves the download's files onto a new format of the book. dir is the format folder relative to the library
+func (c *Client) AssociateFormatAndDownload(bookId, downloadId uuid.UUID, dir string, params BookFormatParams) (BookFormat, error) {
+
+	var files fileManagement.Files
+	var audio *string
+	var text *string
+
+	err := c.handler.QueryRow(`
+	SELECT dir_name, audio_files, text_files, cover FROM downloads WHERE id = ?
+	`, downloadId).Scan(&files.Root, &audio, &text, &files.Cover)
+	if err != nil {
+		return BookFormat{}, err
+	}
+
+	if audio != nil {
+		err = files.ParseAudioJson(*audio)
+		if err != nil {
+			return BookFormat{}, err
+		}
+	}
+	if text != nil {
+		err = files.ParseTextJson(*text)
+		if err != nil {
+			return BookFormat{}, err
+		}
+	}
+
+	if params.Source == nil {
+		params.Source = files.Root
+	}
+
+	files.UpdateDirectory(dir)
+
+	return c.AddBookFormat(bookId, params, files)
+}
+
+func (c *Client) GetBookFormat(id uuid.UUID) (BookFormat, error) {
+	return scanBookFormat(c.handler.QueryRow("SELECT "+formatColumns+" FROM book_formats WHERE id = ?", id))
+}
+
+func (c *Client) GetBookFormats(bookId uuid.UUID) ([]BookFormat, error) {
+
+	rows, err := c.handler.Query("SELECT "+formatColumns+" FROM book_formats WHERE book_id = ? ORDER BY created_at ASC", bookId)
+	if err != nil {
+		return []BookFormat{}, err
+	}
+	defer rows.Close()
+
+	formats := []BookFormat{}
+	for rows.Next() {
+		format, err := scanBookFormat(rows)
+		if err != nil {
+			return []BookFormat{}, err
+		}
+		formats = append(formats, format)
+	}
+
+	return formats, rows.Err()
+}
+
+func (c *Client) UpdateBookFormat(id uuid.UUID, params BookFormatParams) (BookFormat, error) {
+
+	setParts := []string{"updated_at = CURRENT_TIMESTAMP"}
+	args := []any{}
+
+	if params.Label != nil {
+		label := strings.TrimSpace(*params.Label)
+		if label == "" {
+			return BookFormat{}, errors.New("format label can't be empty")
+		}
+		setParts = append(setParts, "label = ?")
+		args = append(args, label)
+	}
+	if params.Narrators != nil {
+		narratorsJson, err := json.Marshal(*params.Narrators)
+		if err != nil {
+			return BookFormat{}, err
+		}
+		setParts = append(setParts, "narrators = ?")
+		args = append(args, string(narratorsJson))
+	}
+	if params.Source != nil {
+		setParts = append(setParts, "source = ?")
+		args = append(args, params.Source)
+	}
+
+	args = append(args, id)
+	_, err := c.handler.Exec("UPDATE book_formats SET "+strings.Join(setParts, ", ")+" WHERE id = ?", args...)
+	if err != nil {
+		return BookFormat{}, err
+	}
+
+	return c.GetBookFormat(id)
+}
+
+func (c *Client) UpdateBookFormatFiles(id uuid.UUID, files fileManagement.Files) error {
+
+	audio, text, err := files.FileListsToJson()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.handler.Exec(`
+	UPDATE book_formats
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		directory = ?,
+		audio_files = ?,
+		text_files = ?,
+		cover = ?
+	WHERE id = ?`, files.Root, audio, text, files.Cover, id)
+
+	return err
+}
+
+// Format folders are nested in the book folder, so they follow it when the book moves. Requires an active db transaction.
+func (c *Client) relocateBookFormats(bookId uuid.UUID, bookDir string) error {
+
+	formats, err := c.GetBookFormats(bookId)
+	if err != nil {
+		return err
+	}
+
+	for _, format := range formats {
+		if format.Files.Root == nil {
+			continue
+		}
+
+		format.Files.UpdateDirectory(path.Join(bookDir, path.Base(*format.Files.Root)))
+		err = c.UpdateBookFormatFiles(format.Id, format.Files)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) DeleteBookFormat(id uuid.UUID) error {
+
+	_, err := c.handler.Exec("DELETE FROM book_formats WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Removed the format with the id \"", id, "\" from the database")
+
+	return nil
+}
+
+func (c *Client) DeleteBookFormats(bookId uuid.UUID) error {
+
+	_, err := c.handler.Exec("DELETE FROM book_formats WHERE book_id = ?", bookId)
+	return err
+}
+
+func (c *Client) CountBookFormats(bookId uuid.UUID) (int, error) {
+
+	var count int
+	err := c.handler.QueryRow("SELECT COUNT(*) FROM book_formats WHERE book_id = ?", bookId).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// #region Helpers
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBookFormat(row rowScanner) (BookFormat, error) {
+
+	var format BookFormat
+	var narratorsStr *string
+	var audioStr *string
+	var textStr *string
+
+	err := row.Scan(
+		&format.Id,
+		&format.BookId,
+		&format.Label,
+		&narratorsStr,
+		&format.Source,
+		&format.Files.Root,
+		&audioStr,
+		&textStr,
+		&format.Files.Cover,
+		&format.CreatedAt,
+		&format.UpdatedAt,
+	)
+	if err != nil {
+		return BookFormat{}, err
+	}
+
+	format.Narrators = []string{}
+	if narratorsStr != nil {
+		err = json.Unmarshal([]byte(*narratorsStr), &format.Narrators)
+		if err != nil {
+			return BookFormat{}, err
+		}
+	}
+
+	if audioStr != nil {
+		err = format.Files.ParseAudioJson(*audioStr)
+		if err != nil {
+			return BookFormat{}, err
+		}
+	}
+
+	if textStr != nil {
+		err = format.Files.ParseTextJson(*textStr)
+		if err != nil {
+			return BookFormat{}, err
+		}
+	}
+
+	format.Kind = FormatKindOf(format.Files)
+
+	return format, nil
+}
@@ -0,0 +1,428 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The parts of a book the history keeps track of. A history entry holds the book as it was before the change,
+// which is the version reverting to the entry restores
+type BookSnapshot struct {
+	Title          string  `json:"title"`
+	Subtitle       *string `json:"subtitle"`
+	Description    *string `json:"description"`
+	Year           *int    `json:"year"`
+	ISBN           *string `json:"isbn"`
+	ASIN           *string `json:"asin"`
+	Publisher      *string `json:"publisher"`
+	PublishDate    *string `json:"publish_date"`
+	Language       *string `json:"language"`
+	Abridged       *bool   `json:"abridged"`
+	Explicit       *bool   `json:"explicit"`
+	RuntimeMinutes *int    `json:"runtime_minutes"`
+
+	Authors   []string   `json:"authors"`
+	Narrators []string   `json:"narrators"`
+	Genres    []string   `json:"genres"`
+	Series    []Category `json:"series"`
+	Tags      []string   `json:"tags"`
+
+	Custom map[string]any `json:"custom"`
+
+	Directory  *string  `json:"directory"`
+	AudioFiles []string `json:"audio_files"`
+	TextFiles  []string `json:"text_files"`
+	// The active cover candidate
+	Cover *uuid.UUID `json:"cover"`
+}
+
+// A field that changed, with its values before and after the change
+type BookChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+type BookHistoryEntry struct {
+	Id       int        `json:"id"`
+	BookId   uuid.UUID  `json:"book_id"`
+	UserId   *uuid.UUID `json:"user_id"`
+	Username *string    `json:"username"`
+	// What made the change, e.g. update, tags, cover or revert
+	Action string `json:"action"`
+	// The entry a revert went back to
+	Reverts   *int         `json:"reverts,omitempty"`
+	Changes   []BookChange `json:"changes"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+type BookHistoryParams struct {
+	UserId  *uuid.UUID
+	Action  string
+	Reverts *int
+}
+
+const bookHistoryColumns = "h.id, h.book_id, h.user_id, u.username, h.action, h.reverts, h.changes, h.created_at"
+
+func (c *Client) GetBookSnapshot(id uuid.UUID) (BookSnapshot, error) {
+
+	book, err := c.GetBook(id)
+	if err != nil {
+		return BookSnapshot{}, err
+	}
+
+	cover, err := c.GetActiveCoverCandidate(id)
+	if err != nil {
+		return BookSnapshot{}, err
+	}
+
+	var coverId *uuid.UUID
+	if cover != nil {
+		coverId = &cover.Id
+	}
+
+	return newBookSnapshot(book, coverId), nil
+}
+
+// The snapshots of the books, loaded with a fixed number of queries. Ids without a book are left out
+func (c *Client) GetBookSnapshots(ids []uuid.UUID) (map[uuid.UUID]BookSnapshot, error) {
+
+	books, err := c.GetBooksByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	param, err := idsParam(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.handler.Query("SELECT book_id, id FROM cover_candidates WHERE active AND book_id IN (SELECT value FROM json_each(?))", param)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	covers := map[uuid.UUID]*uuid.UUID{}
+	for rows.Next() {
+		var bookId, coverId uuid.UUID
+		err = rows.Scan(&bookId, &coverId)
+		if err != nil {
+			return nil, err
+		}
+		covers[bookId] = &coverId
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	snapshots := map[uuid.UUID]BookSnapshot{}
+	for _, book := range books {
+		snapshots[*book.Id] = newBookSnapshot(book, covers[*book.Id])
+	}
+
+	return snapshots, nil
+}
+
+func newBookSnapshot(book Book, cover *uuid.UUID) BookSnapshot {
+
+	series := []Category{}
+	for _, s := range book.Series {
+		series = append(series, Category{Name: s.Name, Index: s.Index})
+	}
+
+	snapshot := BookSnapshot{
+		Title:          book.Title,
+		Subtitle:       book.Subtitle,
+		Description:    book.Description,
+		Year:           book.Year,
+		ISBN:           book.ISBN,
+		ASIN:           book.ASIN,
+		Publisher:      book.Publisher,
+		PublishDate:    book.PublishDate,
+		Language:       book.Language,
+		Abridged:       book.Abridged,
+		Explicit:       book.Explicit,
+		RuntimeMinutes: book.RuntimeMinutes,
+
+		Authors:   CategoryToStrSlice(book.Authors),
+		Narrators: CategoryToStrSlice(book.Narrators),
+		Genres:    CategoryToStrSlice(book.Genres),
+		Series:    series,
+		Tags:      book.Tags,
+
+		Custom:    book.Custom,
+		Directory: book.Files.Root,
+		Cover:     cover,
+	}
+	if book.Files.AudioFiles != nil {
+		snapshot.AudioFiles = *book.Files.AudioFiles
+	}
+	if book.Files.TextFiles != nil {
+		snapshot.TextFiles = *book.Files.TextFiles
+	}
+
+	return snapshot
+}
+
+// Compares the book to how it was before and adds an entry for the fields that changed. Nothing is added if nothing changed
+func (c *Client) RecordBookChange(id uuid.UUID, before BookSnapshot, params BookHistoryParams) (bool, error) {
+
+	after, err := c.GetBookSnapshot(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The book was deleted, and its history with it
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return c.addBookChange(id, before, after, params)
+}
+
+// RecordBookChange for many books, loading them with a fixed number of queries. Returns the number of books that changed
+func (c *Client) RecordBookChanges(before map[uuid.UUID]BookSnapshot, params BookHistoryParams) (int, error) {
+
+	ids := []uuid.UUID{}
+	for id := range before {
+		ids = append(ids, id)
+	}
+
+	// Deleted books are left out, their history went with them
+	after, err := c.GetBookSnapshots(ids)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for id, snapshot := range after {
+		added, err := c.addBookChange(id, before[id], snapshot, params)
+		if err != nil {
+			return changed, err
+		}
+		if added {
+			changed++
+		}
+	}
+
+	return changed, nil
+}
+
+func (c *Client) addBookChange(id uuid.UUID, before, after BookSnapshot, params BookHistoryParams) (bool, error) {
+
+	changes, err := diffBookSnapshots(before, after)
+	if err != nil || len(changes) == 0 {
+		return false, err
+	}
+
+	changesJson, err := json.Marshal(changes)
+	if err != nil {
+		return false, err
+	}
+	snapshotJson, err := json.Marshal(before)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = c.handler.Exec(`
+	INSERT INTO book_history (book_id, user_id, action, reverts, changes, snapshot, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, id, params.UserId, params.Action, params.Reverts, string(changesJson), string(snapshotJson))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Newest first
+func (c *Client) GetBookHistory(bookId uuid.UUID) ([]BookHistoryEntry, error) {
+
+	rows, err := c.handler.Query(`
+	SELECT `+bookHistoryColumns+` FROM book_history AS h
+	LEFT JOIN users AS u ON u.id = h.user_id
+	WHERE h.book_id = ?
+	ORDER BY h.id DESC
+	`, bookId)
+	if err != nil {
+		return []BookHistoryEntry{}, err
+	}
+	defer rows.Close()
+
+	entries := []BookHistoryEntry{}
+	for rows.Next() {
+		entry, err := scanBookHistoryEntry(rows)
+		if err != nil {
+			return []BookHistoryEntry{}, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Returns the entry and the book as it was before the entry's change. sql.ErrNoRows if the book has no such entry
+func (c *Client) GetBookHistoryEntry(bookId uuid.UUID, id int) (BookHistoryEntry, BookSnapshot, error) {
+
+	var snapshotJson string
+	entry, err := scanBookHistoryEntry(c.handler.QueryRow(`
+	SELECT `+bookHistoryColumns+`, h.snapshot FROM book_history AS h
+	LEFT JOIN users AS u ON u.id = h.user_id
+	WHERE h.book_id = ? AND h.id = ?
+	`, bookId, id), &snapshotJson)
+	if err != nil {
+		return BookHistoryEntry{}, BookSnapshot{}, err
+	}
+
+	var snapshot BookSnapshot
+	err = json.Unmarshal([]byte(snapshotJson), &snapshot)
+	if err != nil {
+		return BookHistoryEntry{}, BookSnapshot{}, err
+	}
+
+	return entry, snapshot, nil
+}
+
+// Puts the book's details, categories and custom values back to the snapshot, and points its files at the snapshot's
+// directory. Only the database is updated, the files and cover have to be moved separately. Custom fields that
+// have since been deleted are skipped, and the file lists are left as they are since they follow what's on disk
+func (c *Client) RestoreBookSnapshot(id uuid.UUID, snapshot BookSnapshot) (Book, error) {
+
+	// Set directly, so values that were empty before are cleared again
+	_, err := c.handler.Exec(`
+	UPDATE books SET
+		subtitle = ?, description = ?, publish_year = ?, isbn = ?, asin = ?, publisher = ?,
+		publish_date = ?, language = ?, abridged = ?, explicit = ?, runtime_minutes = ?
+	WHERE id = ?
+	`, snapshot.Subtitle, snapshot.Description, snapshot.Year, snapshot.ISBN, snapshot.ASIN, snapshot.Publisher,
+		snapshot.PublishDate, snapshot.Language, snapshot.Abridged, snapshot.Explicit, snapshot.RuntimeMinutes, id)
+	if err != nil {
+		return Book{}, err
+	}
+
+	fields, err := c.GetCustomFields()
+	if err != nil {
+		return Book{}, err
+	}
+	current, err := c.GetBookCustomValues(id)
+	if err != nil {
+		return Book{}, err
+	}
+	custom := map[string]any{}
+	for key := range current {
+		custom[key] = nil
+	}
+	for key, value := range snapshot.Custom {
+		if slices.ContainsFunc(fields, func(f CustomField) bool { return f.Key == key }) {
+			custom[key] = value
+		}
+	}
+
+	authors := StrToCategorySlice(snapshot.Authors)
+	narrators := StrToCategorySlice(snapshot.Narrators)
+	genres := StrToCategorySlice(snapshot.Genres)
+	series := slices.Clone(snapshot.Series)
+	tags := slices.Clone(snapshot.Tags)
+	if tags == nil {
+		tags = []string{}
+	}
+
+	book, _, err := c.UpdateBook(id, BookParams{
+		Title:     &snapshot.Title,
+		Authors:   &authors,
+		Narrators: &narrators,
+		Genres:    &genres,
+		Series:    &series,
+		Tags:      &tags,
+		Custom:    &custom,
+	})
+	if err != nil {
+		return Book{}, err
+	}
+
+	// The folder goes back to where it was, as long as the book still has files
+	if snapshot.Directory != nil && book.Files.Root != nil && *snapshot.Directory != *book.Files.Root {
+		book.Files.UpdateDirectory(*snapshot.Directory)
+		err = book.ApplyBookFiles(c)
+		if err != nil {
+			return Book{}, err
+		}
+
+		err = c.relocateBookFormats(id, *book.Files.Root)
+		if err != nil {
+			return Book{}, err
+		}
+	}
+
+	return c.GetBook(id)
+}
+
+// The fields that differ, in the order of BookSnapshot
+func diffBookSnapshots(before, after BookSnapshot) ([]BookChange, error) {
+
+	changes := []BookChange{}
+
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+	for i := range b.NumField() {
+		field := b.Type().Field(i)
+
+		beforeJson, err := json.Marshal(b.Field(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		afterJson, err := json.Marshal(a.Field(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(emptyAsNull(beforeJson), emptyAsNull(afterJson)) {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			changes = append(changes, BookChange{name, beforeJson, afterJson})
+		}
+	}
+
+	return changes, nil
+}
+
+// An empty list or object is no different from a missing one
+func emptyAsNull(value []byte) []byte {
+	switch string(value) {
+	case "[]", "{}", `""`:
+		return []byte("null")
+	}
+	return value
+}
+
+func scanBookHistoryEntry(row rowScanner, extra ...any) (BookHistoryEntry, error) {
+
+	var entry BookHistoryEntry
+	var changesJson string
+
+	err := row.Scan(append([]any{
+		&entry.Id,
+		&entry.BookId,
+		&entry.UserId,
+		&entry.Username,
+		&entry.Action,
+		&entry.Reverts,
+		&changesJson,
+		&entry.CreatedAt,
+	}, extra...)...)
+	if err != nil {
+		return BookHistoryEntry{}, err
+	}
+
+	err = json.Unmarshal([]byte(changesJson), &entry.Changes)
+	if err != nil {
+		return BookHistoryEntry{}, err
+	}
+
+	return entry, nil
+}
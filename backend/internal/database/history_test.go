@@ -0,0 +1,179 @@
+package database
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBookHistory(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	_, err := client.AddCustomField(CustomFieldParams{Key: ptr("rating"), Type: ptr(CustomNumber)})
+	if err != nil {
+		t.Fatalf("AddCustomField failed: %v", err)
+	}
+
+	authors := []Category{{Name: "Frank Herbert"}}
+	book, err := client.AddBook(BookParams{
+		Title:   ptr("Dune"),
+		Authors: &authors,
+		Tags:    &[]string{"Sci-Fi"},
+		Custom:  &map[string]any{"rating": 5.0},
+	})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+	id := *book.Id
+
+	before, err := client.GetBookSnapshot(id)
+	if err != nil {
+		t.Fatalf("GetBookSnapshot failed: %v", err)
+	}
+
+	// A bad metadata apply
+	wrongAuthors := []Category{{Name: "Brian Herbert"}, {Name: "Kevin J. Anderson"}}
+	_, _, err = client.UpdateBook(id, BookParams{
+		Title:       ptr("Dune: House Atreides"),
+		Description: ptr("A prequel"),
+		Authors:     &wrongAuthors,
+		Tags:        &[]string{},
+		Custom:      &map[string]any{"rating": nil},
+	})
+	if err != nil {
+		t.Fatalf("UpdateBook failed: %v", err)
+	}
+
+	recorded, err := client.RecordBookChange(id, before, BookHistoryParams{Action: "update"})
+	if err != nil || !recorded {
+		t.Fatalf("Expected the change to be recorded: %v", err)
+	}
+
+	// Nothing changed since, so nothing is added
+	unchanged, err := client.GetBookSnapshot(id)
+	if err != nil {
+		t.Fatalf("GetBookSnapshot failed: %v", err)
+	}
+	if recorded, _ := client.RecordBookChange(id, unchanged, BookHistoryParams{Action: "update"}); recorded {
+		t.Error("Expected an unchanged book not to be recorded")
+	}
+
+	history, err := client.GetBookHistory(id)
+	if err != nil {
+		t.Fatalf("GetBookHistory failed: %v", err)
+	}
+	if len(history) != 1 {
+		t.Fatalf("Expected one history entry, got %d", len(history))
+	}
+
+	fields := []string{}
+	for _, change := range history[0].Changes {
+		fields = append(fields, change.Field)
+	}
+	if !slices.Equal(fields, []string{"title", "description", "authors", "tags", "custom"}) {
+		t.Errorf("Expected the changed fields in order, got %v", fields)
+	}
+	if string(history[0].Changes[0].Before) != `"Dune"` || string(history[0].Changes[0].After) != `"Dune: House Atreides"` {
+		t.Errorf("Expected the title's before and after values, got %+v", history[0].Changes[0])
+	}
+
+	entry, snapshot, err := client.GetBookHistoryEntry(id, history[0].Id)
+	if err != nil {
+		t.Fatalf("GetBookHistoryEntry failed: %v", err)
+	}
+
+	current, err := client.GetBookSnapshot(id)
+	if err != nil {
+		t.Fatalf("GetBookSnapshot failed: %v", err)
+	}
+	restored, err := client.RestoreBookSnapshot(id, snapshot)
+	if err != nil {
+		t.Fatalf("RestoreBookSnapshot failed: %v", err)
+	}
+	if restored.Title != "Dune" || restored.Description != nil {
+		t.Errorf("Expected the details to be restored, got %q and %v", restored.Title, restored.Description)
+	}
+	if names := CategoryToStrSlice(restored.Authors); !slices.Equal(names, []string{"Frank Herbert"}) {
+		t.Errorf("Expected the author to be restored, got %v", names)
+	}
+	if !slices.Equal(restored.Tags, []string{"Sci-Fi"}) || restored.Custom["rating"] != 5.0 {
+		t.Errorf("Expected the tags and custom values to be restored, got %v and %v", restored.Tags, restored.Custom)
+	}
+
+	_, err = client.RecordBookChange(id, current, BookHistoryParams{Action: "revert", Reverts: &entry.Id})
+	if err != nil {
+		t.Fatalf("RecordBookChange failed: %v", err)
+	}
+	history, err = client.GetBookHistory(id)
+	if err != nil {
+		t.Fatalf("GetBookHistory failed: %v", err)
+	}
+	if len(history) != 2 || history[0].Action != "revert" || history[0].Reverts == nil || *history[0].Reverts != entry.Id {
+		t.Errorf("Expected the revert to be the newest entry, got %+v", history)
+	}
+
+	if _, _, err := client.GetBookHistoryEntry(id, 999); err == nil {
+		t.Error("Expected an error for a missing entry")
+	}
+}
+
+func TestRecordBookChanges(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	addTestLibrary(t, &client, 20)
+
+	summaries, err := client.GetBooksSummary(map[string][]string{"count": {"20"}})
+	if err != nil {
+		t.Fatalf("GetBooksSummary failed: %v", err)
+	}
+	ids := []uuid.UUID{}
+	for _, book := range summaries.Items {
+		ids = append(ids, book.Id)
+	}
+
+	counter := &countingHandler{Handler: client.handler}
+	client.handler = counter
+	before, err := client.GetBookSnapshots(ids)
+	if err != nil {
+		t.Fatalf("GetBookSnapshots failed: %v", err)
+	}
+	if counter.queries > 12 {
+		t.Errorf("Expected a constant number of queries, got %d", counter.queries)
+	}
+	client.handler = counter.Handler
+
+	// The batch matches the snapshots taken one at a time
+	single, err := client.GetBookSnapshot(ids[0])
+	if err != nil {
+		t.Fatalf("GetBookSnapshot failed: %v", err)
+	}
+	if len(before) != len(ids) || !reflect.DeepEqual(before[ids[0]], single) {
+		t.Fatalf("Expected a snapshot of every book, got %d and %+v", len(before), before[ids[0]])
+	}
+
+	// One book changed and one deleted
+	_, _, err = client.UpdateBook(ids[0], BookParams{Title: ptr("Renamed")})
+	if err != nil {
+		t.Fatalf("UpdateBook failed: %v", err)
+	}
+	err = client.DeleteBook(ids[1])
+	if err != nil {
+		t.Fatalf("DeleteBook failed: %v", err)
+	}
+
+	changed, err := client.RecordBookChanges(before, BookHistoryParams{Action: "scan"})
+	if err != nil {
+		t.Fatalf("RecordBookChanges failed: %v", err)
+	}
+	if changed != 1 {
+		t.Errorf("Expected one book recorded, got %d", changed)
+	}
+	history, err := client.GetBookHistory(ids[0])
+	if err != nil || len(history) != 1 || history[0].Action != "scan" || history[0].Changes[0].Field != "title" {
+		t.Errorf("Expected the title change recorded, got %+v (%v)", history, err)
+	}
+}
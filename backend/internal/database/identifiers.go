@@ -0,0 +1,266 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// An id of the book in another system. A book can have any number of them, each identifier belongs to one book
+type BookIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+const (
+	IdentifierISBN        = "isbn"
+	IdentifierASIN        = "asin"
+	IdentifierOpenLibrary = "openlibrary"
+	IdentifierGoogle      = "google"
+	IdentifierGoodreads   = "goodreads"
+)
+
+var IdentifierTypes = []string{IdentifierISBN, IdentifierASIN, IdentifierOpenLibrary, IdentifierGoogle, IdentifierGoodreads}
+
+// Returned when an identifier is added to a book while another book has it
+var ErrIdentifierTaken = errors.New("another book has the identifier")
+
+var (
+	asinPattern        = regexp.MustCompile(`^[A-Z0-9]{10}$`)
+	openLibraryPattern = regexp.MustCompile(`^OL\d+[WM]$`)
+	googlePattern      = regexp.MustCompile(`^[A-Za-z0-9_-]{12}$`)
+	goodreadsPattern   = regexp.MustCompile(`^\d+$`)
+)
+
+// Returns the identifier in the form it's stored in. ISBN-10s become ISBN-13s, so either finds the book.
+// Returns an error describing the problem if the value isn't valid for the type
+func NormalizeIdentifier(identifierType, value string) (string, error) {
+
+	value = strings.TrimSpace(value)
+
+	switch identifierType {
+	case IdentifierISBN:
+		isbn, ok := NormalizeISBN(value)
+		if !ok {
+			return "", fmt.Errorf("\"%s\" isn't a valid ISBN-10 or ISBN-13", value)
+		}
+		return isbn, nil
+
+	case IdentifierASIN:
+		value = strings.ToUpper(value)
+		if !asinPattern.MatchString(value) {
+			return "", fmt.Errorf("\"%s\" isn't a valid asin", value)
+		}
+		return value, nil
+
+	case IdentifierOpenLibrary:
+		value = strings.ToUpper(strings.TrimPrefix(strings.TrimPrefix(value, "/works/"), "/books/"))
+		if !openLibraryPattern.MatchString(value) {
+			return "", fmt.Errorf("\"%s\" isn't an OpenLibrary work or edition key like OL45804W", value)
+		}
+		return value, nil
+
+	case IdentifierGoogle:
+		if !googlePattern.MatchString(value) {
+			return "", fmt.Errorf("\"%s\" isn't a Google Books volume id", value)
+		}
+		return value, nil
+
+	case IdentifierGoodreads:
+		if !goodreadsPattern.MatchString(value) {
+			return "", fmt.Errorf("\"%s\" isn't a Goodreads book id", value)
+		}
+		return value, nil
+	}
+
+	return "", fmt.Errorf("identifier types are %s", strings.Join(IdentifierTypes, ", "))
+}
+
+// Validates the check digit of an ISBN-10 or ISBN-13 and returns it as an ISBN-13. Hyphens and spaces are ignored
+func NormalizeISBN(isbn string) (string, bool) {
+
+	isbn = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, char := range isbn {
+			digit := int(char - '0')
+			if i == 9 && char == 'X' {
+				digit = 10
+			} else if char < '0' || char > '9' {
+				return "", false
+			}
+			sum += digit * (10 - i)
+		}
+		if sum%11 != 0 {
+			return "", false
+		}
+
+		isbn13 := "978" + isbn[:9]
+		return isbn13 + string(isbn13CheckDigit(isbn13)), true
+
+	case 13:
+		for _, char := range isbn {
+			if char < '0' || char > '9' {
+				return "", false
+			}
+		}
+		if isbn13CheckDigit(isbn[:12]) != rune(isbn[12]) {
+			return "", false
+		}
+		return isbn, true
+	}
+
+	return "", false
+}
+
+func (c *Client) GetBookIdentifiers(bookId uuid.UUID) ([]BookIdentifier, error) {
+
+	rows, err := c.handler.Query("SELECT type, value FROM book_identifiers WHERE book_id = ? ORDER BY type, created_at", bookId)
+	if err != nil {
+		return []BookIdentifier{}, err
+	}
+	defer rows.Close()
+
+	identifiers := []BookIdentifier{}
+	for rows.Next() {
+		var identifier BookIdentifier
+		err = rows.Scan(&identifier.Type, &identifier.Value)
+		if err != nil {
+			return []BookIdentifier{}, err
+		}
+		identifiers = append(identifiers, identifier)
+	}
+
+	return identifiers, rows.Err()
+}
+
+// Returns the value of the book's first identifier of the type
+func (c *Client) GetBookIdentifier(bookId uuid.UUID, identifierType string) (string, bool, error) {
+
+	identifiers, err := c.GetBookIdentifiers(bookId)
+	if err != nil {
+		return "", false, err
+	}
+
+	i := slices.IndexFunc(identifiers, func(identifier BookIdentifier) bool { return identifier.Type == identifierType })
+	if i < 0 {
+		return "", false, nil
+	}
+
+	return identifiers[i].Value, true, nil
+}
+
+// Returns ErrIdentifierTaken if another book has the identifier, or the normalization error if it isn't valid
+func (c *Client) AddBookIdentifier(bookId uuid.UUID, identifier BookIdentifier) error {
+
+	value, err := NormalizeIdentifier(identifier.Type, identifier.Value)
+	if err != nil {
+		return err
+	}
+
+	owner, found, err := c.FindBookByIdentifier(identifier.Type, value)
+	if err != nil {
+		return err
+	}
+	if found && owner != bookId {
+		return ErrIdentifierTaken
+	}
+	if found {
+		return nil
+	}
+
+	_, err = c.handler.Exec("INSERT INTO book_identifiers (book_id, type, value, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", bookId, identifier.Type, value)
+	return err
+}
+
+func (c *Client) RemoveBookIdentifier(bookId uuid.UUID, identifierType, value string) error {
+
+	if normalized, err := NormalizeIdentifier(identifierType, value); err == nil {
+		value = normalized
+	}
+
+	_, err := c.handler.Exec("DELETE FROM book_identifiers WHERE book_id = ? AND type = ? AND value = ?", bookId, identifierType, value)
+	return err
+}
+
+// Finds the book with the identifier. Without a type, the value is matched against identifiers of every type
+func (c *Client) FindBookByIdentifier(identifierType, value string) (uuid.UUID, bool, error) {
+
+	types := IdentifierTypes
+	if identifierType != "" {
+		types = []string{identifierType}
+	}
+
+	conditions := []string{}
+	args := []any{}
+	for _, t := range types {
+		normalized, err := NormalizeIdentifier(t, value)
+		if err != nil {
+			continue
+		}
+		conditions = append(conditions, "(type = ? AND value = ?)")
+		args = append(args, t, normalized)
+	}
+	if len(conditions) == 0 {
+		return uuid.Nil, false, nil
+	}
+
+	var id uuid.UUID
+	err := c.handler.QueryRow("SELECT book_id FROM book_identifiers WHERE "+strings.Join(conditions, " OR ")+" LIMIT 1", args...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, false, nil
+	} else if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	return id, true, nil
+}
+
+// Adds what identifiers it can. Invalid identifiers and ones other books have are skipped
+func (c *Client) addBookIdentifiers(bookId uuid.UUID, params BookParams) error {
+
+	identifiers := []BookIdentifier{}
+	if params.ISBN != nil {
+		identifiers = append(identifiers, BookIdentifier{IdentifierISBN, *params.ISBN})
+	}
+	if params.ASIN != nil {
+		identifiers = append(identifiers, BookIdentifier{IdentifierASIN, *params.ASIN})
+	}
+	if params.Identifiers != nil {
+		identifiers = append(identifiers, *params.Identifiers...)
+	}
+
+	for _, identifier := range identifiers {
+		if _, err := NormalizeIdentifier(identifier.Type, identifier.Value); err != nil {
+			continue
+		}
+
+		err := c.AddBookIdentifier(bookId, identifier)
+		if err != nil && !errors.Is(err, ErrIdentifierTaken) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// #region Helpers
+
+func isbn13CheckDigit(first12 string) rune {
+	sum := 0
+	for i, char := range first12 {
+		digit := int(char - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return rune('0' + (10-sum%10)%10)
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+
+	tests := []struct {
+		ISBN     string
+		Expected string
+		Ok       bool
+	}{
+		{"0-441-17271-7", "9780441172719", true},
+		{"080442957X", "9780804429573", true},
+		{"978-0-441-17271-9", "9780441172719", true},
+		{"9780441172710", "", false},
+		{"0441172718", "", false},
+		{"04411727", "", false},
+		{"X441172717", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ISBN, func(t *testing.T) {
+			isbn, ok := NormalizeISBN(test.ISBN)
+			if ok != test.Ok || isbn != test.Expected {
+				t.Errorf("Expected \"%s\" (%v), got \"%s\" (%v)", test.Expected, test.Ok, isbn, ok)
+			}
+		})
+	}
+}
+
+func TestBookIdentifiers(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	title := "Dune"
+	isbn10 := "0441172717"
+	identifiers := []BookIdentifier{
+		{IdentifierOpenLibrary, "/works/OL893415W"},
+		{IdentifierGoodreads, "not a number"},
+	}
+	book, err := client.AddBook(BookParams{Title: &title, ISBN: &isbn10, Identifiers: &identifiers})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+
+	if len(book.Identifiers) != 2 {
+		t.Fatalf("Expected the ISBN and OpenLibrary identifiers, got %+v", book.Identifiers)
+	}
+
+	for _, lookup := range []BookIdentifier{
+		{IdentifierISBN, "978-0-441-17271-9"},
+		{IdentifierISBN, "0441172717"},
+		{IdentifierOpenLibrary, "OL893415W"},
+		{"", "OL893415W"},
+	} {
+		id, found, err := client.FindBookByIdentifier(lookup.Type, lookup.Value)
+		if err != nil {
+			t.Fatalf("FindBookByIdentifier failed: %v", err)
+		}
+		if !found || id != *book.Id {
+			t.Errorf("Expected to find the book by %+v", lookup)
+		}
+	}
+
+	other := "Dune Messiah"
+	otherBook, err := client.AddBook(BookParams{Title: &other})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+	err = client.AddBookIdentifier(*otherBook.Id, BookIdentifier{IdentifierISBN, "9780441172719"})
+	if !errors.Is(err, ErrIdentifierTaken) {
+		t.Errorf("Expected ErrIdentifierTaken, got %v", err)
+	}
+
+	results, err := client.GetBooksSummary(map[string][]string{"q": {"isbn:0441172717"}})
+	if err != nil {
+		t.Fatalf("GetBooksSummary failed: %v", err)
+	}
+	if len(results.Items) != 1 || results.Items[0].Id != *book.Id {
+		t.Errorf("Expected the isbn query to find the book by its ISBN-10, got %+v", results.Items)
+	}
+
+	err = client.RemoveBookIdentifier(*book.Id, IdentifierISBN, "0441172717")
+	if err != nil {
+		t.Fatalf("RemoveBookIdentifier failed: %v", err)
+	}
+	if _, found, _ := client.FindBookByIdentifier(IdentifierISBN, "9780441172719"); found {
+		t.Error("Expected the ISBN to be removed")
+	}
+}
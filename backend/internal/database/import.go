@@ -0,0 +1,826 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+)
+
+// What to do with a row that matches a book in the library, by id or identifier
+const (
+	ImportMatchUpdate = "update"
+	ImportMatchSkip   = "skip"
+)
+
+// What to do with a row whose identifiers belong to other books, or to earlier rows
+const (
+	ImportConflictSkip  = "skip"
+	ImportConflictStrip = "strip"
+	ImportConflictFail  = "fail"
+)
+
+// What happened to a row
+const (
+	ImportActionCreate   = "create"
+	ImportActionUpdate   = "update"
+	ImportActionSkip     = "skip"
+	ImportActionConflict = "conflict"
+	ImportActionError    = "error"
+)
+
+// The columns a CSV import can map. Custom fields are mapped as custom.<key>
+var ImportColumns = []string{
+	"id", "title", "subtitle", "authors", "series", "genres", "narrators", "tags", "publisher", "publish_date", "year",
+	"language", "abridged", "explicit", "runtime_minutes", "isbn", "asin", "identifiers", "description", "root",
+}
+
+// Returned when an import can't be read, or can't be applied as asked
+type ImportError struct {
+	Msg string
+}
+
+func (e ImportError) Error() string {
+	return "import error: " + e.Msg
+}
+
+// Returned with the results when the conflict policy is fail and a row has conflicts. Nothing should be kept
+var ErrImportConflicts = errors.New("the import has conflicts")
+
+type ImportOptions struct {
+	OnMatch    string
+	OnConflict string
+
+	// Recorded in the history of the updated books
+	UserId *uuid.UUID
+}
+
+// A book to import
+type ImportItem struct {
+	// The position in the import. The line for CSV imports, counting the header
+	Row int
+
+	// The book's id where it was exported from. Matches the book when it's in this library
+	Id     *uuid.UUID
+	Params BookParams
+
+	// The book's folder, relative to the library. Files should be set to the folder's contents when it exists
+	Root  *string
+	Files *fileManagement.Files
+
+	// Why the row couldn't be read
+	Error string
+}
+
+type ImportConflict struct {
+	// The identifier's type, or book when an earlier row imported the same book
+	Type  string `json:"type"`
+	Value string `json:"value"`
+
+	// The book that has the identifier, or the row that imported it first
+	BookId *uuid.UUID `json:"book_id,omitempty"`
+	Row    *int       `json:"row,omitempty"`
+}
+
+type ImportItemResult struct {
+	Row       int              `json:"row"`
+	Title     string           `json:"title"`
+	Action    string           `json:"action"`
+	BookId    *uuid.UUID       `json:"book_id"`
+	Conflicts []ImportConflict `json:"conflicts,omitempty"`
+
+	// The library folder linked to the book
+	Folder   *string  `json:"folder,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Error    *string  `json:"error,omitempty"`
+
+	// The book's folder before an update, to move the files from if it changed
+	previousFolder *string
+}
+
+// The folder of an updated book before the import
+func (r ImportItemResult) PreviousFolder() *string {
+	return r.previousFolder
+}
+
+type ImportResult struct {
+	DryRun    bool `json:"dry_run"`
+	Created   int  `json:"created"`
+	Updated   int  `json:"updated"`
+	Skipped   int  `json:"skipped"`
+	Conflicts int  `json:"conflicts"`
+	Errors    int  `json:"errors"`
+
+	// Keys of the custom fields added from the export
+	CustomFields []string           `json:"custom_fields"`
+	Items        []ImportItemResult `json:"items"`
+}
+
+// #region Reading
+
+// Reads a LibraryExport into the custom fields it defines and the books to import
+func ParseLibraryJSON(r io.Reader) ([]CustomField, []ImportItem, error) {
+
+	var export LibraryExport
+	err := json.NewDecoder(r).Decode(&export)
+	if err != nil {
+		return nil, nil, ImportError{"the body isn't a library export: " + err.Error()}
+	}
+	if export.Version == 0 {
+		return nil, nil, ImportError{"the body isn't a library export, it has no version"}
+	}
+	if export.Version > ExportVersion {
+		return nil, nil, ImportError{fmt.Sprintf("the export is version %d but only up to version %d can be imported", export.Version, ExportVersion)}
+	}
+
+	// Categories are matched by name, the ids are from the other library
+	categories := func(cats []Category) *[]Category {
+		if cats == nil {
+			return nil
+		}
+		imported := []Category{}
+		for _, cat := range cats {
+			imported = append(imported, Category{Name: cat.Name, Index: cat.Index, Asin: cat.Asin})
+		}
+		return &imported
+	}
+
+	items := []ImportItem{}
+	for i, book := range export.Books {
+		item := ImportItem{
+			Row: i + 1,
+			Id:  book.Id,
+			Params: BookParams{
+				Title:          &book.Title,
+				Subtitle:       book.Subtitle,
+				Description:    book.Description,
+				Year:           book.Year,
+				ISBN:           book.ISBN,
+				ASIN:           book.ASIN,
+				Publisher:      book.Publisher,
+				PublishDate:    book.PublishDate,
+				Language:       book.Language,
+				Abridged:       book.Abridged,
+				Explicit:       book.Explicit,
+				RuntimeMinutes: book.RuntimeMinutes,
+				Series:         categories(book.Series),
+				Authors:        categories(book.Authors),
+				Genres:         categories(book.Genres),
+				Narrators:      categories(book.Narrators),
+			},
+			Root: book.Files.Root,
+		}
+		if book.Tags != nil {
+			item.Params.Tags = &book.Tags
+		}
+		if book.Identifiers != nil {
+			item.Params.Identifiers = &book.Identifiers
+		}
+		if len(book.Custom) > 0 {
+			item.Params.Custom = &book.Custom
+		}
+		items = append(items, item)
+	}
+
+	return export.CustomFields, items, nil
+}
+
+// Reads a CSV with a header row into the books to import. mapping maps ImportColumns and custom.<key> to the header of
+// the column they're read from. Columns named like the ImportColumns are read without being mapped, so a CSV export can
+// be imported as it is. Lists are separated with ";", series are "Name #index" and identifiers "type:value". Empty
+// cells are left out, so they don't change the books being updated
+func (c *Client) ParseLibraryCSV(r io.Reader, mapping map[string]string) ([]ImportItem, error) {
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, ImportError{"the CSV is empty"}
+	} else if err != nil {
+		return nil, ImportError{"the CSV can't be read: " + err.Error()}
+	}
+	// Spreadsheets often start the file with a byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	fields, err := c.GetCustomFields()
+	if err != nil {
+		return nil, err
+	}
+	fieldKeys := map[string]bool{}
+	for _, field := range fields {
+		fieldKeys[field.Key] = true
+	}
+
+	isColumn := func(name string) bool {
+		if key, ok := strings.CutPrefix(name, "custom."); ok {
+			return fieldKeys[key]
+		}
+		return slices.Contains(ImportColumns, name)
+	}
+
+	// Which of the CSV's columns each import column is read from
+	columns := map[string]int{}
+	for i, name := range header {
+		if isColumn(name) {
+			columns[name] = i
+		}
+	}
+	for name, from := range mapping {
+		if !isColumn(name) {
+			if strings.HasPrefix(name, "custom.") {
+				return nil, ImportError{fmt.Sprintf("there's no custom field %s", strings.TrimPrefix(name, "custom."))}
+			}
+			return nil, ImportError{fmt.Sprintf("%s isn't a column that can be imported", name)}
+		}
+		i := slices.Index(header, from)
+		if i < 0 {
+			return nil, ImportError{fmt.Sprintf("the CSV has no %s column to read %s from", from, name)}
+		}
+		columns[name] = i
+	}
+	if !slices.ContainsFunc([]string{"title", "id", "isbn", "asin"}, func(name string) bool { _, ok := columns[name]; return ok }) {
+		return nil, ImportError{"the CSV needs a title, id, isbn or asin column to import books by"}
+	}
+
+	items := []ImportItem{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, ImportError{"the CSV can't be read: " + err.Error()}
+		}
+
+		line, _ := reader.FieldPos(0)
+		item, err := parseImportRecord(record, columns)
+		item.Row = line
+		if err != nil {
+			item.Error = err.Error()
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func parseImportRecord(record []string, columns map[string]int) (ImportItem, error) {
+
+	item := ImportItem{}
+	params := &item.Params
+
+	cell := func(name string) *string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return nil
+		}
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+	list := func(name string) []string {
+		value := cell(name)
+		if value == nil {
+			return nil
+		}
+		items := []string{}
+		for _, item := range strings.Split(*value, ";") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	categories := func(name string) *[]Category {
+		names := list(name)
+		if names == nil {
+			return nil
+		}
+		cats := []Category{}
+		for _, name := range names {
+			cats = append(cats, Category{Name: name})
+		}
+		return &cats
+	}
+	number := func(name string) (*int, error) {
+		value := cell(name)
+		if value == nil {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(*value)
+		if err != nil {
+			return nil, fmt.Errorf("%s isn't a whole number", name)
+		}
+		return &n, nil
+	}
+	boolean := func(name string) (*bool, error) {
+		value := cell(name)
+		if value == nil {
+			return nil, nil
+		}
+		b, err := strconv.ParseBool(*value)
+		if err != nil {
+			return nil, fmt.Errorf("%s isn't true or false", name)
+		}
+		return &b, nil
+	}
+
+	var err error
+	if id := cell("id"); id != nil {
+		parsed, err := uuid.Parse(*id)
+		if err != nil {
+			return item, fmt.Errorf("id isn't a valid id")
+		}
+		item.Id = &parsed
+	}
+
+	params.Title = cell("title")
+	params.Subtitle = cell("subtitle")
+	params.Description = cell("description")
+	params.Publisher = cell("publisher")
+	params.PublishDate = cell("publish_date")
+	params.Language = cell("language")
+	params.ISBN = cell("isbn")
+	params.ASIN = cell("asin")
+	item.Root = cell("root")
+
+	params.Authors = categories("authors")
+	params.Genres = categories("genres")
+	params.Narrators = categories("narrators")
+	if tags := list("tags"); tags != nil {
+		params.Tags = &tags
+	}
+
+	// Series are "Name #index"
+	if names := list("series"); names != nil {
+		series := []Category{}
+		for _, name := range names {
+			cat := Category{Name: name}
+			if i := strings.LastIndex(name, " #"); i > 0 {
+				index := strings.TrimSpace(name[i+2:])
+				cat.Name = strings.TrimSpace(name[:i])
+				cat.Index = &index
+			}
+			series = append(series, cat)
+		}
+		params.Series = &series
+	}
+
+	// Identifiers are "type:value"
+	if values := list("identifiers"); values != nil {
+		identifiers := []BookIdentifier{}
+		for _, value := range values {
+			identifierType, identifierValue, ok := strings.Cut(value, ":")
+			if !ok {
+				return item, fmt.Errorf("the identifier %s isn't type:value", value)
+			}
+			identifiers = append(identifiers, BookIdentifier{strings.ToLower(strings.TrimSpace(identifierType)), strings.TrimSpace(identifierValue)})
+		}
+		params.Identifiers = &identifiers
+	}
+
+	params.Year, err = number("year")
+	if err != nil {
+		return item, err
+	}
+	params.RuntimeMinutes, err = number("runtime_minutes")
+	if err != nil {
+		return item, err
+	}
+	params.Abridged, err = boolean("abridged")
+	if err != nil {
+		return item, err
+	}
+	params.Explicit, err = boolean("explicit")
+	if err != nil {
+		return item, err
+	}
+
+	// Normalized like the custom values of any other edit
+	custom := map[string]any{}
+	for name := range columns {
+		if key, ok := strings.CutPrefix(name, "custom."); ok {
+			if value := cell(name); value != nil {
+				custom[key] = *value
+			}
+		}
+	}
+	if len(custom) > 0 {
+		params.Custom = &custom
+	}
+
+	return item, nil
+}
+
+// #region Applying
+
+// Adds the custom fields that don't exist yet, then imports each item in order. Items that match a book by id or by
+// identifier update it, the rest are added. Each item is imported on its own, one that fails is left out and listed
+// with its error. Folders are linked to books that don't have files yet. Requires an active db transaction, which
+// should be rolled back for a dry run, or when ErrImportConflicts is returned
+func (c *Client) ImportBooks(fields []CustomField, items []ImportItem, opts ImportOptions) (ImportResult, error) {
+
+	if opts.OnMatch == "" {
+		opts.OnMatch = ImportMatchUpdate
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = ImportConflictSkip
+	}
+	if !slices.Contains([]string{ImportMatchUpdate, ImportMatchSkip}, opts.OnMatch) {
+		return ImportResult{}, ImportError{"on_match must be update or skip"}
+	}
+	if !slices.Contains([]string{ImportConflictSkip, ImportConflictStrip, ImportConflictFail}, opts.OnConflict) {
+		return ImportResult{}, ImportError{"on_conflict must be skip, strip or fail"}
+	}
+
+	result := ImportResult{CustomFields: []string{}, Items: []ImportItemResult{}}
+
+	for _, field := range fields {
+		if _, err := c.GetCustomField(field.Key); err == nil {
+			continue
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return ImportResult{}, err
+		}
+
+		params := CustomFieldParams{Key: &field.Key, Name: &field.Name, Type: &field.Type}
+		if field.Options != nil {
+			params.Options = &field.Options
+		}
+		_, err := c.AddCustomField(params)
+		var fieldErr CustomFieldError
+		if errors.As(err, &fieldErr) {
+			return ImportResult{}, ImportError{fmt.Sprintf("the custom field %s can't be added: %s", field.Key, fieldErr.Msg)}
+		} else if err != nil {
+			return ImportResult{}, err
+		}
+		result.CustomFields = append(result.CustomFields, field.Key)
+	}
+
+	im := importer{
+		c:           c,
+		opts:        opts,
+		books:       map[uuid.UUID]int{},
+		identifiers: map[string]int{},
+		folders:     map[string]int{},
+	}
+	for _, item := range items {
+
+		_, err := c.handler.Exec("SAVEPOINT import_book")
+		if err != nil {
+			return ImportResult{}, err
+		}
+
+		itemResult, err := im.importBook(item)
+		if err != nil {
+			_, rollbackErr := c.handler.Exec("ROLLBACK TO import_book")
+			if rollbackErr != nil {
+				return ImportResult{}, rollbackErr
+			}
+
+			msg := err.Error()
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				msg = "Books can't share ISBN or ASIN numbers"
+			}
+			itemResult.Action = ImportActionError
+			itemResult.Error = &msg
+			itemResult.Folder = nil
+		}
+
+		_, err = c.handler.Exec("RELEASE import_book")
+		if err != nil {
+			return ImportResult{}, err
+		}
+
+		if len(itemResult.Conflicts) > 0 {
+			result.Conflicts++
+		}
+		switch itemResult.Action {
+		case ImportActionCreate:
+			result.Created++
+		case ImportActionUpdate:
+			result.Updated++
+		case ImportActionSkip:
+			result.Skipped++
+		case ImportActionError:
+			result.Errors++
+		}
+		result.Items = append(result.Items, itemResult)
+	}
+
+	if opts.OnConflict == ImportConflictFail && result.Conflicts > 0 {
+		return result, ErrImportConflicts
+	}
+
+	return result, nil
+}
+
+type importer struct {
+	c    *Client
+	opts ImportOptions
+
+	// The rows that imported each book, identifier and folder
+	books       map[uuid.UUID]int
+	identifiers map[string]int
+	folders     map[string]int
+}
+
+// Imports the item. A returned error is the item's own, and whatever it changed should be rolled back
+func (im *importer) importBook(item ImportItem) (ImportItemResult, error) {
+
+	c := im.c
+	result := ImportItemResult{Row: item.Row}
+	if item.Params.Title != nil {
+		result.Title = *item.Params.Title
+	}
+
+	if item.Error != "" {
+		return result, errors.New(item.Error)
+	}
+
+	params := item.Params
+	err := c.checkImportParams(&params)
+	if err != nil {
+		return result, err
+	}
+
+	// The book the item matches. The id comes first, then the identifiers in order
+	var match *uuid.UUID
+	if item.Id != nil {
+		exists, err := c.CheckBookExistsID(*item.Id)
+		if err != nil {
+			return result, err
+		}
+		if exists {
+			match = item.Id
+		}
+	}
+
+	claims := importClaims(params)
+	for _, claim := range claims {
+		key := claim.Type + ":" + claim.Value
+		if row, ok := im.identifiers[key]; ok {
+			result.Conflicts = append(result.Conflicts, ImportConflict{Type: claim.Type, Value: claim.Value, Row: &row})
+			continue
+		}
+
+		var owner uuid.UUID
+		var found bool
+		switch claim.Type {
+		case IdentifierISBN:
+			found, owner, err = c.CheckBookExistsISBN(claim.Value)
+		case IdentifierASIN:
+			found, owner, err = c.CheckBookExistsASIN(claim.Value)
+		default:
+			owner, found, err = c.FindBookByIdentifier(claim.Type, claim.Value)
+		}
+		if err != nil {
+			return result, err
+		}
+		if !found {
+			continue
+		}
+
+		if match == nil {
+			match = &owner
+		} else if owner != *match {
+			result.Conflicts = append(result.Conflicts, ImportConflict{Type: claim.Type, Value: claim.Value, BookId: &owner})
+		}
+	}
+
+	sameBook := false
+	if match != nil {
+		result.BookId = match
+		if row, ok := im.books[*match]; ok {
+			result.Conflicts = append(result.Conflicts, ImportConflict{Type: "book", Value: match.String(), Row: &row})
+			sameBook = true
+		}
+	}
+
+	if len(result.Conflicts) > 0 {
+		switch {
+		case im.opts.OnConflict == ImportConflictFail:
+			result.Action = ImportActionConflict
+			return result, nil
+		// A book an earlier row imported can't be stripped from the item
+		case im.opts.OnConflict == ImportConflictSkip || sameBook:
+			result.Action = ImportActionSkip
+			return result, nil
+		}
+
+		// Strip. The item is imported without the identifiers other books have
+		conflicting := map[string]bool{}
+		for _, conflict := range result.Conflicts {
+			conflicting[conflict.Type+":"+conflict.Value] = true
+		}
+		if params.ISBN != nil && conflicting[IdentifierISBN+":"+*params.ISBN] {
+			params.ISBN = nil
+		}
+		if params.ASIN != nil && conflicting[IdentifierASIN+":"+*params.ASIN] {
+			params.ASIN = nil
+		}
+		if params.Identifiers != nil {
+			identifiers := slices.DeleteFunc(slices.Clone(*params.Identifiers), func(identifier BookIdentifier) bool {
+				return conflicting[identifier.Type+":"+identifier.Value]
+			})
+			params.Identifiers = &identifiers
+		}
+		claims = slices.DeleteFunc(claims, func(claim BookIdentifier) bool { return conflicting[claim.Type+":"+claim.Value] })
+	}
+
+	if match != nil && im.opts.OnMatch == ImportMatchSkip {
+		result.Action = ImportActionSkip
+		return result, nil
+	}
+
+	var id uuid.UUID
+	if match == nil {
+		if params.Title == nil || strings.TrimSpace(*params.Title) == "" {
+			return result, fmt.Errorf("a title is needed to add a book")
+		}
+
+		book, err := c.AddBook(params)
+		if err != nil {
+			return result, err
+		}
+		id = *book.Id
+		result.Action = ImportActionCreate
+		result.BookId = &id
+
+	} else {
+		id = *match
+
+		before, err := c.GetBookSnapshot(id)
+		if err != nil {
+			return result, err
+		}
+		result.previousFolder, err = c.GetBookDirectory(id)
+		if err != nil {
+			return result, err
+		}
+
+		book, _, err := c.UpdateBook(id, params)
+		if err != nil {
+			return result, err
+		}
+		result.Action = ImportActionUpdate
+		result.Title = book.Title
+
+		defer func() {
+			if result.Action == ImportActionUpdate {
+				_, err := c.RecordBookChange(id, before, BookHistoryParams{UserId: im.opts.UserId, Action: "import"})
+				if err != nil {
+					msg := "Imported, but failed to record the change in the book's history: " + err.Error()
+					result.Warnings = append(result.Warnings, msg)
+				}
+			}
+		}()
+	}
+
+	err = im.linkFolder(id, item, &result)
+	if err != nil {
+		return result, err
+	}
+
+	im.books[id] = item.Row
+	for _, claim := range claims {
+		im.identifiers[claim.Type+":"+claim.Value] = item.Row
+	}
+
+	return result, nil
+}
+
+// Links the item's folder to the book when the folder exists, isn't another book's and the book has no files yet.
+// Anything that stops it is added to the warnings
+func (im *importer) linkFolder(id uuid.UUID, item ImportItem, result *ImportItemResult) error {
+
+	if item.Root == nil {
+		return nil
+	}
+	if item.Files == nil || item.Files.Root == nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("There's no book folder at \"%s\" in the library", *item.Root))
+		return nil
+	}
+	root := *item.Files.Root
+
+	if row, ok := im.folders[root]; ok {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("The folder \"%s\" was already linked to the book of row %d", root, row))
+		return nil
+	}
+
+	var owner uuid.UUID
+	err := im.c.handler.QueryRow("SELECT id FROM books WHERE directory = ?", root).Scan(&owner)
+	if err == nil {
+		if owner != id {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("The folder \"%s\" belongs to the book %s", root, owner))
+		}
+		return nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	dir, err := im.c.GetBookDirectory(id)
+	if err != nil {
+		return err
+	}
+	if dir != nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("The book already has files at \"%s\"", *dir))
+		return nil
+	}
+
+	err = im.c.UpdateBookFiles(id, *item.Files)
+	if err != nil {
+		return err
+	}
+	im.folders[root] = item.Row
+	result.Folder = &root
+
+	return nil
+}
+
+// Checks and normalizes the item's identifiers, publish date and custom values. Empty identifiers are cleared
+func (c *Client) checkImportParams(params *BookParams) error {
+
+	normalize := func(identifierType string, value *string) (*string, error) {
+		if value == nil || *value == "" {
+			return nil, nil
+		}
+		normalized, err := NormalizeIdentifier(identifierType, *value)
+		if err != nil {
+			return nil, err
+		}
+		return &normalized, nil
+	}
+
+	var err error
+	params.ISBN, err = normalize(IdentifierISBN, params.ISBN)
+	if err != nil {
+		return err
+	}
+	params.ASIN, err = normalize(IdentifierASIN, params.ASIN)
+	if err != nil {
+		return err
+	}
+	if params.Identifiers != nil {
+		identifiers := []BookIdentifier{}
+		for _, identifier := range *params.Identifiers {
+			value, err := normalize(identifier.Type, &identifier.Value)
+			if err != nil {
+				return err
+			}
+			if value != nil {
+				identifiers = append(identifiers, BookIdentifier{identifier.Type, *value})
+			}
+		}
+		params.Identifiers = &identifiers
+	}
+
+	if params.PublishDate != nil && *params.PublishDate != "" {
+		if _, _, ok := NormalizePublishDate(*params.PublishDate); !ok {
+			return fmt.Errorf("invalid publish date %s", *params.PublishDate)
+		}
+	}
+
+	if params.Custom != nil {
+		err = c.ValidateCustomValues(*params.Custom)
+		var fieldErr CustomFieldError
+		if errors.As(err, &fieldErr) {
+			return fmt.Errorf("invalid custom value for %s: %s", fieldErr.Key, fieldErr.Msg)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// The identifiers the book would take, ISBN and ASIN first, without repeats
+func importClaims(params BookParams) []BookIdentifier {
+
+	claims := []BookIdentifier{}
+	if params.ISBN != nil {
+		claims = append(claims, BookIdentifier{IdentifierISBN, *params.ISBN})
+	}
+	if params.ASIN != nil {
+		claims = append(claims, BookIdentifier{IdentifierASIN, *params.ASIN})
+	}
+	if params.Identifiers != nil {
+		for _, identifier := range *params.Identifiers {
+			if !slices.Contains(claims, identifier) {
+				claims = append(claims, identifier)
+			}
+		}
+	}
+
+	return claims
+}
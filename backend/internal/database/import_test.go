@@ -0,0 +1,190 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Ethanol2/book-organizer/internal/fileManagement"
+)
+
+func TestImportLibrary(t *testing.T) {
+	source := setupTestDB(t)
+	defer source.db.Close()
+
+	addTestLibrary(t, &source, 12)
+	_, err := source.AddBook(BookParams{
+		Title:   ptr("Dune"),
+		Authors: &[]Category{{Name: "Frank Herbert"}},
+		Series:  &[]Category{{Name: "Dune", Index: ptr("1")}},
+		ISBN:    ptr("9780441172719"),
+		Custom:  &map[string]any{"rating": float64(5)},
+	})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+
+	var export bytes.Buffer
+	err = source.ExportJSON(&export, map[string][]string{})
+	if err != nil {
+		t.Fatalf("ExportJSON failed: %v", err)
+	}
+
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	importBooks := func(fields []CustomField, items []ImportItem, opts ImportOptions) (ImportResult, error) {
+		t.Helper()
+		var result ImportResult
+		err := client.HandleTransaction(func(c *Client) error {
+			result, err = c.ImportBooks(fields, items, opts)
+			return err
+		})
+		return result, err
+	}
+
+	// Into an empty library every book is added, along with the custom field
+	fields, items, err := ParseLibraryJSON(bytes.NewReader(export.Bytes()))
+	if err != nil {
+		t.Fatalf("ParseLibraryJSON failed: %v", err)
+	}
+	result, err := importBooks(fields, items, ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportBooks failed: %v", err)
+	}
+	if result.Created != 13 || result.Updated != 0 || result.Errors != 0 {
+		t.Fatalf("Expected 13 books added, got %+v", result)
+	}
+	if len(result.CustomFields) != 1 || result.CustomFields[0] != "rating" {
+		t.Errorf("Expected the rating field to be added, got %v", result.CustomFields)
+	}
+
+	id, found, err := client.FindBookByIdentifier(IdentifierISBN, "9780441172719")
+	if err != nil || !found {
+		t.Fatalf("Expected Dune to be found by its ISBN, got %v", err)
+	}
+	dune, err := client.GetBook(id)
+	if err != nil {
+		t.Fatalf("GetBook failed: %v", err)
+	}
+	if len(dune.Series) != 1 || *dune.Series[0].Index != "1" || dune.Custom["rating"] != float64(5) {
+		t.Errorf("Expected Dune's details to be imported, got %+v", dune)
+	}
+
+	// Importing again matches the books by identifier instead of adding them again
+	_, items, _ = ParseLibraryJSON(bytes.NewReader(export.Bytes()))
+	result, err = importBooks(nil, items, ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportBooks failed: %v", err)
+	}
+	if result.Created != 0 || result.Updated != 13 {
+		t.Errorf("Expected every book updated, got %+v", result)
+	}
+	result, err = importBooks(nil, items, ImportOptions{OnMatch: ImportMatchSkip})
+	if err != nil {
+		t.Fatalf("ImportBooks failed: %v", err)
+	}
+	if result.Skipped != 13 {
+		t.Errorf("Expected every book skipped, got %+v", result)
+	}
+
+	// Versions from newer builds are refused
+	_, _, err = ParseLibraryJSON(strings.NewReader(`{"version": 99, "books": []}`))
+	var importErr ImportError
+	if !errors.As(err, &importErr) {
+		t.Errorf("Expected an ImportError for a newer version, got %v", err)
+	}
+
+	// CSV, with mapped columns
+	hyperion, err := client.AddBook(BookParams{Title: ptr("Hyperion"), ASIN: ptr("B000FBJCJE")})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+	csvFile := "\ufeffBook Title,Writer,isbn,asin,year,custom.rating\n" +
+		"Dune Messiah,Frank Herbert,,,1969,4\n" +
+		"Dune,,9780441172719,B000FBJCJE,,\n" +
+		"Children of Dune,Frank Herbert,,,nineteen,\n" +
+		"Dune Copy,Frank Herbert,0441172717,,,\n"
+	items, err = client.ParseLibraryCSV(strings.NewReader(csvFile), map[string]string{"title": "Book Title", "authors": "Writer"})
+	if err != nil {
+		t.Fatalf("ParseLibraryCSV failed: %v", err)
+	}
+	if len(items) != 4 || items[0].Row != 2 {
+		t.Fatalf("Expected 4 rows starting at line 2, got %d", len(items))
+	}
+
+	_, err = client.ParseLibraryCSV(strings.NewReader(csvFile), map[string]string{"title": "Name"})
+	if !errors.As(err, &importErr) {
+		t.Errorf("Expected an ImportError for a missing column, got %v", err)
+	}
+
+	// Row 3 matches Dune by ISBN, but Hyperion has the ASIN. Row 5 is Dune's ISBN as an ISBN-10, so it's the same
+	// book as row 3
+	result, err = importBooks(nil, items, ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportBooks failed: %v", err)
+	}
+	expected := []string{ImportActionCreate, ImportActionSkip, ImportActionError, ImportActionUpdate}
+	for i, action := range expected {
+		if result.Items[i].Action != action {
+			t.Errorf("Expected row %d to be %s, got %+v", result.Items[i].Row, action, result.Items[i])
+		}
+	}
+	if conflicts := result.Items[1].Conflicts; len(conflicts) != 1 || conflicts[0].Type != IdentifierASIN || *conflicts[0].BookId != *hyperion.Id {
+		t.Errorf("Expected the ASIN to conflict with Hyperion, got %+v", conflicts)
+	}
+
+	// Stripped, row 3 updates Dune without the ASIN. Row 5's ISBN is then claimed by row 3, so it's added without it
+	result, err = importBooks(nil, items, ImportOptions{OnConflict: ImportConflictStrip})
+	if err != nil {
+		t.Fatalf("ImportBooks failed: %v", err)
+	}
+	if result.Items[1].Action != ImportActionUpdate || *result.Items[1].BookId != id {
+		t.Errorf("Expected row 3 to update Dune, got %+v", result.Items[1])
+	}
+	if result.Items[3].Action != ImportActionCreate || len(result.Items[3].Conflicts) != 1 || *result.Items[3].Conflicts[0].Row != 3 {
+		t.Errorf("Expected row 5 to be added after conflicting with row 3, got %+v", result.Items[3])
+	}
+	if asin, _, _ := client.GetBookIdentifier(id, IdentifierASIN); asin != "" {
+		t.Errorf("Expected Dune to be left without the ASIN, got %s", asin)
+	}
+
+	// With fail, nothing is kept
+	before, _ := client.GetBooksSummary(map[string][]string{})
+	result, err = importBooks(nil, items, ImportOptions{OnConflict: ImportConflictFail})
+	if !errors.Is(err, ErrImportConflicts) {
+		t.Fatalf("Expected ErrImportConflicts, got %v", err)
+	}
+	if result.Items[1].Action != ImportActionConflict {
+		t.Errorf("Expected row 3 to be a conflict, got %+v", result.Items[1])
+	}
+	after, _ := client.GetBooksSummary(map[string][]string{})
+	if after.ResultsCount != before.ResultsCount {
+		t.Errorf("Expected the failed import to be rolled back, %d books became %d", before.ResultsCount, after.ResultsCount)
+	}
+
+	// Folders are linked by path, once
+	root := "Dan Simmons/The Fall of Hyperion"
+	files := fileManagement.Files{Root: &root, AudioFiles: &[]string{root + "/01.mp3"}, TextFiles: &[]string{}}
+	result, err = importBooks(nil, []ImportItem{
+		{Row: 1, Params: BookParams{Title: ptr("The Fall of Hyperion")}, Root: &root, Files: &files},
+		{Row: 2, Params: BookParams{Title: ptr("The Fall of Hyperion (Copy)")}, Root: &root, Files: &files},
+		{Row: 3, Params: BookParams{Title: ptr("Endymion")}, Root: ptr("Dan Simmons/Endymion")},
+	}, ImportOptions{})
+	if err != nil {
+		t.Fatalf("ImportBooks failed: %v", err)
+	}
+	if result.Items[0].Folder == nil || *result.Items[0].Folder != root {
+		t.Errorf("Expected the folder to be linked, got %+v", result.Items[0])
+	}
+	if dir, _ := client.GetBookDirectory(*result.Items[0].BookId); dir == nil || *dir != root {
+		t.Errorf("Expected the book's directory to be the folder, got %v", dir)
+	}
+	if result.Items[1].Folder != nil || len(result.Items[1].Warnings) != 1 {
+		t.Errorf("Expected the folder not to be linked twice, got %+v", result.Items[1])
+	}
+	if len(result.Items[2].Warnings) != 1 {
+		t.Errorf("Expected a warning for a missing folder, got %+v", result.Items[2])
+	}
+}
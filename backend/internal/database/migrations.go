@@ -0,0 +1,581 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      func(c *Client) error
+}
+
+// Ordered list of up-migrations. Append new migrations to the end, never edit or reorder ones that have shipped
+var migrations = []migration{
+	{1, "initial schema", migrateInitialSchema},
+	{2, "saved searches", migrateSavedSearches},
+	{3, "collections", migrateCollections},
+	{4, "category aliases", migrateCategoryAliases},
+	{5, "sort names", migrateSortNames},
+	{6, "series details", migrateSeriesDetails},
+	{7, "author profiles", migrateAuthorProfiles},
+	{8, "book identifiers", migrateBookIdentifiers},
+	{9, "tags", migrateTags},
+	{10, "custom fields", migrateCustomFields},
+	{11, "publication details", migratePublicationDetails},
+	{12, "book history", migrateBookHistory},
+	{13, "cover candidate hashes", migrateCoverCandidateHashes},
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Applies pending migrations, each in its own transaction. The database is backed up next to dbPath before anything is applied
+func (c *Client) migrate(dbPath string) error {
+
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	if err != nil {
+		return err
+	}
+
+	current, err := c.GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	latest := LatestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("the database schema is at version %d but this build only supports up to version %d. Update the app or restore a backup", current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	if hasData, err := c.hasTables(); err != nil {
+		return err
+	} else if hasData {
+		backupPath := fmt.Sprintf("%s.v%d-%s.bak", dbPath, current, time.Now().Format("20060102-150405"))
+		log.Println("Backing up the database to \"", backupPath, "\" before migrating")
+
+		_, err = c.db.Exec("VACUUM INTO ?", backupPath)
+		if err != nil {
+			return fmt.Errorf("failed to back up the database before migrating: %w", err)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		log.Printf("Applying migration %d (%s)\n", m.Version, m.Name)
+
+		err = c.HandleTransaction(func(tc *Client) error {
+			err := m.Up(tc)
+			if err != nil {
+				return err
+			}
+
+			_, err = tc.handler.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) GetSchemaVersion() (int, error) {
+
+	var version int
+	err := c.handler.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// Lists every known migration along with when it was applied. Migrations that are recorded in the database but unknown to this build are included too
+func (c *Client) GetMigrationStatus() ([]MigrationStatus, error) {
+
+	applied := map[int]MigrationStatus{}
+
+	rows, err := c.handler.Query("SELECT version, name, applied_at FROM schema_migrations ORDER BY version ASC")
+	if err != nil {
+		return []MigrationStatus{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status MigrationStatus
+		err = rows.Scan(&status.Version, &status.Name, &status.AppliedAt)
+		if err != nil {
+			return []MigrationStatus{}, err
+		}
+		applied[status.Version] = status
+	}
+	if err = rows.Err(); err != nil {
+		return []MigrationStatus{}, err
+	}
+
+	statuses := []MigrationStatus{}
+	for _, m := range migrations {
+		if status, ok := applied[m.Version]; ok {
+			statuses = append(statuses, status)
+			delete(applied, m.Version)
+			continue
+		}
+		statuses = append(statuses, MigrationStatus{Version: m.Version, Name: m.Name})
+	}
+
+	unknown := slices.Sorted(maps.Keys(applied))
+	for _, version := range unknown {
+		statuses = append(statuses, applied[version])
+	}
+
+	return statuses, nil
+}
+
+// Opens the database without migrating it and reads the migration status
+func ReadMigrationStatus(dbPath string) ([]MigrationStatus, error) {
+
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return []MigrationStatus{}, err
+	}
+	defer db.Close()
+
+	c := Client{db: db, handler: db}
+
+	var exists bool
+	err = c.handler.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')").Scan(&exists)
+	if err != nil {
+		return []MigrationStatus{}, err
+	}
+	if !exists {
+		statuses := []MigrationStatus{}
+		for _, m := range migrations {
+			statuses = append(statuses, MigrationStatus{Version: m.Version, Name: m.Name})
+		}
+		return statuses, nil
+	}
+
+	return c.GetMigrationStatus()
+}
+
+// Checks for tables other than schema_migrations, which means the database existed before this run
+func (c *Client) hasTables() (bool, error) {
+	var exists bool
+	err := c.handler.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence'))").Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// For code migrations share with the rest of the app, which can run before the column is added
+func (c *Client) hasColumn(table, column string) (bool, error) {
+	var exists bool
+	err := c.handler.QueryRow("SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// #region Migrations
+
+// Migration 2. Saved searches hold GET /api/books filters as a url query string. user_id is NULL when authentication is off
+func migrateSavedSearches(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	CREATE TABLE saved_searches (
+		id TEXT PRIMARY KEY,
+		user_id TEXT,
+		name TEXT NOT NULL,
+		filters TEXT NOT NULL DEFAULT '',
+		pinned BOOLEAN NOT NULL DEFAULT 0,
+		position INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+	CREATE INDEX saved_searches_user ON saved_searches (user_id, pinned, position);
+	`)
+	return err
+}
+
+// Migration 3. Collections are hand picked, ordered lists of books. Private collections are only visible to their owner
+func migrateCollections(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	CREATE TABLE collections (
+		id TEXT PRIMARY KEY,
+		user_id TEXT,
+		name TEXT NOT NULL,
+		description TEXT,
+		shared BOOLEAN NOT NULL DEFAULT 0,
+		cover_book_id TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (cover_book_id) REFERENCES books(id) ON DELETE SET NULL
+	);
+	CREATE TABLE collections_books (
+		collection_id TEXT NOT NULL,
+		book_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (collection_id, book_id),
+		FOREIGN KEY (collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+		FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+	);
+	CREATE INDEX collections_books_book ON collections_books (book_id);
+	`)
+	return err
+}
+
+// Migration 4. Other names for authors, narrators, series and genres. Metadata using an alias is mapped onto the category
+func migrateCategoryAliases(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	CREATE TABLE category_aliases (
+		category_type TEXT NOT NULL,
+		alias TEXT NOT NULL COLLATE NOCASE,
+		category_id INTEGER NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (category_type, alias)
+	);
+	CREATE INDEX category_aliases_category ON category_aliases (category_type, category_id);
+	`)
+	return err
+}
+
+// Migration 5. Authors and narrators get a "Last, First" sort name, which is generated unless a user set it. Books get a title without its leading article
+func migrateSortNames(c *Client) error {
+
+	for _, catType := range []CategoryType{Authors, Narrators} {
+		_, err := c.handler.Exec(fmt.Sprintf(`
+		ALTER TABLE %[1]s ADD COLUMN sort_name TEXT;
+		ALTER TABLE %[1]s ADD COLUMN sort_name_custom BOOLEAN NOT NULL DEFAULT 0;
+		`, catType))
+		if err != nil {
+			return err
+		}
+
+		categories, err := c.GetAllOfCategory(catType, "", false)
+		if err != nil {
+			return err
+		}
+
+		for _, cat := range categories {
+			_, err = c.handler.Exec(fmt.Sprintf("UPDATE %s SET sort_name = ? WHERE id = ?", catType), GenerateSortName(cat.Name), cat.Id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := c.handler.Exec("ALTER TABLE books ADD COLUMN sort_title TEXT")
+	if err != nil {
+		return err
+	}
+
+	return c.refreshSortTitles()
+}
+
+// Migration 6. Series get a description, a total book count and an Audible asin. Books in a series get the number their index sorts by
+func migrateSeriesDetails(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	ALTER TABLE series ADD COLUMN description TEXT;
+	ALTER TABLE series ADD COLUMN total_books INTEGER;
+	ALTER TABLE series ADD COLUMN asin TEXT;
+	ALTER TABLE books_series ADD COLUMN sort_index REAL;
+	`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := c.handler.Query("SELECT book_id, series_id, series_index FROM books_series WHERE series_index IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	type update struct {
+		bookId    string
+		seriesId  int
+		sortIndex *float64
+	}
+	updates := []update{}
+	for rows.Next() {
+		var u update
+		var index *string
+		err = rows.Scan(&u.bookId, &u.seriesId, &index)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if u.sortIndex = seriesSortIndex(index); u.sortIndex != nil {
+			updates = append(updates, u)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, u := range updates {
+		_, err = c.handler.Exec("UPDATE books_series SET sort_index = ? WHERE book_id = ? AND series_id = ?", u.sortIndex, u.bookId, u.seriesId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Migration 7. Authors get a biography, a photo and their Audible and OpenLibrary ids
+func migrateAuthorProfiles(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	ALTER TABLE authors ADD COLUMN bio TEXT;
+	ALTER TABLE authors ADD COLUMN photo_url TEXT;
+	ALTER TABLE authors ADD COLUMN asin TEXT;
+	ALTER TABLE authors ADD COLUMN openlibrary_key TEXT;
+	`)
+	return err
+}
+
+// Migration 8. Any number of typed identifiers per book. Valid ISBNs and asins already on books are copied over, ISBNs as ISBN-13s
+func migrateBookIdentifiers(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	CREATE TABLE book_identifiers (
+		book_id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		value TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (type, value),
+		FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE
+	);
+	CREATE INDEX book_identifiers_book ON book_identifiers (book_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := c.handler.Query("SELECT id, isbn, asin FROM books WHERE isbn IS NOT NULL OR asin IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	type book struct {
+		id         uuid.UUID
+		isbn, asin *string
+	}
+	books := []book{}
+	for rows.Next() {
+		var b book
+		err = rows.Scan(&b.id, &b.isbn, &b.asin)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		books = append(books, b)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, b := range books {
+		err = c.addBookIdentifiers(b.id, BookParams{ISBN: b.isbn, ASIN: b.asin})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Migration 9. Tags move out of the JSON in books.tags into a category table. Tags only differing in case become one tag
+func migrateTags(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	CREATE TABLE tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL COLLATE NOCASE
+	);
+	CREATE TABLE books_tags (
+		book_id TEXT NOT NULL,
+		tag_id INTEGER NOT NULL,
+		rank INTEGER NOT NULL,
+		PRIMARY KEY (book_id, tag_id),
+		FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+		FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+	);
+	CREATE INDEX books_tags_tag ON books_tags (tag_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := c.handler.Query("SELECT id, tags FROM books WHERE tags IS NOT NULL")
+	if err != nil {
+		return err
+	}
+
+	bookTags := map[string][]string{}
+	for rows.Next() {
+		var id, tagsStr string
+		err = rows.Scan(&id, &tagsStr)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		var tags []string
+		if err := json.Unmarshal([]byte(tagsStr), &tags); err != nil {
+			log.Println("Skipping the unreadable tags of", id, "=>", err)
+			continue
+		}
+		bookTags[id] = tags
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for id, tags := range bookTags {
+		for i, tag := range tagCategories(tags) {
+			err = c.associateBookAndCategoryType(id, tag, i)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The search index triggers read the column, so they're dropped with it. They're recreated after migrating
+	for name := range searchIndexTriggers() {
+		_, err = c.handler.Exec("DROP TRIGGER IF EXISTS " + name)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = c.handler.Exec("ALTER TABLE books DROP COLUMN tags")
+	if err != nil {
+		return err
+	}
+
+	// Emptying the index has it rebuilt from the new table
+	var hasIndex bool
+	err = c.handler.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5') AND EXISTS (SELECT 1 FROM sqlite_master WHERE name = 'books_fts')").Scan(&hasIndex)
+	if err != nil {
+		return err
+	}
+	if hasIndex {
+		_, err = c.handler.Exec("DELETE FROM books_fts")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Migration 10. User defined fields and the books' values for them. The value column has no type, so numbers and booleans
+// are stored as numbers and sort as numbers
+func migrateCustomFields(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	CREATE TABLE custom_fields (
+		key TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		options TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE book_custom_values (
+		book_id TEXT NOT NULL,
+		field_key TEXT NOT NULL,
+		value NOT NULL,
+		PRIMARY KEY (book_id, field_key),
+		FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+		FOREIGN KEY (field_key) REFERENCES custom_fields(key) ON DELETE CASCADE
+	);
+	CREATE INDEX book_custom_values_field ON book_custom_values (field_key, value);
+	`)
+	return err
+}
+
+// Migration 11. The full publish date, language, abridged and explicit flags and runtime that metadata providers return
+func migratePublicationDetails(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	ALTER TABLE books ADD COLUMN publish_date TEXT;
+	ALTER TABLE books ADD COLUMN language TEXT;
+	ALTER TABLE books ADD COLUMN abridged BOOLEAN;
+	ALTER TABLE books ADD COLUMN explicit BOOLEAN;
+	ALTER TABLE books ADD COLUMN runtime_minutes INTEGER;
+	CREATE INDEX books_language ON books (language);
+	`)
+	return err
+}
+
+// Migration 12. Every change to a book, with the book as it was before the change so it can be reverted
+func migrateBookHistory(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	CREATE TABLE book_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id TEXT NOT NULL,
+		user_id TEXT,
+		action TEXT NOT NULL,
+		reverts INTEGER,
+		changes TEXT NOT NULL,
+		snapshot TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+	);
+	CREATE INDEX book_history_book ON book_history (book_id, id);
+	`)
+	return err
+}
+
+// Migration 13. The hash of each candidate's image, so the same image isn't added to a book's gallery twice. Candidates
+// added before this have no hash until their image is compared
+func migrateCoverCandidateHashes(c *Client) error {
+
+	_, err := c.handler.Exec(`
+	ALTER TABLE cover_candidates ADD COLUMN hash TEXT;
+	CREATE INDEX cover_candidates_hash ON cover_candidates (book_id, hash);
+	`)
+	return err
+}
@@ -0,0 +1,275 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Page params that can't be used. These are the client's fault
+type PageError struct {
+	Msg string
+}
+
+func (e PageError) Error() string {
+	return e.Msg
+}
+
+// An expression books are ordered by
+type sortKey struct {
+	expr string
+	args []any
+	desc bool
+}
+
+// Where a page of books ended. Cursors are only valid for the sort they were made with
+type bookCursor struct {
+	Sort   string `json:"s"`
+	Values []any  `json:"v"`
+}
+
+// A page of GET /api/books results. Pages are picked by number, or by the cursor of the page before
+type bookPage struct {
+	Count  int
+	Page   int
+	Total  int
+	Next   *string
+	cursor *bookCursor
+
+	sort   string
+	values []any // The sort values of the row being scanned, read after the selected columns
+	seen   int
+	last   *string
+}
+
+func parseBookPage(filters map[string][]string) (*bookPage, error) {
+
+	page := &bookPage{Count: DefaultPageSize, Page: 1}
+
+	if count, ok := filters["count"]; ok {
+		n, err := strconv.Atoi(count[0])
+		if err != nil || n < 1 || n > MaxPageSize {
+			return nil, PageError{fmt.Sprintf("count must be a number from 1 to %d", MaxPageSize)}
+		}
+		page.Count = n
+	}
+
+	if number, ok := filters["page"]; ok {
+		n, err := strconv.Atoi(number[0])
+		if err != nil || n < 1 {
+			return nil, PageError{"page must be a number from 1"}
+		}
+		page.Page = n
+	}
+
+	if cursor, ok := filters["cursor"]; ok {
+		if _, ok := filters["page"]; ok {
+			return nil, PageError{"Use either page or cursor, not both"}
+		}
+
+		data, err := base64.RawURLEncoding.DecodeString(cursor[0])
+		if err != nil {
+			return nil, PageError{"Invalid cursor"}
+		}
+
+		// Numbers are kept exact, so the page starts right after the row the cursor was made from
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		page.cursor = &bookCursor{}
+		err = decoder.Decode(page.cursor)
+		if err != nil {
+			return nil, PageError{"Invalid cursor"}
+		}
+		for i, value := range page.cursor.Values {
+			switch v := value.(type) {
+			case json.Number:
+				if n, err := v.Int64(); err == nil {
+					page.cursor.Values[i] = n
+				} else if f, err := v.Float64(); err == nil {
+					page.cursor.Values[i] = f
+				} else {
+					return nil, PageError{"Invalid cursor"}
+				}
+			case string, nil:
+			default:
+				return nil, PageError{"Invalid cursor"}
+			}
+		}
+		page.Page = 0
+	}
+
+	return page, nil
+}
+
+// Runs the query for the page of books, selecting the columns followed by the search snippet. The total is counted
+// for the filters alone, so it's the same for every page. Scan each row into append(dest, page.dest()...) and call
+// page.scanned after, which is false for the row past the end of the page
+func (c *Client) queryBookPage(filters map[string][]string, columns string) (*sql.Rows, *bookPage, error) {
+
+	page, err := parseBookPage(filters)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query, err := buildSearchQuery(filters, c.fullTextSearch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	where := func(conditions []string) string {
+		if len(conditions) == 0 {
+			return ""
+		}
+		return "WHERE " + strings.Join(conditions, " AND ") + " "
+	}
+
+	err = c.handler.QueryRow("SELECT COUNT(*) FROM books "+query.join+where(query.where), query.args...).Scan(&page.Total)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The sort's signature ties cursors to it
+	hash := fnv.New64a()
+	for _, key := range query.sort {
+		fmt.Fprintf(hash, "%s %v %t;", key.expr, key.args, key.desc)
+	}
+	page.sort = strconv.FormatUint(hash.Sum64(), 36)
+
+	conditions := query.where
+	args := []any{}
+	columns += ", " + query.snippet
+	order := []string{}
+	orderArgs := []any{}
+	for _, key := range query.sort {
+		// Unary plus makes it an expression, so dates are read as they're stored rather than parsed
+		columns += ", +(" + key.expr + ")"
+		args = append(args, key.args...)
+
+		direction := " ASC"
+		if key.desc {
+			direction = " DESC"
+		}
+		order = append(order, key.expr+direction)
+		orderArgs = append(orderArgs, key.args...)
+	}
+	args = append(args, query.args...)
+
+	offset := (page.Page - 1) * page.Count
+	if page.cursor != nil {
+		if page.cursor.Sort != page.sort || len(page.cursor.Values) != len(query.sort) {
+			return nil, nil, PageError{"The cursor is from a different sort"}
+		}
+
+		condition, conditionArgs := keysetCondition(query.sort, page.cursor.Values)
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+		offset = 0
+	}
+	args = append(args, orderArgs...)
+
+	// One more than the page to find out if there's a next page
+	sqlQuery := "SELECT " + columns + " FROM books " + query.join + where(conditions) +
+		"ORDER BY " + strings.Join(order, ", ") + fmt.Sprintf(" LIMIT %d OFFSET %d", page.Count+1, offset)
+
+	rows, err := c.handler.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w\nQuery: %s", err, sqlQuery)
+	}
+
+	page.values = make([]any, len(query.sort))
+	return rows, page, nil
+}
+
+// Where to scan the row's sort values
+func (p *bookPage) dest() []any {
+	dest := make([]any, len(p.values))
+	for i := range p.values {
+		dest[i] = &p.values[i]
+	}
+	return dest
+}
+
+// Records the row that was just scanned. Returns false for the row past the end of the page, whose only
+// purpose is to show there's a next page
+func (p *bookPage) scanned() bool {
+
+	p.seen++
+	if p.seen > p.Count {
+		p.Next = p.last
+		return false
+	}
+
+	values := make([]any, len(p.values))
+	for i, value := range p.values {
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		values[i] = value
+	}
+
+	data, err := json.Marshal(bookCursor{Sort: p.sort, Values: values})
+	if err == nil {
+		cursor := base64.RawURLEncoding.EncodeToString(data)
+		p.last = &cursor
+	}
+	return true
+}
+
+// The condition for rows that come after the values in the sort. NULLs sort before everything else, as in SQLite
+func keysetCondition(sort []sortKey, values []any) (string, []any) {
+
+	options := []string{}
+	args := []any{}
+	for i, key := range sort {
+		parts := []string{}
+		partArgs := []any{}
+
+		// Equal on every key before this one
+		for j := range i {
+			expr := "(" + sort[j].expr + ")"
+			if values[j] == nil {
+				parts = append(parts, expr+" IS NULL")
+				partArgs = append(partArgs, sort[j].args...)
+			} else {
+				parts = append(parts, expr+" = ?")
+				partArgs = append(partArgs, append(append([]any{}, sort[j].args...), values[j])...)
+			}
+		}
+
+		// And after on this one
+		expr := "(" + key.expr + ")"
+		switch {
+		case !key.desc && values[i] == nil:
+			parts = append(parts, expr+" IS NOT NULL")
+			partArgs = append(partArgs, key.args...)
+		case !key.desc:
+			parts = append(parts, expr+" > ?")
+			partArgs = append(partArgs, append(append([]any{}, key.args...), values[i])...)
+		case values[i] == nil:
+			// Nothing comes after NULL in descending order
+			continue
+		default:
+			parts = append(parts, "("+expr+" < ? OR "+expr+" IS NULL)")
+			partArgs = append(partArgs, append(append([]any{}, key.args...), values[i])...)
+			partArgs = append(partArgs, key.args...)
+		}
+
+		options = append(options, "("+strings.Join(parts, " AND ")+")")
+		args = append(args, partArgs...)
+	}
+
+	if len(options) == 0 {
+		return "0", nil
+	}
+	return "(" + strings.Join(options, " OR ") + ")", args
+}
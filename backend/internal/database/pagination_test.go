@@ -0,0 +1,188 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBookPagination(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	_, err := client.AddCustomField(CustomFieldParams{Key: ptr("rating"), Type: ptr(CustomNumber)})
+	if err != nil {
+		t.Fatalf("AddCustomField failed: %v", err)
+	}
+
+	// Books with two authors used to be listed once per author when sorting by author
+	for i := range 25 {
+		authors := []Category{{Name: fmt.Sprintf("Author %d", i%4)}, {Name: "Co Author"}}
+		params := BookParams{Title: ptr(fmt.Sprintf("Book %02d", i)), Authors: &authors}
+		if i%3 == 0 {
+			params.Publisher = ptr("Tor")
+		}
+		if i%2 == 0 {
+			params.Custom = &map[string]any{"rating": float64(i % 5)}
+		}
+		_, err := client.AddBook(params)
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+	}
+	_, err = client.AddBook(BookParams{Title: ptr("No Authors")})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+
+	var pageErr PageError
+	for _, filters := range []map[string][]string{
+		{"count": {"1000000"}},
+		{"count": {"0"}},
+		{"count": {"ten"}},
+		{"page": {"-1"}},
+		{"cursor": {"not a cursor"}},
+		{"cursor": {"abc"}, "page": {"2"}},
+	} {
+		if _, err := client.GetBooksSummary(filters); !errors.As(err, &pageErr) {
+			t.Errorf("Expected a PageError for %v, got %v", filters, err)
+		}
+	}
+
+	walk := func(filters map[string][]string) ([]uuid.UUID, int) {
+		t.Helper()
+
+		ids := []uuid.UUID{}
+		total := -1
+		filters["count"] = []string{"4"}
+		for pages := 0; pages < 20; pages++ {
+			results, err := client.GetBooksSummary(filters)
+			if err != nil {
+				t.Fatalf("GetBooksSummary failed for %v: %v", filters, err)
+			}
+			if total >= 0 && results.ResultsCount != total {
+				t.Errorf("The total changed between pages, %d then %d", total, results.ResultsCount)
+			}
+			total = results.ResultsCount
+
+			for _, book := range results.Items {
+				ids = append(ids, book.Id)
+			}
+			if results.NextCursor == nil {
+				return ids, total
+			}
+			filters["cursor"] = []string{*results.NextCursor}
+		}
+		t.Fatalf("Too many pages for %v", filters)
+		return nil, 0
+	}
+
+	for _, filters := range []map[string][]string{
+		{},
+		{"sortBy": {"authors"}},
+		{"sortBy": {"authors"}, "sortOrder": {"desc"}},
+		{"sortBy": {"title"}, "sortOrder": {"desc"}},
+		{"sortBy": {"created_at"}},
+		{"sortBy": {"publisher"}, "sortOrder": {"desc"}},
+		{"sortBy": {"custom.rating"}, "sortOrder": {"desc"}},
+		{"sortBy": {"genres"}},
+		{"authors": {"Author 1"}},
+		{"publisher": {"Tor"}, "sortBy": {"title"}},
+		{"search": {"book"}},
+	} {
+		// Every page by number, for comparison
+		byNumber := []uuid.UUID{}
+		for page := 1; ; page++ {
+			results, err := client.GetBooksSummary(mergeFilters(filters, map[string][]string{"count": {"4"}, "page": {fmt.Sprint(page)}}))
+			if err != nil {
+				t.Fatalf("GetBooksSummary failed for %v: %v", filters, err)
+			}
+			for _, book := range results.Items {
+				byNumber = append(byNumber, book.Id)
+			}
+			if len(results.Items) < 4 {
+				break
+			}
+		}
+
+		ids, total := walk(mergeFilters(filters, nil))
+		if len(ids) != total {
+			t.Errorf("Expected %d books for %v, got %d", total, filters, len(ids))
+		}
+		if !slices.Equal(ids, byNumber) {
+			t.Errorf("Expected the cursor pages to match the numbered pages for %v", filters)
+		}
+
+		unique := slices.Clone(ids)
+		slices.SortFunc(unique, func(a, b uuid.UUID) int { return slices.Compare(a[:], b[:]) })
+		if len(slices.Compact(unique)) != len(ids) {
+			t.Errorf("Expected each book once for %v", filters)
+		}
+	}
+
+	// Totals follow the filters
+	for _, test := range []struct {
+		filters map[string][]string
+		total   int
+	}{
+		{map[string][]string{}, 26},
+		{map[string][]string{"authors": {"Author 1"}}, 6},
+		{map[string][]string{"authors": {"Author"}}, 25},
+		{map[string][]string{"publisher": {"Tor"}}, 9},
+		{map[string][]string{"q": {"custom.rating>=3"}}, 5},
+	} {
+		results, err := client.GetBooksSummary(test.filters)
+		if err != nil {
+			t.Fatalf("GetBooksSummary failed: %v", err)
+		}
+		if results.ResultsCount != test.total {
+			t.Errorf("Expected %d books for %v, got %d", test.total, test.filters, results.ResultsCount)
+		}
+	}
+
+	// Cursors end on the book id rather than the rowid, which VACUUM can renumber
+	first, err := client.GetBooksSummary(map[string][]string{"count": {"10"}, "sortBy": {"publisher"}})
+	if err != nil {
+		t.Fatalf("GetBooksSummary failed: %v", err)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(*first.NextCursor)
+	if err != nil {
+		t.Fatalf("Failed to decode the cursor: %v", err)
+	}
+	var cursor bookCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil {
+		t.Fatalf("Failed to decode the cursor: %v", err)
+	}
+	if last := first.Items[len(first.Items)-1].Id.String(); len(cursor.Values) == 0 || cursor.Values[len(cursor.Values)-1] != last {
+		t.Errorf("Expected the cursor to end on the id %s, got %v", last, cursor.Values)
+	}
+
+	// A cursor only works with the sort it came from
+	results, err := client.GetBooksSummary(map[string][]string{"sortBy": {"title"}})
+	if err != nil {
+		t.Fatalf("GetBooksSummary failed: %v", err)
+	}
+	if results.NextCursor == nil {
+		t.Fatal("Expected a next cursor")
+	}
+	_, err = client.GetBooksSummary(map[string][]string{"sortBy": {"authors"}, "cursor": {*results.NextCursor}})
+	if !errors.As(err, &pageErr) {
+		t.Errorf("Expected a PageError for a cursor from another sort, got %v", err)
+	}
+}
+
+func mergeFilters(a, b map[string][]string) map[string][]string {
+	merged := map[string][]string{}
+	for _, m := range []map[string][]string{a, b} {
+		for key, value := range m {
+			merged[key] = value
+		}
+	}
+	return merged
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// Layouts accepted for publish dates, and the layout each is stored in. Partial dates keep their precision
+var publishDateLayouts = []struct {
+	layout string
+	stored string
+}{
+	{"2006-01-02", "2006-01-02"},
+	{time.RFC3339, "2006-01-02"},
+	{"2006/01/02", "2006-01-02"},
+	{"January 2, 2006", "2006-01-02"},
+	{"Jan 2, 2006", "2006-01-02"},
+	{"2 January 2006", "2006-01-02"},
+	{"2006-01", "2006-01"},
+	{"January 2006", "2006-01"},
+	{"Jan 2006", "2006-01"},
+	{"2006", "2006"},
+}
+
+// Returns the date as YYYY-MM-DD, or YYYY-MM or YYYY when that's all that's known, along with the year
+func NormalizePublishDate(date string) (string, int, bool) {
+
+	date = strings.TrimSpace(date)
+	for _, l := range publishDateLayouts {
+		if t, err := time.Parse(l.layout, date); err == nil {
+			return t.Format(l.stored), t.Year(), true
+		}
+	}
+
+	return "", 0, false
+}
+
+// ISO 639-1 codes for the language names and ISO 639-2 codes the metadata providers use
+var languageCodes = map[string]string{
+	"english": "en", "eng": "en",
+	"french": "fr", "fre": "fr", "fra": "fr", "français": "fr",
+	"german": "de", "ger": "de", "deu": "de", "deutsch": "de",
+	"spanish": "es", "spa": "es", "español": "es",
+	"italian": "it", "ita": "it", "italiano": "it",
+	"portuguese": "pt", "por": "pt",
+	"dutch": "nl", "dut": "nl", "nld": "nl",
+	"japanese": "ja", "jpn": "ja",
+	"chinese": "zh", "chi": "zh", "zho": "zh", "mandarin_chinese": "zh",
+	"russian": "ru", "rus": "ru",
+	"polish": "pl", "pol": "pl",
+	"swedish": "sv", "swe": "sv",
+	"danish": "da", "dan": "da",
+	"norwegian": "no", "nor": "no",
+	"finnish": "fi", "fin": "fi",
+	"hindi": "hi", "hin": "hi",
+	"korean": "ko", "kor": "ko",
+	"arabic": "ar", "ara": "ar",
+	"turkish": "tr", "tur": "tr",
+}
+
+// Returns the language as an ISO 639-1 code when it's a name or code this knows, otherwise lowercased. Region subtags are dropped (en-GB is en)
+func NormalizeLanguage(language string) string {
+
+	language = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(language, "/languages/")))
+	if code, ok := languageCodes[language]; ok {
+		return code
+	}
+	if base, _, ok := strings.Cut(strings.ReplaceAll(language, "_", "-"), "-"); ok && len(base) == 2 {
+		return base
+	}
+
+	return language
+}
@@ -0,0 +1,98 @@
+package database
+
+import "testing"
+
+func TestNormalizePublishDate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		year     int
+		ok       bool
+	}{
+		{"2006-07-17", "2006-07-17", 2006, true},
+		{"2006-07-17T00:00:00Z", "2006-07-17", 2006, true},
+		{"July 17, 2006", "2006-07-17", 2006, true},
+		{"Jul 2006", "2006-07", 2006, true},
+		{" 1965 ", "1965", 1965, true},
+		{"someday", "", 0, false},
+	}
+	for _, test := range tests {
+		date, year, ok := NormalizePublishDate(test.input)
+		if date != test.expected || year != test.year || ok != test.ok {
+			t.Errorf("Expected %q to be (%q, %d, %v), got (%q, %d, %v)", test.input, test.expected, test.year, test.ok, date, year, ok)
+		}
+	}
+}
+
+func TestNormalizeLanguage(t *testing.T) {
+	for input, expected := range map[string]string{
+		"English":        "en",
+		"eng":            "en",
+		"/languages/fre": "fr",
+		"en-GB":          "en",
+		"pt_BR":          "pt",
+		"Klingon":        "klingon",
+	} {
+		if language := NormalizeLanguage(input); language != expected {
+			t.Errorf("Expected %q to be %q, got %q", input, expected, language)
+		}
+	}
+}
+
+func TestPublicationDetails(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	books := []BookParams{
+		{Title: ptr("The Hobbit"), PublishDate: ptr("September 21, 1937"), Language: ptr("English"), RuntimeMinutes: ptr(660)},
+		{Title: ptr("Le Petit Prince"), PublishDate: ptr("1943-04"), Language: ptr("fra"), Abridged: ptr(true)},
+		{Title: ptr("American Psycho"), PublishDate: ptr("1991"), Language: ptr("en"), Explicit: ptr(true)},
+	}
+	for _, params := range books {
+		book, err := client.AddBook(params)
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+		if *params.Title == "The Hobbit" {
+			if book.PublishDate == nil || *book.PublishDate != "1937-09-21" || book.Year == nil || *book.Year != 1937 {
+				t.Errorf("Expected the date to be normalized and the year filled in, got %v and %v", book.PublishDate, book.Year)
+			}
+			if book.Language == nil || *book.Language != "en" {
+				t.Errorf("Expected the language to be normalized, got %v", book.Language)
+			}
+		}
+	}
+
+	tests := []struct {
+		filters  map[string][]string
+		expected []string
+	}{
+		{map[string][]string{"language": {"en"}, "sortBy": {"title"}, "sortOrder": {"asc"}}, []string{"American Psycho", "The Hobbit"}},
+		{map[string][]string{"language": {"en"}, "explicit": {"false"}}, []string{"The Hobbit"}},
+		{map[string][]string{"abridged": {"true"}}, []string{"Le Petit Prince"}},
+		{map[string][]string{"q": {"published<1940"}}, []string{"The Hobbit"}},
+		{map[string][]string{"q": {"published:1943"}}, []string{"Le Petit Prince"}},
+		{map[string][]string{"q": {"runtime>600"}}, []string{"The Hobbit"}},
+		{map[string][]string{"sortBy": {"publish_date"}, "sortOrder": {"desc"}}, []string{"American Psycho", "Le Petit Prince", "The Hobbit"}},
+	}
+	for _, test := range tests {
+		results, err := client.GetBooksSummary(test.filters)
+		if err != nil {
+			t.Fatalf("GetBooksSummary failed for %v: %v", test.filters, err)
+		}
+		titles := []string{}
+		for _, item := range results.Items {
+			titles = append(titles, item.Title)
+		}
+		if len(titles) != len(test.expected) {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.filters, titles)
+			continue
+		}
+		for i := range titles {
+			if titles[i] != test.expected[i] {
+				t.Errorf("Expected %v for %v, got %v", test.expected, test.filters, titles)
+				break
+			}
+		}
+	}
+}
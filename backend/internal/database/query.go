@@ -0,0 +1,527 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The query language accepted by the q filter of GET /api/books, e.g.
+//
+//	author:"Brandon Sanderson" series:Stormlight year>=2010 -genre:romance narrator:(Kramer OR Reading) has:files format:m4b
+//
+// Terms are AND-ed together unless joined by OR. Terms can be negated with - or NOT and grouped with parentheses.
+// A field followed by a group applies to every value in the group. Words without a field search the book's text.
+// Custom fields are queried as custom.<key>, e.g. custom.kids_approved:true custom.purchased>=2024-01-01
+
+// A syntax error in a query. Pos is the byte offset of the problem in the query
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e QueryError) Error() string {
+	return fmt.Sprintf("query error at position %d: %s", e.Pos, e.Msg)
+}
+
+type queryField struct {
+	// Builds the condition for a value. Returns an error message if the value isn't valid for the field
+	condition  func(op, value string) (string, []any, string)
+	comparable bool
+}
+
+func categoryCondition(cat CategoryType) func(string, string) (string, []any, string) {
+	return func(op, value string) (string, []any, string) {
+		return fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM books_%[1]s JOIN %[1]s ON %[1]s.id = books_%[1]s.%[2]s_id WHERE books_%[1]s.book_id = books.id AND %[1]s.name LIKE ?)",
+			cat, categorySingular[cat],
+		), []any{"%" + value + "%"}, ""
+	}
+}
+
+// Matches tags by their whole name or one of their aliases, ignoring case. "war" doesn't match "warhammer"
+func tagCondition(op, value string) (string, []any, string) {
+	return `EXISTS (SELECT 1 FROM books_tags JOIN tags ON tags.id = books_tags.tag_id WHERE books_tags.book_id = books.id AND (tags.name = ? OR tags.id IN (
+		SELECT category_id FROM category_aliases WHERE category_type = 'tags' AND alias = ? COLLATE NOCASE)))`,
+		[]any{strings.TrimSpace(value), strings.TrimSpace(value)}, ""
+}
+
+func columnCondition(column string) func(string, string) (string, []any, string) {
+	return func(op, value string) (string, []any, string) {
+		return "COALESCE(books." + column + ", '') LIKE ?", []any{"%" + value + "%"}, ""
+	}
+}
+
+// Matches the column, or an identifier exactly. Without a type, identifiers of every type are matched
+func identifierCondition(identifierType string) func(string, string) (string, []any, string) {
+	return func(op, value string) (string, []any, string) {
+
+		types := IdentifierTypes
+		if identifierType != "" {
+			types = []string{identifierType}
+		}
+
+		conditions := []string{}
+		args := []any{}
+		if identifierType != "" {
+			column, _, _ := columnCondition(identifierType)(op, value)
+			conditions = append(conditions, column)
+			args = append(args, "%"+value+"%")
+		}
+		for _, t := range types {
+			if normalized, err := NormalizeIdentifier(t, value); err == nil {
+				conditions = append(conditions, "EXISTS (SELECT 1 FROM book_identifiers WHERE book_identifiers.book_id = books.id AND type = ? AND value = ?)")
+				args = append(args, t, normalized)
+			}
+		}
+		if len(conditions) == 0 {
+			return "0", nil, ""
+		}
+
+		return "(" + strings.Join(conditions, " OR ") + ")", args, ""
+	}
+}
+
+var queryFields = map[string]queryField{
+	"author":      {condition: categoryCondition(Authors)},
+	"narrator":    {condition: categoryCondition(Narrators)},
+	"series":      {condition: categoryCondition(Series)},
+	"genre":       {condition: categoryCondition(Genres)},
+	"title":       {condition: columnCondition("title")},
+	"subtitle":    {condition: columnCondition("subtitle")},
+	"description": {condition: columnCondition("description")},
+	"publisher":   {condition: columnCondition("publisher")},
+	"tag":         {condition: tagCondition},
+	"isbn":        {condition: identifierCondition(IdentifierISBN)},
+	"asin":        {condition: identifierCondition(IdentifierASIN)},
+	"id":          {condition: identifierCondition("")},
+	"language":    {condition: languageCondition},
+	"explicit":    {condition: flagCondition("explicit")},
+	"abridged":    {condition: flagCondition("abridged")},
+	"runtime": {
+		comparable: true,
+		condition: func(op, value string) (string, []any, string) {
+			minutes, err := strconv.Atoi(value)
+			if err != nil {
+				return "", nil, "runtime must be a number of minutes"
+			}
+			return "books.runtime_minutes " + op + " ?", []any{minutes}, ""
+		},
+	},
+	"published": {
+		comparable: true,
+		condition: func(op, value string) (string, []any, string) {
+			date, _, ok := NormalizePublishDate(value)
+			if !ok {
+				return "", nil, "published must be a date like 2024-01-31, 2024-01 or 2024"
+			}
+			// A partial date compares against the start of the books' dates, so published:2024 matches any date in 2024
+			if op == "=" {
+				return "books.publish_date LIKE ?", []any{date + "%"}, ""
+			}
+			return "books.publish_date " + op + " ?", []any{date}, ""
+		},
+	},
+	"year": {
+		comparable: true,
+		condition: func(op, value string) (string, []any, string) {
+			year, err := strconv.Atoi(value)
+			if err != nil {
+				return "", nil, "year must be a number"
+			}
+			return "books.publish_year " + op + " ?", []any{year}, ""
+		},
+	},
+	"has": {
+		condition: func(op, value string) (string, []any, string) {
+			switch strings.ToLower(value) {
+			case "files":
+				return "books.directory IS NOT NULL", nil, ""
+			case "cover":
+				return "books.cover IS NOT NULL", nil, ""
+			case "isbn":
+				return "books.isbn IS NOT NULL", nil, ""
+			case "asin":
+				return "books.asin IS NOT NULL", nil, ""
+			case "description":
+				return "books.description IS NOT NULL AND books.description != ''", nil, ""
+			case "language":
+				return "books.language IS NOT NULL", nil, ""
+			case "audio":
+				return "(books.audio_files IS NOT NULL AND books.audio_files NOT IN ('null', '[]'))", nil, ""
+			case "text":
+				return "(books.text_files IS NOT NULL AND books.text_files NOT IN ('null', '[]'))", nil, ""
+			case "series":
+				return "EXISTS (SELECT 1 FROM books_series WHERE books_series.book_id = books.id)", nil, ""
+			case "formats":
+				return "EXISTS (SELECT 1 FROM book_formats WHERE book_formats.book_id = books.id)", nil, ""
+			}
+			return "", nil, "has can be files, cover, isbn, asin, description, language, audio, text, series or formats"
+		},
+	},
+	"format": {
+		condition: func(op, value string) (string, []any, string) {
+			ext := "%." + strings.TrimPrefix(strings.ToLower(value), ".") + `"%`
+			return `(books.audio_files LIKE ? OR books.text_files LIKE ? OR EXISTS (
+				SELECT 1 FROM book_formats WHERE book_formats.book_id = books.id AND (book_formats.audio_files LIKE ? OR book_formats.text_files LIKE ?)))`,
+				[]any{ext, ext, ext, ext}, ""
+		},
+	},
+}
+
+var queryFieldAliases = map[string]string{
+	"authors":   "author",
+	"narrators": "narrator",
+	"genres":    "genre",
+	"tags":      "tag",
+}
+
+// Books without the flag set match false
+func flagCondition(column string) func(string, string) (string, []any, string) {
+	return func(op, value string) (string, []any, string) {
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", nil, column + " must be true or false"
+		}
+		return "COALESCE(books." + column + ", FALSE) = ?", []any{flag}, ""
+	}
+}
+
+func languageCondition(op, value string) (string, []any, string) {
+	return "books.language = ?", []any{NormalizeLanguage(value)}, ""
+}
+
+// Custom fields are used as custom.<key>
+func lookupQueryField(name string) (queryField, bool) {
+
+	if key, ok := strings.CutPrefix(name, "custom."); ok && customFieldKeyPattern.MatchString(key) {
+		return queryField{condition: customFieldCondition(key), comparable: true}, true
+	}
+
+	def, ok := queryFields[name]
+	return def, ok
+}
+
+// Words without a field. Searches the full text index when it's available
+func textCondition(value string, fullTextSearch bool) (string, []any) {
+	if fullTextSearch {
+		if match := buildMatchExpression(`"` + value + `"`); match != "" {
+			if !strings.Contains(value, " ") {
+				match += "*"
+			}
+			return "books.id IN (SELECT book_id FROM books_fts WHERE books_fts MATCH ?)", []any{match}
+		}
+	}
+
+	term := "%" + value + "%"
+	return "(books.title LIKE ? OR books.subtitle LIKE ? OR books.description LIKE ?)", []any{term, term, term}
+}
+
+// Parses the query into a parameterized SQL condition
+func ParseQuery(query string, fullTextSearch bool) (string, []any, error) {
+
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return "", nil, err
+	}
+
+	p := queryParser{tokens: tokens, fullTextSearch: fullTextSearch}
+	if p.peek().kind == tokenEnd {
+		return "", nil, nil
+	}
+
+	sql, args, err := p.parseOr("")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEnd {
+		if tok.kind == tokenRParen {
+			return "", nil, QueryError{tok.pos, "unexpected closing parenthesis"}
+		}
+		return "", nil, QueryError{tok.pos, fmt.Sprintf("unexpected %q", tok.text)}
+	}
+
+	return sql, args, nil
+}
+
+// #region Lexer
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenQuoted
+	tokenLParen
+	tokenRParen
+	tokenColon
+	tokenCompare
+	tokenMinus
+	tokenOr
+	tokenAnd
+	tokenNot
+)
+
+type queryToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lexQuery(query string) ([]queryToken, error) {
+
+	tokens := []queryToken{}
+	isWordChar := func(r rune) bool {
+		return !unicode.IsSpace(r) && !strings.ContainsRune(`()":<>=`, r)
+	}
+
+	runes := []rune(query)
+	pos := func(i int) int { return len(string(runes[:i])) }
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, queryToken{tokenLParen, "(", pos(i)})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, queryToken{tokenRParen, ")", pos(i)})
+			i++
+
+		case r == ':':
+			tokens = append(tokens, queryToken{tokenColon, ":", pos(i)})
+			i++
+
+		case r == '<' || r == '>' || r == '=':
+			op := string(r)
+			if r != '=' && i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			tokens = append(tokens, queryToken{tokenCompare, op, pos(i)})
+			i += len(op)
+
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, QueryError{pos(i), "unterminated quote"}
+			}
+			tokens = append(tokens, queryToken{tokenQuoted, string(runes[i+1 : end]), pos(i)})
+			i = end + 1
+
+		case r == '-' && (i+1 < len(runes) && (isWordChar(runes[i+1]) || runes[i+1] == '"' || runes[i+1] == '(')):
+			tokens = append(tokens, queryToken{tokenMinus, "-", pos(i)})
+			i++
+
+		default:
+			start := i
+			for i < len(runes) && isWordChar(runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+
+			kind := tokenWord
+			switch word {
+			case "OR":
+				kind = tokenOr
+			case "AND":
+				kind = tokenAnd
+			case "NOT":
+				kind = tokenNot
+			}
+			tokens = append(tokens, queryToken{kind, word, pos(start)})
+		}
+	}
+
+	return append(tokens, queryToken{tokenEnd, "", len(query)}), nil
+}
+
+// #region Parser
+
+type queryParser struct {
+	tokens         []queryToken
+	current        int
+	fullTextSearch bool
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.current]
+}
+
+func (p *queryParser) next() queryToken {
+	tok := p.tokens[p.current]
+	if tok.kind != tokenEnd {
+		p.current++
+	}
+	return tok
+}
+
+// or := and (OR and)*
+func (p *queryParser) parseOr(field string) (string, []any, error) {
+
+	sql, args, err := p.parseAnd(field)
+	if err != nil {
+		return "", nil, err
+	}
+
+	parts := []string{sql}
+	for p.peek().kind == tokenOr {
+		p.next()
+		sql, more, err := p.parseAnd(field)
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, sql)
+		args = append(args, more...)
+	}
+
+	if len(parts) == 1 {
+		return parts[0], args, nil
+	}
+	return "(" + strings.Join(parts, " OR ") + ")", args, nil
+}
+
+// and := unary (AND? unary)*
+func (p *queryParser) parseAnd(field string) (string, []any, error) {
+
+	sql, args, err := p.parseUnary(field)
+	if err != nil {
+		return "", nil, err
+	}
+
+	parts := []string{sql}
+	for {
+		tok := p.peek()
+		if tok.kind == tokenAnd {
+			p.next()
+		} else if tok.kind == tokenEnd || tok.kind == tokenOr || tok.kind == tokenRParen {
+			break
+		}
+
+		sql, more, err := p.parseUnary(field)
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, sql)
+		args = append(args, more...)
+	}
+
+	if len(parts) == 1 {
+		return parts[0], args, nil
+	}
+	return "(" + strings.Join(parts, " AND ") + ")", args, nil
+}
+
+// unary := (- | NOT) unary | primary
+func (p *queryParser) parseUnary(field string) (string, []any, error) {
+
+	if kind := p.peek().kind; kind == tokenMinus || kind == tokenNot {
+		p.next()
+		sql, args, err := p.parseUnary(field)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + sql + ")", args, nil
+	}
+
+	return p.parsePrimary(field)
+}
+
+// primary := ( or ) | word : value | word compare word | word | "quoted"
+// field is set inside a field's group, e.g. narrator:(Kramer OR Reading)
+func (p *queryParser) parsePrimary(field string) (string, []any, error) {
+
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenLParen:
+		sql, args, err := p.parseOr(field)
+		if err != nil {
+			return "", nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return "", nil, QueryError{tok.pos, "missing closing parenthesis"}
+		}
+		return sql, args, nil
+
+	case tokenQuoted:
+		return p.value(field, "=", tok)
+
+	case tokenWord:
+		next := p.peek()
+		if next.kind != tokenColon && next.kind != tokenCompare {
+			return p.value(field, "=", tok)
+		}
+
+		if field != "" {
+			return "", nil, QueryError{tok.pos, fmt.Sprintf("%q can't be used inside the group of %q", tok.text+next.text, field+":")}
+		}
+
+		name := strings.ToLower(tok.text)
+		if alias, ok := queryFieldAliases[name]; ok {
+			name = alias
+		}
+		def, ok := lookupQueryField(name)
+		if !ok {
+			return "", nil, QueryError{tok.pos, fmt.Sprintf("unknown field %q", tok.text)}
+		}
+
+		op := p.next()
+		if op.kind == tokenCompare && !def.comparable {
+			return "", nil, QueryError{op.pos, fmt.Sprintf("%q can't be compared with %s", tok.text, op.text)}
+		}
+
+		value := p.peek()
+		switch value.kind {
+		case tokenLParen:
+			if op.kind == tokenCompare {
+				return "", nil, QueryError{value.pos, "comparisons can't be grouped"}
+			}
+			return p.parsePrimary(name)
+
+		case tokenWord, tokenQuoted:
+			p.next()
+			operator := "="
+			if op.kind == tokenCompare {
+				operator = op.text
+			}
+			return p.value(name, operator, value)
+		}
+
+		return "", nil, QueryError{value.pos, fmt.Sprintf("expected a value after %q", tok.text+op.text)}
+
+	case tokenEnd:
+		return "", nil, QueryError{tok.pos, "unexpected end of query"}
+
+	case tokenRParen:
+		return "", nil, QueryError{tok.pos, "unexpected closing parenthesis"}
+	}
+
+	return "", nil, QueryError{tok.pos, fmt.Sprintf("unexpected %q", tok.text)}
+}
+
+// Builds the condition for a value, for the given field or the book's text if there's no field
+func (p *queryParser) value(field, op string, tok queryToken) (string, []any, error) {
+
+	if field == "" {
+		sql, args := textCondition(tok.text, p.fullTextSearch)
+		return sql, args, nil
+	}
+
+	def, _ := lookupQueryField(field)
+	sql, args, msg := def.condition(op, tok.text)
+	if msg != "" {
+		return "", nil, QueryError{tok.pos, msg}
+	}
+
+	return sql, args, nil
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseQueryErrors(t *testing.T) {
+
+	tests := []struct {
+		Name  string
+		Query string
+		Pos   int
+	}{
+		{"Unknown field", "colour:blue", 0},
+		{"Missing value", "author:", 7},
+		{"Unterminated quote", `series:"Stormlight`, 7},
+		{"Unclosed group", "narrator:(Kramer OR Reading", 9},
+		{"Stray parenthesis", "author:Sanderson )", 17},
+		{"Dangling OR", "author:Sanderson OR", 19},
+		{"Bad year", "year>=soon", 6},
+		{"Compare text field", "author>Sanderson", 6},
+		{"Field in group", "narrator:(author:Kramer)", 10},
+		{"Bad has value", "has:pictures", 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			_, _, err := ParseQuery(test.Query, false)
+
+			var queryErr QueryError
+			if !errors.As(err, &queryErr) {
+				t.Fatalf("Expected a QueryError, got %v", err)
+			}
+			if queryErr.Pos != test.Pos {
+				t.Errorf("Expected the error at position %d, got %d (%s)", test.Pos, queryErr.Pos, queryErr.Msg)
+			}
+		})
+	}
+}
+
+func TestParseQueryArgs(t *testing.T) {
+
+	tests := []struct {
+		Query string
+		Args  []any
+	}{
+		{"", nil},
+		{"year>=2010", []any{2010}},
+		{`author:"Brandon Sanderson" -genre:romance`, []any{"%Brandon Sanderson%", "%romance%"}},
+		{"narrator:(Kramer OR Reading)", []any{"%Kramer%", "%Reading%"}},
+		{"format:.M4B", []any{`%.m4b"%`, `%.m4b"%`, `%.m4b"%`, `%.m4b"%`}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Query, func(t *testing.T) {
+			_, args, err := ParseQuery(test.Query, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(args) != len(test.Args) {
+				t.Fatalf("Expected args %v, got %v", test.Args, args)
+			}
+			for i := range args {
+				if args[i] != test.Args[i] {
+					t.Errorf("Expected args %v, got %v", test.Args, args)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryBooks(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	type testBook struct {
+		title     string
+		year      int
+		author    string
+		narrator  string
+		genre     string
+		hasFiles  bool
+		audioFile string
+	}
+	books := []testBook{
+		{"The Way of Kings", 2010, "Brandon Sanderson", "Michael Kramer", "Fantasy", true, "The Way of Kings.m4b"},
+		{"Mistborn", 2006, "Brandon Sanderson", "Michael Kramer", "Fantasy", false, ""},
+		{"Warbreaker", 2009, "Brandon Sanderson", "Alyssa Bresnahan", "Romance", false, ""},
+		{"Kingdom of Ash", 2018, "Sarah J. Maas", "Elizabeth Evans", "Romance", true, "Kingdom of Ash.mp3"},
+	}
+
+	for _, b := range books {
+		authors := []Category{{Name: b.author}}
+		narrators := []Category{{Name: b.narrator}}
+		genres := []Category{{Name: b.genre}}
+
+		book, err := client.AddBook(BookParams{Title: &b.title, Year: &b.year, Authors: &authors, Narrators: &narrators, Genres: &genres})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+
+		if b.hasFiles {
+			book.Files.Root = &b.title
+			book.Files.AudioFiles = &[]string{b.audioFile}
+			err = book.ApplyBookFiles(&client)
+			if err != nil {
+				t.Fatalf("ApplyBookFiles failed: %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		Query    string
+		Expected int
+	}{
+		{`author:"Brandon Sanderson"`, 3},
+		{"author:Sanderson year>=2009", 2},
+		{"author:Sanderson -genre:romance", 2},
+		{"narrator:(Kramer OR Evans)", 3},
+		{"has:files", 2},
+		{"-has:files", 2},
+		{"format:m4b", 1},
+		{"(year<2007 OR year>2015) NOT author:Maas", 1},
+		{"mistborn", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Query, func(t *testing.T) {
+			results, err := client.GetBooksSummary(map[string][]string{"q": {test.Query}})
+			if err != nil {
+				t.Fatalf("GetBooksSummary failed: %v", err)
+			}
+			if len(results.Items) != test.Expected {
+				t.Errorf("Expected %d results, got %d", test.Expected, len(results.Items))
+			}
+		})
+	}
+}
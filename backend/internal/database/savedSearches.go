@@ -0,0 +1,221 @@
+package database
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A set of GET /api/books filters saved under a name. Saved searches belong to the user that created them,
+// or to nobody when authentication is off. Their books are evaluated whenever they're requested
+type SavedSearch struct {
+	Id        uuid.UUID           `json:"id"`
+	UserId    *uuid.UUID          `json:"user_id"`
+	Name      string              `json:"name"`
+	Query     string              `json:"query"`
+	Filters   map[string][]string `json:"filters"`
+	Pinned    bool                `json:"pinned"`
+	Position  int                 `json:"position"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+type SavedSearchParams struct {
+	Name *string `json:"name"`
+
+	// Either the url query string of a GET /api/books request, or the same filters as an object
+	Query   *string              `json:"query"`
+	Filters *map[string][]string `json:"filters"`
+
+	Pinned *bool `json:"pinned"`
+}
+
+// Filters that only affect how results are returned. They aren't saved, the request evaluating the search sets them
+var savedSearchIgnoredFilters = []string{"page", "count", "cursor", "view", "saved"}
+
+const savedSearchColumns = "id, user_id, name, filters, pinned, position, created_at, updated_at"
+
+// Returns the params' filters as a query string, or ok = false if the params don't set any filters
+func (params SavedSearchParams) Encode() (string, bool, error) {
+
+	var filters url.Values
+	switch {
+	case params.Filters != nil:
+		filters = url.Values(*params.Filters)
+	case params.Query != nil:
+		var err error
+		filters, err = url.ParseQuery(strings.TrimPrefix(*params.Query, "?"))
+		if err != nil {
+			return "", false, err
+		}
+	default:
+		return "", false, nil
+	}
+
+	for _, key := range savedSearchIgnoredFilters {
+		filters.Del(key)
+	}
+
+	return filters.Encode(), true, nil
+}
+
+func (c *Client) AddSavedSearch(userId *uuid.UUID, params SavedSearchParams) (SavedSearch, error) {
+
+	id := uuid.New()
+
+	query, _, err := params.Encode()
+	if err != nil {
+		return SavedSearch{}, err
+	}
+
+	pinned := params.Pinned != nil && *params.Pinned
+
+	_, err = c.handler.Exec(`
+	INSERT INTO saved_searches
+		(id, user_id, name, filters, pinned, position, created_at, updated_at)
+	VALUES
+		(?, ?, ?, ?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM saved_searches WHERE user_id IS ?), CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, id, userId, strings.TrimSpace(*params.Name), query, pinned, userId)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+
+	log.Println("Saved the search \"", *params.Name, "\"")
+
+	return c.GetSavedSearch(userId, id)
+}
+
+// Saved searches are only visible to the user that created them
+func (c *Client) GetSavedSearch(userId *uuid.UUID, id uuid.UUID) (SavedSearch, error) {
+	return scanSavedSearch(c.handler.QueryRow("SELECT "+savedSearchColumns+" FROM saved_searches WHERE id = ? AND user_id IS ?", id, userId))
+}
+
+// Pinned searches come first, then the rest in the user's order
+func (c *Client) GetSavedSearches(userId *uuid.UUID) ([]SavedSearch, error) {
+
+	rows, err := c.handler.Query("SELECT "+savedSearchColumns+" FROM saved_searches WHERE user_id IS ? ORDER BY pinned DESC, position ASC", userId)
+	if err != nil {
+		return []SavedSearch{}, err
+	}
+	defer rows.Close()
+
+	searches := []SavedSearch{}
+	for rows.Next() {
+		search, err := scanSavedSearch(rows)
+		if err != nil {
+			return []SavedSearch{}, err
+		}
+		searches = append(searches, search)
+	}
+
+	return searches, rows.Err()
+}
+
+func (c *Client) UpdateSavedSearch(userId *uuid.UUID, id uuid.UUID, params SavedSearchParams) (SavedSearch, error) {
+
+	setParts := []string{"updated_at = CURRENT_TIMESTAMP"}
+	args := []any{}
+
+	if params.Name != nil {
+		setParts = append(setParts, "name = ?")
+		args = append(args, strings.TrimSpace(*params.Name))
+	}
+	if query, ok, err := params.Encode(); err != nil {
+		return SavedSearch{}, err
+	} else if ok {
+		setParts = append(setParts, "filters = ?")
+		args = append(args, query)
+	}
+	if params.Pinned != nil {
+		setParts = append(setParts, "pinned = ?")
+		args = append(args, *params.Pinned)
+	}
+
+	args = append(args, id, userId)
+	_, err := c.handler.Exec("UPDATE saved_searches SET "+strings.Join(setParts, ", ")+" WHERE id = ? AND user_id IS ?", args...)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+
+	return c.GetSavedSearch(userId, id)
+}
+
+// Sets the order of the user's saved searches. Searches missing from ids keep their relative order after the listed ones
+func (c *Client) ReorderSavedSearches(userId *uuid.UUID, ids []uuid.UUID) error {
+
+	searches, err := c.GetSavedSearches(userId)
+	if err != nil {
+		return err
+	}
+
+	position := 0
+	seen := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		_, err = c.handler.Exec("UPDATE saved_searches SET position = ? WHERE id = ? AND user_id IS ?", position, id, userId)
+		if err != nil {
+			return err
+		}
+		position++
+	}
+
+	for _, search := range searches {
+		if seen[search.Id] {
+			continue
+		}
+
+		_, err = c.handler.Exec("UPDATE saved_searches SET position = ? WHERE id = ?", position, search.Id)
+		if err != nil {
+			return err
+		}
+		position++
+	}
+
+	return nil
+}
+
+func (c *Client) DeleteSavedSearch(userId *uuid.UUID, id uuid.UUID) error {
+
+	_, err := c.handler.Exec("DELETE FROM saved_searches WHERE id = ? AND user_id IS ?", id, userId)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Removed the saved search with the id \"", id, "\"")
+
+	return nil
+}
+
+// #region Helpers
+
+func scanSavedSearch(row rowScanner) (SavedSearch, error) {
+
+	var search SavedSearch
+	err := row.Scan(
+		&search.Id,
+		&search.UserId,
+		&search.Name,
+		&search.Query,
+		&search.Pinned,
+		&search.Position,
+		&search.CreatedAt,
+		&search.UpdatedAt,
+	)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+
+	search.Filters, err = url.ParseQuery(search.Query)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+
+	return search, nil
+}
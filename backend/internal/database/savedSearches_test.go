@@ -0,0 +1,89 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSavedSearches(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	titles := []string{"Mistborn", "Warbreaker", "Kingdom of Ash"}
+	for _, title := range titles {
+		_, err := client.AddBook(BookParams{Title: &title})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+	}
+
+	names := []string{"First", "Second", "Third"}
+	queries := []string{"?q=title:mistborn&page=3", "sort=title", "search=kingdom"}
+	ids := []uuid.UUID{}
+	for i := range names {
+		search, err := client.AddSavedSearch(nil, SavedSearchParams{Name: &names[i], Query: &queries[i]})
+		if err != nil {
+			t.Fatalf("AddSavedSearch failed: %v", err)
+		}
+		ids = append(ids, search.Id)
+	}
+
+	// Paging isn't part of the search
+	first, err := client.GetSavedSearch(nil, ids[0])
+	if err != nil {
+		t.Fatalf("GetSavedSearch failed: %v", err)
+	}
+	if first.Query != "q=title%3Amistborn" {
+		t.Errorf("Expected the page to be dropped from the query, got %s", first.Query)
+	}
+
+	results, err := client.GetBooksSummary(first.Filters)
+	if err != nil {
+		t.Fatalf("GetBooksSummary failed: %v", err)
+	}
+	if len(results.Items) != 1 || results.Items[0].Title != "Mistborn" {
+		t.Errorf("Expected the saved search to find Mistborn, got %v", results.Items)
+	}
+
+	// Pinned searches come first, the rest follow the given order
+	pinned := true
+	_, err = client.UpdateSavedSearch(nil, ids[1], SavedSearchParams{Pinned: &pinned})
+	if err != nil {
+		t.Fatalf("UpdateSavedSearch failed: %v", err)
+	}
+	err = client.ReorderSavedSearches(nil, []uuid.UUID{ids[2]})
+	if err != nil {
+		t.Fatalf("ReorderSavedSearches failed: %v", err)
+	}
+
+	searches, err := client.GetSavedSearches(nil)
+	if err != nil {
+		t.Fatalf("GetSavedSearches failed: %v", err)
+	}
+	expected := []uuid.UUID{ids[1], ids[2], ids[0]}
+	if len(searches) != len(expected) {
+		t.Fatalf("Expected %d saved searches, got %d", len(expected), len(searches))
+	}
+	for i := range expected {
+		if searches[i].Id != expected[i] {
+			t.Errorf("Expected %s at position %d, got %s", expected[i], i, searches[i].Id)
+		}
+	}
+
+	// Other users can't see the searches
+	other := uuid.New()
+	_, err = client.GetSavedSearch(&other, ids[0])
+	if err == nil {
+		t.Error("Saved searches shouldn't be visible to other users")
+	}
+
+	err = client.DeleteSavedSearch(nil, ids[0])
+	if err != nil {
+		t.Fatalf("DeleteSavedSearch failed: %v", err)
+	}
+	_, err = client.GetSavedSearch(nil, ids[0])
+	if err == nil {
+		t.Error("The saved search should be gone")
+	}
+}
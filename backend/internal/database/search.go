@@ -0,0 +1,166 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// The FTS5 index over the library. Rows are keyed by book_id and kept in sync by triggers
+const searchIndexModule = `fts5(book_id UNINDEXED, title, subtitle, description, authors, narrators, series, tags, publisher, tokenize = 'porter unicode61 remove_diacritics 2')`
+
+// bm25 weights, in the same order as the index columns
+const searchIndexRank = "bm25(books_fts, 0, 10.0, 5.0, 1.0, 6.0, 3.0, 5.0, 2.0, 1.0)"
+
+// The snippet is taken from whichever column matched best
+const searchIndexSnippet = "snippet(books_fts, -1, '<mark>', '</mark>', '…', 12)"
+
+var searchIndexCategories = []CategoryType{Authors, Narrators, Series, Tags}
+
+// Creates the full text search index and its triggers when SQLite was built with FTS5 (the sqlite_fts5 build tag).
+// Otherwise the triggers are removed and search falls back to LIKE queries
+func (c *Client) ensureSearchIndex() error {
+
+	var available bool
+	err := c.handler.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available)
+	if err != nil {
+		return err
+	}
+
+	// Triggers are recreated on every start so they always match this build
+	for name := range searchIndexTriggers() {
+		_, err = c.handler.Exec("DROP TRIGGER IF EXISTS " + name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !available {
+		log.Println("SQLite was built without FTS5. Book search falls back to simple matching")
+		c.fullTextSearch = false
+		return nil
+	}
+
+	var existing *string
+	err = c.handler.QueryRow("SELECT MAX(sql) FROM sqlite_master WHERE name = 'books_fts'").Scan(&existing)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !strings.Contains(*existing, searchIndexModule) {
+		log.Println("The search index definition changed. Rebuilding it")
+		_, err = c.handler.Exec("DROP TABLE books_fts")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = c.handler.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS books_fts USING " + searchIndexModule)
+	if err != nil {
+		return err
+	}
+
+	for _, trigger := range searchIndexTriggers() {
+		_, err = c.handler.Exec(trigger)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Books changed while running a build without FTS5 aren't in the index
+	var indexed, books int
+	err = c.handler.QueryRow("SELECT (SELECT COUNT(*) FROM books_fts), (SELECT COUNT(*) FROM books)").Scan(&indexed, &books)
+	if err != nil {
+		return err
+	}
+	if indexed != books {
+		log.Println("Rebuilding the search index")
+		_, err = c.handler.Exec("DELETE FROM books_fts; " + searchIndexInsert("1"))
+		if err != nil {
+			return err
+		}
+	}
+
+	c.fullTextSearch = true
+	return nil
+}
+
+// Returns the index triggers, mapped by name
+func searchIndexTriggers() map[string]string {
+
+	refresh := func(where string) string {
+		return fmt.Sprintf("DELETE FROM books_fts WHERE book_id IN (SELECT id FROM books WHERE %s); %s", where, searchIndexInsert(where))
+	}
+
+	triggers := map[string]string{
+		"books_fts_insert": "AFTER INSERT ON books BEGIN " + refresh("books.id = NEW.id") + " END",
+		"books_fts_update": "AFTER UPDATE ON books BEGIN DELETE FROM books_fts WHERE book_id = OLD.id; " + searchIndexInsert("books.id = NEW.id") + " END",
+		"books_fts_delete": "AFTER DELETE ON books BEGIN DELETE FROM books_fts WHERE book_id = OLD.id; END",
+	}
+
+	for _, cat := range searchIndexCategories {
+		joinTable := "books_" + string(cat)
+		triggers[joinTable+"_fts_insert"] = fmt.Sprintf("AFTER INSERT ON %s BEGIN %s END", joinTable, refresh("books.id = NEW.book_id"))
+		triggers[joinTable+"_fts_update"] = fmt.Sprintf("AFTER UPDATE ON %s BEGIN %s %s END", joinTable, refresh("books.id = OLD.book_id"), refresh("books.id = NEW.book_id"))
+		triggers[joinTable+"_fts_delete"] = fmt.Sprintf("AFTER DELETE ON %s BEGIN %s END", joinTable, refresh("books.id = OLD.book_id"))
+
+		// Renaming a category updates every book that uses it
+		linked := fmt.Sprintf("books.id IN (SELECT book_id FROM %s WHERE %s_id = NEW.id)", joinTable, categorySingular[cat])
+		triggers[string(cat)+"_fts_update"] = fmt.Sprintf("AFTER UPDATE OF name ON %s BEGIN %s END", cat, refresh(linked))
+	}
+
+	for name, body := range triggers {
+		triggers[name] = fmt.Sprintf("CREATE TRIGGER %s %s;", name, body)
+	}
+
+	return triggers
+}
+
+// Indexes the books matching the where clause
+func searchIndexInsert(where string) string {
+
+	categoryNames := func(cat CategoryType) string {
+		return fmt.Sprintf(
+			"(SELECT group_concat(%[1]s.name, ' ') FROM books_%[1]s JOIN %[1]s ON %[1]s.id = books_%[1]s.%[2]s_id WHERE books_%[1]s.book_id = books.id)",
+			cat, categorySingular[cat],
+		)
+	}
+
+	return fmt.Sprintf(`
+	INSERT INTO books_fts (book_id, title, subtitle, description, authors, narrators, series, tags, publisher)
+	SELECT books.id, books.title, books.subtitle, books.description, %s, %s, %s, %s, books.publisher
+	FROM books WHERE %s;`,
+		categoryNames(Authors), categoryNames(Narrators), categoryNames(Series), categoryNames(Tags), where,
+	)
+}
+
+// Turns a user's search into an FTS5 query. Words match as prefixes, quoted text matches as a phrase and every part must match
+func buildMatchExpression(search string) string {
+
+	parts := []string{}
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+
+	for i, segment := range strings.Split(search, `"`) {
+		segment = strings.TrimSpace(segment)
+		if segment == "" {
+			continue
+		}
+
+		// Odd segments were inside quotes. An unclosed quote is treated as a phrase too
+		if i%2 == 1 {
+			parts = append(parts, quote(segment))
+			continue
+		}
+
+		for _, word := range strings.Fields(segment) {
+			if !strings.ContainsFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+				continue
+			}
+			parts = append(parts, quote(word)+"*")
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,238 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// A series with its books in reading order. Books are ordered by the number their index starts with, so "2" comes before "10"
+// and "1.5" sits between the first and second books
+type SeriesDetails struct {
+	Id          int     `json:"id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	TotalBooks  *int    `json:"total_books"`
+	Asin        *string `json:"asin"`
+
+	Books []SeriesBook `json:"books"`
+
+	// Whole numbers up to the total book count, or the highest index in the library, that none of the books cover.
+	// Never goes past MaxSeriesTotal
+	MissingIndices []int `json:"missing_indices"`
+}
+
+type SeriesBook struct {
+	BookOverview
+	Index *string `json:"index"`
+}
+
+type SeriesParams struct {
+	Description *string `json:"description"`
+	TotalBooks  *int    `json:"total_books"`
+	Asin        *string `json:"asin"`
+}
+
+// The most books a series can have. Totals are capped at this, and indices past it (like years) aren't counted as missing
+const MaxSeriesTotal = 1000
+
+// Series changes that can't be saved. These are the client's fault
+type SeriesError struct {
+	Msg string
+}
+
+func (e SeriesError) Error() string {
+	return e.Msg
+}
+
+// Matches "3", "1.5", "1-3" and "Book 2", the first number and an optional range end
+var seriesIndexPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)(?:\s*[-–—]\s*(\d+(?:\.\d+)?))?`)
+
+// Reads the range an index covers. Single books start and end on the same number. ok is false when the index has no number
+func ParseSeriesIndex(index string) (float64, float64, bool) {
+
+	match := seriesIndexPattern.FindStringSubmatch(index)
+	if match == nil {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	end := start
+	if match[2] != "" {
+		end, err = strconv.ParseFloat(match[2], 64)
+		if err != nil || end < start {
+			end = start
+		}
+	}
+
+	return start, end, true
+}
+
+// Whole numbers from 1 up to total, or the highest number covered, that none of the indices cover. Both stop at
+// MaxSeriesTotal, and indices that go past it are left out rather than expanded
+func MissingSeriesIndices(indices []string, total *int) []int {
+
+	covered := map[int]bool{}
+	highest := 0
+	for _, index := range indices {
+		start, end, ok := ParseSeriesIndex(index)
+		if !ok || end > MaxSeriesTotal {
+			continue
+		}
+		for n := int(math.Ceil(start)); n <= int(math.Floor(end)); n++ {
+			covered[n] = true
+		}
+		highest = max(highest, int(math.Floor(end)))
+	}
+	if total != nil {
+		highest = max(highest, min(*total, MaxSeriesTotal))
+	}
+
+	missing := []int{}
+	for n := 1; n <= highest; n++ {
+		if !covered[n] {
+			missing = append(missing, n)
+		}
+	}
+
+	return missing
+}
+
+// Returns sql.ErrNoRows if the series doesn't exist
+func (c *Client) GetSeries(id int) (SeriesDetails, error) {
+
+	var series SeriesDetails
+	err := c.handler.QueryRow("SELECT id, name, description, total_books, asin FROM series WHERE id = ?", id).Scan(
+		&series.Id,
+		&series.Name,
+		&series.Description,
+		&series.TotalBooks,
+		&series.Asin,
+	)
+	if err != nil {
+		return SeriesDetails{}, err
+	}
+
+	rows, err := c.handler.Query(`
+	SELECT books.id, books.title, books.subtitle, books.cover, books.directory, books_series.series_index
+	FROM books_series JOIN books ON books.id = books_series.book_id
+	WHERE books_series.series_id = ?
+	ORDER BY books_series.sort_index IS NULL, books_series.sort_index, books_series.series_index, books.sort_title COLLATE NOCASE
+	`, id)
+	if err != nil {
+		return SeriesDetails{}, err
+	}
+	defer rows.Close()
+
+	series.Books = []SeriesBook{}
+	indices := []string{}
+	for rows.Next() {
+		var book SeriesBook
+		var dir *string
+		err = rows.Scan(&book.Id, &book.Title, &book.Subtitle, &book.Cover, &dir, &book.Index)
+		if err != nil {
+			return SeriesDetails{}, err
+		}
+
+		book.HasFiles = dir != nil
+		if book.Index != nil {
+			indices = append(indices, *book.Index)
+		}
+
+		series.Books = append(series.Books, book)
+	}
+	if err = rows.Err(); err != nil {
+		return SeriesDetails{}, err
+	}
+	rows.Close()
+
+	ids := make([]uuid.UUID, len(series.Books))
+	for i, book := range series.Books {
+		ids[i] = book.Id
+	}
+	authors, err := c.getCategoriesOfBooks(ids, Authors)
+	if err != nil {
+		return SeriesDetails{}, err
+	}
+	for i := range series.Books {
+		series.Books[i].Authors = authors[series.Books[i].Id]
+		if series.Books[i].Authors == nil {
+			series.Books[i].Authors = []Category{}
+		}
+	}
+
+	series.MissingIndices = MissingSeriesIndices(indices, series.TotalBooks)
+
+	return series, nil
+}
+
+// Empty descriptions and asins and a total of 0 clear the value. Totals over MaxSeriesTotal return a SeriesError
+func (c *Client) UpdateSeries(id int, params SeriesParams) (SeriesDetails, error) {
+
+	if params.TotalBooks != nil && *params.TotalBooks > MaxSeriesTotal {
+		return SeriesDetails{}, SeriesError{fmt.Sprintf("A series can't have more than %d books", MaxSeriesTotal)}
+	}
+
+	setParts := []string{}
+	args := []any{}
+
+	if params.Description != nil {
+		setParts = append(setParts, "description = ?")
+		args = append(args, nullIfEmpty(*params.Description))
+	}
+	if params.TotalBooks != nil {
+		setParts = append(setParts, "total_books = ?")
+		if *params.TotalBooks > 0 {
+			args = append(args, *params.TotalBooks)
+		} else {
+			args = append(args, nil)
+		}
+	}
+	if params.Asin != nil {
+		setParts = append(setParts, "asin = ?")
+		args = append(args, nullIfEmpty(strings.ToUpper(*params.Asin)))
+	}
+
+	if len(setParts) > 0 {
+		args = append(args, id)
+		result, err := c.handler.Exec("UPDATE series SET "+strings.Join(setParts, ", ")+" WHERE id = ?", args...)
+		if err != nil {
+			return SeriesDetails{}, err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return SeriesDetails{}, sql.ErrNoRows
+		}
+	}
+
+	return c.GetSeries(id)
+}
+
+// #region Helpers
+
+// The number a series index sorts by, or nil when it has none
+func seriesSortIndex(index *string) *float64 {
+	if index == nil {
+		return nil
+	}
+	start, _, ok := ParseSeriesIndex(*index)
+	if !ok {
+		return nil
+	}
+	return &start
+}
+
+func nullIfEmpty(s string) *string {
+	if s = strings.TrimSpace(s); s == "" {
+		return nil
+	}
+	return &s
+}
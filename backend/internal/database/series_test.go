@@ -0,0 +1,142 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseSeriesIndex(t *testing.T) {
+
+	tests := []struct {
+		Index string
+		Start float64
+		End   float64
+		Ok    bool
+	}{
+		{"2", 2, 2, true},
+		{"10", 10, 10, true},
+		{"1.5", 1.5, 1.5, true},
+		{"1-3", 1, 3, true},
+		{"4 – 6", 4, 6, true},
+		{"Book 7", 7, 7, true},
+		{"3-1", 3, 3, true},
+		{"Prequel", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Index, func(t *testing.T) {
+			start, end, ok := ParseSeriesIndex(test.Index)
+			if ok != test.Ok || start != test.Start || end != test.End {
+				t.Errorf("Expected %v-%v (%v), got %v-%v (%v)", test.Start, test.End, test.Ok, start, end, ok)
+			}
+		})
+	}
+}
+
+func TestMissingSeriesIndices(t *testing.T) {
+
+	total := 8
+	missing := MissingSeriesIndices([]string{"1-3", "1.5", "5", "Prequel"}, &total)
+	if !slices.Equal(missing, []int{4, 6, 7, 8}) {
+		t.Errorf("Expected [4 6 7 8], got %v", missing)
+	}
+
+	missing = MissingSeriesIndices([]string{"2", "4"}, nil)
+	if !slices.Equal(missing, []int{1, 3}) {
+		t.Errorf("Without a total, expected [1 3], got %v", missing)
+	}
+
+	// Huge totals and ranges are capped instead of expanded, and years aren't counted as indices
+	total = 200000000
+	missing = MissingSeriesIndices([]string{"1-2000000000", "2", "2019"}, &total)
+	if len(missing) != MaxSeriesTotal-1 || missing[0] != 1 || missing[len(missing)-1] != MaxSeriesTotal {
+		t.Errorf("Expected 1 and 3 to %d missing, got %d numbers", MaxSeriesTotal, len(missing))
+	}
+
+	missing = MissingSeriesIndices([]string{"1-2000000000", "3", "2019"}, nil)
+	if !slices.Equal(missing, []int{1, 2}) {
+		t.Errorf("Expected indices past the cap ignored, got %v", missing)
+	}
+}
+
+func TestSeriesOrder(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	books := []struct {
+		title string
+		index string
+	}{
+		{"Book Ten", "10"},
+		{"Book Two", "2"},
+		{"Novella", "1.5"},
+		{"Omnibus", "3-4"},
+		{"Book One", "1"},
+	}
+	for _, b := range books {
+		asin := "B000000001"
+		series := []Category{{Name: "The Series", Index: &b.index, Asin: &asin}}
+		authors := []Category{{Name: "Author of " + b.title}}
+		_, err := client.AddBook(BookParams{Title: &b.title, Series: &series, Authors: &authors})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+	}
+
+	var id int
+	err := client.db.QueryRow("SELECT id FROM series WHERE name = 'The Series'").Scan(&id)
+	if err != nil {
+		t.Fatalf("Failed to find the series: %v", err)
+	}
+
+	series, err := client.GetSeries(id)
+	if err != nil {
+		t.Fatalf("GetSeries failed: %v", err)
+	}
+
+	titles := []string{}
+	for _, book := range series.Books {
+		titles = append(titles, book.Title)
+		if len(book.Authors) != 1 || book.Authors[0].Name != "Author of "+book.Title {
+			t.Errorf("Expected %s's author, got %+v", book.Title, book.Authors)
+		}
+	}
+	if !slices.Equal(titles, []string{"Book One", "Novella", "Book Two", "Omnibus", "Book Ten"}) {
+		t.Errorf("Unexpected order %v", titles)
+	}
+	if !slices.Equal(series.MissingIndices, []int{5, 6, 7, 8, 9}) {
+		t.Errorf("Expected [5 6 7 8 9] missing, got %v", series.MissingIndices)
+	}
+	if series.Asin == nil || *series.Asin != "B000000001" {
+		t.Errorf("Expected the asin from the metadata, got %v", series.Asin)
+	}
+
+	total := 12
+	series, err = client.UpdateSeries(id, SeriesParams{TotalBooks: &total})
+	if err != nil {
+		t.Fatalf("UpdateSeries failed: %v", err)
+	}
+	if !slices.Equal(series.MissingIndices, []int{5, 6, 7, 8, 9, 11, 12}) {
+		t.Errorf("Expected the total to extend the missing indices, got %v", series.MissingIndices)
+	}
+
+	total = 200000000
+	_, err = client.UpdateSeries(id, SeriesParams{TotalBooks: &total})
+	var seriesErr SeriesError
+	if !errors.As(err, &seriesErr) {
+		t.Errorf("Expected a SeriesError for a huge total, got %v", err)
+	}
+	series, _ = client.GetSeries(id)
+	if series.TotalBooks == nil || *series.TotalBooks != 12 {
+		t.Errorf("Expected the total left at 12, got %v", series.TotalBooks)
+	}
+
+	results, err := client.GetBooksSummary(map[string][]string{"sortBy": {"series"}, "sortOrder": {"asc"}})
+	if err != nil {
+		t.Fatalf("GetBooksSummary failed: %v", err)
+	}
+	if results.Items[len(results.Items)-1].Title != "Book Ten" {
+		t.Errorf("Expected \"Book Ten\" last when sorting by series, got \"%s\"", results.Items[len(results.Items)-1].Title)
+	}
+}
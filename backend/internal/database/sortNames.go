@@ -0,0 +1,215 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type SortOptions struct {
+	// Leading words ignored when sorting titles, by language code
+	TitleArticles map[string][]string
+
+	// The languages whose articles apply to books without a language
+	TitleLanguages []string
+
+	// Name author folders with the author's sort name, "Sanderson, Brandon" instead of "Brandon Sanderson"
+	FolderSortNames bool
+}
+
+var defaultTitleArticles = map[string][]string{
+	"en": {"the", "a", "an"},
+	"fr": {"le", "la", "les", "l'", "un", "une"},
+	"de": {"der", "die", "das", "ein", "eine"},
+	"es": {"el", "la", "los", "las", "un", "una"},
+	"it": {"il", "lo", "la", "i", "gli", "le", "l'", "un", "uno", "una"},
+	"nl": {"de", "het", "een"},
+}
+
+// Lowercase words that belong to the surname that follows them
+var nameParticles = []string{"da", "de", "del", "della", "der", "des", "di", "du", "la", "le", "van", "von", "den", "ten", "ter", "st.", "bin", "ibn", "al", "el"}
+
+var nameSuffixes = []string{"jr", "jr.", "sr", "sr.", "ii", "iii", "iv", "phd", "ph.d.", "md", "m.d.", "esq", "esq."}
+
+func DefaultSortOptions() SortOptions {
+	return SortOptions{
+		TitleArticles:  defaultTitleArticles,
+		TitleLanguages: []string{"en"},
+	}
+}
+
+// Parses per language article lists like "en:the,a,an;fr:le,la,les,l'". Listed languages replace the default lists
+func ParseTitleArticles(spec string) (map[string][]string, error) {
+
+	articles := map[string][]string{}
+	for lang, words := range defaultTitleArticles {
+		articles[lang] = words
+	}
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		lang, words, ok := strings.Cut(entry, ":")
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if !ok || lang == "" {
+			return nil, fmt.Errorf("article lists look like \"en:the,a,an\", got \"%s\"", entry)
+		}
+
+		list := []string{}
+		for _, word := range strings.Split(words, ",") {
+			if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+				list = append(list, word)
+			}
+		}
+		articles[lang] = list
+	}
+
+	return articles, nil
+}
+
+// Applies the sort options. Book sort titles are regenerated if the articles changed how they sort
+func (c *Client) SetSortOptions(opts SortOptions) error {
+	c.sorting = opts
+	return c.refreshSortTitles()
+}
+
+// Turns "Ursula K. Le Guin" into "Le Guin, Ursula K.". Names that already contain a comma are assumed to be sorted already
+func GenerateSortName(name string) string {
+
+	name = strings.Join(strings.Fields(name), " ")
+
+	suffix := ""
+	if before, after, ok := cutLast(name, ","); ok && slices.Contains(nameSuffixes, strings.ToLower(strings.TrimSpace(after))) {
+		name, suffix = before, strings.TrimSpace(after)
+	}
+	if strings.Contains(name, ",") {
+		return name
+	}
+
+	fields := strings.Fields(name)
+	if len(fields) > 2 && slices.Contains(nameSuffixes, strings.ToLower(fields[len(fields)-1])) {
+		suffix = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) < 2 {
+		return name
+	}
+
+	// Particles before the last name stay with it. The first word is always a given name
+	start := len(fields) - 1
+	for start > 1 && slices.Contains(nameParticles, strings.ToLower(fields[start-1])) {
+		start--
+	}
+
+	sortName := strings.Join(fields[start:], " ") + ", " + strings.Join(fields[:start], " ")
+	if suffix != "" {
+		sortName += ", " + suffix
+	}
+
+	return sortName
+}
+
+// Removes a leading article from the title. A title that's only an article is left alone
+func GenerateSortTitle(title string, articles []string) string {
+
+	title = strings.TrimSpace(title)
+	lower := strings.ToLower(title)
+
+	for _, article := range articles {
+		prefix := article
+		if !strings.HasSuffix(article, "'") {
+			prefix += " "
+		}
+
+		if strings.HasPrefix(lower, prefix) && strings.TrimSpace(title[len(prefix):]) != "" {
+			return strings.TrimSpace(title[len(prefix):])
+		}
+	}
+
+	return title
+}
+
+// The articles to use for a book in the language. Books without a language use the configured title languages
+func (c *Client) titleArticles(language string) []string {
+
+	opts := c.sorting
+	if opts.TitleArticles == nil {
+		opts = DefaultSortOptions()
+	}
+
+	languages := opts.TitleLanguages
+	if language != "" {
+		languages = []string{strings.ToLower(language)}
+	}
+
+	articles := []string{}
+	for _, lang := range languages {
+		articles = append(articles, opts.TitleArticles[lang]...)
+	}
+
+	return articles
+}
+
+func (c *Client) refreshSortTitles() error {
+
+	// Migration 5 runs this before books have a language
+	languageColumn := "''"
+	if ok, err := c.hasColumn("books", "language"); err != nil {
+		return err
+	} else if ok {
+		languageColumn = "COALESCE(language, '')"
+	}
+
+	rows, err := c.handler.Query("SELECT id, title, sort_title, " + languageColumn + " FROM books")
+	if err != nil {
+		return err
+	}
+
+	type update struct {
+		id        string
+		sortTitle string
+	}
+	updates := []update{}
+	for rows.Next() {
+		var id, title, language string
+		var sortTitle *string
+		err = rows.Scan(&id, &title, &sortTitle, &language)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		generated := GenerateSortTitle(title, c.titleArticles(language))
+		if sortTitle == nil || *sortTitle != generated {
+			updates = append(updates, update{id, generated})
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, u := range updates {
+		_, err = c.handler.Exec("UPDATE books SET sort_title = ? WHERE id = ?", u.sortTitle, u.id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasSortName(catType CategoryType) bool {
+	return catType == Authors || catType == Narrators
+}
+
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
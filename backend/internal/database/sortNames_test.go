@@ -0,0 +1,123 @@
+package database
+
+import "testing"
+
+func TestGenerateSortName(t *testing.T) {
+
+	tests := []struct {
+		Name     string
+		Expected string
+	}{
+		{"Brandon Sanderson", "Sanderson, Brandon"},
+		{"Ursula K. Le Guin", "Le Guin, Ursula K."},
+		{"J.R.R. Tolkien", "Tolkien, J.R.R."},
+		{"Leonardo da Vinci", "da Vinci, Leonardo"},
+		{"Ludwig van Beethoven", "van Beethoven, Ludwig"},
+		{"Martin Luther King, Jr.", "King, Martin Luther, Jr."},
+		{"Robert Downey Jr", "Downey, Robert, Jr"},
+		{"Le Anh", "Anh, Le"},
+		{"Plato", "Plato"},
+		{"Sanderson, Brandon", "Sanderson, Brandon"},
+		{"  Brandon   Sanderson ", "Sanderson, Brandon"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if got := GenerateSortName(test.Name); got != test.Expected {
+				t.Errorf("Expected \"%s\", got \"%s\"", test.Expected, got)
+			}
+		})
+	}
+}
+
+func TestGenerateSortTitle(t *testing.T) {
+
+	articles, err := ParseTitleArticles("fr:le,la,les,l'")
+	if err != nil {
+		t.Fatalf("ParseTitleArticles failed: %v", err)
+	}
+
+	tests := []struct {
+		Title    string
+		Language string
+		Expected string
+	}{
+		{"The Way of Kings", "en", "Way of Kings"},
+		{"A Memory of Light", "en", "Memory of Light"},
+		{"Anathem", "en", "Anathem"},
+		{"The", "en", "The"},
+		{"L'Étranger", "fr", "Étranger"},
+		{"Les Misérables", "fr", "Misérables"},
+		{"The Hobbit", "fr", "The Hobbit"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Title, func(t *testing.T) {
+			if got := GenerateSortTitle(test.Title, articles[test.Language]); got != test.Expected {
+				t.Errorf("Expected \"%s\", got \"%s\"", test.Expected, got)
+			}
+		})
+	}
+
+	_, err = ParseTitleArticles("the,a,an")
+	if err == nil {
+		t.Error("Article lists without a language should be rejected")
+	}
+}
+
+func TestSortByAuthorAndTitle(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	books := []struct {
+		title  string
+		author string
+	}{
+		{"The Way of Kings", "Brandon Sanderson"},
+		{"A Wizard of Earthsea", "Ursula K. Le Guin"},
+		{"Anathem", "Neal Stephenson"},
+	}
+	for _, b := range books {
+		authors := []Category{{Name: b.author}}
+		_, err := client.AddBook(BookParams{Title: &b.title, Authors: &authors})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+	}
+
+	expectOrder := func(sortBy string, expected ...string) {
+		t.Helper()
+
+		results, err := client.GetBooksSummary(map[string][]string{"sortBy": {sortBy}, "sortOrder": {"asc"}})
+		if err != nil {
+			t.Fatalf("GetBooksSummary failed: %v", err)
+		}
+		for i, title := range expected {
+			if results.Items[i].Title != title {
+				t.Errorf("Sorting by %s, expected \"%s\" at %d, got \"%s\"", sortBy, title, i, results.Items[i].Title)
+			}
+		}
+	}
+
+	expectOrder("authors", "A Wizard of Earthsea", "The Way of Kings", "Anathem")
+	expectOrder("title", "Anathem", "The Way of Kings", "A Wizard of Earthsea")
+
+	// A sort name set by hand survives renames
+	var id int
+	err := client.db.QueryRow("SELECT id FROM authors WHERE name = 'Neal Stephenson'").Scan(&id)
+	if err != nil {
+		t.Fatalf("Failed to find the author: %v", err)
+	}
+	_, err = client.SetCategorySortName(Authors, id, "Aaa")
+	if err != nil {
+		t.Fatalf("SetCategorySortName failed: %v", err)
+	}
+	author, err := client.RenameCategory(Authors, id, "Neal Town Stephenson")
+	if err != nil {
+		t.Fatalf("RenameCategory failed: %v", err)
+	}
+	if author.SortName == nil || *author.SortName != "Aaa" {
+		t.Errorf("Expected the custom sort name to be kept, got %v", author.SortName)
+	}
+	expectOrder("authors", "Anathem", "A Wizard of Earthsea", "The Way of Kings")
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Adds and removes tags on many books at once. Tags are matched by name or alias, ignoring case, and missing tags are created.
+// Returns the ids of the books that changed
+func (c *Client) TagBooks(bookIds []uuid.UUID, add, remove []string) ([]uuid.UUID, error) {
+
+	resolve := func(name string, create bool) (*int, error) {
+		tag := Category{Name: name, Type: Tags}
+		if ok, err := tag.GetID(c.handler); err != nil || ok {
+			return tag.Id, err
+		}
+		if !create {
+			return nil, nil
+		}
+		tag, err := c.AddCategory(Tags, name)
+		return tag.Id, err
+	}
+
+	addIds, removeIds := []int{}, []int{}
+	for _, tag := range tagCategories(add) {
+		id, err := resolve(tag.Name, true)
+		if err != nil {
+			return []uuid.UUID{}, err
+		}
+		addIds = append(addIds, *id)
+	}
+	for _, tag := range tagCategories(remove) {
+		id, err := resolve(tag.Name, false)
+		if err != nil {
+			return []uuid.UUID{}, err
+		}
+		if id != nil {
+			removeIds = append(removeIds, *id)
+		}
+	}
+
+	changed := []uuid.UUID{}
+	for _, bookId := range bookIds {
+		var count int64
+
+		// New tags go after the book's other tags
+		for _, tagId := range addIds {
+			result, err := c.handler.Exec(`
+			INSERT OR IGNORE INTO books_tags (book_id, tag_id, rank)
+			SELECT books.id, ?, (SELECT COALESCE(MAX(rank) + 1, 0) FROM books_tags WHERE book_id = books.id)
+			FROM books WHERE books.id = ?
+			`, tagId, bookId)
+			if err != nil {
+				return []uuid.UUID{}, err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return []uuid.UUID{}, err
+			}
+			count += n
+		}
+
+		for _, tagId := range removeIds {
+			result, err := c.handler.Exec("DELETE FROM books_tags WHERE book_id = ? AND tag_id = ?", bookId, tagId)
+			if err != nil {
+				return []uuid.UUID{}, err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return []uuid.UUID{}, err
+			}
+			count += n
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		_, err := c.handler.Exec("UPDATE books SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", bookId)
+		if err != nil {
+			return []uuid.UUID{}, err
+		}
+		changed = append(changed, bookId)
+	}
+
+	err := c.CleanupCategories()
+	if err != nil {
+		return []uuid.UUID{}, err
+	}
+
+	return changed, nil
+}
+
+// Trims the tags and drops empty ones and repeats. Tags are compared ignoring case, like the tags table does
+func tagCategories(tags []string) []Category {
+
+	seen := map[string]bool{}
+	cats := []Category{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		cats = append(cats, Category{Name: tag, Type: Tags})
+	}
+
+	return cats
+}
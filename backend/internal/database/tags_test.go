@@ -0,0 +1,140 @@
+package database
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTags(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	books := map[string][]string{
+		"Horus Rising":  {"Warhammer 40k", "Sci-Fi"},
+		"War and Peace": {"War", " war ", "Classics"},
+		"Dune":          {"Sci-Fi"},
+	}
+	ids := map[string]uuid.UUID{}
+	for title, tags := range books {
+		book, err := client.AddBook(BookParams{Title: &title, Tags: &tags})
+		if err != nil {
+			t.Fatalf("AddBook failed: %v", err)
+		}
+		ids[title] = *book.Id
+
+		if title == "War and Peace" && !slices.Equal(book.Tags, []string{"War", "Classics"}) {
+			t.Errorf("Expected repeated tags to be dropped, got %v", book.Tags)
+		}
+	}
+
+	for _, filters := range []map[string][]string{
+		{"tags": {"war"}},
+		{"q": {"tag:WAR"}},
+	} {
+		results, err := client.GetBooksSummary(filters)
+		if err != nil {
+			t.Fatalf("GetBooksSummary failed: %v", err)
+		}
+		if len(results.Items) != 1 || results.Items[0].Title != "War and Peace" {
+			t.Errorf("Expected %v to only match War and Peace, got %+v", filters, results.Items)
+		}
+	}
+
+	results, err := client.GetBooksSummary(map[string][]string{"tags": {"sci-fi, warhammer 40k"}})
+	if err != nil {
+		t.Fatalf("GetBooksSummary failed: %v", err)
+	}
+	if len(results.Items) != 1 || results.Items[0].Title != "Horus Rising" {
+		t.Errorf("Expected every listed tag to be required, got %+v", results.Items)
+	}
+
+	tags, err := client.GetAllOfCategory(Tags, "book_count", true)
+	if err != nil {
+		t.Fatalf("GetAllOfCategory failed: %v", err)
+	}
+	if len(tags) != 4 || tags[0].Name != "Sci-Fi" || tags[0].BookCount == nil || *tags[0].BookCount != 2 {
+		t.Fatalf("Expected Sci-Fi to be the most used tag, got %+v", tags)
+	}
+
+	horus, dune := ids["Horus Rising"], ids["Dune"]
+	changed, err := client.TagBooks([]uuid.UUID{horus, dune}, []string{"Favourites", "sci-fi"}, []string{"Warhammer 40K"})
+	if err != nil {
+		t.Fatalf("TagBooks failed: %v", err)
+	}
+	if len(changed) != 2 {
+		t.Errorf("Expected both books to change, got %v", changed)
+	}
+
+	book, err := client.GetBook(horus)
+	if err != nil {
+		t.Fatalf("GetBook failed: %v", err)
+	}
+	if !slices.Equal(book.Tags, []string{"Sci-Fi", "Favourites"}) {
+		t.Errorf("Expected the new tag after the existing one, got %v", book.Tags)
+	}
+
+	// The unused tag is cleaned up
+	tag := Category{Name: "Warhammer 40k", Type: Tags}
+	if ok, _ := tag.GetID(client.handler); ok {
+		t.Error("Expected the unused tag to be removed")
+	}
+
+	favourites := Category{Name: "Favourites", Type: Tags}
+	if ok, err := favourites.GetID(client.handler); !ok || err != nil {
+		t.Fatalf("Expected to find the new tag: %v", err)
+	}
+	_, err = client.RenameCategory(Tags, *favourites.Id, "Favorites")
+	if err != nil {
+		t.Fatalf("RenameCategory failed: %v", err)
+	}
+	results, err = client.GetBooksSummary(map[string][]string{"q": {"tag:favourites"}})
+	if err != nil {
+		t.Fatalf("GetBooksSummary failed: %v", err)
+	}
+	if len(results.Items) != 2 {
+		t.Errorf("Expected the old tag name to still match, got %+v", results.Items)
+	}
+}
+
+func TestMigrateTags(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.db.Close()
+
+	title := "War and Peace"
+	book, err := client.AddBook(BookParams{Title: &title})
+	if err != nil {
+		t.Fatalf("AddBook failed: %v", err)
+	}
+
+	// Back to the schema before the migration
+	for name := range searchIndexTriggers() {
+		_, err = client.db.Exec("DROP TRIGGER IF EXISTS " + name)
+		if err != nil {
+			t.Fatalf("Failed to drop %s: %v", name, err)
+		}
+	}
+	_, err = client.db.Exec(`
+	DROP TABLE books_tags;
+	DROP TABLE tags;
+	ALTER TABLE books ADD COLUMN tags TEXT;
+	UPDATE books SET tags = '["War", "war", "Classics"]';
+	`)
+	if err != nil {
+		t.Fatalf("Failed to restore the old schema: %v", err)
+	}
+
+	err = client.HandleTransaction(migrateTags)
+	if err != nil {
+		t.Fatalf("migrateTags failed: %v", err)
+	}
+
+	migrated, err := client.GetBook(*book.Id)
+	if err != nil {
+		t.Fatalf("GetBook failed: %v", err)
+	}
+	if !slices.Equal(migrated.Tags, []string{"War", "Classics"}) {
+		t.Errorf("Expected the JSON tags to be converted, got %v", migrated.Tags)
+	}
+}
@@ -105,7 +105,13 @@ func (c *Client) RemoveAllUsers() error {
 		return err
 	}
 
-	_, err = c.handler.Exec("DELETE FROM refresh_tokens")
+	return c.RemoveAllRefreshTokens()
+}
+
+// Signs every user out once their access token expires
+func (c *Client) RemoveAllRefreshTokens() error {
+
+	_, err := c.handler.Exec("DELETE FROM refresh_tokens")
 	if err != nil {
 		return err
 	}
@@ -5,8 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"image"
-	"image/jpeg"
-	_ "image/png" // Registers PNG decoder
 	"io"
 	"log"
 	"net/http"
@@ -25,34 +23,56 @@ func CreateMetadataFile(metadata MetadataFile, dirPath string) error {
 }
 
 func DownloadTempFile(url string) (*os.File, error) {
-	resp, err := http.Get(url)
+
+	img, err := downloadImage(url)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	tmp, err := os.CreateTemp("", "bookOrg-*.jpg")
+	if err != nil {
+		return nil, err
 	}
 
-	img, _, err := image.Decode(resp.Body)
+	err = EncodeJpeg(tmp, img)
 	if err != nil {
-		log.Println("Failed to decode")
+		log.Println("Failed to encode")
 		return nil, err
 	}
 
-	tmp, err := os.CreateTemp("", "bookOrg-*.jpg")
+	return tmp, nil
+}
+
+// Downloads the image and writes it to dstPath as a jpeg, scaled down like covers. The file at dstPath is only
+// replaced once the new image is fully written
+func DownloadImage(url, dstPath string) error {
+
+	img, err := downloadImage(url)
+	if err != nil {
+		return err
+	}
+
+	return writeJpegAtomic(img, dstPath)
+}
+
+func downloadImage(url string) (image.Image, error) {
+	resp, err := http.Get(url)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	err = jpeg.Encode(tmp, img, &jpeg.Options{Quality: 90})
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	img, _, err := DecodeImage(resp.Body)
 	if err != nil {
-		log.Println("Failed to encode")
+		log.Println("Failed to decode")
 		return nil, err
 	}
 
-	return tmp, nil
+	return ScaleDown(img, MaxCoverDimension), nil
 }
 
 func CreateTempFileFromRequest(r *http.Request) (*os.File, error) {
@@ -1,9 +1,13 @@
 package fileManagement
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"strings"
 )
 
 func CreateDirectory(path string) error {
@@ -51,7 +55,69 @@ func MoveFilesWithPaths(oldPath, newPath string) error {
 	return nil
 }
 
+func CopyFile(srcPath, dstPath string) error {
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// The hex sha256 of the file's contents
+func HashFile(filePath string) (string, error) {
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 func DeleteFiles(path string) error {
 	err := os.RemoveAll(path)
 	return err
 }
+
+// Strips characters that can't be used in a folder name
+func SafeFolderName(name string) string {
+
+	name = strings.NewReplacer("/", "-", "\\", "-", "\x00", "").Replace(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "Unknown"
+	}
+	return name
+}
+
+// Returns a folder name, based on name, that doesn't exist in parentDir yet
+func UniqueFolderName(parentDir, name string) string {
+
+	unique := name
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path.Join(parentDir, unique)); os.IsNotExist(err) {
+			return unique
+		}
+		unique = fmt.Sprintf("%s (%d)", name, i)
+	}
+}
@@ -24,3 +24,32 @@ func RemoveDirectoryContents(dirPath string) error {
 
 	return nil
 }
+
+// Removes the empty folders left behind after a book folder moves out of the library.
+// Walks up from dirPath towards root, stopping at the first folder that still has contents.
+func RemoveEmptyParents(root, dirPath string) error {
+
+	for dir := path.Dir(dirPath); dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+
+		fullPath := path.Join(root, dir)
+		items, err := os.ReadDir(fullPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		if len(items) > 0 {
+			return nil
+		}
+
+		log.Println("Removing empty folder \"", fullPath, "\"")
+		err = os.Remove(fullPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,6 +20,9 @@ type Scanner struct {
 	Directory string
 	running   bool
 
+	// Held during each scan when set, so the scan can be paused while something else needs the database
+	Lock sync.Locker
+
 	AddHandler    func([]Files) error
 	DeleteHandler func(uuid.UUID) error
 	UpdateHandler func(uuid.UUID, Files) error
@@ -52,7 +56,13 @@ func (scan *Scanner) Start(ctx context.Context) error {
 
 			default:
 
+				if scan.Lock != nil {
+					scan.Lock.Lock()
+				}
 				err := scan.Scan()
+				if scan.Lock != nil {
+					scan.Lock.Unlock()
+				}
 				if err != nil {
 					log.Println("Scan error =>", err)
 				}
@@ -107,7 +117,7 @@ func (scan *Scanner) ScanNew(toIgnore []string) error {
 			continue
 		}
 
-		files, err := getFolderContents(scan.Directory, item.Name())
+		files, err := GetFolderContents(scan.Directory, item.Name())
 		if err != nil {
 			log.Println(err)
 			continue
@@ -139,7 +149,7 @@ func (scan *Scanner) ScanExisting(ids []uuid.UUID, dirs []string) error {
 			continue
 		}
 
-		newFiles, err := getFolderContents(scan.Directory, dir)
+		newFiles, err := GetFolderContents(scan.Directory, dir)
 		if err != nil {
 			log.Println(err)
 			continue
@@ -164,7 +174,7 @@ func getFileType(filename string) FileType {
 	case ".m4b", ".aax", ".mp3", ".aa", ".wma", ".flac", ".wav", ".daisy":
 		return Audio
 
-	case ".png", ".jpg", ".jpeg":
+	case ".png", ".jpg", ".jpeg", ".webp", ".gif":
 		return Image
 
 	case ".epub", ".pdf", ".azw3", ".kfx", ".azw", ".mobi", ".iba", ".lrf", ".lrx", ".fb2", ".djvu", ".lit", ".prc", ".pdb", ".cbz", ".cbr", ".txt", ".rtf", ".html", ".docx":
@@ -179,7 +189,7 @@ func getFileType(filename string) FileType {
 	return Other
 }
 
-func getFolderContents(root, folder string) (Files, error) {
+func GetFolderContents(root, folder string) (Files, error) {
 
 	p := path.Join(root, folder)
 
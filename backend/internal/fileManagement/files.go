@@ -40,6 +40,9 @@ type MetadataFile struct {
 	Language      string   `json:"language"`
 	Explicit      bool     `json:"explicit,omitempty"`
 	Abridged      bool     `json:"abridged,omitempty"`
+
+	// The book's custom field values, by field key
+	Custom map[string]any `json:"custom,omitempty"`
 }
 
 func (files Files) FileListsToJson() (*string, *string, error) {
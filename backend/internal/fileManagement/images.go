@@ -0,0 +1,219 @@
+package fileManagement
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif" // Registers GIF decoder
+	"image/jpeg"
+	_ "image/png" // Registers PNG decoder
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registers WebP decoder
+)
+
+// Covers larger than this (in either dimension) are scaled down when normalized
+const MaxCoverDimension int = 2000
+
+// Thumbnail widths served by the cover endpoints
+var CoverSizes = map[string]int{
+	"small":  160,
+	"medium": 320,
+	"large":  640,
+}
+
+// Decodes a JPEG, PNG, GIF or WebP image
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("unsupported or corrupt image: %w", err)
+	}
+	return img, format, nil
+}
+
+// Reads the width and height of the image without decoding all of it
+func ImageDimensions(imgPath string) (int, int, error) {
+
+	file, err := os.Open(imgPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unsupported or corrupt image: %w", err)
+	}
+
+	return config.Width, config.Height, nil
+}
+
+func EncodeJpeg(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+}
+
+// Scales the image down so its longest side is at most maxDimension. Smaller images are returned as is
+func ScaleDown(img image.Image, maxDimension int) image.Image {
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= maxDimension && height <= maxDimension {
+		return img
+	}
+
+	if width >= height {
+		height = max(1, height*maxDimension/width)
+		width = maxDimension
+	} else {
+		width = max(1, width*maxDimension/height)
+		height = maxDimension
+	}
+
+	return scale(img, width, height)
+}
+
+// Scales the image to the given width, keeping the aspect ratio. Images already narrower are returned as is
+func ScaleToWidth(img image.Image, width int) image.Image {
+
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	return scale(img, width, height)
+}
+
+// Re-encodes the image at srcPath as a JPEG at dstPath, scaling it down if it's oversized.
+// srcPath and dstPath can be the same file. Returns the dimensions of the saved image
+func NormalizeCover(srcPath, dstPath string) (int, int, error) {
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	img, _, err := DecodeImage(src)
+	src.Close()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	img = ScaleDown(img, MaxCoverDimension)
+	err = writeJpegAtomic(img, dstPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return img.Bounds().Dx(), img.Bounds().Dy(), nil
+}
+
+// Returns the path to a cached thumbnail of the cover, generating it if it's missing or older than the cover.
+// Thumbnails are stored in cacheDir as <key>-<width>.jpg
+func GetThumbnail(coverPath, cacheDir, key string, width int) (string, error) {
+
+	coverInfo, err := os.Stat(coverPath)
+	if err != nil {
+		return "", err
+	}
+
+	thumbPath := path.Join(cacheDir, fmt.Sprintf("%s-%d.jpg", key, width))
+	if thumbInfo, err := os.Stat(thumbPath); err == nil && !thumbInfo.ModTime().Before(coverInfo.ModTime()) {
+		return thumbPath, nil
+	}
+
+	err = CreateDirectory(cacheDir)
+	if err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(coverPath)
+	if err != nil {
+		return "", err
+	}
+	img, _, err := DecodeImage(src)
+	src.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = writeJpegAtomic(ScaleToWidth(img, width), thumbPath)
+	if err != nil {
+		return "", err
+	}
+
+	return thumbPath, nil
+}
+
+// Removes every cached thumbnail generated for key
+func DeleteThumbnails(cacheDir, key string) error {
+
+	matches, err := filepath.Glob(path.Join(cacheDir, key+"-*.jpg"))
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		err = os.Remove(match)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the image files directly inside dirPath
+func ListImages(dirPath string) ([]string, error) {
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return []string{}, err
+	}
+
+	images := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && IsImageFile(entry.Name()) {
+			images = append(images, entry.Name())
+		}
+	}
+
+	return images, nil
+}
+
+func IsImageFile(filename string) bool {
+	return getFileType(strings.ToLower(filename)) == Image
+}
+
+func scale(img image.Image, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+	return dst
+}
+
+// Writes to a temp file in the destination folder first so readers never see a partial image
+func writeJpegAtomic(img image.Image, dstPath string) error {
+
+	tmp, err := os.CreateTemp(path.Dir(dstPath), ".bookOrg-*.jpg")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = EncodeJpeg(tmp, img)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dstPath)
+}
@@ -0,0 +1,266 @@
+package fileManagement
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Writes a solid image of the given size in the given format
+func writeTestImage(t *testing.T, imgPath, format string, width, height int) {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		for y := range height {
+			img.Set(x, y, color.RGBA{200, 100, 50, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("Failed to encode the test image: %v", err)
+	}
+
+	err = os.WriteFile(imgPath, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write the test image: %v", err)
+	}
+}
+
+// Returns the format and dimensions of the image file
+func readTestImage(t *testing.T, imgPath string) (string, int, int) {
+	t.Helper()
+
+	file, err := os.Open(imgPath)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", imgPath, err)
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		t.Fatalf("Failed to decode %s: %v", imgPath, err)
+	}
+	return format, config.Width, config.Height
+}
+
+func TestDecodeImage(t *testing.T) {
+
+	// A 1x1 lossless WebP
+	webp, _ := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+
+	var pngBuf, jpegBuf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	png.Encode(&pngBuf, img)
+	jpeg.Encode(&jpegBuf, img, nil)
+
+	tests := []struct {
+		Name   string
+		Data   []byte
+		Format string
+		Width  int
+	}{
+		{"png", pngBuf.Bytes(), "png", 4},
+		{"jpeg", jpegBuf.Bytes(), "jpeg", 4},
+		{"webp", webp, "webp", 1},
+		{"not an image", []byte("<html></html>"), "", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			img, format, err := DecodeImage(bytes.NewReader(test.Data))
+			if test.Format == "" {
+				if err == nil {
+					t.Errorf("Expected an error, got a %s image", format)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeImage failed: %v", err)
+			}
+			if format != test.Format || img.Bounds().Dx() != test.Width {
+				t.Errorf("Expected a %s %dpx wide, got a %s %dpx wide", test.Format, test.Width, format, img.Bounds().Dx())
+			}
+		})
+	}
+}
+
+func TestNormalizeCover(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		Name   string
+		Format string
+		Width  int
+		Height int
+
+		ExpectedWidth  int
+		ExpectedHeight int
+	}{
+		{"small png", "png", 300, 450, 300, 450},
+		{"small jpeg", "jpeg", 450, 300, 450, 300},
+		{"tall png", "png", 1000, 4000, 500, MaxCoverDimension},
+		{"wide jpeg", "jpeg", 3000, 1500, MaxCoverDimension, 1000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			src := path.Join(dir, test.Name+"."+test.Format)
+			writeTestImage(t, src, test.Format, test.Width, test.Height)
+
+			dst := path.Join(dir, test.Name+".jpg")
+			width, height, err := NormalizeCover(src, dst)
+			if err != nil {
+				t.Fatalf("NormalizeCover failed: %v", err)
+			}
+			if width != test.ExpectedWidth || height != test.ExpectedHeight {
+				t.Errorf("Expected %dx%d, got %dx%d", test.ExpectedWidth, test.ExpectedHeight, width, height)
+			}
+
+			format, width, height := readTestImage(t, dst)
+			if format != "jpeg" || width != test.ExpectedWidth || height != test.ExpectedHeight {
+				t.Errorf("Expected a %dx%d jpeg saved, got a %dx%d %s", test.ExpectedWidth, test.ExpectedHeight, width, height, format)
+			}
+		})
+	}
+
+	// In place
+	src := path.Join(dir, "in place.png")
+	writeTestImage(t, src, "png", 20, 10)
+	_, _, err := NormalizeCover(src, src)
+	if err != nil {
+		t.Fatalf("NormalizeCover failed in place: %v", err)
+	}
+	if format, _, _ := readTestImage(t, src); format != "jpeg" {
+		t.Errorf("Expected the image replaced by a jpeg, got %s", format)
+	}
+
+	err = os.WriteFile(path.Join(dir, "broken.jpg"), []byte("not an image"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = NormalizeCover(path.Join(dir, "broken.jpg"), path.Join(dir, "broken-out.jpg"))
+	if err == nil {
+		t.Error("Expected an error for a corrupt image")
+	}
+	if _, err := os.Stat(path.Join(dir, "broken-out.jpg")); err == nil {
+		t.Error("Expected nothing saved for a corrupt image")
+	}
+}
+
+func TestGetThumbnail(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := path.Join(dir, "thumbnails")
+
+	tests := []struct {
+		Name   string
+		Format string
+		Width  int
+		Height int
+	}{
+		{"png", "png", 800, 1200},
+		{"jpeg", "jpeg", 800, 1200},
+		{"narrow", "jpeg", 200, 300},
+	}
+
+	for _, test := range tests {
+		coverPath := path.Join(dir, test.Name+"."+test.Format)
+		writeTestImage(t, coverPath, test.Format, test.Width, test.Height)
+
+		for size, width := range CoverSizes {
+			t.Run(test.Name+" "+size, func(t *testing.T) {
+				thumbPath, err := GetThumbnail(coverPath, cacheDir, test.Name, width)
+				if err != nil {
+					t.Fatalf("GetThumbnail failed: %v", err)
+				}
+
+				// Images are never scaled up
+				expectedWidth := min(width, test.Width)
+				expectedHeight := test.Height * expectedWidth / test.Width
+
+				format, gotWidth, gotHeight := readTestImage(t, thumbPath)
+				if format != "jpeg" || gotWidth != expectedWidth || gotHeight != expectedHeight {
+					t.Errorf("Expected a %dx%d jpeg, got a %dx%d %s", expectedWidth, expectedHeight, gotWidth, gotHeight, format)
+				}
+			})
+		}
+	}
+
+	// Cached thumbnails are reused until the cover changes
+	coverPath := path.Join(dir, "png.png")
+	thumbPath, _ := GetThumbnail(coverPath, cacheDir, "png", CoverSizes["small"])
+	before, _ := os.Stat(thumbPath)
+
+	GetThumbnail(coverPath, cacheDir, "png", CoverSizes["small"])
+	after, _ := os.Stat(thumbPath)
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Error("Expected the cached thumbnail to be reused")
+	}
+
+	writeTestImage(t, coverPath, "png", 400, 400)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(coverPath, future, future)
+	GetThumbnail(coverPath, cacheDir, "png", CoverSizes["small"])
+	if _, _, height := readTestImage(t, thumbPath); height != CoverSizes["small"] {
+		t.Errorf("Expected the thumbnail regenerated from the new cover, got %dpx high", height)
+	}
+
+	err := DeleteThumbnails(cacheDir, "png")
+	if err != nil {
+		t.Fatalf("DeleteThumbnails failed: %v", err)
+	}
+	if _, err := os.Stat(thumbPath); !os.IsNotExist(err) {
+		t.Error("Expected the thumbnails deleted")
+	}
+	if _, err := os.Stat(path.Join(cacheDir, "jpeg-160.jpg")); err != nil {
+		t.Error("Expected other covers' thumbnails kept")
+	}
+}
+
+func TestDownloadImage(t *testing.T) {
+	dir := t.TempDir()
+	writeTestImage(t, path.Join(dir, "photo.png"), "png", 3000, 1500)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	dst := path.Join(dir, "photo.jpg")
+	err := DownloadImage(server.URL+"/photo.png", dst)
+	if err != nil {
+		t.Fatalf("DownloadImage failed: %v", err)
+	}
+	if format, width, height := readTestImage(t, dst); format != "jpeg" || width != MaxCoverDimension || height != 1000 {
+		t.Errorf("Expected a %dx1000 jpeg, got a %dx%d %s", MaxCoverDimension, width, height, format)
+	}
+
+	// Downloads that aren't images, or fail, leave the saved image alone
+	err = os.WriteFile(path.Join(dir, "page.html"), []byte("<html></html>"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"page.html", "missing.png"} {
+		if err := DownloadImage(server.URL+"/"+name, dst); err == nil {
+			t.Errorf("Expected an error downloading %s", name)
+		}
+		if format, _, _ := readTestImage(t, dst); format != "jpeg" {
+			t.Errorf("Expected the saved image kept after downloading %s", name)
+		}
+	}
+}
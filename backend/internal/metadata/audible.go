@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/url"
 	"regexp"
 	"strconv"
@@ -65,6 +66,27 @@ type AudibleBookDetails struct {
 	} `json:"seriesSecondary,omitempty"`
 }
 
+type AudibleSeriesDetails struct {
+	Product struct {
+		Asin             string `json:"asin"`
+		Title            string `json:"title"`
+		PublisherSummary string `json:"publisher_summary"`
+		Relationships    []struct {
+			Asin                  string `json:"asin"`
+			RelationshipToProduct string `json:"relationship_to_product"`
+			RelationshipType      string `json:"relationship_type"`
+			Sequence              string `json:"sequence"`
+		} `json:"relationships"`
+	} `json:"product"`
+}
+
+type AudibleAuthorDetails struct {
+	Asin        string `json:"asin"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+}
+
 type AudibleError struct {
 	Error struct {
 		Code    string `json:"code"`
@@ -234,12 +256,14 @@ func GetFromAudible(asin, region string, cache *cache.Cache) (database.BookParam
 		series = append(series, database.Category{
 			Name:  item.SeriesPrimary.Name,
 			Index: &item.SeriesPrimary.Position,
+			Asin:  &item.SeriesPrimary.Asin,
 		})
 	}
 	if item.SeriesSecondary != nil {
 		series = append(series, database.Category{
-			Name:  item.SeriesPrimary.Name,
-			Index: &item.SeriesPrimary.Position,
+			Name:  item.SeriesSecondary.Name,
+			Index: &item.SeriesSecondary.Position,
+			Asin:  &item.SeriesSecondary.Asin,
 		})
 	}
 
@@ -247,6 +271,7 @@ func GetFromAudible(asin, region string, cache *cache.Cache) (database.BookParam
 	for _, author := range item.Authors {
 		authors = append(authors, database.Category{
 			Name: author.Name,
+			Asin: &author.Asin,
 		})
 	}
 
@@ -262,19 +287,135 @@ func GetFromAudible(asin, region string, cache *cache.Cache) (database.BookParam
 		isbn = &item.Isbn
 	}
 
+	var publishDate, language *string
+	if !item.ReleaseDate.IsZero() {
+		date := item.ReleaseDate.Format("2006-01-02")
+		publishDate = &date
+	}
+	if item.Language != "" {
+		lang := database.NormalizeLanguage(item.Language)
+		language = &lang
+	}
+
+	var abridged *bool
+	switch strings.ToLower(item.FormatType) {
+	case "abridged":
+		abridged = new(bool)
+		*abridged = true
+	case "unabridged":
+		abridged = new(bool)
+	}
+
+	var runtime *int
+	if item.RuntimeLengthMin > 0 {
+		runtime = &item.RuntimeLengthMin
+	}
+
 	return database.BookParams{
-		Title:       &item.Title,
-		Description: &item.Description,
-		Year:        &year,
-		Publisher:   &item.PublisherName,
-		ISBN:        isbn,
-		ASIN:        &asin,
-		Genres:      &genres,
-		Series:      &series,
-		Authors:     &authors,
-		Narrators:   &narrators,
-		Cover:       &item.Image,
-		Key:         &key,
+		Title:          &item.Title,
+		Description:    &item.Description,
+		Year:           &year,
+		Publisher:      &item.PublisherName,
+		ISBN:           isbn,
+		ASIN:           &asin,
+		PublishDate:    publishDate,
+		Language:       language,
+		Abridged:       abridged,
+		Explicit:       &item.IsAdult,
+		RuntimeMinutes: runtime,
+		Genres:         &genres,
+		Series:         &series,
+		Authors:        &authors,
+		Narrators:      &narrators,
+		Cover:          &item.Image,
+		Key:            &key,
+	}, nil
+}
+
+// Looks up a series by its asin. The total is the highest whole number Audible has a book for, so novellas between
+// books don't count towards it
+func GetAudibleSeries(asin, region string, cache *cache.Cache) (database.SeriesParams, error) {
+
+	if !IsValidASIN(asin) {
+		return database.SeriesParams{}, fmt.Errorf("invalid asin")
+	}
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   "api.audible." + region,
+		Path:   fmt.Sprintf("1.0/catalog/products/%s", asin),
+	}
+	q := u.Query()
+	q.Add("response_groups", "product_desc,relationships")
+	u.RawQuery = q.Encode()
+
+	log.Println("Querying Audible =>", u.String())
+
+	body, err := cache.HttpGet(u.String())
+	if err != nil {
+		return database.SeriesParams{}, err
+	}
+
+	var item AudibleSeriesDetails
+	err = json.Unmarshal(body, &item)
+	if err != nil {
+		return database.SeriesParams{}, err
+	}
+
+	total := 0
+	for _, rel := range item.Product.Relationships {
+		if rel.RelationshipToProduct != "child" || rel.RelationshipType != "series" {
+			continue
+		}
+		_, end, ok := database.ParseSeriesIndex(rel.Sequence)
+		if ok && end == math.Trunc(end) {
+			total = max(total, int(end))
+		}
+	}
+
+	params := database.SeriesParams{Asin: &asin}
+	if description := strings.TrimSpace(stripTags(item.Product.PublisherSummary)); description != "" {
+		params.Description = &description
+	}
+	if total > 0 {
+		params.TotalBooks = &total
+	}
+
+	return params, nil
+}
+
+func GetAudibleAuthor(asin, region string, cache *cache.Cache) (AuthorDetails, error) {
+
+	if !IsValidASIN(asin) {
+		return AuthorDetails{}, fmt.Errorf("invalid asin")
+	}
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   "api.audnex.us",
+		Path:   fmt.Sprintf("authors/%s", asin),
+	}
+	q := u.Query()
+	q.Add("region", audibleRegions[region])
+	u.RawQuery = q.Encode()
+
+	log.Println("Querying Audible =>", u.String())
+
+	body, err := cache.HttpGet(u.String())
+	if err != nil {
+		return AuthorDetails{}, err
+	}
+
+	var item AudibleAuthorDetails
+	err = json.Unmarshal(body, &item)
+	if err != nil {
+		return AuthorDetails{}, err
+	}
+
+	return AuthorDetails{
+		Bio:      strings.TrimSpace(stripTags(item.Description)),
+		PhotoUrl: item.Image,
+		Asin:     asin,
 	}, nil
 }
 
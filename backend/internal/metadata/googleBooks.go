@@ -187,10 +187,13 @@ func (result *GoogleBooksItem) parse() (database.BookParams, error) {
 	}
 
 	isbn := ""
+	identifiers := []database.BookIdentifier{{Type: database.IdentifierGoogle, Value: result.ID}}
 	for _, id := range result.VolumeInfo.IndustryIdentifiers {
-		if id.Type == "ISBN_13" {
+		if id.Type == "ISBN_13" && isbn == "" {
 			isbn = id.Identifier
-			break
+		}
+		if id.Type == "ISBN_13" || id.Type == "ISBN_10" {
+			identifiers = append(identifiers, database.BookIdentifier{Type: database.IdentifierISBN, Value: id.Identifier})
 		}
 	}
 
@@ -221,15 +224,37 @@ func (result *GoogleBooksItem) parse() (database.BookParams, error) {
 	desc := stripTags(result.VolumeInfo.Description)
 	//desc := result.VolumeInfo.Description
 
+	var publishDate, language *string
+	if date, _, ok := database.NormalizePublishDate(result.VolumeInfo.PublishedDate); ok {
+		publishDate = &date
+	}
+	if result.VolumeInfo.Language != "" {
+		lang := database.NormalizeLanguage(result.VolumeInfo.Language)
+		language = &lang
+	}
+
+	var explicit *bool
+	switch result.VolumeInfo.MaturityRating {
+	case "MATURE":
+		explicit = new(bool)
+		*explicit = true
+	case "NOT_MATURE":
+		explicit = new(bool)
+	}
+
 	return database.BookParams{
 		Title:       &result.VolumeInfo.Title,
 		Subtitle:    &result.VolumeInfo.Subtitle,
 		Description: &desc,
 		Year:        &year,
+		PublishDate: publishDate,
+		Language:    language,
+		Explicit:    explicit,
 		Publisher:   &result.VolumeInfo.Publisher,
 		ISBN:        &isbn,
 		Authors:     &authors,
 		Genres:      &genres,
+		Identifiers: &identifiers,
 		Cover:       result.VolumeInfo.ImageLinks.GetBiggestImage(),
 		Key:         &key,
 	}, nil
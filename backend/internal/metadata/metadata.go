@@ -32,6 +32,15 @@ type SearchResults struct {
 	Items      []database.BookParams `json:"items"`
 }
 
+// What a provider knows about an author. Empty fields weren't found
+type AuthorDetails struct {
+	Bio            string
+	PhotoUrl       string
+	Asin           string
+	OpenLibraryKey string
+	AlternateNames []string
+}
+
 func BookToMetadata(book database.Book) *fileManagement.MetadataFile {
 	md := fileManagement.MetadataFile{}
 
@@ -76,11 +85,19 @@ func BookToMetadata(book database.Book) *fileManagement.MetadataFile {
 	md.Series = series
 	md.Genres = database.CategoryToStrSlice(book.Genres)
 	md.PublishedYear = year
+	md.PublishedDate = book.PublishDate
 	md.Publisher = pub
 	md.Description = desc
 	md.Isbn = isbn
 	md.Asin = asin
 	md.Tags = book.Tags
+	md.Custom = book.Custom
+
+	if book.Language != nil {
+		md.Language = *book.Language
+	}
+	md.Explicit = book.Explicit != nil && *book.Explicit
+	md.Abridged = book.Abridged != nil && *book.Abridged
 
 	return &md
 }
@@ -119,6 +136,31 @@ func MetadataToBookParams(metadata fileManagement.MetadataFile) database.BookPar
 		}
 	}
 
+	var publishDate *string
+	if metadata.PublishedDate != nil {
+		if date, y, ok := database.NormalizePublishDate(*metadata.PublishedDate); ok {
+			publishDate = &date
+			if year == nil {
+				year = &y
+			}
+		}
+	}
+
+	var language *string
+	if len(metadata.Language) > 0 && metadata.Language != "null" {
+		l := database.NormalizeLanguage(metadata.Language)
+		language = &l
+	}
+
+	// The flags are left out of metadata.json when false, so only a true value is carried over
+	var explicit, abridged *bool
+	if metadata.Explicit {
+		explicit = &metadata.Explicit
+	}
+	if metadata.Abridged {
+		abridged = &metadata.Abridged
+	}
+
 	var isbn *string
 	if len(metadata.Isbn) > 0 && metadata.Isbn != "null" {
 		isbn = &metadata.Isbn
@@ -135,6 +177,10 @@ func MetadataToBookParams(metadata fileManagement.MetadataFile) database.BookPar
 		Description: desc,
 		Publisher:   &metadata.Publisher,
 		Year:        year,
+		PublishDate: publishDate,
+		Language:    language,
+		Explicit:    explicit,
+		Abridged:    abridged,
 		ISBN:        isbn,
 		ASIN:        asin,
 
@@ -43,10 +43,32 @@ type OpenLibrarySearchResults struct {
 
 type Description string
 type OpenLibraryItem struct {
-	Description Description `json:"description"`
-	Title       string      `json:"title"`
-	Subtitle    string      `json:"subtitle"`
-	Subjects    []string    `json:"subjects"`
+	Description      Description `json:"description"`
+	Title            string      `json:"title"`
+	Subtitle         string      `json:"subtitle"`
+	Subjects         []string    `json:"subjects"`
+	FirstPublishDate string      `json:"first_publish_date"`
+}
+
+type OpenLibraryEdition struct {
+	Works []struct {
+		Key string `json:"key"`
+	} `json:"works"`
+}
+
+type OpenLibraryAuthor struct {
+	Name           string      `json:"name"`
+	Bio            Description `json:"bio"`
+	Photos         []int       `json:"photos"`
+	AlternateNames []string    `json:"alternate_names"`
+}
+
+type OpenLibraryAuthorSearchResults struct {
+	NumFound int `json:"numFound"`
+	Docs     []struct {
+		Key  string `json:"key"`
+		Name string `json:"name"`
+	} `json:"docs"`
 }
 
 func SearchOpenLibrary(params SearchParams, cache *cache.Cache) (SearchResults, error) {
@@ -116,11 +138,22 @@ func SearchOpenLibrary(params SearchParams, cache *cache.Cache) (SearchResults,
 	return results.parse(params.Genres), nil
 }
 
+// Gets the work by its key. Edition keys (OL…M) are looked up to find their work, the identifier keeps the edition key
 func GetFromOpenLibrary(id string, cache *cache.Cache) (database.BookParams, error) {
+
+	workId := id
+	if strings.HasSuffix(id, "M") {
+		var err error
+		workId, err = getOpenLibraryEditionWork(id, cache)
+		if err != nil {
+			return database.BookParams{}, err
+		}
+	}
+
 	u := url.URL{
 		Scheme: "https",
 		Host:   "openlibrary.org",
-		Path:   fmt.Sprintf("works/%s.json", id),
+		Path:   fmt.Sprintf("works/%s.json", workId),
 	}
 
 	log.Println("Querying OpenLibrary:", u.String())
@@ -174,14 +207,125 @@ func GetFromOpenLibrary(id string, cache *cache.Cache) (database.BookParams, err
 		}
 	}
 
+	identifiers := []database.BookIdentifier{{Type: database.IdentifierOpenLibrary, Value: id}}
+
+	var publishDate *string
+	if date, _, ok := database.NormalizePublishDate(olItem.FirstPublishDate); ok {
+		publishDate = &date
+	}
+
 	return database.BookParams{
 		Description: &desc,
 		Title:       &olItem.Title,
 		Subtitle:    &olItem.Subtitle,
+		PublishDate: publishDate,
 		Genres:      &genres,
+		Identifiers: &identifiers,
 	}, nil
 }
 
+// Returns the key of the work the edition belongs to
+func getOpenLibraryEditionWork(id string, cache *cache.Cache) (string, error) {
+	u := url.URL{
+		Scheme: "https",
+		Host:   "openlibrary.org",
+		Path:   fmt.Sprintf("books/%s.json", id),
+	}
+
+	log.Println("Querying OpenLibrary:", u.String())
+
+	body, err := cache.HttpGet(u.String())
+	if err != nil {
+		return "", err
+	}
+
+	var edition OpenLibraryEdition
+	err = json.Unmarshal(body, &edition)
+	if err != nil {
+		return "", err
+	}
+	if len(edition.Works) == 0 {
+		return "", fmt.Errorf("the OpenLibrary edition %s doesn't belong to a work", id)
+	}
+
+	return strings.TrimPrefix(edition.Works[0].Key, "/works/"), nil
+}
+
+// Looks the author up by key. Without a key, the first author search result with exactly the name is used
+func GetOpenLibraryAuthor(key, name string, cache *cache.Cache) (AuthorDetails, error) {
+
+	if key == "" {
+		u := url.URL{
+			Scheme: "https",
+			Host:   "openlibrary.org",
+			Path:   "search/authors.json",
+		}
+		q := u.Query()
+		q.Add("q", name)
+		u.RawQuery = q.Encode()
+
+		log.Println("Querying OpenLibrary:", u.String())
+
+		body, err := cache.HttpGet(u.String())
+		if err != nil {
+			return AuthorDetails{}, err
+		}
+
+		var results OpenLibraryAuthorSearchResults
+		err = json.Unmarshal(body, &results)
+		if err != nil {
+			return AuthorDetails{}, err
+		}
+
+		for _, doc := range results.Docs {
+			if strings.EqualFold(doc.Name, name) {
+				key = doc.Key
+				break
+			}
+		}
+		if key == "" {
+			return AuthorDetails{}, fmt.Errorf("no OpenLibrary author is named \"%s\"", name)
+		}
+	}
+
+	key = strings.TrimPrefix(key, "/authors/")
+
+	u := url.URL{
+		Scheme: "https",
+		Host:   "openlibrary.org",
+		Path:   fmt.Sprintf("authors/%s.json", key),
+	}
+
+	log.Println("Querying OpenLibrary:", u.String())
+
+	body, err := cache.HttpGet(u.String())
+	if err != nil {
+		return AuthorDetails{}, err
+	}
+
+	var olAuthor OpenLibraryAuthor
+	err = json.Unmarshal(body, &olAuthor)
+	if err != nil {
+		return AuthorDetails{}, err
+	}
+
+	details := AuthorDetails{
+		Bio:            strings.TrimSpace(stripTags(string(olAuthor.Bio))),
+		OpenLibraryKey: key,
+		AlternateNames: olAuthor.AlternateNames,
+	}
+
+	// Missing photos are listed as -1
+	for _, photo := range olAuthor.Photos {
+		if photo > 0 {
+			details.PhotoUrl = fmt.Sprintf("https://covers.openlibrary.org/a/id/%d-L.jpg", photo)
+			break
+		}
+	}
+
+	return details, nil
+}
+
 func (results *OpenLibrarySearchResults) parse(genres *[]string) SearchResults {
 
 	standardResults := SearchResults{
@@ -228,15 +372,29 @@ func (results *OpenLibrarySearchResults) parse(genres *[]string) SearchResults {
 		cover := fmt.Sprintf("https://covers.openlibrary.org/b/id/%d-L.jpg", result.CoverI)
 		key := fmt.Sprintf("/api/metadata/%s?source=%s", strings.Trim(result.Key, "/works/"), "open%20library")
 
+		identifiers := []database.BookIdentifier{{Type: database.IdentifierOpenLibrary, Value: result.Key}}
+		if isbn != nil {
+			identifiers = append(identifiers, database.BookIdentifier{Type: database.IdentifierISBN, Value: *isbn})
+		}
+
+		// Works list every language they were published in, so only a single language says what the book is in
+		var language *string
+		if len(result.Language) == 1 {
+			lang := database.NormalizeLanguage(result.Language[0])
+			language = &lang
+		}
+
 		book := database.BookParams{
-			Title:    &result.Title,
-			Subtitle: &result.Subtitle,
-			Year:     &result.FirstPublishYear,
-			ISBN:     isbn,
-			Authors:  &authors,
-			Series:   &seriesList,
-			Cover:    &cover,
-			Key:      &key,
+			Title:       &result.Title,
+			Subtitle:    &result.Subtitle,
+			Year:        &result.FirstPublishYear,
+			Language:    language,
+			ISBN:        isbn,
+			Authors:     &authors,
+			Series:      &seriesList,
+			Identifiers: &identifiers,
+			Cover:       &cover,
+			Key:         &key,
 		}
 
 		if len(genresCats) > 0 {
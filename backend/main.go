@@ -6,14 +6,19 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/Ethanol2/book-organizer/internal/auth"
+	"github.com/Ethanol2/book-organizer/internal/backup"
 	"github.com/Ethanol2/book-organizer/internal/cache"
 	"github.com/Ethanol2/book-organizer/internal/database"
 	"github.com/Ethanol2/book-organizer/internal/fileManagement"
@@ -27,6 +32,10 @@ type apiConfig struct {
 	// System Structs
 	db      database.Client
 	mdCache cache.Cache
+	backups *backup.Manager
+
+	// Held for reading by every request and library scan, and for writing while a backup is restored
+	maintenance sync.RWMutex
 
 	// Folder Paths
 	frontendPath  string
@@ -43,9 +52,14 @@ type apiConfig struct {
 	port              string
 	googleBooksApiKey string
 	tokenSecret       string
-	authRequired      bool
+	// Read by the middlewares before they wait on maintenance, so a restore can change it while requests are waiting
+	authRequired   atomic.Bool
+	backupInterval time.Duration
+	backupMetadata bool
 }
 
+const defaultMetadataPath = "./data/metadata"
+
 type cliFlags struct {
 	dbReset        bool
 	booksReset     bool
@@ -59,6 +73,17 @@ type cliFlags struct {
 
 	usersReset    bool
 	clearSessions bool
+
+	migrationStatus bool
+
+	backupList     bool
+	backupCreate   bool
+	backupMetadata bool
+	backupRestore  string
+
+	exportFormat  string
+	exportPath    string
+	exportFilters string
 }
 
 func main() {
@@ -69,6 +94,32 @@ func main() {
 		return
 	}
 
+	if flags.migrationStatus {
+		err = printMigrationStatus()
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if flags.backupList || flags.backupCreate || flags.backupRestore != "" {
+		err = runBackupCommand(flags)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flags.exportPath != "" {
+		err = runExportCommand(flags)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	log.Println("Starting book organizer")
 
 	cfg, err := initConfig(flags)
@@ -83,13 +134,13 @@ func main() {
 	mux.Handle("/", fHandler)
 
 	// Auth Endpoints
-	mux.HandleFunc("GET /api/auth/status", cfg.handlerGetAuthStatus)
-	mux.HandleFunc("POST /api/auth/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/auth/logout", cfg.handlerLogout)
-	mux.HandleFunc("POST /api/auth/register", cfg.handlerRegister)
+	mux.HandleFunc("GET /api/auth/status", cfg.maintenanceMiddleware(cfg.handlerGetAuthStatus))
+	mux.HandleFunc("POST /api/auth/login", cfg.maintenanceMiddleware(cfg.handlerLogin))
+	mux.HandleFunc("POST /api/auth/logout", cfg.maintenanceMiddleware(cfg.handlerLogout))
+	mux.HandleFunc("POST /api/auth/register", cfg.maintenanceMiddleware(cfg.handlerRegister))
 	mux.HandleFunc("DELETE /api/auth/users/{id}", cfg.uuidMiddleware(cfg.handlerDeleteUser))
 	mux.HandleFunc("PUT /api/auth/users/{id}/reset-password", cfg.uuidMiddleware(cfg.handlerUpdatePassword))
-	mux.HandleFunc("POST /api/auth/refresh", cfg.handlerRefresh)
+	mux.HandleFunc("POST /api/auth/refresh", cfg.maintenanceMiddleware(cfg.handlerRefresh))
 
 	// Downloads Endpoints
 	mux.HandleFunc("POST /api/downloads/{id}/associate", cfg.uuidMiddleware(cfg.handlerAssociateDownloadToBook))
@@ -100,19 +151,95 @@ func main() {
 	// Category Endpoints
 	mux.HandleFunc("POST /api/categories/{categoryType}", cfg.authMiddleware(cfg.handlerPutCategory))
 	mux.HandleFunc("GET /api/categories/{categoryType}", cfg.authMiddleware(cfg.handlerGetAllOfCategory))
+	mux.HandleFunc("GET /api/categories/{categoryType}/{id}", cfg.authMiddleware(cfg.handlerGetCategory))
+	mux.HandleFunc("PATCH /api/categories/{categoryType}/{id}", cfg.authMiddleware(cfg.handlerUpdateCategory))
+	mux.HandleFunc("DELETE /api/categories/{categoryType}/{id}", cfg.authMiddleware(cfg.handlerDeleteCategory))
+	mux.HandleFunc("POST /api/categories/{categoryType}/{id}/merge", cfg.authMiddleware(cfg.handlerMergeCategories))
+	mux.HandleFunc("POST /api/categories/{categoryType}/{id}/aliases", cfg.authMiddleware(cfg.handlerAddCategoryAlias))
+	mux.HandleFunc("DELETE /api/categories/{categoryType}/{id}/aliases/{alias}", cfg.authMiddleware(cfg.handlerDeleteCategoryAlias))
+
+	// Authors
+	mux.HandleFunc("GET /api/authors/{id}", cfg.authMiddleware(cfg.handlerGetAuthor))
+	mux.HandleFunc("PATCH /api/authors/{id}", cfg.authMiddleware(cfg.handlerUpdateAuthor))
+	mux.HandleFunc("GET /api/authors/{id}/photo", cfg.authMiddleware(cfg.handlerGetAuthorPhoto))
+	mux.HandleFunc("POST /api/authors/{id}/refresh", cfg.authMiddleware(cfg.handlerRefreshAuthor))
+
+	// Series
+	mux.HandleFunc("GET /api/series/{id}", cfg.authMiddleware(cfg.handlerGetSeries))
+	mux.HandleFunc("PATCH /api/series/{id}", cfg.authMiddleware(cfg.handlerUpdateSeries))
+	mux.HandleFunc("POST /api/series/{id}/refresh", cfg.authMiddleware(cfg.handlerRefreshSeries))
 
 	// Book Endpoints
 	mux.HandleFunc("GET /api/library/scan", cfg.authMiddleware(cfg.handlerGetScanLibrary))
 	mux.HandleFunc("POST /api/books", cfg.authMiddleware(cfg.handlerPostBook))
 	mux.HandleFunc("GET /api/books", cfg.authMiddleware(cfg.handlerGetBooks))
+	mux.HandleFunc("PATCH /api/books", cfg.authMiddleware(cfg.handlerBulkEditBooks))
+	mux.HandleFunc("GET /api/books/lookup", cfg.authMiddleware(cfg.handlerLookupBook))
+	mux.HandleFunc("POST /api/books/tags", cfg.authMiddleware(cfg.handlerTagBooks))
+	mux.HandleFunc("GET /api/books/duplicates", cfg.authMiddleware(cfg.handlerGetDuplicateBooks))
+	mux.HandleFunc("POST /api/books/merge", cfg.authMiddleware(cfg.handlerMergeBooks))
 	mux.HandleFunc("GET /api/books/{id}", cfg.uuidMiddleware(cfg.handlerGetBook))
 	mux.HandleFunc("PATCH /api/books/{id}", cfg.uuidMiddleware(cfg.handlerUpdateBook))
 	mux.HandleFunc("DELETE /api/books/{id}", cfg.uuidMiddleware(cfg.handlerDeleteBook))
+	mux.HandleFunc("GET /api/books/{id}/cover", cfg.uuidMiddleware(cfg.handlerGetBookCover))
+	mux.HandleFunc("GET /api/books/{id}/covers", cfg.uuidMiddleware(cfg.handlerGetCoverCandidates))
+	mux.HandleFunc("POST /api/books/{id}/covers", cfg.uuidMiddleware(cfg.handlerPostCoverCandidate))
+	mux.HandleFunc("POST /api/books/{id}/covers/fetch", cfg.uuidMiddleware(cfg.handlerFetchCoverCandidates))
+	mux.HandleFunc("GET /api/books/{id}/covers/{coverId}", cfg.uuidMiddleware(cfg.handlerGetCoverCandidate))
+	mux.HandleFunc("POST /api/books/{id}/covers/{coverId}/activate", cfg.uuidMiddleware(cfg.handlerActivateCoverCandidate))
+	mux.HandleFunc("DELETE /api/books/{id}/covers/{coverId}", cfg.uuidMiddleware(cfg.handlerDeleteCoverCandidate))
+	mux.HandleFunc("POST /api/books/{id}/unassociate", cfg.uuidMiddleware(cfg.handlerUnassociateBook))
+	mux.HandleFunc("GET /api/books/{id}/formats", cfg.uuidMiddleware(cfg.handlerGetBookFormats))
+	mux.HandleFunc("PATCH /api/books/{id}/formats/{formatId}", cfg.uuidMiddleware(cfg.handlerUpdateBookFormat))
+	mux.HandleFunc("DELETE /api/books/{id}/formats/{formatId}", cfg.uuidMiddleware(cfg.handlerDeleteBookFormat))
+	mux.HandleFunc("GET /api/books/{id}/identifiers", cfg.uuidMiddleware(cfg.handlerGetBookIdentifiers))
+	mux.HandleFunc("POST /api/books/{id}/identifiers", cfg.uuidMiddleware(cfg.handlerAddBookIdentifier))
+	mux.HandleFunc("DELETE /api/books/{id}/identifiers/{type}/{value}", cfg.uuidMiddleware(cfg.handlerDeleteBookIdentifier))
+	mux.HandleFunc("GET /api/books/{id}/history", cfg.uuidMiddleware(cfg.handlerGetBookHistory))
+	mux.HandleFunc("POST /api/books/{id}/history/{entryId}/revert", cfg.uuidMiddleware(cfg.handlerRevertBook))
+	mux.HandleFunc("GET /api/books/{id}/metadata", cfg.uuidMiddleware(cfg.handlerGetBookMetadata))
+
+	// Custom Fields
+	mux.HandleFunc("GET /api/custom-fields", cfg.authMiddleware(cfg.handlerGetCustomFields))
+	mux.HandleFunc("POST /api/custom-fields", cfg.authMiddleware(cfg.handlerPostCustomField))
+	mux.HandleFunc("GET /api/custom-fields/{key}", cfg.authMiddleware(cfg.handlerGetCustomField))
+	mux.HandleFunc("PATCH /api/custom-fields/{key}", cfg.authMiddleware(cfg.handlerUpdateCustomField))
+	mux.HandleFunc("DELETE /api/custom-fields/{key}", cfg.authMiddleware(cfg.handlerDeleteCustomField))
+
+	// Saved Searches
+	mux.HandleFunc("GET /api/searches", cfg.authMiddleware(cfg.handlerGetSavedSearches))
+	mux.HandleFunc("POST /api/searches", cfg.authMiddleware(cfg.handlerPostSavedSearch))
+	mux.HandleFunc("PUT /api/searches/order", cfg.authMiddleware(cfg.handlerReorderSavedSearches))
+	mux.HandleFunc("GET /api/searches/{id}", cfg.uuidMiddleware(cfg.handlerGetSavedSearch))
+	mux.HandleFunc("PATCH /api/searches/{id}", cfg.uuidMiddleware(cfg.handlerUpdateSavedSearch))
+	mux.HandleFunc("DELETE /api/searches/{id}", cfg.uuidMiddleware(cfg.handlerDeleteSavedSearch))
+	mux.HandleFunc("GET /api/searches/{id}/books", cfg.uuidMiddleware(cfg.handlerGetSavedSearchBooks))
+
+	// Collections
+	mux.HandleFunc("GET /api/collections", cfg.authMiddleware(cfg.handlerGetCollections))
+	mux.HandleFunc("POST /api/collections", cfg.authMiddleware(cfg.handlerPostCollection))
+	mux.HandleFunc("GET /api/collections/{id}", cfg.uuidMiddleware(cfg.handlerGetCollection))
+	mux.HandleFunc("PATCH /api/collections/{id}", cfg.uuidMiddleware(cfg.handlerUpdateCollection))
+	mux.HandleFunc("DELETE /api/collections/{id}", cfg.uuidMiddleware(cfg.handlerDeleteCollection))
+	mux.HandleFunc("GET /api/collections/{id}/books", cfg.uuidMiddleware(cfg.handlerGetCollectionBooks))
+	mux.HandleFunc("POST /api/collections/{id}/books", cfg.uuidMiddleware(cfg.handlerAddCollectionBooks))
+	mux.HandleFunc("DELETE /api/collections/{id}/books", cfg.uuidMiddleware(cfg.handlerRemoveCollectionBooks))
+	mux.HandleFunc("PUT /api/collections/{id}/books/order", cfg.uuidMiddleware(cfg.handlerReorderCollectionBooks))
 
 	// Metadata
 	mux.HandleFunc("GET /api/metadata/", cfg.authMiddleware(cfg.handlerMetadataSearch))
 	mux.HandleFunc("GET /api/metadata/{id}", cfg.authMiddleware(cfg.handlerGetMetadataBookDetails))
 
+	// Export and Import
+	mux.HandleFunc("GET /api/export", cfg.authMiddleware(cfg.handlerExportLibrary))
+	mux.HandleFunc("POST /api/import", cfg.authMiddleware(cfg.handlerImportLibrary))
+
+	// Backups
+	mux.HandleFunc("GET /api/backups", cfg.authMiddleware(cfg.handlerGetBackups))
+	mux.HandleFunc("POST /api/backups", cfg.authMiddleware(cfg.handlerPostBackup))
+	mux.HandleFunc("GET /api/backups/{name}", cfg.authMiddleware(cfg.handlerDownloadBackup))
+	mux.HandleFunc("POST /api/backups/{name}/restore", cfg.exclusiveMiddleware(cfg.handlerRestoreBackup))
+
 	// Media
 	mux.Handle("/media/downloads/", http.StripPrefix(cfg.downloadsName, http.FileServer(http.Dir(cfg.downloadsPath))))
 	mux.Handle("/media/library/", http.StripPrefix(cfg.libraryName, http.FileServer(http.Dir(cfg.libraryPath))))
@@ -131,6 +258,8 @@ func main() {
 		UpdateHandler: cfg.db.UpdateDownloadFiles,
 		DeleteHandler: cfg.db.DeleteDownload,
 		GetExisting:   cfg.db.GetAllDownloadsIdsAndDirs,
+
+		Lock: cfg.maintenance.RLocker(),
 	}
 	err = scanner.Start(context.Background())
 	if err != nil {
@@ -141,6 +270,11 @@ func main() {
 	// Cull refresh tokens once a week
 	cfg.refreshTokenCulling(time.Hour * 168)
 
+	if cfg.backupInterval > 0 {
+		cfg.backups.Schedule(context.Background(), cfg.backupInterval, cfg.backupMetadata)
+		log.Println("Scheduled backups every", cfg.backupInterval)
+	}
+
 	// Start server in a goroutine to allow a controlled shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -202,6 +336,27 @@ My LinkedIn:	https://www.linkedin.com/in/ethan-colucci/
 [-rs, --reset-sessions]
 	Clears the refresh tokens, effectively logging out every user with an expired session.
 
+[-ms, --migration-status]:
+	Prints the database schema version and which migrations have been applied, then exits.
+	Pending migrations are applied automatically when the app starts, after backing up the database.
+
+[-bl, --backup-list]:
+	Lists the backups in BACKUP_PATH, then exits.
+
+[-bc, --backup-create] <m, metadata>:
+	Backs up the database to BACKUP_PATH, then exits. Safe to run while the app is running.
+
+	<m, metadata>:
+		Includes the metadata folder (covers and photos) in the backup.
+
+[-br, --backup-restore] <name>:
+	Restores the named backup, then exits. The current database is backed up first.
+	Stop the app before restoring from the command line, or use the restore endpoint while it runs.
+
+[-ex, --export] <format> <file> [filters]:
+	Exports the library to the file, then exits. Formats are json, csv (a row per book) and csv-files (a row per file).
+	The optional filters are those of GET /api/books as a query string, like "authors=Sanderson&tags=fantasy".
+
 [-cl, --clear-library]:
 	Clears the contents of the library, defined in the .env as LIBRARY_PATH.
 
@@ -252,6 +407,43 @@ My LinkedIn:	https://www.linkedin.com/in/ethan-colucci/
 			fmt.Println("Reset downloads flag (-rd)")
 			flags.downloadsReset = true
 
+		case "-ms", "--migration-status":
+			flags.migrationStatus = true
+
+		case "-bl", "--backup-list":
+			flags.backupList = true
+
+		case "-bc", "--backup-create":
+			flags.backupCreate = true
+
+			if i+1 < len(args) && (args[i+1] == "m" || args[i+1] == "metadata") {
+				flags.backupMetadata = true
+				i++
+			}
+
+		case "-br", "--backup-restore":
+			if i+1 >= len(args) || args[i+1] == "" || strings.HasPrefix(args[i+1], "-") {
+				return cliFlags{}, fmt.Errorf("%s needs the name of the backup to restore. Use -bl to list them", arg)
+			}
+			flags.backupRestore = args[i+1]
+			i++
+
+		case "-ex", "--export":
+			if i+2 >= len(args) {
+				return cliFlags{}, fmt.Errorf("%s needs a format and a file to export to", arg)
+			}
+			if _, ok := exportFormats[args[i+1]]; !ok {
+				return cliFlags{}, fmt.Errorf("unknown export format \"%s\". Formats are json, csv and csv-files", args[i+1])
+			}
+			flags.exportFormat = args[i+1]
+			flags.exportPath = args[i+2]
+			i += 2
+
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				flags.exportFilters = args[i+1]
+				i++
+			}
+
 		case "-cl", "--clear-library":
 			fmt.Println("Clear library flag (-l)")
 			flags.clearLibrary = true
@@ -298,6 +490,195 @@ My LinkedIn:	https://www.linkedin.com/in/ethan-colucci/
 	return flags, nil
 }
 
+func printMigrationStatus() error {
+
+	godotenv.Load(".env")
+
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		return fmt.Errorf("DB_PATH must be set")
+	}
+
+	statuses, err := database.ReadMigrationStatus(dbPath)
+	if err != nil {
+		return fmt.Errorf("couldn't read the migration status of \"%s\": %v", dbPath, err)
+	}
+
+	current := 0
+	pending := 0
+	fmt.Println("Version | Applied At          | Name")
+	fmt.Println("-----------------------------------------------------------")
+	for _, status := range statuses {
+		applied := "pending            "
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Format(time.DateTime)
+			current = max(current, status.Version)
+		} else {
+			pending++
+		}
+		fmt.Printf("%7d | %s | %s\n", status.Version, applied, status.Name)
+	}
+	fmt.Println()
+
+	fmt.Printf("Schema version %d. This build supports up to version %d. %d pending migration(s)\n", current, database.LatestSchemaVersion(), pending)
+	if current > database.LatestSchemaVersion() {
+		fmt.Println("The database is newer than this build. The app won't start until it's updated")
+	}
+
+	return nil
+}
+
+// Lists, creates or restores a backup from the command line
+func runBackupCommand(flags cliFlags) error {
+
+	godotenv.Load(".env")
+
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		return fmt.Errorf("DB_PATH must be set")
+	}
+
+	settings, err := loadBackupSettings()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.NewClient(dbPath)
+	if err != nil {
+		return fmt.Errorf("couldn't open database: %v", err)
+	}
+	defer db.Close()
+
+	backups := settings.manager(&db, defaultMetadataPath)
+
+	switch {
+	case flags.backupRestore != "":
+		safety, err := backups.Restore(flags.backupRestore)
+		if err != nil {
+			return fmt.Errorf("couldn't restore \"%s\": %v", flags.backupRestore, err)
+		}
+
+		// Sessions from when the backup was made would be valid again
+		err = db.RemoveAllRefreshTokens()
+		if err != nil {
+			return fmt.Errorf("restored \"%s\", but couldn't end the sessions it brought back: %v", flags.backupRestore, err)
+		}
+		fmt.Printf("Restored %s. The previous database was backed up to %s\n", flags.backupRestore, safety.Name)
+
+	case flags.backupCreate:
+		manifest, err := backups.Create(backup.ReasonManual, flags.backupMetadata)
+		if err != nil {
+			return fmt.Errorf("couldn't create the backup: %v", err)
+		}
+		fmt.Printf("Created %s (%d bytes)\n", manifest.Name, manifest.Size)
+
+	case flags.backupList:
+		manifests, err := backups.List()
+		if err != nil {
+			return fmt.Errorf("couldn't list the backups in \"%s\": %v", settings.path, err)
+		}
+
+		fmt.Println("Name                     | Created At          | Reason      | Version | Metadata | Size")
+		fmt.Println("-----------------------------------------------------------------------------------------")
+		for _, m := range manifests {
+			fmt.Printf("%-24s | %s | %-11s | %7d | %-8t | %d\n", m.Name, m.CreatedAt.Local().Format(time.DateTime), m.Reason, m.SchemaVersion, m.Metadata, m.Size)
+		}
+		fmt.Println()
+		fmt.Printf("%d backup(s) in \"%s\"\n", len(manifests), settings.path)
+	}
+
+	return nil
+}
+
+// Exports the library to a file from the command line
+func runExportCommand(flags cliFlags) error {
+
+	filters, err := url.ParseQuery(flags.exportFilters)
+	if err != nil {
+		return fmt.Errorf("invalid export filters \"%s\": %v", flags.exportFilters, err)
+	}
+
+	godotenv.Load(".env")
+
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		return fmt.Errorf("DB_PATH must be set")
+	}
+
+	db, err := database.NewClient(dbPath)
+	if err != nil {
+		return fmt.Errorf("couldn't open database: %v", err)
+	}
+	defer db.Close()
+
+	file, err := os.Create(flags.exportPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = exportFormats[flags.exportFormat].export(&db, file, filters)
+	if err != nil {
+		file.Close()
+		os.Remove(flags.exportPath)
+		return fmt.Errorf("couldn't export the library: %v", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported the library to %s\n", flags.exportPath)
+	return nil
+}
+
+type backupSettings struct {
+	path     string
+	interval time.Duration
+	keep     int
+	metadata bool
+}
+
+func loadBackupSettings() (backupSettings, error) {
+
+	settings := backupSettings{
+		path:     os.Getenv("BACKUP_PATH"),
+		keep:     7,
+		metadata: os.Getenv("BACKUP_METADATA") == "true",
+	}
+	if settings.path == "" {
+		settings.path = "./data/backups"
+	}
+
+	if interval := os.Getenv("BACKUP_INTERVAL"); interval != "" {
+		var err error
+		settings.interval, err = time.ParseDuration(interval)
+		if err != nil || settings.interval < time.Minute {
+			return backupSettings{}, fmt.Errorf("invalid BACKUP_INTERVAL \"%s\". Use a duration of at least a minute, like 24h", interval)
+		}
+	}
+
+	if keep := os.Getenv("BACKUP_KEEP"); keep != "" {
+		var err error
+		settings.keep, err = strconv.Atoi(keep)
+		if err != nil || settings.keep < 0 {
+			return backupSettings{}, fmt.Errorf("invalid BACKUP_KEEP \"%s\". Use the number of scheduled backups to keep, or 0 to keep them all", keep)
+		}
+	}
+
+	return settings, nil
+}
+
+func (settings backupSettings) manager(db *database.Client, metadataPath string) *backup.Manager {
+	return &backup.Manager{
+		Dir:          settings.path,
+		MetadataPath: metadataPath,
+		Keep:         settings.keep,
+		DB:           db,
+	}
+}
+
 func initConfig(flags cliFlags) (*apiConfig, error) {
 
 	godotenv.Load(".env")
@@ -307,7 +688,7 @@ func initConfig(flags cliFlags) (*apiConfig, error) {
 		return nil, fmt.Errorf("DB_PATH must be set")
 	}
 
-	metadataPath := "./data/metadata"
+	metadataPath := defaultMetadataPath
 
 	clearMetadata := func() error {
 		if _, err := os.Stat(metadataPath); err == nil {
@@ -355,6 +736,21 @@ func initConfig(flags cliFlags) (*apiConfig, error) {
 		return nil, fmt.Errorf("couldn't open database: %v", err)
 	}
 
+	sorting := database.DefaultSortOptions()
+	sorting.TitleArticles, err = database.ParseTitleArticles(os.Getenv("SORT_TITLE_ARTICLES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SORT_TITLE_ARTICLES: %v", err)
+	}
+	if languages := os.Getenv("SORT_TITLE_LANGUAGES"); languages != "" {
+		sorting.TitleLanguages = strings.Split(strings.ToLower(strings.ReplaceAll(languages, " ", "")), ",")
+	}
+	sorting.FolderSortNames = os.Getenv("FOLDER_SORT_NAMES") == "true"
+
+	err = db.SetSortOptions(sorting)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't apply the sort options: %v", err)
+	}
+
 	fPath := os.Getenv("FRONTEND_PATH")
 	if fPath == "" {
 		return nil, fmt.Errorf("FRONTEND_PATH must be set")
@@ -393,6 +789,11 @@ func initConfig(flags cliFlags) (*apiConfig, error) {
 		return nil, fmt.Errorf("token secret must be set")
 	}
 
+	backupSettings, err := loadBackupSettings()
+	if err != nil {
+		return nil, err
+	}
+
 	if flags.clearDownloads {
 		err = fileManagement.RemoveDirectoryContents(dPath)
 		if err != nil {
@@ -617,7 +1018,7 @@ func initConfig(flags cliFlags) (*apiConfig, error) {
 		return nil, err
 	}
 
-	return &apiConfig{
+	cfg := &apiConfig{
 		db:      db,
 		mdCache: cache.NewCache(time.Minute * 5),
 
@@ -633,8 +1034,13 @@ func initConfig(flags cliFlags) (*apiConfig, error) {
 		port:              port,
 		googleBooksApiKey: gbApiKey,
 		tokenSecret:       secret,
-		authRequired:      authRequired,
-	}, nil
+		backupInterval:    backupSettings.interval,
+		backupMetadata:    backupSettings.metadata,
+	}
+	cfg.authRequired.Store(authRequired)
+	cfg.backups = backupSettings.manager(&cfg.db, metadataPath)
+
+	return cfg, nil
 }
 
 // #region Routines
@@ -693,12 +1099,15 @@ func (cfg *apiConfig) uuidMiddleware(handler func(uuid.UUID, http.ResponseWriter
 			return
 		}
 
-		_, err = authenticate(cfg.authRequired, r, cfg.tokenSecret)
+		_, err = authenticate(cfg.authRequired.Load(), r, cfg.tokenSecret)
 		if err != nil {
 			respondWithError(w, http.StatusUnauthorized, AuthBadAuthorization, err)
 			return
 		}
 
+		cfg.maintenance.RLock()
+		defer cfg.maintenance.RUnlock()
+
 		handler(id, w, r)
 	}
 
@@ -708,12 +1117,45 @@ func (cfg *apiConfig) authMiddleware(handler func(http.ResponseWriter, *http.Req
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		_, err := authenticate(cfg.authRequired, r, cfg.tokenSecret)
+		_, err := authenticate(cfg.authRequired.Load(), r, cfg.tokenSecret)
 		if err != nil {
 			respondWithError(w, http.StatusUnauthorized, AuthBadAuthorization, err)
 			return
 		}
 
+		cfg.maintenance.RLock()
+		defer cfg.maintenance.RUnlock()
+
+		handler(w, r)
+	}
+}
+
+// For endpoints without authentication. Waits while a backup is restored
+func (cfg *apiConfig) maintenanceMiddleware(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		cfg.maintenance.RLock()
+		defer cfg.maintenance.RUnlock()
+
+		handler(w, r)
+	}
+}
+
+// Runs the handler once every other request and library scan has finished, holding new ones until it's done
+func (cfg *apiConfig) exclusiveMiddleware(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		_, err := authenticate(cfg.authRequired.Load(), r, cfg.tokenSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, AuthBadAuthorization, err)
+			return
+		}
+
+		cfg.maintenance.Lock()
+		defer cfg.maintenance.Unlock()
+
 		handler(w, r)
 	}
 }
@@ -35,6 +35,26 @@ func TestCliFlags(t *testing.T) {
 		{"-t d", []string{"-t", "d"}, cliFlags{dbTestData: true, dbReset: true, downTestData: true}, false},
 		{"-t test-downloads", []string{"-t", "test-downloads"}, cliFlags{dbTestData: true, dbReset: true, downTestData: true}, false},
 
+		{"-ms", []string{"-ms"}, cliFlags{migrationStatus: true}, false},
+		{"--migration-status", []string{"--migration-status"}, cliFlags{migrationStatus: true}, false},
+
+		{"-bl", []string{"-bl"}, cliFlags{backupList: true}, false},
+		{"--backup-list", []string{"--backup-list"}, cliFlags{backupList: true}, false},
+		{"-bc", []string{"-bc"}, cliFlags{backupCreate: true}, false},
+		{"-bc m", []string{"-bc", "m"}, cliFlags{backupCreate: true, backupMetadata: true}, false},
+		{"--backup-create metadata", []string{"--backup-create", "metadata"}, cliFlags{backupCreate: true, backupMetadata: true}, false},
+		{"-br", []string{"-br", "backup-20260101-120000"}, cliFlags{backupRestore: "backup-20260101-120000"}, false},
+		{"-br without a name", []string{"-br"}, cliFlags{}, true},
+		{"-br followed by a flag", []string{"-br", "-bl"}, cliFlags{}, true},
+		{"-br with an empty name", []string{"-br", ""}, cliFlags{}, true},
+
+		{"-ex", []string{"-ex", "json", "library.json"}, cliFlags{exportFormat: "json", exportPath: "library.json"}, false},
+		{"--export with filters", []string{"--export", "csv", "library.csv", "authors=Sanderson"}, cliFlags{exportFormat: "csv", exportPath: "library.csv", exportFilters: "authors=Sanderson"}, false},
+		{"-ex followed by a flag", []string{"-ex", "csv-files", "files.csv", "-cl"}, cliFlags{exportFormat: "csv-files", exportPath: "files.csv", clearLibrary: true}, false},
+		{"-ex unknown format", []string{"-ex", "xml", "library.xml"}, cliFlags{}, true},
+		{"-ex without a file", []string{"-ex", "json"}, cliFlags{}, true},
+		{"-ex with empty filters", []string{"-ex", "json", "library.json", ""}, cliFlags{exportFormat: "json", exportPath: "library.json"}, false},
+
 		{"Clear Directories (-cl -cd)", []string{"-cl", "-cd"}, cliFlags{clearLibrary: true, clearDownloads: true}, false},
 		{"Unknown", []string{"cd"}, cliFlags{}, true},
 	}
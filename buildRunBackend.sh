@@ -1,3 +1,3 @@
 #!/bin/bash
 
-go build -C ./backend -o ../out && ./out $@
\ No newline at end of file
+go build -C ./backend -tags sqlite_fts5 -o ../out && ./out $@